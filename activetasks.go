@@ -0,0 +1,182 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+var activeTasksNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support active tasks"}
+
+// activeTasksPollInterval is the interval at which WaitForTasks polls the
+// active tasks.
+var activeTasksPollInterval = time.Second
+
+// The common values of ActiveTask.Type.
+const (
+	TaskIndexer            = "indexer"
+	TaskDatabaseCompaction = "database_compaction"
+	TaskViewCompaction     = "view_compaction"
+	TaskReplication        = "replication"
+)
+
+// ActiveTask is a task running on the server, such as the building of an
+// index, a compaction, a replication or the splitting of a shard.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#active-tasks
+type ActiveTask struct {
+	Type string `json:"type"`
+	// Node is the cluster node running the task.
+	Node      string    `json:"node,omitempty"`
+	PID       string    `json:"pid"`
+	StartedOn time.Time `json:"started_on"`
+	UpdatedOn time.Time `json:"updated_on"`
+	// Progress is the percentage of the task completed, if reported.
+	Progress     int   `json:"progress,omitempty"`
+	ChangesDone  int64 `json:"changes_done,omitempty"`
+	TotalChanges int64 `json:"total_changes,omitempty"`
+	// Database is the database, or database shard, the task operates on.
+	Database       string `json:"database,omitempty"`
+	DesignDocument string `json:"design_document,omitempty"`
+}
+
+// DBName returns the name of the database the task operates on. For a task
+// operating on a shard, such as "shards/00000000-1fffffff/foo.1590000000",
+// this is the name of the clustered database, "foo".
+func (t *ActiveTask) DBName() string {
+	name := t.Database
+	if !strings.HasPrefix(name, "shards/") {
+		return name
+	}
+	name = strings.TrimPrefix(name, "shards/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// ActiveTasks returns the tasks currently running on the server.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#active-tasks
+func (c *Client) ActiveTasks(ctx context.Context) (tasks []*ActiveTask, err error) {
+	op := c.op("ActiveTasks", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	tasker, ok := c.driverClient.(driver.ActiveTasker)
+	if !ok {
+		return nil, op.wrap(activeTasksNotImplemented)
+	}
+	var driverTasks []*driver.ActiveTask
+	err = c.retryPolicy(nil).do(ctx, func() (err error) {
+		driverTasks, err = tasker.ActiveTasks(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	tasks = make([]*ActiveTask, len(driverTasks))
+	for i, task := range driverTasks {
+		tasks[i] = &ActiveTask{
+			Type:           task.Type,
+			Node:           task.Node,
+			PID:            task.PID,
+			StartedOn:      unixTime(task.StartedOn),
+			UpdatedOn:      unixTime(task.UpdatedOn),
+			Progress:       task.Progress,
+			ChangesDone:    task.ChangesDone,
+			TotalChanges:   task.TotalChanges,
+			Database:       task.Database,
+			DesignDocument: task.DesignDocument,
+		}
+	}
+	return tasks, nil
+}
+
+// FilterTasks returns the tasks for which match returns true.
+func FilterTasks(tasks []*ActiveTask, match func(*ActiveTask) bool) []*ActiveTask {
+	var matched []*ActiveTask
+	for _, task := range tasks {
+		if match(task) {
+			matched = append(matched, task)
+		}
+	}
+	return matched
+}
+
+// TasksForDB returns a function, for use with FilterTasks or WaitForTasks,
+// which matches the tasks operating on the database dbName, or any of its
+// shards. If taskTypes are provided, only tasks of those types are matched.
+func TasksForDB(dbName string, taskTypes ...string) func(*ActiveTask) bool {
+	return func(task *ActiveTask) bool {
+		if task.DBName() != dbName {
+			return false
+		}
+		if len(taskTypes) == 0 {
+			return true
+		}
+		for _, taskType := range taskTypes {
+			if task.Type == taskType {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WaitForTasks blocks until no active task is matched by match, polling the
+// active tasks every second. It returns an error if ActiveTasks fails with an
+// error which is not retryable, or when ctx is done.
+//
+// For example, to wait for the compaction of a database to finish:
+//
+//	err := client.WaitForTasks(ctx, kivik.TasksForDB("foo", kivik.TaskDatabaseCompaction))
+func (c *Client) WaitForTasks(ctx context.Context, match func(*ActiveTask) bool) error {
+	for {
+		tasks, err := c.ActiveTasks(ctx)
+		switch {
+		case err == nil:
+			if len(FilterTasks(tasks, match)) == 0 {
+				return nil
+			}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !IsRetryable(err):
+			return err
+		}
+		timer := time.NewTimer(activeTasksPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestActiveTasks(t *testing.T) {
+	type tt struct {
+		client driver.Client
+		want   []*ActiveTask
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		client: &mock.Client{},
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support active tasks",
+	})
+	tests.Add("client error", tt{
+		client: &mock.ActiveTasker{
+			ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+				return nil, errors.New("client error")
+			},
+		},
+		status: http.StatusInternalServerError,
+		err:    "client error",
+	})
+	tests.Add("success", tt{
+		client: &mock.ActiveTasker{
+			ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+				return []*driver.ActiveTask{{
+					Type:           "indexer",
+					Node:           "node1@127.0.0.1",
+					PID:            "<0.1.0>",
+					StartedOn:      1590000000,
+					UpdatedOn:      1590000060,
+					Progress:       42,
+					ChangesDone:    420,
+					TotalChanges:   1000,
+					Database:       "shards/00000000-1fffffff/foo.1580000000",
+					DesignDocument: "_design/app",
+				}}, nil
+			},
+		},
+		want: []*ActiveTask{{
+			Type:           TaskIndexer,
+			Node:           "node1@127.0.0.1",
+			PID:            "<0.1.0>",
+			StartedOn:      time.Unix(1590000000, 0),
+			UpdatedOn:      time.Unix(1590000060, 0),
+			Progress:       42,
+			ChangesDone:    420,
+			TotalChanges:   1000,
+			Database:       "shards/00000000-1fffffff/foo.1580000000",
+			DesignDocument: "_design/app",
+		}},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		c := &Client{driverClient: tt.client}
+		got, err := c.ActiveTasks(context.Background())
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestActiveTaskDBName(t *testing.T) {
+	tests := map[string]string{
+		"foo": "foo",
+		"shards/00000000-1fffffff/foo.1580000000":    "foo",
+		"shards/00000000-1fffffff/a/b.c.1580000000":  "a/b.c",
+		"shards/00000000-1fffffff/_users.1580000000": "_users",
+		"": "",
+	}
+	for database, want := range tests {
+		if got := (&ActiveTask{Database: database}).DBName(); got != want {
+			t.Errorf("%q: expected %q, got %q", database, want, got)
+		}
+	}
+}
+
+func TestFilterTasks(t *testing.T) {
+	tasks := []*ActiveTask{
+		{Type: TaskIndexer, Database: "shards/00000000-7fffffff/foo.1"},
+		{Type: TaskDatabaseCompaction, Database: "shards/80000000-ffffffff/foo.1"},
+		{Type: TaskIndexer, Database: "shards/00000000-7fffffff/foobar.1"},
+		{Type: TaskReplication},
+	}
+	got := FilterTasks(tasks, TasksForDB("foo"))
+	if d := testy.DiffInterface(tasks[:2], got); d != nil {
+		t.Error(d)
+	}
+	got = FilterTasks(tasks, TasksForDB("foo", TaskDatabaseCompaction, TaskViewCompaction))
+	if d := testy.DiffInterface(tasks[1:2], got); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestWaitForTasks(t *testing.T) {
+	interval := activeTasksPollInterval
+	activeTasksPollInterval = time.Millisecond
+	defer func() { activeTasksPollInterval = interval }()
+
+	compaction := &driver.ActiveTask{Type: "database_compaction", Database: "shards/00000000-ffffffff/foo.1"}
+	other := &driver.ActiveTask{Type: "database_compaction", Database: "shards/00000000-ffffffff/bar.1"}
+
+	t.Run("success", func(t *testing.T) {
+		responses := []func() ([]*driver.ActiveTask, error){
+			func() ([]*driver.ActiveTask, error) { return []*driver.ActiveTask{compaction, other}, nil },
+			func() ([]*driver.ActiveTask, error) { return nil, &Error{HTTPStatus: http.StatusServiceUnavailable} },
+			func() ([]*driver.ActiveTask, error) { return []*driver.ActiveTask{compaction}, nil },
+			func() ([]*driver.ActiveTask, error) { return []*driver.ActiveTask{other}, nil },
+		}
+		var calls int
+		c := &Client{driverClient: &mock.ActiveTasker{
+			ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+				calls++
+				response := responses[0]
+				responses = responses[1:]
+				return response()
+			},
+		}}
+		if err := c.WaitForTasks(context.Background(), TasksForDB("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 4 {
+			t.Errorf("Unexpected number of calls: %d", calls)
+		}
+	})
+	t.Run("fatal error", func(t *testing.T) {
+		c := &Client{driverClient: &mock.ActiveTasker{
+			ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+				return nil, &Error{HTTPStatus: http.StatusUnauthorized, Message: "unauthorized"}
+			},
+		}}
+		err := c.WaitForTasks(context.Background(), TasksForDB("foo"))
+		testy.StatusError(t, "unauthorized", http.StatusUnauthorized, err)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		c := &Client{driverClient: &mock.ActiveTasker{
+			ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+				return []*driver.ActiveTask{compaction}, nil
+			},
+		}}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := c.WaitForTasks(ctx, TasksForDB("foo"))
+		if err != context.DeadlineExceeded {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
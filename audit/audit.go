@@ -0,0 +1,234 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package audit reviews the security documents and design documents of the
+// databases of a server, and reports access control weaknesses:
+//
+//	report, err := audit.Run(ctx, client, audit.Config{
+//		AllowedRoles: []string{"staff", "ops"},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	for _, f := range report.Findings {
+//		log.Printf("%s: %s", f.DB, f.Message)
+//	}
+//
+// The Report may be marshaled to JSON. Main implements a command which runs
+// an audit, and writes the report to standard output.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// Kind identifies the kind of a Finding.
+type Kind string
+
+// The kinds of findings.
+const (
+	// WorldReadable is reported for a database without members, which any
+	// user, including an anonymous one, may read.
+	WorldReadable Kind = "world_readable"
+	// NoAdmins is reported for a database without admins, which only server
+	// admins may administer.
+	NoAdmins Kind = "no_admins"
+	// UnknownRole is reported for each role granted access to a database,
+	// which is not in Config.AllowedRoles.
+	UnknownRole Kind = "unknown_role"
+	// NoValidation is reported for each design document which does not
+	// define a validate_doc_update function.
+	NoValidation Kind = "no_validate_doc_update"
+	// Failed is reported for a database which could not be audited.
+	Failed Kind = "error"
+)
+
+// designPrefix is the prefix of design document IDs.
+const designPrefix = "_design/"
+
+// serverAdminRole is the role of server admins, which is always allowed.
+const serverAdminRole = "_admin"
+
+// Config configures an audit.
+type Config struct {
+	// DBs lists the databases to audit. If empty, all databases are audited.
+	DBs []string
+
+	// AllowedRoles lists the roles which may be granted access to databases.
+	// If nil, roles are not checked. The _admin role is always allowed.
+	AllowedRoles []string
+
+	// SkipDesignDocs disables the check of design documents, which requires
+	// reading every design document of every database.
+	SkipDesignDocs bool
+}
+
+// Finding describes a weakness found in a database.
+type Finding struct {
+	DB   string `json:"db"`
+	Kind Kind   `json:"kind"`
+	// Section is "admins" or "members", for an UnknownRole.
+	Section string `json:"section,omitempty"`
+	// Role is the role of an UnknownRole.
+	Role string `json:"role,omitempty"`
+	// DDoc is the ID of the design document, for NoValidation.
+	DDoc string `json:"ddoc,omitempty"`
+	// Message describes the finding.
+	Message string `json:"message"`
+}
+
+// Report is the result of an audit.
+type Report struct {
+	// Audited is the number of databases audited, including those which
+	// failed.
+	Audited int `json:"audited"`
+	// Findings lists the findings, grouped by database, in the order in
+	// which the databases were audited.
+	Findings []Finding `json:"findings"`
+}
+
+// Filter returns the findings of the given kind.
+func (r *Report) Filter(kind Kind) []Finding {
+	var result []Finding
+	for _, f := range r.Findings {
+		if f.Kind == kind {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// Run audits the databases of client, as configured by cfg.
+//
+// Errors auditing individual databases are reported as Failed findings. Run
+// returns an error only if the databases cannot be listed, or ctx is done.
+func Run(ctx context.Context, client *kivik.Client, cfg Config) (*Report, error) {
+	dbNames := cfg.DBs
+	if len(dbNames) == 0 {
+		var err error
+		if dbNames, err = client.AllDBs(ctx); err != nil {
+			return nil, err
+		}
+	}
+	report := &Report{Findings: []Finding{}}
+	var allowed map[string]bool
+	if cfg.AllowedRoles != nil {
+		allowed = map[string]bool{serverAdminRole: true}
+		for _, role := range cfg.AllowedRoles {
+			allowed[role] = true
+		}
+	}
+	for _, name := range dbNames {
+		findings, err := auditDB(ctx, client.DB(name), allowed, !cfg.SkipDesignDocs)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return report, ctxErr
+		}
+		report.Audited++
+		report.Findings = append(report.Findings, findings...)
+		if err != nil {
+			report.Findings = append(report.Findings, Finding{
+				DB:      name,
+				Kind:    Failed,
+				Message: err.Error(),
+			})
+		}
+	}
+	return report, nil
+}
+
+func auditDB(ctx context.Context, db *kivik.DB, allowed map[string]bool, ddocs bool) ([]Finding, error) {
+	name := db.Name()
+	sec, err := db.Security(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	if sec.Allows(nil, kivik.ReaderAccess) {
+		findings = append(findings, Finding{
+			DB:      name,
+			Kind:    WorldReadable,
+			Message: "database has no members, and may be read by anyone",
+		})
+	}
+	if len(sec.Admins.Names) == 0 && len(sec.Admins.Roles) == 0 {
+		findings = append(findings, Finding{
+			DB:      name,
+			Kind:    NoAdmins,
+			Message: "database has no admins",
+		})
+	}
+	if allowed != nil {
+		findings = append(findings, unknownRoles(name, "admins", sec.Admins.Roles, allowed)...)
+		findings = append(findings, unknownRoles(name, "members", sec.Members.Roles, allowed)...)
+	}
+	if !ddocs {
+		return findings, nil
+	}
+	unvalidated, err := unvalidatedDDocs(ctx, db)
+	for _, ddoc := range unvalidated {
+		findings = append(findings, Finding{
+			DB:      name,
+			Kind:    NoValidation,
+			DDoc:    ddoc,
+			Message: fmt.Sprintf("design document %s does not define validate_doc_update", ddoc),
+		})
+	}
+	return findings, err
+}
+
+func unknownRoles(db, section string, roles []string, allowed map[string]bool) []Finding {
+	var findings []Finding
+	for _, role := range roles {
+		if allowed[role] {
+			continue
+		}
+		findings = append(findings, Finding{
+			DB:      db,
+			Kind:    UnknownRole,
+			Section: section,
+			Role:    role,
+			Message: fmt.Sprintf("role %q in %s is not in the allowlist", role, section),
+		})
+	}
+	return findings
+}
+
+// unvalidatedDDocs returns the IDs of the design documents of db which do not
+// define validate_doc_update.
+func unvalidatedDDocs(ctx context.Context, db *kivik.DB) ([]string, error) {
+	rs := db.AllDocs(ctx, kivik.Options{
+		"startkey":     designPrefix,
+		"endkey":       designPrefix + kivik.EndKeySuffix,
+		"include_docs": true,
+	})
+	defer rs.Close() // nolint: errcheck
+	var ids []string
+	for rs.Next() {
+		if !strings.HasPrefix(rs.ID(), designPrefix) {
+			continue
+		}
+		var ddoc struct {
+			ValidateDocUpdate string `json:"validate_doc_update"`
+		}
+		if err := rs.ScanDoc(&ddoc); err != nil {
+			return ids, err
+		}
+		if strings.TrimSpace(ddoc.ValidateDocUpdate) == "" {
+			ids = append(ids, rs.ID())
+		}
+	}
+	return ids, rs.Err()
+}
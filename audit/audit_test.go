@@ -0,0 +1,196 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+type database struct {
+	security *driver.Security
+	err      error
+	ddocs    map[string]string
+}
+
+// server simulates the security and design documents of a server.
+type server struct {
+	dbs map[string]*database
+}
+
+var (
+	registerOnce sync.Once
+	servers      sync.Map
+)
+
+// dsn registers s, and returns the DSN with which the audittest driver
+// connects to it.
+func (s *server) dsn(t *testing.T) string {
+	registerOnce.Do(func() {
+		kivik.Register("audittest", &mock.Driver{
+			NewClientFunc: func(dsn string, _ map[string]interface{}) (driver.Client, error) {
+				s, _ := servers.Load(dsn)
+				return s.(*server).driverClient(), nil
+			},
+		})
+	})
+	servers.Store(t.Name(), s)
+	return t.Name()
+}
+
+func (s *server) client(t *testing.T) *kivik.Client {
+	client, err := kivik.New("audittest", s.dsn(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (s *server) driverClient() driver.Client {
+	return &mock.Client{
+		AllDBsFunc: func(context.Context, map[string]interface{}) ([]string, error) {
+			var names []string
+			for name := range s.dbs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names, nil
+		},
+		DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+			return s.db(s.dbs[name]), nil
+		},
+	}
+}
+
+func (s *server) db(d *database) driver.DB {
+	return &mock.DB{
+		SecurityFunc: func(context.Context) (*driver.Security, error) {
+			if d.err != nil {
+				return nil, d.err
+			}
+			return d.security, nil
+		},
+		AllDocsFunc: func(_ context.Context, opts map[string]interface{}) (driver.Rows, error) {
+			start, _ := opts["startkey"].(string)
+			end, _ := opts["endkey"].(string)
+			var ids []string
+			for id := range d.ddocs {
+				if id >= start && id <= end {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+			return &mock.Rows{
+				NextFunc: func(row *driver.Row) error {
+					if len(ids) == 0 {
+						return io.EOF
+					}
+					row.ID = ids[0]
+					row.Doc = []byte(d.ddocs[ids[0]])
+					ids = ids[1:]
+					return nil
+				},
+				CloseFunc: func() error { return nil },
+			}, nil
+		},
+	}
+}
+
+func (s *server) run(t *testing.T, cfg Config) *Report {
+	report, err := Run(context.Background(), s.client(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func testServer() *server {
+	return &server{dbs: map[string]*database{
+		"public": {
+			security: &driver.Security{Admins: driver.Members{Names: []string{"alice"}}},
+		},
+		"orphan": {
+			security: &driver.Security{Members: driver.Members{Roles: []string{"staff"}}},
+		},
+		"roles": {
+			security: &driver.Security{
+				Admins:  driver.Members{Roles: []string{"_admin", "ops", "root"}},
+				Members: driver.Members{Roles: []string{"staff", "guests"}},
+			},
+			ddocs: map[string]string{
+				"_design/views":    `{"views":{}}`,
+				"_design/validate": `{"validate_doc_update":"function(){}"}`,
+			},
+		},
+		"broken": {
+			err: &kivik.Error{HTTPStatus: http.StatusForbidden, Err: errors.New("forbidden")},
+		},
+	}}
+}
+
+func TestRun(t *testing.T) {
+	report := testServer().run(t, Config{AllowedRoles: []string{"staff", "ops"}})
+	expected := &Report{
+		Audited: 4,
+		Findings: []Finding{
+			{DB: "broken", Kind: Failed, Message: "forbidden"},
+			{DB: "orphan", Kind: NoAdmins, Message: "database has no admins"},
+			{DB: "public", Kind: WorldReadable, Message: "database has no members, and may be read by anyone"},
+			{DB: "roles", Kind: UnknownRole, Section: "admins", Role: "root", Message: `role "root" in admins is not in the allowlist`},
+			{DB: "roles", Kind: UnknownRole, Section: "members", Role: "guests", Message: `role "guests" in members is not in the allowlist`},
+			{DB: "roles", Kind: NoValidation, DDoc: "_design/views", Message: "design document _design/views does not define validate_doc_update"},
+		},
+	}
+	if d := testy.DiffInterface(expected, report); d != nil {
+		t.Error(d)
+	}
+	if n := len(report.Filter(UnknownRole)); n != 2 {
+		t.Errorf("Unexpected number of unknown roles: %d", n)
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	report := testServer().run(t, Config{DBs: []string{"roles"}, SkipDesignDocs: true})
+	if report.Audited != 1 || len(report.Findings) != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	result, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffJSON([]byte(`{"audited":1,"findings":[]}`), result); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Run(ctx, testServer().client(t), Config{DBs: []string{"public"}})
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
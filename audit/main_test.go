@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestRunCommand(t *testing.T) {
+	type tt struct {
+		driver string
+		args   []string
+		env    bool
+		status int
+		stdout string
+		stderr string
+	}
+	tests := testy.NewTable()
+	tests.Add("no dsn", tt{
+		status: exitUsage,
+	})
+	tests.Add("bad flag", tt{
+		args:   []string{"-foo"},
+		status: exitUsage,
+	})
+	tests.Add("unknown driver", tt{
+		driver: "nonexistent",
+		env:    true,
+		status: exitError,
+		stderr: "kivik-audit: kivik: unknown driver",
+	})
+	tests.Add("clean", tt{
+		args:   []string{"-dbs", "roles", "-skip-ddocs", "-strict"},
+		env:    true,
+		stdout: `{"audited":1,"findings":[]}`,
+	})
+	tests.Add("findings", tt{
+		args:   []string{"-dbs", "roles", "-allow-roles", "staff", "-skip-ddocs", "-strict"},
+		status: exitFindings,
+		stdout: `{"audited":1,"findings":[
+			{"db":"roles","kind":"unknown_role","section":"admins","role":"ops","message":"role \"ops\" in admins is not in the allowlist"},
+			{"db":"roles","kind":"unknown_role","section":"admins","role":"root","message":"role \"root\" in admins is not in the allowlist"},
+			{"db":"roles","kind":"unknown_role","section":"members","role":"guests","message":"role \"guests\" in members is not in the allowlist"}
+		]}`,
+	})
+	tests.Run(t, func(t *testing.T, tt tt) {
+		dsn := testServer().dsn(t)
+		args := tt.args
+		if !tt.env && tt.status != exitUsage {
+			args = append([]string{"-dsn", dsn}, args...)
+		}
+		getenv := func(string) string {
+			if tt.env {
+				return dsn
+			}
+			return ""
+		}
+		driverName := tt.driver
+		if driverName == "" {
+			driverName = "audittest"
+		}
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		status := run(context.Background(), "kivik-audit", driverName, args, getenv, stdout, stderr)
+		if status != tt.status {
+			t.Errorf("Unexpected status %d: %s", status, stderr)
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("Unexpected error output: %s", stderr)
+		}
+		if tt.stdout == "" {
+			return
+		}
+		if d := testy.DiffJSON([]byte(tt.stdout), stdout.Bytes()); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -0,0 +1,326 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// DefaultSessionTimeout is the lifetime assumed for a cookie session, when
+// neither the driver nor CookieAuth.Timeout provide one. It matches the
+// default of CouchDB's [chttpd_auth] timeout setting.
+const DefaultSessionTimeout = 10 * time.Minute
+
+// Headers used by CouchDB proxy authentication.
+const (
+	ProxyAuthUserHeader  = "X-Auth-CouchDB-UserName"
+	ProxyAuthRolesHeader = "X-Auth-CouchDB-Roles"
+	ProxyAuthTokenHeader = "X-Auth-CouchDB-Token"
+)
+
+// Authenticator is implemented by the authenticators of this package, which
+// may be passed to Client.Authenticate, for drivers which support them.
+type Authenticator interface {
+	// User returns the name of the user the authenticator authenticates as,
+	// or an empty string if it is not known.
+	User() string
+}
+
+var (
+	_ Authenticator = &BasicAuth{}
+	_ Authenticator = &CookieAuth{}
+	_ Authenticator = &JWTAuth{}
+	_ Authenticator = &ProxyAuth{}
+)
+
+// BasicAuth authenticates each request with HTTP Basic Authentication.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// User returns a.Username.
+func (a *BasicAuth) User() string { return a.Username }
+
+// CookieAuth authenticates with the _session endpoint, and authenticates
+// subsequent requests with the session cookie named SessionCookieName.
+//
+// The client re-authenticates automatically before the session expires.
+type CookieAuth struct {
+	Username string
+	Password string
+	// Timeout is the lifetime of the session, used if the driver does not
+	// report the expiry of the session cookie. If zero,
+	// DefaultSessionTimeout is used.
+	Timeout time.Duration
+}
+
+// User returns a.Username.
+func (a *CookieAuth) User() string { return a.Username }
+
+func (a *CookieAuth) timeout() time.Duration {
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+	return DefaultSessionTimeout
+}
+
+// JWTAuth authenticates each request with a JSON Web Token, sent as a bearer
+// token.
+type JWTAuth struct {
+	Token string
+}
+
+// User returns the sub claim of the token, which CouchDB uses as the user
+// name. It returns an empty string if the token cannot be decoded. The
+// signature of the token is not verified.
+func (a *JWTAuth) User() string {
+	parts := strings.Split(a.Token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Sub
+}
+
+// ProxyAuth authenticates each request as a user vouched for by a trusted
+// proxy, by way of the X-Auth-CouchDB-* headers.
+type ProxyAuth struct {
+	Username string
+	Roles    []string
+	// Secret is the server's [chttpd_auth] secret, with which the token is
+	// signed. If empty, no token is sent, which requires the server to be
+	// configured with proxy_use_secret = false.
+	Secret string
+	// Hash is the hash function used to sign the token. If nil, SHA-256 is
+	// used. CouchDB versions before 3.3 require SHA-1.
+	Hash func() hash.Hash
+}
+
+// User returns a.Username.
+func (a *ProxyAuth) User() string { return a.Username }
+
+// Token returns the hex-encoded HMAC of the user name, keyed by the secret,
+// or an empty string if no secret is set.
+func (a *ProxyAuth) Token() string {
+	if a.Secret == "" {
+		return ""
+	}
+	h := a.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	mac := hmac.New(h, []byte(a.Secret))
+	_, _ = mac.Write([]byte(a.Username))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Header returns the headers to be sent with each request.
+func (a *ProxyAuth) Header() http.Header {
+	h := http.Header{}
+	h.Set(ProxyAuthUserHeader, a.Username)
+	if len(a.Roles) > 0 {
+		h.Set(ProxyAuthRolesHeader, strings.Join(a.Roles, ","))
+	}
+	if token := a.Token(); token != "" {
+		h.Set(ProxyAuthTokenHeader, token)
+	}
+	return h
+}
+
+// session tracks the authenticator in effect for a client, and re-authenticates
+// before a cookie session expires.
+type session struct {
+	auth    interface{}
+	expires time.Time
+	timer   *time.Timer
+	err     error
+}
+
+// authenticate authenticates with a. prev is the session being renewed, or
+// nil for a call to Authenticate. If the session is ended by Logout or Close
+// while the driver is authenticating, or prev is no longer in effect, the new
+// session is not started, and the driver is logged out of it.
+func (c *Client) authenticate(ctx context.Context, a interface{}, prev *session) error {
+	auth, ok := c.driverClient.(driver.Authenticator)
+	if !ok {
+		return &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support authentication"}
+	}
+	c.authMu.Lock()
+	ends := c.sessionEnds
+	c.authMu.Unlock()
+	if err := auth.Authenticate(ctx, a); err != nil {
+		return err
+	}
+	if !c.startSession(a, ends, prev) {
+		if deauth, ok := c.driverClient.(driver.Deauthenticator); ok {
+			_ = deauth.Logout(ctx)
+		}
+	}
+	return nil
+}
+
+// startSession records a as the authenticator in effect, following a
+// successful authentication. For a CookieAuth, a re-authentication is
+// scheduled once nine tenths of the session lifetime have elapsed.
+//
+// ends is the value of c.sessionEnds when the authentication began, and prev
+// is the session being renewed, if any. startSession returns false, without
+// starting the session, if a session has since been ended, or prev has been
+// replaced.
+func (c *Client) startSession(a interface{}, ends uint64, prev *session) bool {
+	s := &session{auth: a}
+	if cookie, ok := a.(*CookieAuth); ok {
+		if expirer, ok := c.driverClient.(driver.SessionExpirer); ok {
+			s.expires = expirer.SessionExpiry()
+		}
+		if now := time.Now(); !s.expires.After(now) {
+			s.expires = now.Add(cookie.timeout())
+		}
+	}
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.sessionEnds != ends || (prev != nil && c.session != prev) {
+		return false
+	}
+	c.session.stop()
+	c.session = s
+	if !s.expires.IsZero() {
+		s.timer = time.AfterFunc(time.Until(s.expires)*9/10, func() {
+			c.refreshSession(s)
+		})
+	}
+	return true
+}
+
+// stop cancels any scheduled renewal. It must be called with authMu held.
+func (s *session) stop() {
+	if s != nil && s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// refreshSession re-authenticates with the authenticator of s, if it is
+// still in effect. On failure, it is retried after half of the remaining
+// lifetime of the session, until the session expires.
+func (c *Client) refreshSession(s *session) {
+	remaining := time.Until(s.expires)
+	ctx, cancel := context.WithTimeout(context.Background(), remaining)
+	defer cancel()
+	c.authMu.Lock()
+	current := c.session == s
+	c.authMu.Unlock()
+	if !current {
+		return
+	}
+	op := c.op("Authenticate", "")
+	ctx, span := c.trace(ctx, op)
+	err := c.authenticate(ctx, s.auth, s)
+	span.end(err)
+	if err == nil {
+		return
+	}
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.session != s {
+		return
+	}
+	s.err = op.wrap(err)
+	if retry := time.Until(s.expires) / 2; retry > time.Millisecond {
+		s.timer = time.AfterFunc(retry, func() {
+			c.refreshSession(s)
+		})
+	}
+}
+
+// endSession ends the current session, and prevents any authentication in
+// progress from starting a new one.
+func (c *Client) endSession() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.session.stop()
+	c.session = nil
+	c.sessionEnds++
+}
+
+// CurrentUser returns the name of the user in effect, as reported by the
+// Authenticator last passed to Authenticate, or an empty string if it is not
+// known.
+func (c *Client) CurrentUser() string {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.session == nil {
+		return ""
+	}
+	if a, ok := c.session.auth.(Authenticator); ok {
+		return a.User()
+	}
+	return ""
+}
+
+// SessionExpiry returns the time at which the current cookie session
+// expires, or the zero time if there is no cookie session. The session is
+// renewed automatically before it expires.
+func (c *Client) SessionExpiry() time.Time {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.session == nil {
+		return time.Time{}
+	}
+	return c.session.expires
+}
+
+// SessionErr returns the error of the last failed attempt to renew the
+// current cookie session, or nil.
+func (c *Client) SessionErr() error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.session == nil {
+		return nil
+	}
+	return c.session.err
+}
+
+// Logout ends the current session, and stops any automatic renewal. The
+// session is ended even if the driver fails to log out, or does not support
+// logging out.
+func (c *Client) Logout(ctx context.Context) (err error) {
+	op := c.op("Logout", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	c.endSession()
+	deauth, ok := c.driverClient.(driver.Deauthenticator)
+	if !ok {
+		return op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support logout"})
+	}
+	return op.wrap(deauth.Logout(ctx))
+}
@@ -0,0 +1,270 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"crypto/sha1" // nolint:gosec
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestAuthenticatorUser(t *testing.T) {
+	tests := map[string]struct {
+		auth Authenticator
+		want string
+	}{
+		"basic":         {&BasicAuth{Username: "bob", Password: "x"}, "bob"},
+		"cookie":        {&CookieAuth{Username: "bob", Password: "x"}, "bob"},
+		"proxy":         {&ProxyAuth{Username: "bob"}, "bob"},
+		"jwt":           {&JWTAuth{Token: "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiAiYWxpY2UiLCAiX2NvdWNoZGIucm9sZXMiOiBbImEiXX0.sig"}, "alice"},
+		"invalid jwt":   {&JWTAuth{Token: "foo"}, ""},
+		"jwt bad claim": {&JWTAuth{Token: "a.!!!.c"}, ""},
+	}
+	for name, test := range tests {
+		if got := test.auth.User(); got != test.want {
+			t.Errorf("%s: expected %q, got %q", name, test.want, got)
+		}
+	}
+}
+
+func TestProxyAuthHeader(t *testing.T) {
+	a := &ProxyAuth{Username: "bob", Roles: []string{"a", "b"}, Secret: "secret"}
+	h := a.Header()
+	if d := testy.DiffInterface([]string{"bob", "a,b", "9c90819f883772660da011f41042fabea4a174e2873386b30949f106dbac797e"}, []string{
+		h.Get(ProxyAuthUserHeader),
+		h.Get(ProxyAuthRolesHeader),
+		h.Get(ProxyAuthTokenHeader),
+	}); d != nil {
+		t.Error(d)
+	}
+	a.Hash = sha1.New
+	if token := a.Token(); token != "dcd244bed8f9dffffa806d4c9523d744d236df13" {
+		t.Errorf("Unexpected SHA-1 token: %s", token)
+	}
+	h = (&ProxyAuth{Username: "bob"}).Header()
+	if len(h) != 1 || h.Get(ProxyAuthUserHeader) != "bob" {
+		t.Errorf("Unexpected headers: %v", h)
+	}
+}
+
+// sessionClient is a driver client which supports cookie sessions.
+type sessionClient struct {
+	*mock.Client
+	mu      sync.Mutex
+	logins  int
+	fail    bool
+	expires time.Time
+	logouts int
+	// hook, if set, is called by Authenticate before it returns.
+	hook func()
+}
+
+var (
+	_ driver.Authenticator   = &sessionClient{}
+	_ driver.SessionExpirer  = &sessionClient{}
+	_ driver.Deauthenticator = &sessionClient{}
+)
+
+func (c *sessionClient) Authenticate(context.Context, interface{}) error {
+	c.mu.Lock()
+	hook := c.hook
+	c.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logins++
+	if c.fail {
+		return &Error{HTTPStatus: http.StatusUnauthorized, Message: "name or password is incorrect"}
+	}
+	return nil
+}
+
+func (c *sessionClient) SessionExpiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expires
+}
+
+func (c *sessionClient) Logout(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logouts++
+	return nil
+}
+
+func (c *sessionClient) logoutCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logouts
+}
+
+func (c *sessionClient) loginCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logins
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCookieAuthRenewal(t *testing.T) {
+	dc := &sessionClient{}
+	c := &Client{driverClient: dc}
+	defer c.Close(context.Background()) // nolint: errcheck
+	start := time.Now()
+	if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob", Timeout: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if user := c.CurrentUser(); user != "bob" {
+		t.Errorf("Unexpected user: %s", user)
+	}
+	first := c.SessionExpiry()
+	if first.Before(start.Add(20*time.Millisecond)) || first.After(time.Now().Add(20*time.Millisecond)) {
+		t.Errorf("Unexpected expiry: %v", first)
+	}
+	waitFor(t, func() bool { return dc.loginCount() >= 3 })
+	if !c.SessionExpiry().After(first) {
+		t.Errorf("Session expiry not extended")
+	}
+	if err := c.SessionErr(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestCookieAuthDriverExpiry(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	dc := &sessionClient{expires: expires}
+	c := &Client{driverClient: dc}
+	defer c.Close(context.Background()) // nolint: errcheck
+	if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.SessionExpiry(); !got.Equal(expires) {
+		t.Errorf("Unexpected expiry: %v", got)
+	}
+}
+
+func TestCookieAuthRenewalFailure(t *testing.T) {
+	dc := &sessionClient{}
+	c := &Client{driverClient: dc}
+	defer c.Close(context.Background()) // nolint: errcheck
+	if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob", Timeout: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	dc.mu.Lock()
+	dc.fail = true
+	dc.mu.Unlock()
+	waitFor(t, func() bool { return c.SessionErr() != nil })
+	testy.StatusError(t, "name or password is incorrect", http.StatusUnauthorized, c.SessionErr())
+	if user := c.CurrentUser(); user != "bob" {
+		t.Errorf("Unexpected user: %s", user)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	t.Run("not implemented", func(t *testing.T) {
+		c := &Client{driverClient: &mock.Authenticator{
+			Client: &mock.Client{},
+			AuthenticateFunc: func(context.Context, interface{}) error {
+				return nil
+			},
+		}}
+		if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+		err := c.Logout(context.Background())
+		if user := c.CurrentUser(); user != "" {
+			t.Errorf("Unexpected user: %s", user)
+		}
+		testy.StatusError(t, "kivik: driver does not support logout", http.StatusNotImplemented, err)
+	})
+	t.Run("error", func(t *testing.T) {
+		c := &Client{driverClient: &mock.Deauthenticator{
+			LogoutFunc: func(context.Context) error {
+				return errors.New("logout failed")
+			},
+		}}
+		err := c.Logout(context.Background())
+		testy.StatusError(t, "logout failed", http.StatusInternalServerError, err)
+	})
+	t.Run("success", func(t *testing.T) {
+		dc := &sessionClient{}
+		c := &Client{driverClient: dc}
+		if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob", Timeout: 20 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Logout(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if dc.logouts != 1 {
+			t.Errorf("Unexpected logouts: %d", dc.logouts)
+		}
+		if user := c.CurrentUser(); user != "" {
+			t.Errorf("Unexpected user: %s", user)
+		}
+		if !c.SessionExpiry().IsZero() {
+			t.Errorf("Unexpected expiry: %v", c.SessionExpiry())
+		}
+		time.Sleep(40 * time.Millisecond)
+		if n := dc.loginCount(); n != 1 {
+			t.Errorf("Session renewed %d times after logout", n-1)
+		}
+	})
+	t.Run("during renewal", func(t *testing.T) {
+		dc := &sessionClient{}
+		c := &Client{driverClient: dc}
+		if err := c.Authenticate(context.Background(), &CookieAuth{Username: "bob", Timeout: 20 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		renewing, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		dc.mu.Lock()
+		dc.hook = func() {
+			once.Do(func() { close(renewing) })
+			<-release
+		}
+		dc.mu.Unlock()
+		<-renewing
+		if err := c.Logout(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		close(release)
+		waitFor(t, func() bool { return dc.logoutCount() == 2 })
+		if user := c.CurrentUser(); user != "" {
+			t.Errorf("Session restored by renewal: %s", user)
+		}
+		time.Sleep(40 * time.Millisecond)
+		if n := dc.loginCount(); n != 2 {
+			t.Errorf("Session renewed %d times after logout", n-1)
+		}
+	})
+}
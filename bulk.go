@@ -15,8 +15,12 @@ package kivik
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"golang.org/x/xerrors"
 
 	"github.com/dannyzhou2015/kivik/v4/driver"
 )
@@ -101,20 +105,30 @@ func (r *BulkResults) UpdateErr() error {
 // As with Put, each individual document may be a JSON-marshable object, or a
 // raw JSON string in a json.RawMessage, or io.Reader.
 func (db *DB) BulkDocs(ctx context.Context, docs []interface{}, options ...Options) (*BulkResults, error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+	op := db.op("BulkDocs", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, span.fail(op.wrap(err))
+	}
 	docsi, err := docsInterfaceSlice(docs)
 	if err != nil {
-		return nil, err
+		return nil, span.fail(op.wrap(err))
 	}
 	if len(docsi) == 0 {
-		return nil, &Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: no documents provided")}
+		return nil, span.fail(op.wrap(&Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: no documents provided")}))
 	}
 	opts := mergeOptions(options...)
 	if bulkDocer, ok := db.driverDB.(driver.BulkDocer); ok {
 		bulki, err := bulkDocer.BulkDocs(ctx, docsi, opts)
 		if err != nil {
-			return nil, err
+			return nil, span.fail(op.wrap(err))
 		}
-		return newBulkResults(ctx, bulki), nil
+		results := newBulkResults(ctx, bulki)
+		results.trace(span)
+		return results, nil
 	}
 	var results []driver.BulkResult
 	for _, doc := range docsi {
@@ -122,9 +136,9 @@ func (db *DB) BulkDocs(ctx context.Context, docs []interface{}, options ...Optio
 		var id, rev string
 		if docID, ok := extractDocID(doc); ok {
 			id = docID
-			rev, err = db.Put(ctx, id, doc, opts)
+			rev, err = db.put(ctx, nil, id, doc, opts)
 		} else {
-			id, rev, err = db.CreateDoc(ctx, doc, opts)
+			id, rev, err = db.driverDB.CreateDoc(ctx, doc, opts)
 		}
 		results = append(results, driver.BulkResult{
 			ID:    id,
@@ -132,7 +146,9 @@ func (db *DB) BulkDocs(ctx context.Context, docs []interface{}, options ...Optio
 			Error: err,
 		})
 	}
-	return newBulkResults(ctx, &emulatedBulkResults{results}), nil
+	bulkResults := newBulkResults(ctx, &emulatedBulkResults{results})
+	bulkResults.trace(span)
+	return bulkResults, nil
 }
 
 type emulatedBulkResults struct {
@@ -165,3 +181,139 @@ func docsInterfaceSlice(docsi []interface{}) ([]interface{}, error) {
 	}
 	return docsi, nil
 }
+
+// BulkResult is the result of a single successful document update in a bulk
+// operation.
+type BulkResult struct {
+	ID  string
+	Rev string
+}
+
+// BulkDocError is the failure of a single document in a bulk operation.
+type BulkDocError struct {
+	// ID is the ID of the document which failed, if known.
+	ID string
+	// Err is the error reported for the document.
+	Err error
+}
+
+var (
+	_ error       = &BulkDocError{}
+	_ statusCoder = &BulkDocError{}
+)
+
+func (e *BulkDocError) Error() string {
+	if e.ID == "" {
+		return e.Err.Error()
+	}
+	return e.ID + ": " + e.Err.Error()
+}
+
+// StatusCode returns the HTTP status code of the document's error.
+func (e *BulkDocError) StatusCode() int {
+	return StatusCode(e.Err)
+}
+
+// Unwrap satisfies the Go 1.13 errors.Wrapper interface.
+func (e *BulkDocError) Unwrap() error {
+	return e.Err
+}
+
+// BulkError is returned when one or more documents in a bulk operation
+// failed. Each failure may be examined individually in Failures, or with
+// errors.As or errors.Is, which consider every failure in turn.
+type BulkError struct {
+	// Failures holds one entry for each failed document, in the order the
+	// results were returned.
+	Failures []*BulkDocError
+}
+
+var (
+	_ error       = &BulkError{}
+	_ statusCoder = &BulkError{}
+)
+
+func (e *BulkError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.Error()
+	}
+	noun := "documents"
+	if len(e.Failures) == 1 {
+		noun = "document"
+	}
+	return fmt.Sprintf("kivik: %d %s failed: %s", len(e.Failures), noun, strings.Join(msgs, "; "))
+}
+
+// StatusCode returns the status code shared by all failures, or 207 (Multi
+// Status) if the failures have differing status codes.
+func (e *BulkError) StatusCode() int {
+	status := 0
+	for _, f := range e.Failures {
+		switch code := f.StatusCode(); {
+		case status == 0:
+			status = code
+		case status != code:
+			return http.StatusMultiStatus
+		}
+	}
+	if status == 0 {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// As finds the first failure which matches target, as defined by errors.As.
+func (e *BulkError) As(target interface{}) bool {
+	for _, f := range e.Failures {
+		if xerrors.As(f, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether any failure matches target, as defined by errors.Is.
+func (e *BulkError) Is(target error) bool {
+	for _, f := range e.Failures {
+		if xerrors.Is(f, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectBulkResults drains r, and returns the successful updates. If any
+// document failed, a *BulkError is returned, along with the successful
+// updates. If iteration fails, that error is returned instead. r is closed
+// by this function.
+func CollectBulkResults(r *BulkResults) ([]BulkResult, error) {
+	defer r.Close() // nolint:errcheck
+	var updates []BulkResult
+	var failures []*BulkDocError
+	for r.Next() {
+		if err := r.UpdateErr(); err != nil {
+			failures = append(failures, &BulkDocError{ID: r.ID(), Err: err})
+			continue
+		}
+		updates = append(updates, BulkResult{ID: r.ID(), Rev: r.Rev()})
+	}
+	if err := r.Err(); err != nil {
+		return updates, err
+	}
+	if len(failures) > 0 {
+		return updates, &BulkError{Failures: failures}
+	}
+	return updates, nil
+}
+
+// BulkDocsStrict works like BulkDocs, but reads all of the results. If any
+// document failed, a *BulkError describing each failure is returned, along
+// with the successful updates.
+func (db *DB) BulkDocsStrict(ctx context.Context, docs []interface{}, options ...Options) ([]BulkResult, error) {
+	results, err := db.BulkDocs(ctx, docs, options...)
+	if err != nil {
+		return nil, err
+	}
+	return CollectBulkResults(results)
+}
@@ -418,3 +418,104 @@ func TestBulkResultsGetters(t *testing.T) {
 		})
 	})
 }
+
+func TestBulkDocsStrict(t *testing.T) {
+	type tst struct {
+		dbDriver driver.DB
+		docs     []interface{}
+		expected []BulkResult
+		status   int
+		err      string
+	}
+	tests := testy.NewTable()
+	tests.Add("db error", tst{
+		dbDriver: &mock.BulkDocer{
+			BulkDocsFunc: func(context.Context, []interface{}, map[string]interface{}) (driver.BulkResults, error) {
+				return nil, errors.New("db error")
+			},
+		},
+		docs:   []interface{}{map[string]string{"_id": "foo"}},
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+	tests.Add("all succeed", tst{
+		dbDriver: &mock.BulkDocer{
+			BulkDocsFunc: func(context.Context, []interface{}, map[string]interface{}) (driver.BulkResults, error) {
+				return &emulatedBulkResults{[]driver.BulkResult{
+					{ID: "foo", Rev: "1-xxx"},
+					{ID: "bar", Rev: "2-xxx"},
+				}}, nil
+			},
+		},
+		docs: []interface{}{map[string]string{"_id": "foo"}, map[string]string{"_id": "bar"}},
+		expected: []BulkResult{
+			{ID: "foo", Rev: "1-xxx"},
+			{ID: "bar", Rev: "2-xxx"},
+		},
+	})
+	tests.Add("partial failure", tst{
+		dbDriver: &mock.BulkDocer{
+			BulkDocsFunc: func(context.Context, []interface{}, map[string]interface{}) (driver.BulkResults, error) {
+				return &emulatedBulkResults{[]driver.BulkResult{
+					{ID: "foo", Rev: "1-xxx"},
+					{ID: "bar", Error: &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}},
+					{ID: "baz", Error: &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}},
+				}}, nil
+			},
+		},
+		docs:     []interface{}{map[string]string{"_id": "foo"}, map[string]string{"_id": "bar"}, map[string]string{"_id": "baz"}},
+		expected: []BulkResult{{ID: "foo", Rev: "1-xxx"}},
+		status:   http.StatusConflict,
+		err:      "kivik: 2 documents failed: bar: conflict; baz: conflict",
+	})
+	tests.Add("mixed failures", tst{
+		dbDriver: &mock.BulkDocer{
+			BulkDocsFunc: func(context.Context, []interface{}, map[string]interface{}) (driver.BulkResults, error) {
+				return &emulatedBulkResults{[]driver.BulkResult{
+					{ID: "bar", Error: &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}},
+					{ID: "baz", Error: &Error{HTTPStatus: http.StatusForbidden, Message: "forbidden"}},
+				}}, nil
+			},
+		},
+		docs:   []interface{}{map[string]string{"_id": "bar"}, map[string]string{"_id": "baz"}},
+		status: http.StatusMultiStatus,
+		err:    "kivik: 2 documents failed: bar: conflict; baz: forbidden",
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		db := &DB{driverDB: test.dbDriver}
+		result, err := db.BulkDocsStrict(context.Background(), test.docs)
+		testy.StatusError(t, test.err, test.status, err)
+		if d := testy.DiffInterface(test.expected, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestBulkErrorAs(t *testing.T) {
+	conflict := &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}
+	var err error = &BulkError{Failures: []*BulkDocError{
+		{ID: "foo", Err: errors.New("foo")},
+		{ID: "bar", Err: conflict},
+	}}
+	var docErr *BulkDocError
+	if !errors.As(err, &docErr) {
+		t.Fatal("Expected *BulkDocError")
+	}
+	if docErr.ID != "foo" {
+		t.Errorf("Unexpected ID: %s", docErr.ID)
+	}
+	var kivikErr *Error
+	if !errors.As(err, &kivikErr) {
+		t.Fatal("Expected *Error")
+	}
+	if kivikErr != conflict {
+		t.Errorf("Unexpected error: %v", kivikErr)
+	}
+	if !errors.Is(err, conflict) {
+		t.Error("Expected errors.Is to find conflict")
+	}
+	if errors.Is(err, io.EOF) {
+		t.Error("Did not expect errors.Is to find io.EOF")
+	}
+}
@@ -0,0 +1,217 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package cache provides a Kivik driver wrapper which caches recently fetched
+// documents, for hot, rarely-changing documents such as configuration and
+// reference data.
+//
+// Documents are cached in a bounded LRU, keyed by ID and revision:
+//
+//	d := cache.Register("couch-cached", &couchdb.Couch{}, cache.Config{
+//		Size: 10000,
+//		DBs:  []string{"config"},
+//	})
+//	client, err := kivik.New("couch-cached", dsn)
+//
+// Get, GetRev and BulkGet are served from the cache when possible. A specific
+// revision of a document never changes, so it may be cached indefinitely. The
+// latest revision of a document is only served from the cache while a
+// continuous changes feed for the database is running; the feed is started on
+// the first cache miss, and evicts documents as they change. If the feed
+// fails, the latest revisions cached for the database are discarded, and the
+// feed is restarted by the next cache miss. Writes made through the same
+// client also evict the written documents immediately.
+//
+// Only calls without options, other than "rev" and kivik.OptionIfNoneMatch,
+// are served from the cache, as options such as "attachments" or "conflicts"
+// change the returned document. Documents with inline attachments are never
+// cached. A conditional Get is answered from the cache when the cached
+// revision or ETag matches. Calls made on behalf of an end user, attached with
+// kivik.WithUser, always bypass the cache, as cached documents may not be
+// visible to that user. To bypass the cache for a single call, pass
+// OptionBypass:
+//
+//	row := db.Get(ctx, "settings", kivik.Options{cache.OptionBypass: true})
+package cache
+
+import (
+	"sync/atomic"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// OptionBypass is the option key which, when set to true, causes a single
+// call to bypass the cache. It is never passed to the underlying driver.
+const OptionBypass = "kivik:cache_bypass"
+
+// DefaultSize is the number of documents cached when Config.Size is zero.
+const DefaultSize = 1000
+
+// Config configures a caching driver.
+type Config struct {
+	// Size is the maximum number of cached entries, shared by all clients
+	// and databases. If zero, DefaultSize is used.
+	Size int
+	// DBs limits caching to the named databases. If empty, documents in all
+	// databases are cached.
+	DBs []string
+}
+
+// Stats reports cache activity.
+type Stats struct {
+	// Hits is the number of documents served from the cache.
+	Hits uint64
+	// Misses is the number of cacheable documents fetched from the
+	// underlying driver.
+	Misses uint64
+	// Evictions is the number of entries discarded to stay within the
+	// configured size.
+	Evictions uint64
+	// Invalidations is the number of entries discarded because the document
+	// changed.
+	Invalidations uint64
+	// Entries is the current number of cached entries.
+	Entries int
+}
+
+// Driver is a driver.Driver which returns caching clients.
+type Driver struct {
+	base driver.Driver
+	dbs  map[string]bool
+	lru  *lru
+
+	clients       uint64
+	hits          uint64
+	misses        uint64
+	invalidations uint64
+}
+
+var _ driver.Driver = &Driver{}
+
+// New returns a caching driver which wraps base.
+func New(base driver.Driver, cfg Config) *Driver {
+	size := cfg.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	d := &Driver{
+		base: base,
+		lru:  newLRU(size),
+	}
+	if len(cfg.DBs) > 0 {
+		d.dbs = make(map[string]bool, len(cfg.DBs))
+		for _, db := range cfg.DBs {
+			d.dbs[db] = true
+		}
+	}
+	return d
+}
+
+// Register makes a caching driver, which wraps base, available by the
+// provided name. It panics under the same conditions as kivik.Register.
+func Register(name string, base driver.Driver, cfg Config) *Driver {
+	d := New(base, cfg)
+	kivik.Register(name, d)
+	return d
+}
+
+// NewClient returns a caching client.
+func (d *Driver) NewClient(dsn string, options map[string]interface{}) (driver.Client, error) {
+	base, err := d.base.NewClient(dsn, options)
+	if err != nil {
+		return nil, err
+	}
+	return forward.NewClient(base, d.newClient(base)), nil
+}
+
+func (d *Driver) newClient(base driver.Client) *client {
+	return &client{
+		base:   base,
+		driver: d,
+		id:     atomic.AddUint64(&d.clients, 1),
+		feeds:  make(map[string]*feed),
+	}
+}
+
+// Stats returns a snapshot of cache activity for all clients created by d.
+func (d *Driver) Stats() Stats {
+	entries, evictions := d.lru.stats()
+	return Stats{
+		Hits:          atomic.LoadUint64(&d.hits),
+		Misses:        atomic.LoadUint64(&d.misses),
+		Evictions:     evictions,
+		Invalidations: atomic.LoadUint64(&d.invalidations),
+		Entries:       entries,
+	}
+}
+
+// Purge discards every cached entry.
+func (d *Driver) Purge() {
+	d.lru.removeIf(func(entryKey) bool { return true })
+}
+
+func (d *Driver) cacheable(dbName string) bool {
+	return d.dbs == nil || d.dbs[dbName]
+}
+
+func (d *Driver) hit(n int)  { atomic.AddUint64(&d.hits, uint64(n)) }
+func (d *Driver) miss(n int) { atomic.AddUint64(&d.misses, uint64(n)) }
+
+func (d *Driver) invalidate(fn func(entryKey) bool) {
+	if n := d.lru.removeIf(fn); n > 0 {
+		atomic.AddUint64(&d.invalidations, uint64(n))
+	}
+}
+
+// invalidateKey discards the entry for key, if any.
+func (d *Driver) invalidateKey(key entryKey) {
+	if d.lru.remove(key) > 0 {
+		atomic.AddUint64(&d.invalidations, 1)
+	}
+}
+
+// splitOptions removes OptionBypass from options, without modifying options.
+func splitOptions(options map[string]interface{}) (bypass bool, opts map[string]interface{}) {
+	v, ok := options[OptionBypass]
+	if !ok {
+		return false, options
+	}
+	bypass, _ = v.(bool)
+	opts = make(map[string]interface{}, len(options)-1)
+	for k, v := range options {
+		if k != OptionBypass {
+			opts[k] = v
+		}
+	}
+	return bypass, opts
+}
+
+// cacheRev returns the revision and ETag requested by options, and true if a
+// call with options may be served from the cache.
+func cacheRev(options map[string]interface{}) (rev, ifNoneMatch string, ok bool) {
+	for k, v := range options {
+		switch k {
+		case "rev":
+			rev, ok = v.(string)
+		case driver.OptionIfNoneMatch:
+			ifNoneMatch, ok = v.(string)
+		default:
+			ok = false
+		}
+		if !ok {
+			return "", "", false
+		}
+	}
+	return rev, ifNoneMatch, true
+}
@@ -0,0 +1,444 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// fakeBackend simulates a database, with a changes feed driven by the test.
+type fakeBackend struct {
+	mu       sync.Mutex
+	revs     map[string]int
+	gets     int
+	feeds    int
+	lastOpts map[string]interface{}
+	changes  chan string
+	feedErr  chan error
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		revs:    map[string]int{},
+		changes: make(chan string),
+		feedErr: make(chan error),
+	}
+}
+
+func (b *fakeBackend) doc(id string) (rev string, body string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.revs[id] + 1
+	rev = fmt.Sprintf("%d-x", n)
+	return rev, fmt.Sprintf(`{"_id":%q,"_rev":%q}`, id, rev)
+}
+
+func (b *fakeBackend) update(id string) {
+	b.mu.Lock()
+	b.revs[id]++
+	b.mu.Unlock()
+	b.changes <- id
+}
+
+func (b *fakeBackend) counts() (gets, feeds int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.gets, b.feeds
+}
+
+func (b *fakeBackend) db() driver.DB {
+	return &mock.BulkGetter{
+		DB: &mock.DB{
+			GetFunc: func(_ context.Context, id string, opts map[string]interface{}) (*driver.Document, error) {
+				b.mu.Lock()
+				b.gets++
+				b.lastOpts = opts
+				b.mu.Unlock()
+				rev, body := b.doc(id)
+				if r, ok := opts["rev"].(string); ok {
+					rev, body = r, fmt.Sprintf(`{"_id":%q,"_rev":%q}`, id, r)
+				}
+				return &driver.Document{Rev: rev, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+			},
+			PutFunc: func(context.Context, string, interface{}, map[string]interface{}) (string, error) {
+				return "2-x", nil
+			},
+			ChangesFunc: func(ctx context.Context, opts map[string]interface{}) (driver.Changes, error) {
+				b.mu.Lock()
+				b.feeds++
+				b.mu.Unlock()
+				return &mock.Changes{
+					NextFunc: func(ch *driver.Change) error {
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case err := <-b.feedErr:
+							return err
+						case id := <-b.changes:
+							ch.ID = id
+							return nil
+						}
+					},
+					CloseFunc: func() error { return nil },
+				}, nil
+			},
+		},
+		BulkGetFunc: func(_ context.Context, refs []driver.BulkGetReference, _ map[string]interface{}) (driver.Rows, error) {
+			b.mu.Lock()
+			b.gets++
+			b.mu.Unlock()
+			var i int
+			return &mock.Rows{
+				NextFunc: func(row *driver.Row) error {
+					if i == len(refs) {
+						return io.EOF
+					}
+					_, body := b.doc(refs[i].ID)
+					row.ID = refs[i].ID
+					row.Doc = []byte(body)
+					i++
+					return nil
+				},
+			}, nil
+		},
+	}
+}
+
+func newTestDB(t *testing.T, cfg Config) (*Driver, *fakeBackend, *client, driver.DB) {
+	return newTestDBWith(t, cfg, (*fakeBackend).db)
+}
+
+// newTestDBWith is like newTestDB, but the underlying DB is returned by
+// dbFunc.
+func newTestDBWith(t *testing.T, cfg Config, dbFunc func(*fakeBackend) driver.DB) (*Driver, *fakeBackend, *client, driver.DB) {
+	backend := newFakeBackend()
+	base := &mock.Client{
+		DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+			return dbFunc(backend), nil
+		},
+	}
+	d := New(&mock.Driver{}, cfg)
+	c := d.newClient(base)
+	db, err := forward.NewClient(base, c).DB("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, backend, c, db
+}
+
+func readRev(t *testing.T, doc *driver.Document, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Body.Close() // nolint:errcheck
+	body, err := ioutil.ReadAll(doc.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), doc.Rev) {
+		t.Errorf("Body %s does not match rev %s", body, doc.Rev)
+	}
+	return doc.Rev
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetInvalidatedByChanges(t *testing.T) {
+	d, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		doc, err := db.Get(ctx, "a", map[string]interface{}{})
+		if rev := readRev(t, doc, err); rev != "1-x" {
+			t.Fatalf("Unexpected rev: %s", rev)
+		}
+	}
+	if gets, feeds := backend.counts(); gets != 1 || feeds != 1 {
+		t.Fatalf("Expected 1 get and 1 feed, got %d and %d", gets, feeds)
+	}
+	if s := d.Stats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 2 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+
+	backend.update("a")
+	waitFor(t, func() bool { return d.Stats().Invalidations == 1 })
+	doc, err := db.Get(ctx, "a", nil)
+	if rev := readRev(t, doc, err); rev != "2-x" {
+		t.Errorf("Stale document served: %s", rev)
+	}
+
+	doc, err = db.Get(ctx, "a", map[string]interface{}{"rev": "1-x"})
+	if rev := readRev(t, doc, err); rev != "1-x" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	if gets, _ := backend.counts(); gets != 2 {
+		t.Errorf("Specific revision should remain cached, got %d gets", gets)
+	}
+}
+
+func TestFeedFailure(t *testing.T) {
+	d, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	ctx := context.Background()
+
+	doc, err := db.Get(ctx, "a", nil)
+	readRev(t, doc, err)
+	backend.feedErr <- errors.New("connection reset")
+	waitFor(t, func() bool { return !c.feed("foo").alive() })
+	if s := d.Stats(); s.Entries != 1 {
+		t.Errorf("Latest revision should be discarded, got %d entries", s.Entries)
+	}
+	doc, err = db.Get(ctx, "a", nil)
+	readRev(t, doc, err)
+	if gets, feeds := backend.counts(); gets != 2 || feeds != 2 {
+		t.Errorf("Expected feed restart, got %d gets and %d feeds", gets, feeds)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	d, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	ctx := context.Background()
+	for _, opts := range []map[string]interface{}{
+		{OptionBypass: true},
+		{OptionBypass: true},
+		{"conflicts": true},
+	} {
+		doc, err := db.Get(ctx, "a", opts)
+		readRev(t, doc, err)
+	}
+	backend.mu.Lock()
+	_, leaked := backend.lastOpts[OptionBypass]
+	backend.mu.Unlock()
+	if leaked {
+		t.Error("OptionBypass passed to driver")
+	}
+	if gets, feeds := backend.counts(); gets != 3 || feeds != 0 {
+		t.Errorf("Expected uncached reads, got %d gets and %d feeds", gets, feeds)
+	}
+	if s := d.Stats(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("Bypassed calls should not be counted: %+v", s)
+	}
+}
+
+func TestImpersonationBypass(t *testing.T) {
+	d, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	doc, err := db.Get(context.Background(), "a", nil)
+	readRev(t, doc, err)
+	ctx := driver.ContextWithIdentity(context.Background(), &driver.Identity{Name: "bob"})
+	for i := 0; i < 2; i++ {
+		doc, err := db.Get(ctx, "a", nil)
+		readRev(t, doc, err)
+	}
+	if gets, _ := backend.counts(); gets != 3 {
+		t.Errorf("Expected impersonated reads to bypass the cache, got %d gets", gets)
+	}
+	if s := d.Stats(); s.Hits != 0 || s.Misses != 1 {
+		t.Errorf("Impersonated calls should not be counted: %+v", s)
+	}
+}
+
+func TestDBFilter(t *testing.T) {
+	_, backend, c, db := newTestDB(t, Config{DBs: []string{"bar"}})
+	defer c.Close(context.Background()) // nolint:errcheck
+	for i := 0; i < 2; i++ {
+		doc, err := db.Get(context.Background(), "a", nil)
+		readRev(t, doc, err)
+	}
+	if gets, _ := backend.counts(); gets != 2 {
+		t.Errorf("Database should not be cached, got %d gets", gets)
+	}
+}
+
+func TestGetMeta(t *testing.T) {
+	_, _, c, db := newTestDB(t, Config{})
+	_ = c.Close(context.Background())
+	if _, ok := db.(driver.MetaGetter); ok {
+		t.Error("DB should not implement MetaGetter when the driver does not")
+	}
+
+	_, backend, c, db := newTestDBWith(t, Config{}, func(b *fakeBackend) driver.DB {
+		return &mock.MetaGetter{
+			DB: b.db().(*mock.BulkGetter).DB,
+			GetMetaFunc: func(context.Context, string, map[string]interface{}) (int64, string, error) {
+				return 0, "9-x", nil
+			},
+		}
+	})
+	defer c.Close(context.Background()) // nolint:errcheck
+	metaer := db.(driver.MetaGetter)
+	if _, rev, err := metaer.GetMeta(context.Background(), "a", nil); err != nil || rev != "9-x" {
+		t.Fatalf("Expected GetMeta from the driver, got %s, %v", rev, err)
+	}
+
+	doc, err := db.Get(context.Background(), "a", nil)
+	readRev(t, doc, err)
+	size, rev, err := metaer.GetMeta(context.Background(), "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "1-x" || size != int64(len(`{"_id":"a","_rev":"1-x"}`)) {
+		t.Errorf("Unexpected meta: %d, %s", size, rev)
+	}
+	if gets, _ := backend.counts(); gets != 1 {
+		t.Errorf("Expected 1 get, got %d", gets)
+	}
+}
+
+func TestBulkGet(t *testing.T) {
+	d, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	refs := []driver.BulkGetReference{{ID: "a"}, {ID: "b"}}
+	for i := 0; i < 2; i++ {
+		rows, err := db.(driver.BulkGetter).BulkGet(context.Background(), refs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		row := &driver.Row{}
+		for rows.Next(row) == nil {
+			ids = append(ids, row.ID)
+		}
+		if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+			t.Error(d)
+		}
+	}
+	if gets, _ := backend.counts(); gets != 1 {
+		t.Errorf("Second BulkGet should be cached, got %d gets", gets)
+	}
+	if s := d.Stats(); s.Hits != 2 || s.Misses != 2 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestWriteEvicts(t *testing.T) {
+	_, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	doc, err := db.Get(context.Background(), "a", nil)
+	readRev(t, doc, err)
+	if _, err := db.Put(context.Background(), "a", map[string]string{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = db.Get(context.Background(), "a", nil)
+	readRev(t, doc, err)
+	if gets, _ := backend.counts(); gets != 2 {
+		t.Errorf("Put should evict, got %d gets", gets)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := newLRU(2)
+	for _, id := range []string{"a", "b", "a", "c"} {
+		if _, ok := c.get(entryKey{id: id}); !ok {
+			c.add(entryKey{id: id}, &entry{})
+		}
+	}
+	if _, ok := c.get(entryKey{id: "b"}); ok {
+		t.Error("Least recently used entry should be evicted")
+	}
+	if _, ok := c.get(entryKey{id: "a"}); !ok {
+		t.Error("Recently used entry should be retained")
+	}
+	if entries, evictions := c.stats(); entries != 2 || evictions != 1 {
+		t.Errorf("Unexpected stats: %d entries, %d evictions", entries, evictions)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	_, backend, c, db := newTestDB(t, Config{})
+	defer c.Close(context.Background()) // nolint:errcheck
+	doc, err := db.Get(context.Background(), "a", nil)
+	readRev(t, doc, err)
+	_, err = db.Get(context.Background(), "a", map[string]interface{}{driver.OptionIfNoneMatch: "1-x"})
+	testy.StatusError(t, "Not Modified", http.StatusNotModified, err)
+	doc, err = db.Get(context.Background(), "a", map[string]interface{}{driver.OptionIfNoneMatch: "0-x"})
+	if rev := readRev(t, doc, err); rev != "1-x" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	if gets, _ := backend.counts(); gets != 1 {
+		t.Errorf("Conditional requests should be served from cache, got %d gets", gets)
+	}
+}
+
+func TestEnsureFeedDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	opened := make(chan struct{})
+	changes := func(ctx context.Context) *mock.Changes {
+		return &mock.Changes{
+			NextFunc: func(*driver.Change) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			CloseFunc: func() error { return nil },
+		}
+	}
+	base := &mock.Client{
+		DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+			return &mock.DB{
+				ChangesFunc: func(ctx context.Context, _ map[string]interface{}) (driver.Changes, error) {
+					if name == "slow" {
+						close(opened)
+						<-release
+					}
+					return changes(ctx), nil
+				},
+			}, nil
+		},
+	}
+	c := New(&mock.Driver{}, Config{}).newClient(base)
+	defer c.Close(context.Background()) // nolint:errcheck
+	slow := make(chan *feed)
+	go func() { slow <- c.ensureFeed("slow") }()
+	<-opened
+	done := make(chan *feed)
+	go func() { done <- c.ensureFeed("fast") }()
+	select {
+	case f := <-done:
+		if !f.alive() {
+			t.Error("Expected a running feed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Opening one feed blocked another")
+	}
+	close(release)
+	if f := <-slow; !f.alive() {
+		t.Error("Expected a running feed")
+	}
+}
@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// client routes calls to the underlying client, and keeps the cache
+// consistent with the calls which change the server or the session.
+type client struct {
+	base   driver.Client
+	driver *Driver
+	id     uint64
+
+	mu     sync.Mutex
+	feeds  map[string]*feed
+	closed bool
+}
+
+var _ forward.ClientRouter = &client{}
+
+func (c *client) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.Client) error) error {
+	switch call.Method {
+	case "DestroyDB":
+		// Discard all entries cached for the database.
+		err := fn(ctx, c.base)
+		c.stopFeed(call.DB)
+		c.driver.invalidate(func(key entryKey) bool {
+			return key.client == c.id && key.db == call.DB
+		})
+		return err
+	case "Authenticate", "Logout":
+		// Discard all entries cached by the client, as they may not be
+		// visible to the new user.
+		c.driver.invalidate(func(key entryKey) bool {
+			return key.client == c.id
+		})
+	}
+	return fn(ctx, c.base)
+}
+
+func (c *client) DB(dbName string, options map[string]interface{}) (driver.DB, forward.DBRouter, error) {
+	db, err := c.base.DB(dbName, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, &cachedDB{DB: db, name: dbName, client: c}, nil
+}
+
+// Close stops all changes feeds, before closing the underlying client.
+func (c *client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	feeds := c.feeds
+	c.feeds = make(map[string]*feed)
+	c.closed = true
+	c.mu.Unlock()
+	for _, f := range feeds {
+		f.stop()
+	}
+	c.driver.invalidate(func(key entryKey) bool {
+		return key.client == c.id
+	})
+	if closer, ok := c.base.(driver.ClientCloser); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// cachedDB serves reads from the cache, and evicts documents as they are
+// written. It is the target of the calls in cachedMethods; other calls are
+// routed to the underlying DB.
+type cachedDB struct {
+	driver.DB
+	name   string
+	client *client
+}
+
+var _ forward.DBRouter = &cachedDB{}
+
+// cachedMethods are the methods served by cachedDB.
+var cachedMethods = map[string]bool{
+	"Get":              true,
+	"GetMeta":          true,
+	"BulkGet":          true,
+	"CreateDoc":        true,
+	"Put":              true,
+	"Delete":           true,
+	"PutAttachment":    true,
+	"DeleteAttachment": true,
+	"Purge":            true,
+	"Copy":             true,
+}
+
+func (db *cachedDB) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.DB) error) error {
+	if cachedMethods[call.Method] {
+		return fn(ctx, db)
+	}
+	return fn(ctx, db.DB)
+}
+
+// Close closes the handle. The database's changes feed, which is shared by
+// all handles, keeps running until the client is closed.
+func (db *cachedDB) Close(ctx context.Context) error {
+	if closer, ok := db.DB.(driver.DBCloser); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+func (db *cachedDB) key(docID, rev string) entryKey {
+	return entryKey{client: db.client.id, db: db.name, id: docID, rev: rev}
+}
+
+// lookup returns the cached entry for docID at rev, or at the latest
+// revision if rev is empty.
+func (db *cachedDB) lookup(docID, rev string) (*entry, bool) {
+	if rev == "" && !db.client.feed(db.name).alive() {
+		return nil, false
+	}
+	return db.client.driver.lru.get(db.key(docID, rev))
+}
+
+// store caches body as revision rev of docID. If latest is true, and no
+// change has been seen since seq, it is also cached as the latest revision.
+func (db *cachedDB) store(docID, rev, etag string, body []byte, latest bool, f *feed, seq uint64) {
+	if rev == "" {
+		return
+	}
+	e := &entry{rev: rev, etag: etag, body: body}
+	lru := db.client.driver.lru
+	lru.add(db.key(docID, rev), e)
+	if latest && f.alive() && f.changeSeq() == seq {
+		lru.add(db.key(docID, ""), e)
+	}
+}
+
+// evict discards the latest revision of each of docIDs.
+func (db *cachedDB) evict(docIDs ...string) {
+	for _, id := range docIDs {
+		db.client.driver.lru.remove(db.key(id, ""))
+	}
+}
+
+// cacheable returns the requested revision and ETag, the options to pass to
+// the underlying driver, and whether the call may be served from the cache.
+func (db *cachedDB) cacheable(ctx context.Context, options map[string]interface{}) (rev, ifNoneMatch string, opts map[string]interface{}, ok bool) {
+	bypass, opts := splitOptions(options)
+	if bypass || driver.IdentityFromContext(ctx) != nil || !db.client.driver.cacheable(db.name) {
+		return "", "", opts, false
+	}
+	rev, ifNoneMatch, ok = cacheRev(opts)
+	return rev, ifNoneMatch, opts, ok
+}
+
+// prepare readies a cache fill of the latest revision, by starting the
+// changes feed, and returns the feed and its current sequence.
+func (db *cachedDB) prepare(rev string) (*feed, uint64) {
+	if rev != "" {
+		return nil, 0
+	}
+	f := db.client.ensureFeed(db.name)
+	if f == nil {
+		return nil, 0
+	}
+	return f, f.changeSeq()
+}
+
+func (e *entry) document() *driver.Document {
+	return &driver.Document{
+		ContentLength: int64(len(e.body)),
+		Rev:           e.rev,
+		ETag:          e.etag,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+	}
+}
+
+// Get is served from the cache when possible. A conditional request, made
+// with driver.OptionIfNoneMatch, is answered from the cache when the cached
+// revision or ETag matches.
+func (db *cachedDB) Get(ctx context.Context, docID string, options map[string]interface{}) (*driver.Document, error) {
+	rev, ifNoneMatch, opts, ok := db.cacheable(ctx, options)
+	if !ok {
+		return db.DB.Get(ctx, docID, opts)
+	}
+	d := db.client.driver
+	if e, ok := db.lookup(docID, rev); ok {
+		d.hit(1)
+		if ifNoneMatch != "" && (ifNoneMatch == e.rev || ifNoneMatch == e.etag) {
+			return nil, &kivik.Error{HTTPStatus: http.StatusNotModified, Message: "Not Modified"}
+		}
+		return e.document(), nil
+	}
+	d.miss(1)
+	f, seq := db.prepare(rev)
+	doc, err := db.DB.Get(ctx, docID, opts)
+	if err != nil || doc.Attachments != nil {
+		return doc, err
+	}
+	body, err := ioutil.ReadAll(doc.Body)
+	_ = doc.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{rev: doc.Rev, etag: doc.ETag, body: body}
+	db.store(docID, doc.Rev, doc.ETag, body, rev == "", f, seq)
+	return e.document(), nil
+}
+
+// GetMeta is served from the cache when possible. It is only called when the
+// underlying driver supports GetMeta; otherwise Kivik falls back to Get, which
+// fills the cache.
+func (db *cachedDB) GetMeta(ctx context.Context, docID string, options map[string]interface{}) (int64, string, error) {
+	rev, _, opts, ok := db.cacheable(ctx, options)
+	if ok {
+		if e, ok := db.lookup(docID, rev); ok {
+			db.client.driver.hit(1)
+			return int64(len(e.body)), e.rev, nil
+		}
+	}
+	metaer, ok := db.DB.(driver.MetaGetter)
+	if !ok {
+		return 0, "", forward.NotImplemented("GetMeta")
+	}
+	return metaer.GetMeta(ctx, docID, opts)
+}
+
+// BulkGet is served from the cache when every requested document is cached.
+// Otherwise the request is passed to the underlying driver, and the returned
+// documents are cached as they are read.
+func (db *cachedDB) BulkGet(ctx context.Context, docs []driver.BulkGetReference, options map[string]interface{}) (driver.Rows, error) {
+	bulkGetter, ok := db.DB.(driver.BulkGetter)
+	if !ok {
+		return nil, forward.NotImplemented("BulkGet")
+	}
+	bypass, opts := splitOptions(options)
+	if bypass || len(opts) > 0 || driver.IdentityFromContext(ctx) != nil || !db.client.driver.cacheable(db.name) {
+		return bulkGetter.BulkGet(ctx, docs, opts)
+	}
+	rows := make([]*driver.Row, 0, len(docs))
+	latest := false
+	for _, ref := range docs {
+		if ref.Rev == "" {
+			latest = true
+		}
+		if ref.AttsSince != "" {
+			rows = nil
+			break
+		}
+		e, ok := db.lookup(ref.ID, ref.Rev)
+		if !ok {
+			rows = nil
+			break
+		}
+		rows = append(rows, &driver.Row{ID: ref.ID, Doc: e.body})
+	}
+	d := db.client.driver
+	if rows != nil {
+		d.hit(len(rows))
+		return &cachedRows{rows: rows}, nil
+	}
+	d.miss(len(docs))
+	var f *feed
+	var seq uint64
+	if latest {
+		f, seq = db.prepare("")
+	}
+	result, err := bulkGetter.BulkGet(ctx, docs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &fillRows{Rows: result, db: db, latest: latest, feed: f, seq: seq}, nil
+}
+
+// cachedRows serves BulkGet results from the cache.
+type cachedRows struct {
+	rows []*driver.Row
+}
+
+var _ driver.Rows = &cachedRows{}
+
+func (r *cachedRows) Next(row *driver.Row) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	*row = *r.rows[0]
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (r *cachedRows) Close() error      { return nil }
+func (r *cachedRows) UpdateSeq() string { return "" }
+func (r *cachedRows) Offset() int64     { return 0 }
+func (r *cachedRows) TotalRows() int64  { return 0 }
+
+// fillRows caches documents as they are read from BulkGet results.
+type fillRows struct {
+	driver.Rows
+	db     *cachedDB
+	latest bool
+	feed   *feed
+	seq    uint64
+}
+
+func (r *fillRows) Next(row *driver.Row) error {
+	if err := r.Rows.Next(row); err != nil {
+		return err
+	}
+	if row.Error != nil || len(row.Doc) == 0 {
+		return nil
+	}
+	var meta struct {
+		ID  string `json:"_id"`
+		Rev string `json:"_rev"`
+		// Documents with inline attachments are not cached.
+		Attachments jsoniter.RawMessage `json:"_attachments"`
+	}
+	if err := json.Unmarshal(row.Doc, &meta); err != nil || meta.Attachments != nil {
+		return nil
+	}
+	id := meta.ID
+	if id == "" {
+		id = row.ID
+	}
+	body := make([]byte, len(row.Doc))
+	copy(body, row.Doc)
+	r.db.store(id, meta.Rev, "", body, r.latest, r.feed, r.seq)
+	return nil
+}
+
+func (db *cachedDB) CreateDoc(ctx context.Context, doc interface{}, options map[string]interface{}) (string, string, error) {
+	docID, rev, err := db.DB.CreateDoc(ctx, doc, options)
+	db.evict(docID)
+	return docID, rev, err
+}
+
+func (db *cachedDB) Put(ctx context.Context, docID string, doc interface{}, options map[string]interface{}) (string, error) {
+	defer db.evict(docID)
+	return db.DB.Put(ctx, docID, doc, options)
+}
+
+func (db *cachedDB) Delete(ctx context.Context, docID, rev string, options map[string]interface{}) (string, error) {
+	defer db.evict(docID)
+	return db.DB.Delete(ctx, docID, rev, options)
+}
+
+func (db *cachedDB) PutAttachment(ctx context.Context, docID, rev string, att *driver.Attachment, options map[string]interface{}) (string, error) {
+	defer db.evict(docID)
+	return db.DB.PutAttachment(ctx, docID, rev, att, options)
+}
+
+func (db *cachedDB) DeleteAttachment(ctx context.Context, docID, rev, filename string, options map[string]interface{}) (string, error) {
+	defer db.evict(docID)
+	return db.DB.DeleteAttachment(ctx, docID, rev, filename, options)
+}
+
+// Purge discards every cached revision of the purged documents.
+func (db *cachedDB) Purge(ctx context.Context, docRevMap map[string][]string) (*driver.PurgeResult, error) {
+	purger, ok := db.DB.(driver.Purger)
+	if !ok {
+		return nil, forward.NotImplemented("Purge")
+	}
+	defer db.client.driver.invalidate(func(key entryKey) bool {
+		_, purged := docRevMap[key.id]
+		return purged && key.client == db.client.id && key.db == db.name
+	})
+	return purger.Purge(ctx, docRevMap)
+}
+
+func (db *cachedDB) Copy(ctx context.Context, targetID, sourceID string, options map[string]interface{}) (string, error) {
+	copier, ok := db.DB.(driver.Copier)
+	if !ok {
+		return "", forward.NotImplemented("Copy")
+	}
+	defer db.evict(targetID)
+	return copier.Copy(ctx, targetID, sourceID, options)
+}
@@ -0,0 +1,124 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// feed follows the changes feed of a single database, and invalidates the
+// latest revision of each document as it changes.
+type feed struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// seq is incremented for every change, so that a document fetched
+	// concurrently with a change is not cached.
+	seq  uint64
+	dead int32
+}
+
+func (f *feed) alive() bool {
+	return f != nil && atomic.LoadInt32(&f.dead) == 0
+}
+
+func (f *feed) changeSeq() uint64 {
+	return atomic.LoadUint64(&f.seq)
+}
+
+func (f *feed) stop() {
+	f.cancel()
+	<-f.done
+}
+
+// ensureFeed returns the running feed for dbName, starting it if necessary.
+// It returns nil if the feed cannot be started. The feed is opened without
+// holding c.mu, so that a slow server does not block other databases.
+func (c *client) ensureFeed(dbName string) *feed {
+	if f := c.feed(dbName); f.alive() {
+		return f
+	}
+	db, err := c.base.DB(dbName, nil)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := db.Changes(ctx, map[string]interface{}{
+		"feed":  "continuous",
+		"since": "now",
+	})
+	if err != nil {
+		cancel()
+		closeDB(db)
+		return nil
+	}
+	c.mu.Lock()
+	current := c.feeds[dbName]
+	if c.closed || current.alive() {
+		// The client was closed, or another feed was started, while this
+		// one was being opened.
+		c.mu.Unlock()
+		cancel()
+		_ = changes.Close()
+		closeDB(db)
+		return current
+	}
+	f := &feed{cancel: cancel, done: make(chan struct{})}
+	c.feeds[dbName] = f
+	c.mu.Unlock()
+	go c.follow(dbName, db, f, changes)
+	return f
+}
+
+func (c *client) follow(dbName string, db driver.DB, f *feed, changes driver.Changes) {
+	defer close(f.done)
+	change := &driver.Change{}
+	for {
+		if err := changes.Next(change); err != nil {
+			break
+		}
+		atomic.AddUint64(&f.seq, 1)
+		c.driver.invalidateKey(entryKey{client: c.id, db: dbName, id: change.ID})
+		*change = driver.Change{}
+	}
+	atomic.StoreInt32(&f.dead, 1)
+	_ = changes.Close()
+	closeDB(db)
+	c.driver.invalidate(func(key entryKey) bool {
+		return key.client == c.id && key.db == dbName && key.rev == ""
+	})
+}
+
+func (c *client) feed(dbName string) *feed {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.feeds[dbName]
+}
+
+func (c *client) stopFeed(dbName string) {
+	c.mu.Lock()
+	f := c.feeds[dbName]
+	delete(c.feeds, dbName)
+	c.mu.Unlock()
+	if f != nil {
+		f.stop()
+	}
+}
+
+func closeDB(db driver.DB) {
+	if closer, ok := db.(driver.DBCloser); ok {
+		_ = closer.Close(context.Background())
+	}
+}
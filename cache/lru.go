@@ -0,0 +1,118 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entryKey identifies a cached document. An empty rev identifies the latest
+// known revision of the document, which is invalidated by the changes feed.
+type entryKey struct {
+	client uint64
+	db     string
+	id     string
+	rev    string
+}
+
+type entry struct {
+	rev  string
+	etag string
+	body []byte
+}
+
+type element struct {
+	key   entryKey
+	entry *entry
+}
+
+// lru is a bounded, least-recently-used set of documents.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	list  *list.List
+	items map[entryKey]*list.Element
+
+	evictions uint64
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		list:  list.New(),
+		items: make(map[entryKey]*list.Element),
+	}
+}
+
+func (c *lru) get(key entryKey) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(el)
+	return el.Value.(*element).entry, true
+}
+
+func (c *lru) add(key entryKey, e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*element).entry = e
+		c.list.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.list.PushFront(&element{key: key, entry: e})
+	for c.list.Len() > c.size {
+		oldest := c.list.Back()
+		c.list.Remove(oldest)
+		delete(c.items, oldest.Value.(*element).key)
+		c.evictions++
+	}
+}
+
+// removeIf removes every entry whose key matches fn, and returns the number
+// removed.
+func (c *lru) removeIf(fn func(entryKey) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for key, el := range c.items {
+		if fn(key) {
+			c.list.Remove(el)
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *lru) remove(key entryKey) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return 0
+	}
+	c.list.Remove(el)
+	delete(c.items, key)
+	return 1
+}
+
+func (c *lru) stats() (entries int, evictions uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len(), c.evictions
+}
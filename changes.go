@@ -82,18 +82,30 @@ func (c *Changes) ScanDoc(dest interface{}) error {
 		return err
 	}
 	defer runlock()
-	return json.Unmarshal(c.curVal.(*driver.Change).Doc, dest)
+	return unmarshal(c.curVal.(*driver.Change).Doc, dest)
 }
 
 // Changes returns an iterator over the real-time changes feed. The feed remains
 // open until explicitly closed, or an error is encountered.
 // See http://couchdb.readthedocs.io/en/latest/api/database/changes.html#get--db-_changes
 func (db *DB) Changes(ctx context.Context, options ...Options) (*Changes, error) {
-	changesi, err := db.driverDB.Changes(ctx, mergeOptions(options...))
+	op := db.op("Changes", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, span.fail(op.wrap(err))
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var changesi driver.Changes
+	err := retry.do(ctx, func() (err error) {
+		changesi, err = db.driverDB.Changes(ctx, opts)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, span.fail(op.wrap(err))
 	}
-	return newChanges(ctx, changesi), nil
+	changes := newChanges(ctx, changesi)
+	changes.trace(span)
+	return changes, nil
 }
 
 // Seq returns the Seq of the current result.
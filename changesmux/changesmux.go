@@ -0,0 +1,324 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package changesmux multiplexes database changes feeds, so that many
+// in-process subscribers share a single upstream feed per database.
+//
+//	mux := changesmux.New(client, changesmux.Config{})
+//	defer mux.Close()
+//	sub, err := mux.Subscribe(ctx, "orders", changesmux.Options{
+//		Selector: map[string]interface{}{"status": "pending"},
+//		Policy:   changesmux.Drop,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer sub.Close()
+//	for sub.Next() {
+//		change := sub.Change()
+//		// ...
+//	}
+//
+// The upstream feed is a continuous feed, with include_docs, which is opened
+// when the first subscriber for the database subscribes, and is closed when
+// the last subscriber closes. If the feed fails, it is reopened from the
+// last sequence received, so subscribers see no gap. Each subscriber filters
+// the feed locally, and has its own bounded buffer, with a Policy which
+// determines what happens when the buffer is full.
+package changesmux // import "github.com/dannyzhou2015/kivik/v4/changesmux"
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// DefaultBufferSize is the buffer size used when Options.BufferSize is zero.
+const DefaultBufferSize = 64
+
+// DefaultRetryDelay is the delay used when Config.RetryDelay is zero.
+const DefaultRetryDelay = time.Second
+
+// ErrSlowConsumer is wrapped by the error of a subscription closed by the
+// Disconnect policy.
+var ErrSlowConsumer = errors.New("changesmux: subscriber too slow")
+
+// Config configures a Mux.
+type Config struct {
+	// Options are passed to each upstream DB.Changes call, in addition to
+	// those set by the Mux: feed, since and include_docs. This may be used to
+	// set a heartbeat, for example.
+	Options kivik.Options
+
+	// RetryDelay is the delay before reopening a failed upstream feed. If
+	// zero, DefaultRetryDelay is used.
+	RetryDelay time.Duration
+
+	// OnError, if set, is called with each error which ends an upstream feed.
+	OnError func(dbName string, err error)
+}
+
+// Mux multiplexes the changes feeds of the databases of a client.
+type Mux struct {
+	client *kivik.Client
+	cfg    Config
+
+	mu     sync.Mutex
+	feeds  map[string]*feed
+	closed bool
+}
+
+// New returns a new Mux for client.
+func New(client *kivik.Client, cfg Config) *Mux {
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	return &Mux{
+		client: client,
+		cfg:    cfg,
+		feeds:  make(map[string]*feed),
+	}
+}
+
+// Subscribe subscribes to changes to the database dbName which match opts.
+// The subscription receives changes made after Subscribe returns, and remains
+// open until it is closed, ctx is cancelled, or the Mux is closed.
+//
+// If there is no upstream feed for dbName yet, Subscribe opens it before
+// returning, and returns an error if it cannot be opened.
+func (m *Mux) Subscribe(ctx context.Context, dbName string, opts Options) (*Subscription, error) {
+	filter, err := opts.compile()
+	if err != nil {
+		return nil, err
+	}
+	size := opts.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	sub := &Subscription{
+		filter: filter,
+		policy: opts.Policy,
+		ch:     make(chan *Change, size),
+		done:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, &kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Message: "changesmux: mux closed"}
+	}
+	f, ok := m.feeds[dbName]
+	if !ok {
+		f = m.newFeed(dbName)
+		m.feeds[dbName] = f
+	}
+	sub.feed = f
+	f.subs = append(f.subs, sub)
+	if !ok {
+		go f.run()
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-f.started:
+	case <-ctx.Done():
+		sub.close(ctx.Err())
+		return nil, ctx.Err()
+	}
+	if f.startErr != nil {
+		sub.close(f.startErr)
+		return nil, f.startErr
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.close(ctx.Err())
+		case <-sub.done:
+		}
+	}()
+	return sub, nil
+}
+
+// remove unsubscribes sub, and stops the upstream feed if it was the last
+// subscriber.
+func (m *Mux) remove(sub *Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := sub.feed
+	for i, s := range f.subs {
+		if s == sub {
+			f.subs = append(f.subs[:i:i], f.subs[i+1:]...)
+			break
+		}
+	}
+	if len(f.subs) == 0 && m.feeds[f.db] == f {
+		delete(m.feeds, f.db)
+		f.cancel()
+	}
+}
+
+// discard stops f, and forgets it, so that the next subscriber opens a new
+// feed.
+func (m *Mux) discard(f *feed) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.feeds[f.db] == f {
+		delete(m.feeds, f.db)
+	}
+	f.cancel()
+}
+
+// Close closes all subscriptions and upstream feeds. The Mux may not be used
+// afterwards.
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	var subs []*Subscription
+	for _, f := range m.feeds {
+		subs = append(subs, f.subs...)
+	}
+	m.mu.Unlock()
+	for _, sub := range subs {
+		sub.close(nil)
+	}
+	return nil
+}
+
+// Subscribers returns the number of open subscriptions to dbName.
+func (m *Mux) Subscribers(dbName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.feeds[dbName]; ok {
+		return len(f.subs)
+	}
+	return 0
+}
+
+// feed is the upstream changes feed of one database.
+type feed struct {
+	mux    *Mux
+	db     string
+	ctx    context.Context
+	cancel context.CancelFunc
+	// subs are the subscribers, in the order they subscribed, which is the
+	// order in which changes are delivered.
+	subs []*Subscription
+	// started is closed once the feed is first opened, or has failed to
+	// open, in which case startErr is set.
+	started  chan struct{}
+	startErr error
+}
+
+func (m *Mux) newFeed(dbName string) *feed {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &feed{
+		mux:     m,
+		db:      dbName,
+		ctx:     ctx,
+		cancel:  cancel,
+		started: make(chan struct{}),
+	}
+}
+
+// run opens the upstream feed, and reads it until the feed is cancelled,
+// reopening it after failures. If the feed cannot be opened the first time,
+// run reports the error to the waiting subscribers, and discards the feed.
+func (f *feed) run() {
+	since := "now"
+	changes, err := f.open(since)
+	f.startErr = err
+	close(f.started)
+	if err != nil {
+		f.mux.discard(f)
+		return
+	}
+	for {
+		since, err = f.read(changes, since)
+		changes = nil
+		if f.ctx.Err() != nil {
+			return
+		}
+		if err != nil && f.mux.cfg.OnError != nil {
+			f.mux.cfg.OnError(f.db, err)
+		}
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(f.mux.cfg.RetryDelay):
+		}
+	}
+}
+
+// open opens the upstream feed from since.
+func (f *feed) open(since string) (*kivik.Changes, error) {
+	opts := kivik.Options{}
+	for k, v := range f.mux.cfg.Options {
+		opts[k] = v
+	}
+	opts["feed"] = "continuous"
+	opts["include_docs"] = true
+	opts["since"] = since
+	return f.mux.client.DB(f.db).Changes(f.ctx, opts)
+}
+
+// read reads changes, or the feed opened from since if changes is nil, until
+// it ends, and returns the last sequence received.
+func (f *feed) read(changes *kivik.Changes, since string) (string, error) {
+	if changes == nil {
+		var err error
+		if changes, err = f.open(since); err != nil {
+			return since, err
+		}
+	}
+	defer changes.Close() // nolint: errcheck
+	for changes.Next() {
+		change := &Change{
+			ID:      changes.ID(),
+			Seq:     changes.Seq(),
+			Deleted: changes.Deleted(),
+			Changes: changes.Changes(),
+		}
+		var doc jsoniter.RawMessage
+		if err := changes.ScanDoc(&doc); err == nil && len(doc) > 0 {
+			change.Doc = doc
+		}
+		if change.Seq != "" {
+			since = change.Seq
+		}
+		f.dispatch(change)
+	}
+	if err := changes.Err(); err != nil {
+		return since, err
+	}
+	if last := changes.LastSeq(); last != "" {
+		since = last
+	}
+	return since, nil
+}
+
+func (f *feed) dispatch(change *Change) {
+	f.mux.mu.Lock()
+	subs := f.subs
+	f.mux.mu.Unlock()
+	for _, sub := range subs {
+		if sub.filter(change) {
+			sub.deliver(f.ctx, change)
+		}
+	}
+}
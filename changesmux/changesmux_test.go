@@ -0,0 +1,378 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package changesmux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// upstream simulates the continuous changes feeds of a server.
+type upstream struct {
+	mu      sync.Mutex
+	opens   map[string]int
+	since   []interface{}
+	changes chan *driver.Change
+	fail    chan error
+	closed  chan string
+	// openErr, if set, is returned when a feed is opened.
+	openErr error
+}
+
+func newUpstream() *upstream {
+	return &upstream{
+		opens:   map[string]int{},
+		changes: make(chan *driver.Change),
+		fail:    make(chan error),
+		closed:  make(chan string, 10),
+	}
+}
+
+var (
+	registerOnce sync.Once
+	upstreams    sync.Map
+)
+
+// client returns a client connected to u, which is registered with the test
+// driver under the test's name.
+func (u *upstream) client(t *testing.T) *kivik.Client {
+	registerOnce.Do(func() {
+		kivik.Register("changesmuxtest", &mock.Driver{
+			NewClientFunc: func(dsn string, _ map[string]interface{}) (driver.Client, error) {
+				u, _ := upstreams.Load(dsn)
+				return u.(*upstream).driverClient(), nil
+			},
+		})
+	})
+	upstreams.Store(t.Name(), u)
+	client, err := kivik.New("changesmuxtest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (u *upstream) driverClient() driver.Client {
+	return &mock.Client{
+		DBFunc: func(dbName string, _ map[string]interface{}) (driver.DB, error) {
+			return &mock.DB{
+				ChangesFunc: func(ctx context.Context, opts map[string]interface{}) (driver.Changes, error) {
+					u.mu.Lock()
+					u.opens[dbName]++
+					u.since = append(u.since, opts["since"])
+					openErr := u.openErr
+					u.mu.Unlock()
+					if openErr != nil {
+						return nil, openErr
+					}
+					return &mock.Changes{
+						NextFunc: func(ch *driver.Change) error {
+							select {
+							case <-ctx.Done():
+								return ctx.Err()
+							case err := <-u.fail:
+								return err
+							case c := <-u.changes:
+								*ch = *c
+								return nil
+							}
+						},
+						CloseFunc: func() error {
+							u.closed <- dbName
+							return nil
+						},
+						LastSeqFunc: func() string { return "" },
+					}, nil
+				},
+			}, nil
+		},
+	}
+}
+
+func (u *upstream) send(id string, seq int, doc string) {
+	u.changes <- &driver.Change{ID: id, Seq: fmt.Sprint(seq), Doc: []byte(doc)}
+}
+
+func (u *upstream) openCount(db string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.opens[db]
+}
+
+func next(t *testing.T, sub *Subscription) *Change {
+	t.Helper()
+	result := make(chan bool)
+	go func() { result <- sub.Next() }()
+	select {
+	case ok := <-result:
+		if !ok {
+			t.Fatalf("Subscription closed: %v", sub.Err())
+		}
+		return sub.Change()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for change")
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	u := newUpstream()
+	mux := New(u.client(t), Config{})
+	defer mux.Close() // nolint: errcheck
+	ctx := context.Background()
+
+	all, err := mux.Subscribe(ctx, "db", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := mux.Subscribe(ctx, "db", Options{DocIDs: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := mux.Subscribe(ctx, "db", Options{Prefix: "user:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, err := mux.Subscribe(ctx, "db", Options{Selector: map[string]interface{}{"type": "fruit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.send("a", 1, `{"_id":"a","type":"fruit"}`)
+	u.send("b", 2, `{"_id":"b","type":"veg"}`)
+	u.send("user:c", 3, `{"_id":"user:c"}`)
+
+	got := map[string][]string{}
+	for name, sub := range map[string]*Subscription{"all": all, "ids": ids, "prefix": prefix, "sel": sel} {
+		want := map[string]int{"all": 3, "ids": 1, "prefix": 1, "sel": 1}[name]
+		for i := 0; i < want; i++ {
+			got[name] = append(got[name], next(t, sub).ID)
+		}
+	}
+	want := map[string][]string{
+		"all":    {"a", "b", "user:c"},
+		"ids":    {"b"},
+		"prefix": {"user:c"},
+		"sel":    {"a"},
+	}
+	if d := testy.DiffInterface(want, got); d != nil {
+		t.Error(d)
+	}
+	if n := u.openCount("db"); n != 1 {
+		t.Errorf("Expected one upstream feed, got %d", n)
+	}
+	var doc struct{ Type string }
+	if err := all.Change().ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeOpensFeed(t *testing.T) {
+	u := newUpstream()
+	mux := New(u.client(t), Config{})
+	defer mux.Close() // nolint: errcheck
+	if _, err := mux.Subscribe(context.Background(), "db", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	// The feed is open, so no change made after Subscribe returns is missed.
+	if n := u.openCount("db"); n != 1 {
+		t.Errorf("Expected the upstream feed to be open, got %d opens", n)
+	}
+}
+
+func TestSubscribeOpenError(t *testing.T) {
+	u := newUpstream()
+	u.openErr = &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "db not found"}
+	mux := New(u.client(t), Config{})
+	defer mux.Close() // nolint: errcheck
+	_, err := mux.Subscribe(context.Background(), "db", Options{})
+	if n := mux.Subscribers("db"); n != 0 {
+		t.Errorf("Expected no subscribers, got %d", n)
+	}
+
+	u.mu.Lock()
+	u.openErr = nil
+	u.mu.Unlock()
+	if _, err := mux.Subscribe(context.Background(), "db", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := u.openCount("db"); n != 2 {
+		t.Errorf("Expected the feed to be reopened, got %d opens", n)
+	}
+	testy.StatusError(t, "db not found", http.StatusNotFound, err)
+}
+
+func TestInvalidSelector(t *testing.T) {
+	mux := New(newUpstream().client(t), Config{})
+	_, err := mux.Subscribe(context.Background(), "db", Options{Selector: map[string]interface{}{"$foo": 1}})
+	testy.StatusError(t, "mango: invalid operator: $foo", http.StatusBadRequest, err)
+}
+
+func TestPolicies(t *testing.T) {
+	u := newUpstream()
+	mux := New(u.client(t), Config{})
+	defer mux.Close() // nolint: errcheck
+	ctx := context.Background()
+
+	drop, err := mux.Subscribe(ctx, "db", Options{BufferSize: 1, Policy: Drop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnect, err := mux.Subscribe(ctx, "db", Options{BufferSize: 1, Policy: Disconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := mux.Subscribe(ctx, "db", Options{BufferSize: 1, Policy: Block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.send("a", 1, `{}`)
+	u.send("b", 2, `{}`)
+
+	// The blocking subscriber's full buffer holds up the feed until it
+	// reads.
+	sent := make(chan struct{})
+	go func() {
+		u.send("c", 3, `{}`)
+		close(sent)
+	}()
+	if id := next(t, block).ID; id != "a" {
+		t.Errorf("Unexpected change: %s", id)
+	}
+	<-sent
+	if id := next(t, block).ID; id != "b" {
+		t.Errorf("Unexpected change: %s", id)
+	}
+
+	if id := next(t, block).ID; id != "c" {
+		t.Errorf("Unexpected change: %s", id)
+	}
+
+	// Changes are delivered in subscription order, so c has been dispatched
+	// to the dropping subscriber, which had no room for b or c.
+	if n := drop.Dropped(); n != 2 {
+		t.Errorf("Expected 2 dropped changes, got %d", n)
+	}
+	if id := next(t, drop).ID; id != "a" {
+		t.Errorf("Unexpected change: %s", id)
+	}
+
+	if disconnect.Next() {
+		t.Error("Expected slow subscriber to be disconnected")
+	}
+	err = disconnect.Err()
+	testy.StatusError(t, "changesmux: subscriber too slow", http.StatusServiceUnavailable, err)
+	if !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("Expected ErrSlowConsumer, got %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	u := newUpstream()
+	var errs []error
+	var mu sync.Mutex
+	mux := New(u.client(t), Config{
+		RetryDelay: time.Millisecond,
+		OnError: func(_ string, err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+	defer mux.Close() // nolint: errcheck
+	sub, err := mux.Subscribe(context.Background(), "db", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.send("a", 7, `{}`)
+	next(t, sub)
+	u.fail <- errors.New("connection reset")
+	u.send("b", 8, `{}`)
+	if id := next(t, sub).ID; id != "b" {
+		t.Errorf("Unexpected change: %s", id)
+	}
+	u.mu.Lock()
+	since := u.since
+	u.mu.Unlock()
+	if d := testy.DiffInterface([]interface{}{"now", "7"}, since); d != nil {
+		t.Errorf("Unexpected since values:\n%s", d)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 {
+		t.Errorf("Expected OnError to be called once, got %v", errs)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	u := newUpstream()
+	mux := New(u.client(t), Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	a, err := mux.Subscribe(ctx, "db", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mux.Subscribe(context.Background(), "db", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return u.openCount("db") == 1 })
+	if n := mux.Subscribers("db"); n != 2 {
+		t.Errorf("Expected 2 subscribers, got %d", n)
+	}
+	cancel()
+	if a.Next() {
+		t.Error("Expected cancelled subscription to close")
+	}
+	if err := a.Err(); err != context.Canceled {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return mux.Subscribers("db") == 1 })
+
+	_ = b.Close()
+	if db := <-u.closed; db != "db" {
+		t.Errorf("Unexpected feed closed: %s", db)
+	}
+	if n := mux.Subscribers("db"); n != 0 {
+		t.Errorf("Expected no subscribers, got %d", n)
+	}
+	if err := b.Err(); err != nil {
+		t.Errorf("Unexpected error after Close: %v", err)
+	}
+
+	_ = mux.Close()
+	_, err = mux.Subscribe(context.Background(), "db", Options{})
+	testy.StatusError(t, "changesmux: mux closed", http.StatusServiceUnavailable, err)
+}
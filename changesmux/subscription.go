@@ -0,0 +1,239 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package changesmux
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/mango"
+)
+
+// Policy determines what happens to a change when a subscriber's buffer is
+// full.
+type Policy int
+
+// Slow consumer policies.
+const (
+	// Block waits until the subscriber has room for the change. This delays
+	// delivery to all subscribers of the same database.
+	Block Policy = iota
+	// Drop discards the change for the subscriber. Subscription.Dropped
+	// reports the number of changes discarded.
+	Drop
+	// Disconnect closes the subscription. Its Err method then returns an
+	// error wrapping ErrSlowConsumer.
+	Disconnect
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case Drop:
+		return "drop"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Options configures a subscription. A change must match all of the filters
+// which are set.
+type Options struct {
+	// DocIDs restricts the subscription to the listed documents.
+	DocIDs []string
+	// Prefix restricts the subscription to documents whose IDs begin with
+	// Prefix.
+	Prefix string
+	// Selector restricts the subscription to documents matching a Mango
+	// selector, evaluated locally. Deleted documents are matched against
+	// their tombstones, as with CouchDB's _selector filter.
+	Selector interface{}
+
+	// BufferSize is the number of changes buffered for the subscriber. If
+	// zero, DefaultBufferSize is used.
+	BufferSize int
+	// Policy determines what happens when the buffer is full.
+	Policy Policy
+}
+
+func (o Options) compile() (func(*Change) bool, error) {
+	var ids map[string]struct{}
+	if o.DocIDs != nil {
+		ids = make(map[string]struct{}, len(o.DocIDs))
+		for _, id := range o.DocIDs {
+			ids[id] = struct{}{}
+		}
+	}
+	var sel *mango.Selector
+	if o.Selector != nil {
+		var err error
+		if sel, err = mango.Compile(o.Selector); err != nil {
+			return nil, err
+		}
+	}
+	switch o.Policy {
+	case Block, Drop, Disconnect:
+	default:
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "changesmux: invalid policy"}
+	}
+	return func(c *Change) bool {
+		if ids != nil {
+			if _, ok := ids[c.ID]; !ok {
+				return false
+			}
+		}
+		if !strings.HasPrefix(c.ID, o.Prefix) {
+			return false
+		}
+		if sel != nil {
+			doc := c.decoded()
+			return doc != nil && sel.Match(doc)
+		}
+		return true
+	}, nil
+}
+
+// Change is a single change delivered to a subscriber. Changes are shared
+// between subscribers, and must not be modified.
+type Change struct {
+	// ID is the document ID.
+	ID string
+	// Seq is the update sequence of the change.
+	Seq string
+	// Deleted is true if the change deleted the document.
+	Deleted bool
+	// Changes lists the leaf revisions of the document.
+	Changes []string
+	// Doc is the raw document, or its tombstone for deleted documents.
+	Doc jsoniter.RawMessage
+
+	once sync.Once
+	doc  map[string]interface{}
+}
+
+// decoded returns the decoded document, decoding it at most once for all
+// subscribers.
+func (c *Change) decoded() map[string]interface{} {
+	c.once.Do(func() {
+		if len(c.Doc) > 0 {
+			_ = json.Unmarshal(c.Doc, &c.doc)
+		}
+	})
+	return c.doc
+}
+
+// ScanDoc unmarshals the document into dest.
+func (c *Change) ScanDoc(dest interface{}) error {
+	if len(c.Doc) == 0 {
+		return &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "changesmux: change has no document"}
+	}
+	return json.Unmarshal(c.Doc, dest)
+}
+
+// Subscription is an iterator over the changes delivered to one subscriber.
+type Subscription struct {
+	feed   *feed
+	filter func(*Change) bool
+	policy Policy
+
+	ch        chan *Change
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	dropped   int64
+
+	cur *Change
+}
+
+// deliver delivers change according to the subscription's policy, or
+// abandons it if the subscription or ctx is done first.
+func (s *Subscription) deliver(ctx context.Context, change *Change) {
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- change:
+		case <-s.done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	select {
+	case s.ch <- change:
+	case <-s.done:
+	default:
+		if s.policy == Drop {
+			atomic.AddInt64(&s.dropped, 1)
+			return
+		}
+		s.close(&kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Err: ErrSlowConsumer})
+	}
+}
+
+// Next waits for the next change. It returns false when the subscription is
+// closed, after which Err reports the reason.
+func (s *Subscription) Next() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.cur = <-s.ch:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Change returns the current change.
+func (s *Subscription) Change() *Change {
+	return s.cur
+}
+
+// Err returns the error which closed the subscription, if any. It returns
+// nil if the subscription was closed explicitly.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Dropped returns the number of changes discarded under the Drop policy.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() error {
+	s.close(nil)
+	return nil
+}
+
+func (s *Subscription) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		s.feed.mux.remove(s)
+	})
+}
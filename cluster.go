@@ -24,12 +24,19 @@ var clusterNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Messag
 // ClusterStatus returns the current cluster status.
 //
 // See http://docs.couchdb.org/en/stable/api/server/common.html#cluster-setup
-func (c *Client) ClusterStatus(ctx context.Context, options ...Options) (string, error) {
+func (c *Client) ClusterStatus(ctx context.Context, options ...Options) (status string, err error) {
+	op := c.op("ClusterStatus", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	cluster, ok := c.driverClient.(driver.Cluster)
 	if !ok {
-		return "", clusterNotImplemented
+		return "", op.wrap(clusterNotImplemented)
 	}
-	return cluster.ClusterStatus(ctx, mergeOptions(options...))
+	status, err = cluster.ClusterStatus(ctx, mergeOptions(options...))
+	return status, op.wrap(err)
 }
 
 // ClusterSetup performs the requested cluster action. action should be
@@ -37,12 +44,18 @@ func (c *Client) ClusterStatus(ctx context.Context, options ...Options) (string,
 // object which is marshalable to a JSON object of the expected format.
 //
 // See http://docs.couchdb.org/en/stable/api/server/common.html#post--_cluster_setup
-func (c *Client) ClusterSetup(ctx context.Context, action interface{}) error {
+func (c *Client) ClusterSetup(ctx context.Context, action interface{}) (err error) {
+	op := c.op("ClusterSetup", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
 	cluster, ok := c.driverClient.(driver.Cluster)
 	if !ok {
-		return clusterNotImplemented
+		return op.wrap(clusterNotImplemented)
 	}
-	return cluster.ClusterSetup(ctx, action)
+	return op.wrap(cluster.ClusterSetup(ctx, action))
 }
 
 // ClusterMembership contains the list of known nodes, and cluster nodes, as returned
@@ -55,11 +68,17 @@ type ClusterMembership struct {
 
 // Membership returns a list of known CouchDB nodes.
 // See https://docs.couchdb.org/en/latest/api/server/common.html#get--_membership
-func (c *Client) Membership(ctx context.Context) (*ClusterMembership, error) {
+func (c *Client) Membership(ctx context.Context) (members *ClusterMembership, err error) {
+	op := c.op("Membership", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	cluster, ok := c.driverClient.(driver.Cluster)
 	if !ok {
-		return nil, clusterNotImplemented
+		return nil, op.wrap(clusterNotImplemented)
 	}
 	nodes, err := cluster.Membership(ctx)
-	return (*ClusterMembership)(nodes), err
+	return (*ClusterMembership)(nodes), op.wrap(err)
 }
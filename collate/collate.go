@@ -0,0 +1,327 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package collate compares JSON values according to CouchDB's view collation
+// rules, for sorting and range-filtering view keys on the client.
+//
+// Values of different types sort in the following order:
+//
+//	null < false < true < numbers < strings < arrays < objects
+//
+// Numbers compare numerically. Arrays compare element by element, and a
+// shorter array sorts before a longer array with the same prefix. Objects
+// compare member by member, first by key, then by value, and an object with
+// fewer members sorts first when the others are equal. As in CouchDB, the
+// members of a JSON object are compared in the order they appear in the JSON,
+// so {"b":1,"a":2} sorts after {"a":1}. A Go map has no order, so the members
+// of a map[string]interface{} are compared in the order of their sorted keys;
+// use Object to compare members in a given order.
+//
+// CouchDB compares strings with the ICU collation algorithm. This package
+// approximates it without the ICU tables: whitespace and punctuation sort
+// before digits, which sort before letters; letters compare case
+// insensitively; and lowercase sorts before uppercase only when strings are
+// otherwise equal. Unassigned code points, such as kivik.EndKeySuffix, sort
+// after all letters, so string prefix ranges behave as they do in CouchDB.
+// Accented letters are not folded to their base letters.
+package collate // import "github.com/dannyzhou2015/kivik/v4/collate"
+
+import (
+	ejson "encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Object is a JSON object which retains the order of its members.
+type Object []Member
+
+// Member is a member of an Object.
+type Member struct {
+	Key   string
+	Value interface{}
+}
+
+// Compare compares a and b, returning -1 if a sorts before b, 1 if a sorts
+// after b, and 0 if they are equal.
+//
+// a and b are normally values as decoded from JSON into an interface{}: nil,
+// bool, float64, json.Number, string, []interface{} and
+// map[string]interface{}, or an Object. Raw JSON, as a json.RawMessage, is
+// decoded with the members of objects in their original order. Other Go
+// numeric types compare as numbers, and any other value is compared as if it
+// had been marshaled to JSON and decoded. A value which cannot be marshaled
+// sorts as null.
+func Compare(a, b interface{}) int {
+	a, b = normalize(a), normalize(b)
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return cmpInt(ra, rb)
+	}
+	switch ta := a.(type) {
+	case bool:
+		return 0
+	case float64:
+		tb := b.(float64)
+		switch {
+		case ta < tb:
+			return -1
+		case ta > tb:
+			return 1
+		}
+		return 0
+	case string:
+		return CompareStrings(ta, b.(string))
+	case []interface{}:
+		tb := b.([]interface{})
+		for i := 0; i < len(ta) && i < len(tb); i++ {
+			if c := Compare(ta[i], tb[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(ta), len(tb))
+	case Object:
+		tb := b.(Object)
+		for i := 0; i < len(ta) && i < len(tb); i++ {
+			if c := CompareStrings(ta[i].Key, tb[i].Key); c != 0 {
+				return c
+			}
+			if c := Compare(ta[i].Value, tb[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(ta), len(tb))
+	}
+	return 0
+}
+
+// CompareJSON compares two raw JSON values, such as the keys of view rows.
+// The members of objects are compared in the order they appear. An error is
+// returned if either value is not valid JSON.
+func CompareJSON(a, b jsoniter.RawMessage) (int, error) {
+	va, err := decodeJSON(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := decodeJSON(b)
+	if err != nil {
+		return 0, err
+	}
+	return Compare(va, vb), nil
+}
+
+// Less reports whether a sorts before b. It is a convenience for use with
+// sort.Slice.
+func Less(a, b interface{}) bool {
+	return Compare(a, b) < 0
+}
+
+// Types in collation order. false and true are distinct ranks, so that values
+// of the same rank need no further comparison of booleans.
+const (
+	rankNull = iota
+	rankFalse
+	rankTrue
+	rankNumber
+	rankString
+	rankArray
+	rankObject
+)
+
+func rank(v interface{}) int {
+	switch t := v.(type) {
+	case nil:
+		return rankNull
+	case bool:
+		if t {
+			return rankTrue
+		}
+		return rankFalse
+	case float64:
+		return rankNumber
+	case string:
+		return rankString
+	case []interface{}:
+		return rankArray
+	case Object:
+		return rankObject
+	}
+	return rankNull
+}
+
+// normalize converts v to one of the types produced by decoding JSON into an
+// interface{}. Arrays and objects are normalized lazily, as their elements
+// are compared.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool, float64, string, []interface{}, Object:
+		return v
+	case map[string]interface{}:
+		obj := make(Object, 0, len(t))
+		for _, k := range sortedKeys(t) {
+			obj = append(obj, Member{Key: k, Value: t[k]})
+		}
+		return obj
+	case ejson.Number:
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil
+		}
+		return f
+	case jsoniter.RawMessage:
+		return decode(t)
+	case ejson.RawMessage:
+		return decode(t)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return decode(data)
+}
+
+func decode(data []byte) interface{} {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// decodeJSON decodes data as Unmarshal would into an interface{}, except that
+// objects are decoded as an Object, with their members in order.
+func decodeJSON(data []byte) (interface{}, error) {
+	iter := json.BorrowIterator(data)
+	defer json.ReturnIterator(iter)
+	v := readValue(iter)
+	if iter.Error != nil {
+		return nil, iter.Error
+	}
+	if iter.WhatIsNext(); iter.Error != io.EOF {
+		return nil, errors.New("collate: invalid JSON: trailing data")
+	}
+	return v, nil
+}
+
+func readValue(iter *jsoniter.Iterator) interface{} {
+	switch iter.WhatIsNext() {
+	case jsoniter.ObjectValue:
+		obj := Object{}
+		iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+			obj = append(obj, Member{Key: key, Value: readValue(iter)})
+			return iter.Error == nil
+		})
+		return obj
+	case jsoniter.ArrayValue:
+		arr := []interface{}{}
+		iter.ReadArrayCB(func(iter *jsoniter.Iterator) bool {
+			arr = append(arr, readValue(iter))
+			return iter.Error == nil
+		})
+		return arr
+	}
+	return iter.Read()
+}
+
+// Character classes, in collation order.
+const (
+	classIgnorable = iota
+	classDigit
+	classLetter
+	classOther
+)
+
+func class(r rune) int {
+	switch {
+	case unicode.IsSpace(r), unicode.IsPunct(r), unicode.IsSymbol(r), unicode.IsControl(r):
+		return classIgnorable
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.IsLetter(r), unicode.IsMark(r), unicode.IsNumber(r):
+		return classLetter
+	}
+	return classOther
+}
+
+// CompareStrings compares two strings, approximating ICU collation as
+// described in the package documentation.
+func CompareStrings(a, b string) int {
+	var tie int
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		a, b = a[na:], b[nb:]
+		if ra == rb {
+			continue
+		}
+		if c := cmpInt(class(ra), class(rb)); c != 0 {
+			return c
+		}
+		la, lb := unicode.ToLower(ra), unicode.ToLower(rb)
+		if la != lb {
+			return cmpInt(int(la), int(lb))
+		}
+		if tie == 0 {
+			// Same letter in a different case: lowercase sorts first.
+			if unicode.IsLower(ra) {
+				tie = -1
+			} else {
+				tie = 1
+			}
+		}
+	}
+	switch {
+	case a == "" && b != "":
+		return -1
+	case a != "" && b == "":
+		return 1
+	}
+	return tie
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return CompareStrings(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
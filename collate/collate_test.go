@@ -0,0 +1,136 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package collate
+
+import (
+	ejson "encoding/json"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"gitlab.com/flimzy/testy"
+)
+
+func TestCompareOrder(t *testing.T) {
+	// In collation order, following the examples in the CouchDB
+	// documentation.
+	ordered := []interface{}{
+		nil,
+		false,
+		true,
+		-1.0,
+		1.0,
+		2.0,
+		"~",
+		"1",
+		"a",
+		"A",
+		"aa",
+		"b",
+		"B",
+		"ba",
+		"foo",
+		"foo\ufff0",
+		[]interface{}{},
+		[]interface{}{"a"},
+		[]interface{}{"a", 1.0},
+		[]interface{}{"b"},
+		map[string]interface{}{},
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"a": 2.0},
+		map[string]interface{}{"b": 1.0},
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := cmpInt(i, j)
+			if got := Compare(a, b); got != want {
+				t.Errorf("Compare(%#v, %#v) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	type tt struct {
+		a, b interface{}
+		want int
+	}
+
+	tests := testy.NewTable()
+	tests.Add("ints and floats", tt{a: 2, b: 1.5, want: 1})
+	tests.Add("uint", tt{a: uint8(3), b: int64(3), want: 0})
+	tests.Add("json.Number", tt{a: ejson.Number("10"), b: 9.5, want: 1})
+	tests.Add("raw message", tt{a: ejson.RawMessage(`["a"]`), b: []interface{}{"a"}, want: 0})
+	tests.Add("jsoniter raw message", tt{a: jsoniter.RawMessage(`"b"`), b: "a", want: 1})
+	tests.Add("string slice", tt{a: []string{"a", "b"}, b: []interface{}{"a", "c"}, want: -1})
+	tests.Add("struct", tt{a: struct{ A int }{A: 1}, b: map[string]interface{}{"A": 1.0}, want: 0})
+	tests.Add("prefix suffix", tt{a: "foobar", b: "foo\ufff0", want: -1})
+	tests.Add("case tie breaks late", tt{a: "Ab", b: "ab", want: 1})
+	tests.Add("case insensitive first", tt{a: "Ab", b: "ac", want: -1})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("Compare(%v, %v) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	})
+}
+
+func TestCompareJSON(t *testing.T) {
+	c, err := CompareJSON(jsoniter.RawMessage(`[1,"a"]`), jsoniter.RawMessage(`[1,"B"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != -1 {
+		t.Errorf("Unexpected result: %d", c)
+	}
+	for _, invalid := range []string{`[`, `1 2`, ``, `{"a":}`} {
+		if _, err := CompareJSON(jsoniter.RawMessage(invalid), jsoniter.RawMessage(`1`)); err == nil {
+			t.Errorf("Expected error for invalid JSON %q", invalid)
+		}
+	}
+}
+
+func TestCompareJSONObjectOrder(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: `{"b":1,"a":2}`, b: `{"a":1}`, want: 1},
+		{a: `{"a":1,"b":2}`, b: `{"b":1,"a":2}`, want: -1},
+		{a: `{"a":1,"b":2}`, b: `{"a":1,"b":2}`, want: 0},
+		{a: `{"a":1}`, b: `{"a":1,"b":2}`, want: -1},
+		{a: `[{"b":1,"a":2}]`, b: `[{"a":3}]`, want: 1},
+		{a: `{"":1}`, b: `{"a":1}`, want: -1},
+	}
+	for _, test := range tests {
+		got, err := CompareJSON(jsoniter.RawMessage(test.a), jsoniter.RawMessage(test.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("CompareJSON(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := Compare(ejson.RawMessage(test.a), ejson.RawMessage(test.b)); got != test.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+	// A map has no order, so its members are compared in key order.
+	if got := Compare(map[string]interface{}{"b": 1, "a": 2}, ejson.RawMessage(`{"a":1}`)); got != 1 {
+		t.Errorf("Unexpected map comparison: %d", got)
+	}
+	if got := Compare(Object{{Key: "b", Value: 1}}, map[string]interface{}{"a": 1, "b": 1}); got != 1 {
+		t.Errorf("Unexpected Object comparison: %d", got)
+	}
+}
@@ -0,0 +1,135 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package collate
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dannyzhou2015/kivik/v4/errors"
+)
+
+// Range is a range of view keys, as selected by the key, startkey, endkey,
+// inclusive_end and descending query options.
+//
+// As in CouchDB, StartKey and EndKey are given in the direction of the query,
+// so for a descending range, StartKey is the greater of the two. To select
+// all string keys with a given prefix, append kivik.EndKeySuffix to the
+// prefix to form the EndKey of an ascending range.
+type Range struct {
+	// StartKey is the first key in the range. It is only used if
+	// HasStartKey is true, as nil is a valid (null) key.
+	StartKey    interface{}
+	HasStartKey bool
+	// EndKey is the last key in the range. It is only used if HasEndKey is
+	// true.
+	EndKey    interface{}
+	HasEndKey bool
+	// ExclusiveEnd excludes keys equal to EndKey from the range. It
+	// corresponds to inclusive_end=false.
+	ExclusiveEnd bool
+	// Descending reverses the collation order.
+	Descending bool
+}
+
+// ParseRange returns the Range selected by options, which are the options of
+// a view query. Options which do not affect the key range are ignored.
+//
+// Keys are Go values, as accepted by Compare. To pass a key already encoded as
+// JSON, as the CouchDB driver accepts for string values, wrap it in a
+// json.RawMessage. Keys are normalized as Compare normalizes its arguments,
+// so an object key is stored as an Object.
+func ParseRange(options map[string]interface{}) (*Range, error) {
+	r := &Range{}
+	if v, ok := options["startkey"]; ok {
+		r.StartKey, r.HasStartKey = v, true
+	}
+	if v, ok := options["start_key"]; ok {
+		r.StartKey, r.HasStartKey = v, true
+	}
+	if v, ok := options["endkey"]; ok {
+		r.EndKey, r.HasEndKey = v, true
+	}
+	if v, ok := options["end_key"]; ok {
+		r.EndKey, r.HasEndKey = v, true
+	}
+	if v, ok := options["key"]; ok {
+		r.StartKey, r.HasStartKey = v, true
+		r.EndKey, r.HasEndKey = v, true
+	}
+	if v, ok := options["inclusive_end"]; ok {
+		inclusive, err := parseBool("inclusive_end", v)
+		if err != nil {
+			return nil, err
+		}
+		r.ExclusiveEnd = !inclusive
+	}
+	if v, ok := options["descending"]; ok {
+		var err error
+		if r.Descending, err = parseBool("descending", v); err != nil {
+			return nil, err
+		}
+	}
+	r.StartKey, r.EndKey = normalize(r.StartKey), normalize(r.EndKey)
+	return r, nil
+}
+
+func parseBool(name string, v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		if b, err := strconv.ParseBool(t); err == nil {
+			return b, nil
+		}
+	}
+	return false, errors.Status(http.StatusBadRequest, fmt.Sprintf("collate: invalid value for %s: %v", name, v))
+}
+
+// Compare compares a and b in the direction of the range.
+func (r *Range) Compare(a, b interface{}) int {
+	if r.Descending {
+		return Compare(b, a)
+	}
+	return Compare(a, b)
+}
+
+// Before reports whether key sorts before the start of the range.
+func (r *Range) Before(key interface{}) bool {
+	return r.HasStartKey && r.Compare(key, r.StartKey) < 0
+}
+
+// After reports whether key sorts after the end of the range.
+func (r *Range) After(key interface{}) bool {
+	if !r.HasEndKey {
+		return false
+	}
+	c := r.Compare(key, r.EndKey)
+	return c > 0 || c == 0 && r.ExclusiveEnd
+}
+
+// Contains reports whether key is within the range.
+func (r *Range) Contains(key interface{}) bool {
+	return !r.Before(key) && !r.After(key)
+}
+
+// Empty reports whether the range can contain no keys, because its end
+// precedes its start. CouchDB rejects queries with such ranges.
+func (r *Range) Empty() bool {
+	if !r.HasStartKey || !r.HasEndKey {
+		return false
+	}
+	c := r.Compare(r.StartKey, r.EndKey)
+	return c > 0 || c == 0 && r.ExclusiveEnd
+}
@@ -0,0 +1,100 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package collate
+
+import (
+	ejson "encoding/json"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestRange(t *testing.T) {
+	type tt struct {
+		options map[string]interface{}
+		status  int
+		err     string
+		in, out []interface{}
+		empty   bool
+	}
+
+	tests := testy.NewTable()
+	tests.Add("unbounded", tt{
+		options: map[string]interface{}{},
+		in:      []interface{}{nil, "a", map[string]interface{}{}},
+	})
+	tests.Add("inclusive", tt{
+		options: map[string]interface{}{"startkey": "b", "endkey": "d"},
+		in:      []interface{}{"b", "B", "c", "d"},
+		out:     []interface{}{"a", "D", 1.0, "da", []interface{}{}},
+	})
+	tests.Add("exclusive end", tt{
+		options: map[string]interface{}{"startkey": "b", "endkey": "d", "inclusive_end": false},
+		in:      []interface{}{"b", "c"},
+		out:     []interface{}{"d", "D"},
+	})
+	tests.Add("exclusive end string", tt{
+		options: map[string]interface{}{"endkey": "d", "inclusive_end": "false"},
+		in:      []interface{}{"c"},
+		out:     []interface{}{"d"},
+	})
+	tests.Add("prefix", tt{
+		options: map[string]interface{}{"start_key": "foo", "end_key": "foo\ufff0"},
+		in:      []interface{}{"foo", "foobar", "FOOBAR", "foo~"},
+		out:     []interface{}{"fo", "fop", []interface{}{"foo"}},
+	})
+	tests.Add("array prefix", tt{
+		options: map[string]interface{}{"startkey": []interface{}{"a"}, "endkey": []interface{}{"a", map[string]interface{}{}}},
+		in:      []interface{}{[]interface{}{"a"}, []interface{}{"a", 1.0}, []interface{}{"a", "z"}},
+		out:     []interface{}{[]interface{}{"b"}, "a"},
+	})
+	tests.Add("descending", tt{
+		options: map[string]interface{}{"startkey": 10, "endkey": 5, "descending": true, "inclusive_end": false},
+		in:      []interface{}{10.0, 6.0},
+		out:     []interface{}{5.0, 11.0},
+	})
+	tests.Add("key", tt{
+		options: map[string]interface{}{"key": ejson.RawMessage(`[1,2]`)},
+		in:      []interface{}{[]interface{}{1.0, 2.0}},
+		out:     []interface{}{[]interface{}{1.0}},
+	})
+	tests.Add("empty", tt{
+		options: map[string]interface{}{"startkey": "b", "endkey": "a"},
+		out:     []interface{}{"a", "b"},
+		empty:   true,
+	})
+	tests.Add("invalid descending", tt{
+		options: map[string]interface{}{"descending": 1},
+		status:  http.StatusBadRequest,
+		err:     "collate: invalid value for descending: 1",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		r, err := ParseRange(tt.options)
+		testy.StatusError(t, tt.err, tt.status, err)
+		for _, key := range tt.in {
+			if !r.Contains(key) {
+				t.Errorf("Expected range to contain %#v", key)
+			}
+		}
+		for _, key := range tt.out {
+			if r.Contains(key) {
+				t.Errorf("Expected range not to contain %#v", key)
+			}
+		}
+		if empty := r.Empty(); empty != tt.empty {
+			t.Errorf("Unexpected Empty(): %v", empty)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// compactionPoll is the backoff used while polling for the completion of a
+// compaction.
+var compactionPoll = &RetryPolicy{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     1.5,
+}
+
+// compactionStartGrace is how long a compaction is waited for to be reported
+// running. CouchDB accepts a compaction request before the compaction starts.
+var compactionStartGrace = 5 * time.Second
+
+// DesignDocInfo contains information about the view index of a design
+// document.
+//
+// See https://docs.couchdb.org/en/stable/api/ddoc/common.html#get--db-_design-ddoc-_info
+type DesignDocInfo struct {
+	// Name is the name of the design document, without the _design/ prefix.
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Language  string `json:"language"`
+	// CompactRunning is true if the view index is being compacted.
+	CompactRunning bool `json:"compact_running"`
+	// UpdaterRunning is true if the view index is being updated.
+	UpdaterRunning bool `json:"updater_running"`
+	WaitingClients int  `json:"waiting_clients"`
+	// DiskSize is the number of bytes used on-disk by the view index.
+	DiskSize int64 `json:"-"`
+	// ActiveSize is the number of bytes used on-disk by live data in the
+	// view index.
+	ActiveSize int64 `json:"-"`
+	// ExternalSize is the uncompressed size of the view index data.
+	ExternalSize int64 `json:"-"`
+}
+
+// Fragmentation returns the fraction, between 0 and 1, of the database file
+// which would be reclaimed by compaction.
+func (s *DBStats) Fragmentation() float64 {
+	return fragmentation(s.DiskSize, s.ActiveSize)
+}
+
+// Fragmentation returns the fraction, between 0 and 1, of the view index file
+// which would be reclaimed by compaction.
+func (i *DesignDocInfo) Fragmentation() float64 {
+	return fragmentation(i.DiskSize, i.ActiveSize)
+}
+
+func fragmentation(diskSize, activeSize int64) float64 {
+	if diskSize <= 0 || activeSize >= diskSize {
+		return 0
+	}
+	if activeSize < 0 {
+		activeSize = 0
+	}
+	return float64(diskSize-activeSize) / float64(diskSize)
+}
+
+// DesignDocInfo returns information about the view index of the design
+// document ddocID. As with CompactView, ddocID is the name of the design
+// document, without the _design/ prefix.
+//
+// See https://docs.couchdb.org/en/stable/api/ddoc/common.html#get--db-_design-ddoc-_info
+func (db *DB) DesignDocInfo(ctx context.Context, ddocID string) (info *DesignDocInfo, err error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+	op := db.op("DesignDocInfo", ddocID, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	if ddocID == "" {
+		return nil, op.wrap(missingArg("ddocID"))
+	}
+	infoer, ok := db.driverDB.(driver.DesignDocInfoer)
+	if !ok {
+		return nil, op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: design doc info not supported by driver"})
+	}
+	var i *driver.DesignDocInfo
+	err = db.retry.do(ctx, func() (err error) {
+		i, err = infoer.DesignDocInfo(ctx, ddocID)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	return &DesignDocInfo{
+		Name:           i.Name,
+		Signature:      i.Signature,
+		Language:       i.Language,
+		CompactRunning: i.CompactRunning,
+		UpdaterRunning: i.UpdaterRunning,
+		WaitingClients: i.WaitingClients,
+		DiskSize:       i.DiskSize,
+		ActiveSize:     i.ActiveSize,
+		ExternalSize:   i.ExternalSize,
+	}, nil
+}
+
+// WaitForCompaction blocks until the database is no longer being compacted,
+// polling Stats with increasing delays. Retryable errors from Stats are
+// ignored.
+//
+// CouchDB starts a compaction after Compact returns, so a compaction which is
+// not yet reported running is waited for, for up to five seconds. If none is
+// seen running in that time, WaitForCompaction returns nil.
+func (db *DB) WaitForCompaction(ctx context.Context) error {
+	return waitForCompaction(ctx, func() (bool, error) {
+		stats, err := db.Stats(ctx)
+		if err != nil {
+			return false, err
+		}
+		return stats.CompactRunning, nil
+	})
+}
+
+// WaitForViewCompaction blocks until the view index of the design document
+// ddocID is no longer being compacted, polling DesignDocInfo with increasing
+// delays. Retryable errors from DesignDocInfo are ignored. As with
+// WaitForCompaction, a compaction which is not yet reported running is waited
+// for, for up to five seconds.
+func (db *DB) WaitForViewCompaction(ctx context.Context, ddocID string) error {
+	return waitForCompaction(ctx, func() (bool, error) {
+		info, err := db.DesignDocInfo(ctx, ddocID)
+		if err != nil {
+			return false, err
+		}
+		return info.CompactRunning, nil
+	})
+}
+
+func waitForCompaction(ctx context.Context, running func() (bool, error)) error {
+	start := time.Now()
+	var started bool
+	for attempt := 1; ; attempt++ {
+		isRunning, err := running()
+		switch {
+		case err == nil:
+			if isRunning {
+				started = true
+			} else if started || time.Since(start) >= compactionStartGrace {
+				return nil
+			}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !IsRetryable(err):
+			return err
+		}
+		timer := time.NewTimer(compactionPoll.backoff(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestFragmentation(t *testing.T) {
+	tests := []struct {
+		disk, active int64
+		want         float64
+	}{
+		{disk: 0, active: 0, want: 0},
+		{disk: 100, active: 100, want: 0},
+		{disk: 100, active: 150, want: 0},
+		{disk: 100, active: 25, want: 0.75},
+		{disk: 100, active: 0, want: 1},
+	}
+	for _, test := range tests {
+		if got := (&DBStats{DiskSize: test.disk, ActiveSize: test.active}).Fragmentation(); got != test.want {
+			t.Errorf("DBStats %d/%d: expected %v, got %v", test.disk, test.active, test.want, got)
+		}
+		if got := (&DesignDocInfo{DiskSize: test.disk, ActiveSize: test.active}).Fragmentation(); got != test.want {
+			t.Errorf("DesignDocInfo %d/%d: expected %v, got %v", test.disk, test.active, test.want, got)
+		}
+	}
+}
+
+func TestDesignDocInfo(t *testing.T) {
+	type tt struct {
+		db     driver.DB
+		ddocID string
+		want   *DesignDocInfo
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("missing ddoc", tt{
+		db:     &mock.DesignDocInfoer{},
+		status: http.StatusBadRequest,
+		err:    "kivik: ddocID required",
+	})
+	tests.Add("not implemented", tt{
+		db:     &mock.DB{},
+		ddocID: "app",
+		status: http.StatusNotImplemented,
+		err:    "kivik: design doc info not supported by driver",
+	})
+	tests.Add("db error", tt{
+		db: &mock.DesignDocInfoer{
+			DesignDocInfoFunc: func(context.Context, string) (*driver.DesignDocInfo, error) {
+				return nil, errors.New("db error")
+			},
+		},
+		ddocID: "app",
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+	tests.Add("success", tt{
+		db: &mock.DesignDocInfoer{
+			DesignDocInfoFunc: func(_ context.Context, ddocID string) (*driver.DesignDocInfo, error) {
+				return &driver.DesignDocInfo{
+					Name:           ddocID,
+					Signature:      "abc",
+					Language:       "javascript",
+					CompactRunning: true,
+					DiskSize:       400,
+					ActiveSize:     100,
+					ExternalSize:   50,
+				}, nil
+			},
+		},
+		ddocID: "app",
+		want: &DesignDocInfo{
+			Name:           "app",
+			Signature:      "abc",
+			Language:       "javascript",
+			CompactRunning: true,
+			DiskSize:       400,
+			ActiveSize:     100,
+			ExternalSize:   50,
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db := &DB{driverDB: tt.db}
+		got, err := db.DesignDocInfo(context.Background(), tt.ddocID)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestWaitForCompaction(t *testing.T) {
+	poll, grace := compactionPoll, compactionStartGrace
+	compactionPoll = &RetryPolicy{InitialBackoff: time.Millisecond}
+	compactionStartGrace = 20 * time.Millisecond
+	defer func() { compactionPoll, compactionStartGrace = poll, grace }()
+
+	type step struct {
+		running bool
+		err     error
+	}
+	steps := func(s ...step) func() (bool, error) {
+		return func() (bool, error) {
+			next := s[0]
+			if len(s) > 1 {
+				s = s[1:]
+			}
+			return next.running, next.err
+		}
+	}
+
+	t.Run("database", func(t *testing.T) {
+		var calls int
+		next := steps(
+			step{running: true},
+			step{err: &Error{HTTPStatus: http.StatusServiceUnavailable}},
+			step{running: true},
+			step{},
+		)
+		db := &DB{driverDB: &mock.DB{
+			StatsFunc: func(context.Context) (*driver.DBStats, error) {
+				calls++
+				running, err := next()
+				if err != nil {
+					return nil, err
+				}
+				return &driver.DBStats{CompactRunning: running}, nil
+			},
+		}}
+		if err := db.WaitForCompaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 4 {
+			t.Errorf("Unexpected number of polls: %d", calls)
+		}
+	})
+	t.Run("not yet started", func(t *testing.T) {
+		var calls int
+		next := steps(
+			step{},
+			step{running: true},
+			step{},
+		)
+		db := &DB{driverDB: &mock.DB{
+			StatsFunc: func(context.Context) (*driver.DBStats, error) {
+				calls++
+				running, _ := next()
+				return &driver.DBStats{CompactRunning: running}, nil
+			},
+		}}
+		if err := db.WaitForCompaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Errorf("Unexpected number of polls: %d", calls)
+		}
+	})
+	t.Run("never started", func(t *testing.T) {
+		db := &DB{driverDB: &mock.DB{
+			StatsFunc: func(context.Context) (*driver.DBStats, error) {
+				return &driver.DBStats{}, nil
+			},
+		}}
+		start := time.Now()
+		if err := db.WaitForCompaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < compactionStartGrace {
+			t.Errorf("Returned after %v, before the grace period", elapsed)
+		}
+	})
+	t.Run("view error", func(t *testing.T) {
+		next := steps(
+			step{running: true},
+			step{err: &Error{HTTPStatus: http.StatusNotFound, Message: "missing"}},
+		)
+		db := &DB{driverDB: &mock.DesignDocInfoer{
+			DesignDocInfoFunc: func(context.Context, string) (*driver.DesignDocInfo, error) {
+				running, err := next()
+				if err != nil {
+					return nil, err
+				}
+				return &driver.DesignDocInfo{CompactRunning: running}, nil
+			},
+		}}
+		err := db.WaitForViewCompaction(context.Background(), "app")
+		testy.StatusError(t, "missing", http.StatusNotFound, err)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		db := &DB{driverDB: &mock.DB{
+			StatsFunc: func(context.Context) (*driver.DBStats, error) {
+				return &driver.DBStats{CompactRunning: true}, nil
+			},
+		}}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := db.WaitForCompaction(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,333 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package compactor compacts the databases and view indexes whose
+// fragmentation exceeds a threshold, optionally only within daily time
+// windows, and reports the disk space reclaimed.
+//
+// A compaction pass may be run on demand:
+//
+//	c := compactor.New(client, compactor.Config{Views: true})
+//	report, err := c.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	log.Printf("reclaimed %d bytes", report.Reclaimed())
+//
+// or repeatedly, with Schedule.
+package compactor
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// DefaultThreshold is the fragmentation threshold used when Config.Threshold
+// is zero. A database with a fragmentation of 0.5 uses twice the disk space
+// of its live data.
+const DefaultThreshold = 0.5
+
+// Config configures a Compactor.
+type Config struct {
+	// DBs lists the databases to consider for compaction. If empty, all
+	// databases are considered.
+	DBs []string
+
+	// Threshold is the fragmentation, between 0 and 1, at or above which a
+	// database is compacted. Fragmentation is the fraction of the file size
+	// not used by live data. If zero, DefaultThreshold is used.
+	Threshold float64
+
+	// Views enables the compaction of view indexes. ViewThreshold is the
+	// fragmentation at or above which a view index is compacted. If zero,
+	// Threshold is used.
+	Views         bool
+	ViewThreshold float64
+
+	// MinSize is the file size, in bytes, below which databases and view
+	// indexes are never compacted.
+	MinSize int64
+
+	// Windows, if set, restricts compaction to the daily periods they
+	// describe, in local time. A compaction is only started within a window,
+	// but one already running is allowed to finish after the window ends.
+	Windows []Window
+}
+
+// Result describes the compaction of a database or view index.
+type Result struct {
+	DB string
+	// DDoc is the name of the design document, whose view index was
+	// compacted, or empty for a database.
+	DDoc string
+	// Fragmentation is the fragmentation before compaction.
+	Fragmentation float64
+	// SizeBefore and SizeAfter are the file sizes before and after
+	// compaction.
+	SizeBefore int64
+	SizeAfter  int64
+	// Err is the error, if any, which prevented compaction.
+	Err error
+}
+
+// Reclaimed returns the number of bytes reclaimed by the compaction.
+func (r Result) Reclaimed() int64 {
+	if r.Err != nil || r.SizeAfter >= r.SizeBefore {
+		return 0
+	}
+	return r.SizeBefore - r.SizeAfter
+}
+
+// Report summarizes a compaction pass.
+type Report struct {
+	// Results lists the compactions attempted, in order.
+	Results []Result
+	// Skipped is the number of databases and view indexes checked, and found
+	// to be below the threshold.
+	Skipped int
+	// Deferred is the number of databases and view indexes which exceeded
+	// the threshold, but were not compacted because the pass ended outside
+	// of the configured windows.
+	Deferred int
+}
+
+// Reclaimed returns the total number of bytes reclaimed by the pass.
+func (r *Report) Reclaimed() int64 {
+	var total int64
+	for _, result := range r.Results {
+		total += result.Reclaimed()
+	}
+	return total
+}
+
+// Compactor compacts fragmented databases and view indexes.
+type Compactor struct {
+	client *kivik.Client
+	cfg    Config
+	now    func() time.Time
+}
+
+// New returns a new Compactor, which compacts databases of client according
+// to cfg.
+func New(client *kivik.Client, cfg Config) *Compactor {
+	if cfg.Threshold == 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.ViewThreshold == 0 {
+		cfg.ViewThreshold = cfg.Threshold
+	}
+	return &Compactor{
+		client: client,
+		cfg:    cfg,
+		now:    time.Now,
+	}
+}
+
+func (c *Compactor) inWindow() bool {
+	if len(c.cfg.Windows) == 0 {
+		return true
+	}
+	now := c.now()
+	for _, w := range c.cfg.Windows {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidate is a database or view index which exceeds the threshold.
+type candidate struct {
+	db            *kivik.DB
+	ddoc          string
+	fragmentation float64
+	size          int64
+}
+
+func (c *Compactor) eligible(size int64, fragmentation, threshold float64) bool {
+	return size > 0 && size >= c.cfg.MinSize && fragmentation >= threshold
+}
+
+// Run performs one compaction pass. Databases are compacted one at a time,
+// those with the most reclaimable space first, each followed by its view
+// indexes if Config.Views is set. Run waits for each compaction to complete
+// before starting the next.
+//
+// Errors compacting individual databases or view indexes are reported in the
+// Report. Run returns an error only if the databases cannot be listed, or ctx
+// is done.
+func (c *Compactor) Run(ctx context.Context) (*Report, error) {
+	report := &Report{}
+	dbNames := c.cfg.DBs
+	if len(dbNames) == 0 {
+		var err error
+		if dbNames, err = c.client.AllDBs(ctx); err != nil {
+			return nil, err
+		}
+	}
+	stats, err := c.client.DBsStats(ctx, dbNames)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return reclaimable(stats[i]) > reclaimable(stats[j])
+	})
+	for _, s := range stats {
+		if s == nil {
+			continue
+		}
+		db := c.client.DB(s.Name)
+		if c.eligible(s.DiskSize, s.Fragmentation(), c.cfg.Threshold) {
+			c.compact(ctx, report, candidate{
+				db:            db,
+				fragmentation: s.Fragmentation(),
+				size:          s.DiskSize,
+			})
+		} else {
+			report.Skipped++
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if c.cfg.Views {
+			if err := c.compactViews(ctx, report, db); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+func reclaimable(s *kivik.DBStats) int64 {
+	if s == nil {
+		return -1
+	}
+	return s.DiskSize - s.ActiveSize
+}
+
+func (c *Compactor) compactViews(ctx context.Context, report *Report, db *kivik.DB) error {
+	rs := db.DesignDocs(ctx)
+	var ddocs []string
+	for rs.Next() {
+		ddocs = append(ddocs, strings.TrimPrefix(rs.ID(), "_design/"))
+	}
+	if err := rs.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report.Results = append(report.Results, Result{DB: db.Name(), Err: err})
+		return nil
+	}
+	for _, ddoc := range ddocs {
+		info, err := db.DesignDocInfo(ctx, ddoc)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			report.Results = append(report.Results, Result{DB: db.Name(), DDoc: ddoc, Err: err})
+			continue
+		}
+		if !c.eligible(info.DiskSize, info.Fragmentation(), c.cfg.ViewThreshold) {
+			report.Skipped++
+			continue
+		}
+		c.compact(ctx, report, candidate{
+			db:            db,
+			ddoc:          ddoc,
+			fragmentation: info.Fragmentation(),
+			size:          info.DiskSize,
+		})
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact compacts the candidate, if within a window, and waits for the
+// compaction to complete.
+func (c *Compactor) compact(ctx context.Context, report *Report, cand candidate) {
+	if !c.inWindow() {
+		report.Deferred++
+		return
+	}
+	result := Result{
+		DB:            cand.db.Name(),
+		DDoc:          cand.ddoc,
+		Fragmentation: cand.fragmentation,
+		SizeBefore:    cand.size,
+	}
+	result.SizeAfter, result.Err = c.compactAndWait(ctx, cand)
+	report.Results = append(report.Results, result)
+}
+
+func (c *Compactor) compactAndWait(ctx context.Context, cand candidate) (int64, error) {
+	db := cand.db
+	if cand.ddoc == "" {
+		if err := db.Compact(ctx); err != nil {
+			return 0, err
+		}
+		if err := db.WaitForCompaction(ctx); err != nil {
+			return 0, err
+		}
+		stats, err := db.Stats(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return stats.DiskSize, nil
+	}
+	if err := db.CompactView(ctx, cand.ddoc); err != nil {
+		return 0, err
+	}
+	if err := db.WaitForViewCompaction(ctx, cand.ddoc); err != nil {
+		return 0, err
+	}
+	info, err := db.DesignDocInfo(ctx, cand.ddoc)
+	if err != nil {
+		return 0, err
+	}
+	return info.DiskSize, nil
+}
+
+// Schedule runs a compaction pass every interval, starting immediately,
+// until ctx is done. Passes are skipped entirely while outside the configured
+// windows. fn, if not nil, is called with the result of each pass which is
+// run. Schedule returns the error of ctx, or a http.StatusBadRequest error if
+// interval is not positive.
+func (c *Compactor) Schedule(ctx context.Context, interval time.Duration, fn func(*Report, error)) error {
+	if interval <= 0 {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "compactor: interval must be positive"}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if c.inWindow() {
+			report, err := c.Run(ctx)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if fn != nil {
+				fn(report, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
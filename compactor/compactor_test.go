@@ -0,0 +1,352 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package compactor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+type file struct {
+	disk, active int64
+	compacting   bool
+	running      bool
+	// delay is the number of polls for which a compaction is not yet
+	// reported running, after it is started.
+	delay int
+}
+
+// server simulates the database and view index files of a server.
+// Compaction is reported running on the first poll after it is started, after
+// any delay, and completes on the next.
+type server struct {
+	mu    sync.Mutex
+	dbs   map[string]*file
+	views map[string]map[string]*file
+	calls []string
+}
+
+func (s *server) stats(f *file) (int64, int64, bool) {
+	switch {
+	case !f.compacting:
+	case f.delay > 0:
+		f.delay--
+	case !f.running:
+		f.running = true
+	default:
+		f.compacting, f.running = false, false
+		f.disk = f.active
+	}
+	return f.disk, f.active, f.running
+}
+
+var (
+	registerOnce sync.Once
+	servers      sync.Map
+)
+
+func (s *server) client(t *testing.T) *kivik.Client {
+	registerOnce.Do(func() {
+		kivik.Register("compactortest", &mock.Driver{
+			NewClientFunc: func(dsn string, _ map[string]interface{}) (driver.Client, error) {
+				s, _ := servers.Load(dsn)
+				return s.(*server).driverClient(), nil
+			},
+		})
+	})
+	servers.Store(t.Name(), s)
+	client, err := kivik.New("compactortest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (s *server) driverClient() driver.Client {
+	return &mock.DBsStatser{
+		Client: &mock.Client{
+			AllDBsFunc: func(context.Context, map[string]interface{}) ([]string, error) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				var names []string
+				for name := range s.dbs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return names, nil
+			},
+			DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+				return s.db(name), nil
+			},
+		},
+		DBsStatsFunc: func(_ context.Context, names []string) ([]*driver.DBStats, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			stats := make([]*driver.DBStats, len(names))
+			for i, name := range names {
+				if f, ok := s.dbs[name]; ok {
+					stats[i] = &driver.DBStats{Name: name, DiskSize: f.disk, ActiveSize: f.active}
+				}
+			}
+			return stats, nil
+		},
+	}
+}
+
+type db struct {
+	*mock.DB
+	server *server
+	name   string
+}
+
+var (
+	_ driver.DesignDocer     = &db{}
+	_ driver.DesignDocInfoer = &db{}
+)
+
+func (s *server) db(name string) *db {
+	return &db{
+		server: s,
+		name:   name,
+		DB: &mock.DB{
+			StatsFunc: func(context.Context) (*driver.DBStats, error) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				disk, active, running := s.stats(s.dbs[name])
+				return &driver.DBStats{Name: name, DiskSize: disk, ActiveSize: active, CompactRunning: running}, nil
+			},
+			CompactFunc: func(context.Context) error {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.calls = append(s.calls, "compact "+name)
+				s.dbs[name].compacting = true
+				return nil
+			},
+			CompactViewFunc: func(_ context.Context, ddoc string) error {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.calls = append(s.calls, "compact "+name+"/"+ddoc)
+				s.views[name][ddoc].compacting = true
+				return nil
+			},
+		},
+	}
+}
+
+func (d *db) DesignDocs(context.Context, map[string]interface{}) (driver.Rows, error) {
+	d.server.mu.Lock()
+	var ids []string
+	for ddoc := range d.server.views[d.name] {
+		ids = append(ids, "_design/"+ddoc)
+	}
+	d.server.mu.Unlock()
+	sort.Strings(ids)
+	return &mock.Rows{
+		NextFunc: func(row *driver.Row) error {
+			if len(ids) == 0 {
+				return io.EOF
+			}
+			row.ID = ids[0]
+			ids = ids[1:]
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}, nil
+}
+
+func (d *db) DesignDocInfo(_ context.Context, ddoc string) (*driver.DesignDocInfo, error) {
+	d.server.mu.Lock()
+	defer d.server.mu.Unlock()
+	f, ok := d.server.views[d.name][ddoc]
+	if !ok {
+		return nil, &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+	}
+	disk, active, running := d.server.stats(f)
+	return &driver.DesignDocInfo{Name: ddoc, DiskSize: disk, ActiveSize: active, CompactRunning: running}, nil
+}
+
+func newServer() *server {
+	return &server{
+		dbs: map[string]*file{
+			"a": {disk: 1000, active: 200},
+			"b": {disk: 1000, active: 900},
+			"c": {disk: 100, active: 10},
+			"d": {disk: 3000, active: 1000},
+		},
+		views: map[string]map[string]*file{
+			"a": {
+				"v1": {disk: 500, active: 100},
+				"v2": {disk: 500, active: 450},
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newServer()
+	c := New(s.client(t), Config{Views: true, MinSize: 500})
+	report, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Report{
+		Results: []Result{
+			{DB: "d", Fragmentation: 2.0 / 3, SizeBefore: 3000, SizeAfter: 1000},
+			{DB: "a", Fragmentation: 0.8, SizeBefore: 1000, SizeAfter: 200},
+			{DB: "a", DDoc: "v1", Fragmentation: 0.8, SizeBefore: 500, SizeAfter: 100},
+		},
+		Skipped: 3,
+	}
+	if d := testy.DiffInterface(want, report); d != nil {
+		t.Error(d)
+	}
+	if n := report.Reclaimed(); n != 3200 {
+		t.Errorf("Unexpected bytes reclaimed: %d", n)
+	}
+	if d := testy.DiffInterface([]string{"compact d", "compact a", "compact a/v1"}, s.calls); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestRunSelectedDBs(t *testing.T) {
+	s := newServer()
+	c := New(s.client(t), Config{DBs: []string{"a", "missing"}, Threshold: 0.9})
+	report, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(&Report{Skipped: 1}, report); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestRunOutsideWindow(t *testing.T) {
+	s := newServer()
+	window, err := ParseWindow("01:00-05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(s.client(t), Config{Views: true, Windows: []Window{window}})
+	c.now = func() time.Time { return time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local) }
+	report, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(&Report{Skipped: 2, Deferred: 4}, report); d != nil {
+		t.Error(d)
+	}
+	if len(s.calls) != 0 {
+		t.Errorf("Unexpected compactions: %v", s.calls)
+	}
+}
+
+func TestRunDelayedStart(t *testing.T) {
+	s := newServer()
+	s.dbs["a"].delay = 1
+	c := New(s.client(t), Config{DBs: []string{"a"}})
+	report, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 1 {
+		t.Fatalf("Unexpected results: %v", report.Results)
+	}
+	if r := report.Results[0]; r.Err != nil || r.SizeBefore != 1000 || r.SizeAfter != 200 {
+		t.Errorf("Unexpected result: %+v", r)
+	}
+}
+
+func TestScheduleInvalidInterval(t *testing.T) {
+	c := New(newServer().client(t), Config{DBs: []string{"a"}})
+	err := c.Schedule(context.Background(), 0, func(*Report, error) {
+		t.Error("Unexpected compaction pass")
+	})
+	testy.StatusError(t, "compactor: interval must be positive", http.StatusBadRequest, err)
+}
+
+func TestSchedule(t *testing.T) {
+	s := newServer()
+	c := New(s.client(t), Config{DBs: []string{"a"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var reports []*Report
+	err := c.Schedule(ctx, time.Millisecond, func(report *Report, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		reports = append(reports, report)
+		if len(reports) == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(reports) != 2 || len(reports[0].Results) != 1 || reports[1].Skipped != 1 {
+		t.Errorf("Unexpected reports: %v", reports)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 1, 1, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window string
+		in     []time.Time
+		out    []time.Time
+	}{
+		{
+			window: "01:00-05:30",
+			in:     []time.Time{at(1, 0), at(3, 0), at(5, 29)},
+			out:    []time.Time{at(0, 59), at(5, 30), at(23, 0)},
+		},
+		{
+			window: "23:00-02:00",
+			in:     []time.Time{at(23, 0), at(0, 0), at(1, 59)},
+			out:    []time.Time{at(2, 0), at(12, 0), at(22, 59)},
+		},
+	}
+	for _, test := range tests {
+		w, err := ParseWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != test.window {
+			t.Errorf("Unexpected String(): %s", w)
+		}
+		for _, tm := range test.in {
+			if !w.Contains(tm) {
+				t.Errorf("%s should contain %s", w, tm.Format("15:04"))
+			}
+		}
+		for _, tm := range test.out {
+			if w.Contains(tm) {
+				t.Errorf("%s should not contain %s", w, tm.Format("15:04"))
+			}
+		}
+	}
+	_, err := ParseWindow("1am-2am")
+	testy.StatusError(t, `compactor: invalid window "1am-2am"`, http.StatusBadRequest, err)
+}
@@ -0,0 +1,73 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package compactor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+const day = 24 * time.Hour
+
+// Window is a daily period during which compaction is allowed. Start and End
+// are offsets from midnight, in the location of the time being tested. If End
+// is before Start, the window spans midnight.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window in the form "HH:MM-HH:MM", such as
+// "23:00-05:30".
+func ParseWindow(s string) (Window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return Window{}, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: fmt.Sprintf("compactor: invalid window %q", s)}
+	}
+	var w Window
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return Window{}, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: fmt.Sprintf("compactor: invalid window %q", s)}
+		}
+		offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = offset
+		} else {
+			w.End = offset
+		}
+	}
+	return w, nil
+}
+
+// Contains returns true if t falls within the window.
+func (w Window) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	start, end := w.Start%day, w.End%day
+	if start <= end {
+		return offset >= start && offset < end
+	}
+	return offset >= start || offset < end
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d",
+		int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute),
+		int(w.End/time.Hour), int(w.End%time.Hour/time.Minute))
+}
@@ -33,11 +33,17 @@ var configNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Message
 // Config returns the entire server config, for the specified node.
 //
 // See http://docs.couchdb.org/en/stable/api/server/configuration.html#get--_node-node-name-_config
-func (c *Client) Config(ctx context.Context, node string) (Config, error) {
+func (c *Client) Config(ctx context.Context, node string) (config Config, err error) {
+	op := c.op("Config", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if configer, ok := c.driverClient.(driver.Configer); ok {
 		driverCf, err := configer.Config(ctx, node)
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		cf := Config{}
 		for k, v := range driverCf {
@@ -45,49 +51,76 @@ func (c *Client) Config(ctx context.Context, node string) (Config, error) {
 		}
 		return cf, nil
 	}
-	return nil, configNotImplemented
+	return nil, op.wrap(configNotImplemented)
 }
 
 // ConfigSection returns the requested section of the server config for the
 // specified node.
 //
 // See http://docs.couchdb.org/en/stable/api/server/configuration.html#node-node-name-config-section
-func (c *Client) ConfigSection(ctx context.Context, node, section string) (ConfigSection, error) {
+func (c *Client) ConfigSection(ctx context.Context, node, section string) (sec ConfigSection, err error) {
+	op := c.op("ConfigSection", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if configer, ok := c.driverClient.(driver.Configer); ok {
 		sec, err := configer.ConfigSection(ctx, node, section)
-		return ConfigSection(sec), err
+		return ConfigSection(sec), op.wrap(err)
 	}
-	return nil, configNotImplemented
+	return nil, op.wrap(configNotImplemented)
 }
 
 // ConfigValue returns a single config value for the specified node.
 //
 // See http://docs.couchdb.org/en/stable/api/server/configuration.html#get--_node-node-name-_config-section-key
-func (c *Client) ConfigValue(ctx context.Context, node, section, key string) (string, error) {
+func (c *Client) ConfigValue(ctx context.Context, node, section, key string) (value string, err error) {
+	op := c.op("ConfigValue", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if configer, ok := c.driverClient.(driver.Configer); ok {
-		return configer.ConfigValue(ctx, node, section, key)
+		value, err := configer.ConfigValue(ctx, node, section, key)
+		return value, op.wrap(err)
 	}
-	return "", configNotImplemented
+	return "", op.wrap(configNotImplemented)
 }
 
 // SetConfigValue sets the server's config value on the specified node, creating
 // the key if it doesn't exist. It returns the old value.
 //
 // See http://docs.couchdb.org/en/stable/api/server/configuration.html#put--_node-node-name-_config-section-key
-func (c *Client) SetConfigValue(ctx context.Context, node, section, key, value string) (string, error) {
+func (c *Client) SetConfigValue(ctx context.Context, node, section, key, value string) (oldValue string, err error) {
+	op := c.op("SetConfigValue", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if configer, ok := c.driverClient.(driver.Configer); ok {
-		return configer.SetConfigValue(ctx, node, section, key, value)
+		oldValue, err := configer.SetConfigValue(ctx, node, section, key, value)
+		return oldValue, op.wrap(err)
 	}
-	return "", configNotImplemented
+	return "", op.wrap(configNotImplemented)
 }
 
 // DeleteConfigKey deletes the configuration key and associated value from the
 // specified node. It returns the old value.
 //
 // See http://docs.couchdb.org/en/stable/api/server/configuration.html#delete--_node-node-name-_config-section-key
-func (c *Client) DeleteConfigKey(ctx context.Context, node, section, key string) (string, error) {
+func (c *Client) DeleteConfigKey(ctx context.Context, node, section, key string) (oldValue string, err error) {
+	op := c.op("DeleteConfigKey", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if configer, ok := c.driverClient.(driver.Configer); ok {
-		return configer.DeleteConfigKey(ctx, node, section, key)
+		oldValue, err := configer.DeleteConfigKey(ctx, node, section, key)
+		return oldValue, op.wrap(err)
 	}
-	return "", configNotImplemented
+	return "", op.wrap(configNotImplemented)
 }
@@ -34,6 +34,7 @@ type DB struct {
 	name     string
 	driverDB driver.DB
 	err      error
+	retry    *RetryPolicy
 }
 
 // Client returns the Client used to connect to the database.
@@ -46,6 +47,21 @@ func (db *DB) Name() string {
 	return db.name
 }
 
+// op returns an Operation describing a call to the named DB method.
+func (db *DB) op(method, docID, rev string) *Operation {
+	var driverName string
+	if db.client != nil {
+		driverName = db.client.driverName
+	}
+	return &Operation{
+		Method: method,
+		Driver: driverName,
+		DB:     db.name,
+		DocID:  docID,
+		Rev:    rev,
+	}
+}
+
 // Err returns the error, if any, that occurred while connecting to or creating
 // the database. This error will be deferred until the next call, normally, so
 // using this method is only ever necessary if you need to directly check the
@@ -54,16 +70,29 @@ func (db *DB) Err() error {
 	return db.err
 }
 
-// AllDocs returns a list of all documents in the database.
+// AllDocs returns a list of all documents in the database. See
+// OptionIfNoneMatch for conditional requests.
 func (db *DB) AllDocs(ctx context.Context, options ...Options) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
-	rowsi, err := db.driverDB.AllDocs(ctx, mergeOptions(options...))
+	op := db.op("AllDocs", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var rowsi driver.Rows
+	err := retry.do(ctx, func() (err error) {
+		rowsi, err = db.driverDB.AllDocs(ctx, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(op.wrap(err))}
 	}
-	return newRows(ctx, rowsi)
+	rs := newRows(ctx, rowsi)
+	rs.trace(span)
+	return rs
 }
 
 // DesignDocs returns a list of all documents in the database.
@@ -71,15 +100,27 @@ func (db *DB) DesignDocs(ctx context.Context, options ...Options) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
+	op := db.op("DesignDocs", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
 	ddocer, ok := db.driverDB.(driver.DesignDocer)
 	if !ok {
-		return &errRS{err: &Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: design doc view not supported by driver")}}
-	}
-	rowsi, err := ddocer.DesignDocs(ctx, mergeOptions(options...))
+		return &errRS{err: span.fail(op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: design doc view not supported by driver")}))}
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var rowsi driver.Rows
+	err := retry.do(ctx, func() (err error) {
+		rowsi, err = ddocer.DesignDocs(ctx, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(op.wrap(err))}
 	}
-	return newRows(ctx, rowsi)
+	rs := newRows(ctx, rowsi)
+	rs.trace(span)
+	return rs
 }
 
 // LocalDocs returns a list of all documents in the database.
@@ -87,47 +128,102 @@ func (db *DB) LocalDocs(ctx context.Context, options ...Options) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
+	op := db.op("LocalDocs", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
 	ldocer, ok := db.driverDB.(driver.LocalDocer)
 	if !ok {
-		return &errRS{err: &Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: local doc view not supported by driver")}}
-	}
-	rowsi, err := ldocer.LocalDocs(ctx, mergeOptions(options...))
+		return &errRS{err: span.fail(op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: local doc view not supported by driver")}))}
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var rowsi driver.Rows
+	err := retry.do(ctx, func() (err error) {
+		rowsi, err = ldocer.LocalDocs(ctx, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(op.wrap(err))}
 	}
-	return newRows(ctx, rowsi)
+	rs := newRows(ctx, rowsi)
+	rs.trace(span)
+	return rs
 }
 
 // Query executes the specified view function from the specified design
 // document. ddoc and view may or may not be be prefixed with '_design/'
-// and '_view/' respectively.
+// and '_view/' respectively. See OptionIfNoneMatch for conditional requests.
 func (db *DB) Query(ctx context.Context, ddoc, view string, options ...Options) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
 	ddoc = strings.TrimPrefix(ddoc, "_design/")
 	view = strings.TrimPrefix(view, "_view/")
-	rowsi, err := db.driverDB.Query(ctx, ddoc, view, mergeOptions(options...))
+	op := db.op("Query", "_design/"+ddoc, "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var rowsi driver.Rows
+	err := retry.do(ctx, func() (err error) {
+		rowsi, err = db.driverDB.Query(ctx, ddoc, view, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(op.wrap(err))}
 	}
-	return newRows(ctx, rowsi)
+	rs := newRows(ctx, rowsi)
+	rs.trace(span)
+	return rs
 }
 
+// OptionIfNoneMatch is the option key used to make a conditional request
+// with Get, AllDocs or Query. Its value is an unquoted ETag, as returned by
+// ResultSet.ETag, or for Get, a document revision. If the document or view is
+// unchanged, the returned ResultSet's error satisfies IsNotModified, and no
+// body is read.
+//
+// Example:
+//
+//	rs := db.Get(ctx, "config", kivik.Options{kivik.OptionIfNoneMatch: etag})
+//	if err := rs.ScanDoc(&config); kivik.IsNotModified(err) {
+//		// The cached copy of config is still current.
+//	}
+const OptionIfNoneMatch = driver.OptionIfNoneMatch
+
 // Get fetches the requested document. Any errors are deferred until the
-// row.ScanDoc call.
+// row.ScanDoc call. See OptionIfNoneMatch for conditional requests.
 func (db *DB) Get(ctx context.Context, docID string, options ...Options) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
-	doc, err := db.driverDB.Get(ctx, docID, mergeOptions(options...))
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	op := db.op("Get", docID, optsRev(opts))
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
+	return db.get(ctx, retry, span, docID, opts)
+}
+
+// get fetches the requested document. span, if not nil, is ended when the
+// document body is closed.
+func (db *DB) get(ctx context.Context, retry *RetryPolicy, span *span, docID string, opts Options) ResultSet {
+	var doc *driver.Document
+	err := retry.do(ctx, func() (err error) {
+		doc, err = db.driverDB.Get(ctx, docID, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(db.op("Get", docID, optsRev(opts)).wrap(err))}
 	}
 	r := &row{
 		id:   docID,
 		rev:  doc.Rev,
-		body: doc.Body,
+		etag: doc.ETag,
+		body: newTracedBody(doc.Body, span),
 	}
 	if doc.Attachments != nil {
 		r.atts = &AttachmentsIterator{atti: doc.Attachments}
@@ -141,19 +237,34 @@ func (db *DB) GetRev(ctx context.Context, docID string, options ...Options) (rev
 	if db.err != nil {
 		return "", db.err
 	}
-	opts := mergeOptions(options...)
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	op := db.op("GetRev", docID, optsRev(opts))
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if r, ok := db.driverDB.(driver.MetaGetter); ok {
-		_, rev, err := r.GetMeta(ctx, docID, opts)
-		return rev, err
+		err = retry.do(ctx, func() (err error) {
+			_, rev, err = r.GetMeta(ctx, docID, opts)
+			return err
+		})
+		return rev, op.wrap(err)
 	}
-	row := db.Get(ctx, docID, opts)
+	row := db.get(ctx, retry, nil, docID, opts)
 	var doc struct {
 		Rev string `json:"_rev"`
 	}
 	// These last two lines cannot be combined for GopherJS due to a bug.
 	// See https://github.com/gopherjs/gopherjs/issues/608
 	err = row.ScanDoc(&doc)
-	return doc.Rev, err
+	return doc.Rev, op.wrap(err)
+}
+
+// optsRev returns the value of the "rev" option, if set.
+func optsRev(opts Options) string {
+	rev, _ := opts["rev"].(string)
+	return rev
 }
 
 // CreateDoc creates a new doc with an auto-generated unique ID. The generated
@@ -162,7 +273,14 @@ func (db *DB) CreateDoc(ctx context.Context, doc interface{}, options ...Options
 	if db.err != nil {
 		return "", "", db.err
 	}
-	return db.driverDB.CreateDoc(ctx, doc, mergeOptions(options...))
+	op := db.op("CreateDoc", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", "", op.wrap(err)
+	}
+	docID, rev, err = db.driverDB.CreateDoc(ctx, doc, mergeOptions(options...))
+	return docID, rev, op.wrap(err)
 }
 
 // normalizeFromJSON unmarshals a []byte, json.RawMessage or io.Reader to a
@@ -228,6 +346,21 @@ func (db *DB) Put(ctx context.Context, docID string, doc interface{}, options ..
 	if db.err != nil {
 		return "", db.err
 	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	op := db.op("Put", docID, optsRev(opts))
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
+	rev, err = db.put(ctx, retry, docID, doc, opts)
+	return rev, op.wrap(err)
+}
+
+// put stores doc. The retry policy is only applied if doc, or opts, include
+// an explicit revision, as otherwise a retry could create a new document
+// after the first attempt succeeded.
+func (db *DB) put(ctx context.Context, retry *RetryPolicy, docID string, doc interface{}, opts Options) (rev string, err error) {
 	if docID == "" {
 		return "", missingArg("docID")
 	}
@@ -235,7 +368,14 @@ func (db *DB) Put(ctx context.Context, docID string, doc interface{}, options ..
 	if err != nil {
 		return "", err
 	}
-	return db.driverDB.Put(ctx, docID, i, mergeOptions(options...))
+	if retry == nil || (optsRev(opts) == "" && !hasRev(i)) {
+		return db.driverDB.Put(ctx, docID, i, opts)
+	}
+	err = retry.do(ctx, func() (err error) {
+		rev, err = db.driverDB.Put(ctx, docID, i, opts)
+		return err
+	})
+	return rev, err
 }
 
 // Delete marks the specified document as deleted. The revision may be provided
@@ -244,27 +384,43 @@ func (db *DB) Delete(ctx context.Context, docID, rev string, options ...Options)
 	if db.err != nil {
 		return "", db.err
 	}
-	if docID == "" {
-		return "", missingArg("docID")
-	}
-	opts := mergeOptions(options...)
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
 	if rv, ok := opts["rev"].(string); ok && rv != "" {
 		rev = rv
 	}
-	return db.driverDB.Delete(ctx, docID, rev, opts)
+	op := db.op("Delete", docID, rev)
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
+	if docID == "" {
+		return "", op.wrap(missingArg("docID"))
+	}
+	err = retry.do(ctx, func() (err error) {
+		newRev, err = db.driverDB.Delete(ctx, docID, rev, opts)
+		return err
+	})
+	return newRev, op.wrap(err)
 }
 
 // Flush requests a flush of disk cache to disk or other permanent storage.
 //
 // See http://docs.couchdb.org/en/2.0.0/api/database/compact.html#db-ensure-full-commit
-func (db *DB) Flush(ctx context.Context) error {
+func (db *DB) Flush(ctx context.Context) (err error) {
 	if db.err != nil {
 		return db.err
 	}
+	op := db.op("Flush", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
 	if flusher, ok := db.driverDB.(driver.Flusher); ok {
-		return flusher.Flush(ctx)
+		return op.wrap(flusher.Flush(ctx))
 	}
-	return &Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: flush not supported by driver")}
+	return op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: flush not supported by driver")})
 }
 
 // DBStats contains database statistics..
@@ -310,13 +466,23 @@ type ClusterConfig struct {
 // Stats returns database statistics.
 //
 // See https://docs.couchdb.org/en/stable/api/database/common.html#get--db
-func (db *DB) Stats(ctx context.Context) (*DBStats, error) {
+func (db *DB) Stats(ctx context.Context) (stats *DBStats, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
-	i, err := db.driverDB.Stats(ctx)
+	op := db.op("Stats", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	var i *driver.DBStats
+	err = db.retry.do(ctx, func() (err error) {
+		i, err = db.driverDB.Stats(ctx)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
 	return driverStats2kivikStats(i), nil
 }
@@ -341,73 +507,111 @@ func driverStats2kivikStats(i *driver.DBStats) *DBStats {
 	}
 }
 
-// Compact begins compaction of the database. Check the CompactRunning field
-// returned by Info() to see if the compaction has completed.
+// Compact begins compaction of the database. Use WaitForCompaction to wait
+// for the compaction to complete.
 // See http://docs.couchdb.org/en/2.0.0/api/database/compact.html#db-compact
 //
 // This method may return immediately, or may wait for the compaction to
 // complete before returning, depending on the backend implementation. In
 // particular, CouchDB triggers the compaction and returns immediately, whereas
 // PouchDB waits until compaction has completed, before returning.
-func (db *DB) Compact(ctx context.Context) error {
+func (db *DB) Compact(ctx context.Context) (err error) {
 	if db.err != nil {
 		return db.err
 	}
-	return db.driverDB.Compact(ctx)
+	op := db.op("Compact", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
+	return op.wrap(db.driverDB.Compact(ctx))
 }
 
 // CompactView compats the view indexes associated with the specified design
-// document.
+// document. Use WaitForViewCompaction to wait for the compaction to complete.
 // See http://docs.couchdb.org/en/2.0.0/api/database/compact.html#db-compact-design-doc
 //
 // This method may return immediately, or may wait for the compaction to
 // complete before returning, depending on the backend implementation. In
 // particular, CouchDB triggers the compaction and returns immediately, whereas
 // PouchDB waits until compaction has completed, before returning.
-func (db *DB) CompactView(ctx context.Context, ddocID string) error {
-	return db.driverDB.CompactView(ctx, ddocID)
+func (db *DB) CompactView(ctx context.Context, ddocID string) (err error) {
+	op := db.op("CompactView", ddocID, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
+	return op.wrap(db.driverDB.CompactView(ctx, ddocID))
 }
 
 // ViewCleanup removes view index files that are no longer required as a result
 // of changed views within design documents.
 // See http://docs.couchdb.org/en/2.0.0/api/database/compact.html#db-view-cleanup
-func (db *DB) ViewCleanup(ctx context.Context) error {
+func (db *DB) ViewCleanup(ctx context.Context) (err error) {
 	if db.err != nil {
 		return db.err
 	}
-	return db.driverDB.ViewCleanup(ctx)
+	op := db.op("ViewCleanup", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
+	return op.wrap(db.driverDB.ViewCleanup(ctx))
 }
 
 // Security returns the database's security document.
 // See http://couchdb.readthedocs.io/en/latest/api/database/security.html#get--db-_security
-func (db *DB) Security(ctx context.Context) (*Security, error) {
+func (db *DB) Security(ctx context.Context) (security *Security, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
-	s, err := db.driverDB.Security(ctx)
+	op := db.op("Security", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	var s *driver.Security
+	err = db.retry.do(ctx, func() (err error) {
+		s, err = db.driverDB.Security(ctx)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
 	return &Security{
 		Admins:  Members(s.Admins),
 		Members: Members(s.Members),
+		Extra:   s.Extra,
 	}, err
 }
 
 // SetSecurity sets the database's security document.
 // See http://couchdb.readthedocs.io/en/latest/api/database/security.html#put--db-_security
-func (db *DB) SetSecurity(ctx context.Context, security *Security) error {
+func (db *DB) SetSecurity(ctx context.Context, security *Security) (err error) {
 	if db.err != nil {
 		return db.err
 	}
+	op := db.op("SetSecurity", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
 	if security == nil {
-		return missingArg("security")
+		return op.wrap(missingArg("security"))
 	}
 	sec := &driver.Security{
 		Admins:  driver.Members(security.Admins),
 		Members: driver.Members(security.Members),
+		Extra:   security.Extra,
 	}
-	return db.driverDB.SetSecurity(ctx, sec)
+	return op.wrap(db.retry.do(ctx, func() error {
+		return db.driverDB.SetSecurity(ctx, sec)
+	}))
 }
 
 // Copy copies the source document to a new document with an ID of targetID. If
@@ -420,19 +624,26 @@ func (db *DB) Copy(ctx context.Context, targetID, sourceID string, options ...Op
 	if db.err != nil {
 		return "", db.err
 	}
+	op := db.op("Copy", targetID, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if targetID == "" {
-		return "", missingArg("targetID")
+		return "", op.wrap(missingArg("targetID"))
 	}
 	if sourceID == "" {
-		return "", missingArg("sourceID")
+		return "", op.wrap(missingArg("sourceID"))
 	}
 	opts := mergeOptions(options...)
 	if copier, ok := db.driverDB.(driver.Copier); ok {
-		return copier.Copy(ctx, targetID, sourceID, opts)
+		targetRev, err = copier.Copy(ctx, targetID, sourceID, opts)
+		return targetRev, op.wrap(err)
 	}
 	var doc map[string]interface{}
 	if err = db.Get(ctx, sourceID, opts).ScanDoc(&doc); err != nil {
-		return "", err
+		return "", op.wrap(err)
 	}
 	delete(doc, "_rev")
 	doc["_id"] = targetID
@@ -446,37 +657,52 @@ func (db *DB) PutAttachment(ctx context.Context, docID string, att *Attachment,
 	if db.err != nil {
 		return "", db.err
 	}
+	opts := mergeOptions(options...)
+	rev := optsRev(opts)
+	op := db.op("PutAttachment", docID, rev)
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
 	if docID == "" {
-		return "", missingArg("docID")
+		return "", op.wrap(missingArg("docID"))
 	}
 	if e := att.validate(); e != nil {
-		return "", e
+		return "", op.wrap(e)
 	}
 	a := driver.Attachment(*att)
-	var rev string
-	opts := mergeOptions(options...)
-	if rv, ok := opts["rev"].(string); ok && rv != "" {
-		rev = rv
-	}
-	return db.driverDB.PutAttachment(ctx, docID, rev, &a, mergeOptions(options...))
+	newRev, err = db.driverDB.PutAttachment(ctx, docID, rev, &a, mergeOptions(options...))
+	return newRev, op.wrap(err)
 }
 
 // GetAttachment returns a file attachment associated with the document.
-func (db *DB) GetAttachment(ctx context.Context, docID, filename string, options ...Options) (*Attachment, error) {
+func (db *DB) GetAttachment(ctx context.Context, docID, filename string, options ...Options) (att *Attachment, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
+	op := db.op("GetAttachment", docID, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if docID == "" {
-		return nil, missingArg("docID")
+		return nil, op.wrap(missingArg("docID"))
 	}
 	if filename == "" {
-		return nil, missingArg("filename")
-	}
-	att, err := db.driverDB.GetAttachment(ctx, docID, filename, mergeOptions(options...))
+		return nil, op.wrap(missingArg("filename"))
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var datt *driver.Attachment
+	err = retry.do(ctx, func() (err error) {
+		datt, err = db.driverDB.GetAttachment(ctx, docID, filename, opts)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
-	a := Attachment(*att)
+	a := Attachment(*datt)
 	return &a, nil
 }
 
@@ -491,29 +717,33 @@ var nilContent = nilContentReader{}
 
 // GetAttachmentMeta returns meta data about an attachment. The attachment
 // content returned will be empty.
-func (db *DB) GetAttachmentMeta(ctx context.Context, docID, filename string, options ...Options) (*Attachment, error) {
+func (db *DB) GetAttachmentMeta(ctx context.Context, docID, filename string, options ...Options) (att *Attachment, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
+	op := db.op("GetAttachmentMeta", docID, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if docID == "" {
-		return nil, missingArg("docID")
+		return nil, op.wrap(missingArg("docID"))
 	}
 	if filename == "" {
-		return nil, missingArg("filename")
+		return nil, op.wrap(missingArg("filename"))
 	}
-	var att *Attachment
 	if metaer, ok := db.driverDB.(driver.AttachmentMetaGetter); ok {
 		a, err := metaer.GetAttachmentMeta(ctx, docID, filename, mergeOptions(options...))
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		att = new(Attachment)
 		*att = Attachment(*a)
 	} else {
-		var err error
 		att, err = db.GetAttachment(ctx, docID, filename, options...)
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 	}
 	if att.Content != nil {
@@ -530,17 +760,24 @@ func (db *DB) DeleteAttachment(ctx context.Context, docID, rev, filename string,
 	if db.err != nil {
 		return "", db.err
 	}
-	if docID == "" {
-		return "", missingArg("docID")
-	}
-	if filename == "" {
-		return "", missingArg("filename")
-	}
 	opts := mergeOptions(options...)
 	if rv, ok := opts["rev"].(string); ok && rv != "" {
 		rev = rv
 	}
-	return db.driverDB.DeleteAttachment(ctx, docID, rev, filename, opts)
+	op := db.op("DeleteAttachment", docID, rev)
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return "", op.wrap(err)
+	}
+	if docID == "" {
+		return "", op.wrap(missingArg("docID"))
+	}
+	if filename == "" {
+		return "", op.wrap(missingArg("filename"))
+	}
+	newRev, err = db.driverDB.DeleteAttachment(ctx, docID, rev, filename, opts)
+	return newRev, op.wrap(err)
 }
 
 // PurgeResult is the result of a purge request.
@@ -561,19 +798,25 @@ type PurgeResult struct {
 //
 // Purge expects as input a map with document ID as key, and slice of
 // revisions as value.
-func (db *DB) Purge(ctx context.Context, docRevMap map[string][]string) (*PurgeResult, error) {
+func (db *DB) Purge(ctx context.Context, docRevMap map[string][]string) (result *PurgeResult, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
+	op := db.op("Purge", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if purger, ok := db.driverDB.(driver.Purger); ok {
 		res, err := purger.Purge(ctx, docRevMap)
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		r := PurgeResult(*res)
 		return &r, nil
 	}
-	return nil, &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: purge not supported by driver"}
+	return nil, op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: purge not supported by driver"})
 }
 
 // BulkGetReference is a reference to a document given in a BulkGet query.
@@ -592,29 +835,44 @@ func (db *DB) BulkGet(ctx context.Context, docs []BulkGetReference, options ...O
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
+	op := db.op("BulkGet", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
 	bulkGetter, ok := db.driverDB.(driver.BulkGetter)
 	if !ok {
-		return &rows{err: &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: bulk get not supported by driver"}}
+		return &rows{err: span.fail(op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: bulk get not supported by driver"}))}
 	}
 	refs := make([]driver.BulkGetReference, len(docs))
 	for i, ref := range docs {
 		refs[i] = driver.BulkGetReference(ref)
 	}
-	rowsi, err := bulkGetter.BulkGet(ctx, refs, mergeOptions(options...))
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
+	var rowsi driver.Rows
+	err := retry.do(ctx, func() (err error) {
+		rowsi, err = bulkGetter.BulkGet(ctx, refs, opts)
+		return err
+	})
 	if err != nil {
-		return &errRS{err: err}
+		return &errRS{err: span.fail(op.wrap(err))}
 	}
-	return newRows(ctx, rowsi)
+	rs := newRows(ctx, rowsi)
+	rs.trace(span)
+	return rs
 }
 
 // Close cleans up any resources used by the DB. The default CouchDB driver
 // does not use this, the default PouchDB driver does.
-func (db *DB) Close(ctx context.Context) error {
+func (db *DB) Close(ctx context.Context) (err error) {
 	if db.err != nil {
 		return db.err
 	}
+	op := db.op("Close", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
 	if closer, ok := db.driverDB.(driver.DBCloser); ok {
-		return closer.Close(ctx)
+		return op.wrap(closer.Close(ctx))
 	}
 	return nil
 }
@@ -648,14 +906,21 @@ func (db *DB) RevsDiff(ctx context.Context, revMap interface{}) ResultSet {
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
+	op := db.op("RevsDiff", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
 	if rd, ok := db.driverDB.(driver.RevsDiffer); ok {
 		rowsi, err := rd.RevsDiff(ctx, revMap)
 		if err != nil {
-			return &errRS{err: err}
+			return &errRS{err: span.fail(op.wrap(err))}
 		}
-		return newRows(ctx, rowsi)
+		rs := newRows(ctx, rowsi)
+		rs.trace(span)
+		return rs
 	}
-	return &errRS{err: &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: _revs_diff not supported by driver"}}
+	return &errRS{err: span.fail(op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: _revs_diff not supported by driver"}))}
 }
 
 // PartitionStats contains partition statistics.
@@ -672,17 +937,23 @@ type PartitionStats struct {
 // PartitionStats returns statistics about the named partition.
 //
 // See https://docs.couchdb.org/en/stable/api/partitioned-dbs.html#db-partition-partition
-func (db *DB) PartitionStats(ctx context.Context, name string) (*PartitionStats, error) {
+func (db *DB) PartitionStats(ctx context.Context, name string) (stats *PartitionStats, err error) {
 	if db.err != nil {
 		return nil, db.err
 	}
+	op := db.op("PartitionStats", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if pdb, ok := db.driverDB.(driver.PartitionedDB); ok {
 		stats, err := pdb.PartitionStats(ctx, name)
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		s := PartitionStats(*stats)
 		return &s, nil
 	}
-	return nil, &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: partitions not supported by driver"}
+	return nil, op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: partitions not supported by driver"})
 }
@@ -325,7 +325,10 @@ func TestGet(t *testing.T) {
 				},
 			},
 			expected: &errRS{
-				err: fmt.Errorf("db error"),
+				err: &Error{
+					Err: fmt.Errorf("db error"),
+					Op:  &Operation{Method: "Get"},
+				},
 			},
 		},
 		{
@@ -389,6 +392,49 @@ func TestGet(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "etag",
+			db: &DB{
+				driverDB: &mock.DB{
+					GetFunc: func(_ context.Context, _ string, _ map[string]interface{}) (*driver.Document, error) {
+						return &driver.Document{
+							Rev:  "1-xxx",
+							ETag: "1-xxx",
+							Body: body(`{"_id":"foo"}`),
+						}, nil
+					},
+				},
+			},
+			docID: "foo",
+			expected: &row{
+				id:   "foo",
+				rev:  "1-xxx",
+				etag: "1-xxx",
+				body: body(`{"_id":"foo"}`),
+			},
+		},
+		{
+			name: "not modified",
+			db: &DB{
+				driverDB: &mock.DB{
+					GetFunc: func(_ context.Context, _ string, options map[string]interface{}) (*driver.Document, error) {
+						if options[OptionIfNoneMatch] != "1-xxx" {
+							return nil, fmt.Errorf("Unexpected options: %v", options)
+						}
+						return nil, &Error{HTTPStatus: http.StatusNotModified, Message: "Not Modified"}
+					},
+				},
+			},
+			docID:   "foo",
+			options: Options{OptionIfNoneMatch: "1-xxx"},
+			expected: &errRS{
+				err: &Error{
+					HTTPStatus: http.StatusNotModified,
+					Message:    "Not Modified",
+					Op:         &Operation{Method: "Get", DocID: "foo"},
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
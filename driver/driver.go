@@ -111,6 +111,73 @@ type ClientReplicator interface {
 	GetReplications(ctx context.Context, options map[string]interface{}) ([]Replication, error)
 }
 
+// SchedulerEvent is an entry in the history of a replication job, as returned
+// by the /_scheduler/jobs endpoint.
+type SchedulerEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// SchedulerInfo contains the replication statistics reported by the
+// replication scheduler.
+type SchedulerInfo struct {
+	ChangesPending        int64  `json:"changes_pending"`
+	CheckpointedSourceSeq string `json:"checkpointed_source_seq,omitempty"`
+	DocWriteFailures      int64  `json:"doc_write_failures"`
+	DocsRead              int64  `json:"docs_read"`
+	DocsWritten           int64  `json:"docs_written"`
+	MissingRevisionsFound int64  `json:"missing_revisions_found"`
+	RevisionsChecked      int64  `json:"revisions_checked"`
+	SourceSeq             string `json:"source_seq,omitempty"`
+	ThroughSeq            string `json:"through_seq,omitempty"`
+	Error                 string `json:"error,omitempty"`
+}
+
+// SchedulerJob represents a replication job, as returned by the
+// /_scheduler/jobs endpoint.
+type SchedulerJob struct {
+	ID        string           `json:"id"`
+	Database  string           `json:"database"`
+	DocID     string           `json:"doc_id"`
+	Source    string           `json:"source"`
+	Target    string           `json:"target"`
+	User      string           `json:"user"`
+	Node      string           `json:"node"`
+	PID       string           `json:"pid"`
+	StartTime time.Time        `json:"start_time"`
+	History   []SchedulerEvent `json:"history"`
+	Info      SchedulerInfo    `json:"info"`
+}
+
+// SchedulerDoc represents the state of a replication document, as returned
+// by the /_scheduler/docs endpoint.
+type SchedulerDoc struct {
+	ID          string        `json:"id"`
+	Database    string        `json:"database"`
+	DocID       string        `json:"doc_id"`
+	Source      string        `json:"source"`
+	Target      string        `json:"target"`
+	Node        string        `json:"node"`
+	State       string        `json:"state"`
+	ErrorCount  int           `json:"error_count"`
+	StartTime   time.Time     `json:"start_time"`
+	LastUpdated time.Time     `json:"last_updated"`
+	Info        SchedulerInfo `json:"info"`
+}
+
+// ClientScheduler is an optional interface that may be implemented by a
+// Client to support the replication scheduler endpoints.
+type ClientScheduler interface {
+	// SchedulerJobs returns the replication jobs known to the scheduler.
+	SchedulerJobs(ctx context.Context, options map[string]interface{}) ([]*SchedulerJob, error)
+	// SchedulerDocs returns the states of the replication documents in
+	// replicatorDB, or in all replicator databases if replicatorDB is empty.
+	SchedulerDocs(ctx context.Context, replicatorDB string, options map[string]interface{}) ([]*SchedulerDoc, error)
+	// SchedulerDoc returns the state of a single replication document.
+	SchedulerDoc(ctx context.Context, replicatorDB, docID string) (*SchedulerDoc, error)
+}
+
 // Authenticator is an optional interface that may be implemented by a Client
 // that supports authenitcated connections.
 type Authenticator interface {
@@ -152,6 +219,8 @@ type Members struct {
 type Security struct {
 	Admins  Members `json:"admins"`
 	Members Members `json:"members"`
+	// Extra holds any other fields of the security document, keyed by name.
+	Extra map[string]interface{} `json:"-"`
 }
 
 // MarshalJSON satisfies the json.Marshaler interface.
@@ -163,10 +232,47 @@ func (s Security) MarshalJSON() ([]byte, error) {
 	if len(s.Admins.Names) > 0 || len(s.Admins.Roles) > 0 {
 		v.Admins = &s.Admins
 	}
-	if len(s.Members.Names) > 0 || len(s.Admins.Roles) > 0 {
+	if len(s.Members.Names) > 0 || len(s.Members.Roles) > 0 {
 		v.Members = &s.Members
 	}
-	return json.Marshal(v)
+	if len(s.Extra) == 0 {
+		return json.Marshal(v)
+	}
+	doc := make(map[string]interface{}, len(s.Extra)+2)
+	for k, x := range s.Extra {
+		doc[k] = x
+	}
+	delete(doc, "admins")
+	delete(doc, "members")
+	if v.Admins != nil {
+		doc["admins"] = v.Admins
+	}
+	if v.Members != nil {
+		doc["members"] = v.Members
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (s *Security) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Admins  Members `json:"admins"`
+		Members Members `json:"members"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	delete(extra, "admins")
+	delete(extra, "members")
+	if len(extra) == 0 {
+		extra = nil
+	}
+	*s = Security{Admins: v.Admins, Members: v.Members, Extra: extra}
+	return nil
 }
 
 // DB is a database handle.
@@ -213,6 +319,14 @@ type DB interface {
 	Query(ctx context.Context, ddoc, view string, options map[string]interface{}) (Rows, error)
 }
 
+// OptionIfNoneMatch is the option key used to request a document or view
+// only if it has changed. Its value is an unquoted ETag, as returned by a
+// previous request, or for documents, a revision. Drivers which support it
+// should send it as an If-None-Match header, and return an error with status
+// http.StatusNotModified if the server reports that the resource is unchanged.
+// It must not be sent to the server as a query parameter.
+const OptionIfNoneMatch = "kivik:if_none_match"
+
 // Document represents a single document returned by Get
 type Document struct {
 	// ContentLength is the size of the document response in bytes.
@@ -223,6 +337,9 @@ type Document struct {
 	// Rev is the revision number returned
 	Rev string
 
+	// ETag is the unquoted ETag header, if present.
+	ETag string
+
 	// Body contains the respons body, either in raw JSON or multipart/related
 	// format.
 	Body io.ReadCloser
@@ -410,6 +527,27 @@ type LocalDocer interface {
 	LocalDocs(ctx context.Context, options map[string]interface{}) (Rows, error)
 }
 
+// DesignDocInfo contains information about the view index of a design
+// document, as returned by the /{db}/_design/{ddoc}/_info endpoint.
+type DesignDocInfo struct {
+	Name           string `json:"name"`
+	Signature      string `json:"signature"`
+	Language       string `json:"language"`
+	CompactRunning bool   `json:"compact_running"`
+	UpdaterRunning bool   `json:"updater_running"`
+	WaitingClients int    `json:"waiting_clients"`
+	DiskSize       int64  `json:"-"`
+	ActiveSize     int64  `json:"-"`
+	ExternalSize   int64  `json:"-"`
+}
+
+// DesignDocInfoer is an optional interface that may be implemented by a DB.
+type DesignDocInfoer interface {
+	// DesignDocInfo returns information about the view index of the design
+	// document ddocID.
+	DesignDocInfo(ctx context.Context, ddocID string) (*DesignDocInfo, error)
+}
+
 // Pinger is an optional interface that may be implemented by a Client. When
 // not implemented, Kivik will call Version instead, to determine if the
 // database is usable.
@@ -438,6 +576,39 @@ type Cluster interface {
 	Membership(ctx context.Context) (*ClusterMembership, error)
 }
 
+// ActiveTask is a task running on the server, as returned by the
+// /_active_tasks endpoint. StartedOn and UpdatedOn are Unix timestamps.
+type ActiveTask struct {
+	Type           string `json:"type"`
+	Node           string `json:"node,omitempty"`
+	PID            string `json:"pid"`
+	StartedOn      int64  `json:"started_on"`
+	UpdatedOn      int64  `json:"updated_on"`
+	Progress       int    `json:"progress,omitempty"`
+	ChangesDone    int64  `json:"changes_done,omitempty"`
+	TotalChanges   int64  `json:"total_changes,omitempty"`
+	Database       string `json:"database,omitempty"`
+	DesignDocument string `json:"design_document,omitempty"`
+}
+
+// ActiveTasker is an optional interface that may be implemented by a Client
+// to report the tasks running on the server.
+type ActiveTasker interface {
+	// ActiveTasks returns the tasks running on the server.
+	ActiveTasks(ctx context.Context) ([]*ActiveTask, error)
+}
+
+// NodeStatser is an optional interface that may be implemented by a Client
+// to report the statistics of cluster nodes.
+type NodeStatser interface {
+	// NodeStats returns the raw JSON response of the /_node/{node}/_stats
+	// endpoint.
+	NodeStats(ctx context.Context, node string) (jsoniter.RawMessage, error)
+	// NodeSystem returns the raw JSON response of the /_node/{node}/_system
+	// endpoint.
+	NodeSystem(ctx context.Context, node string) (jsoniter.RawMessage, error)
+}
+
 // ClientCloser is an optional interface that may be implemented by a Client
 // to clean up resources when a Client is no longer needed.
 type ClientCloser interface {
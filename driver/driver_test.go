@@ -14,6 +14,8 @@ package driver
 
 import (
 	"testing"
+
+	"gitlab.com/flimzy/testy"
 )
 
 func TestSecurityMarshalJSON(t *testing.T) {
@@ -34,3 +36,26 @@ func TestSecurityMarshalJSON(t *testing.T) {
 		}
 	})
 }
+
+func TestSecurityExtra(t *testing.T) {
+	input := `{"admins":{"names":["alice"]},"members":{"roles":["staff"]},"couchdb_auth_only":true}`
+	var sec Security
+	if err := json.Unmarshal([]byte(input), &sec); err != nil {
+		t.Fatal(err)
+	}
+	expected := Security{
+		Admins:  Members{Names: []string{"alice"}},
+		Members: Members{Roles: []string{"staff"}},
+		Extra:   map[string]interface{}{"couchdb_auth_only": true},
+	}
+	if d := testy.DiffInterface(expected, sec); d != nil {
+		t.Error(d)
+	}
+	got, err := json.Marshal(sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffJSON([]byte(input), got); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,47 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package driver
+
+import "context"
+
+// Identity is the identity of the end user on whose behalf a request is
+// made, as attached to a context by kivik.WithUser.
+type Identity struct {
+	Name  string
+	Roles []string
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying id.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity carried by ctx, or nil. Drivers
+// which satisfy Impersonator should make each request as this user, rather
+// than with the client's own credentials.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Impersonator is an optional interface that may be implemented by a Client
+// which makes requests as the Identity carried by their context, such as by
+// proxy authentication, or with a session cookie per user.
+type Impersonator interface {
+	// Impersonates returns true if the client applies the Identity of each
+	// request's context. Clients which wrap other clients should return the
+	// value of the wrapped client.
+	Impersonates() bool
+}
@@ -69,6 +69,13 @@ type RowsWarner interface {
 	Warning() string
 }
 
+// RowsETagger is an optional interface that may be implemented by a Rows, to
+// expose the ETag of a view or _all_docs response.
+type RowsETagger interface {
+	// ETag returns the unquoted ETag header, if present.
+	ETag() string
+}
+
 // Bookmarker is an optional interface that may be implemented by a Rows for
 // returning a paging bookmark.
 type Bookmarker interface {
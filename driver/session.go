@@ -14,6 +14,7 @@ package driver
 
 import (
 	"context"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
@@ -44,3 +45,19 @@ type Sessioner interface {
 	// Session returns information about the authenticated user.
 	Session(ctx context.Context) (*Session, error)
 }
+
+// Deauthenticator is an optional interface that a Client may satisfy to end
+// an authenticated session.
+type Deauthenticator interface {
+	// Logout ends the current session, if any, and discards the credentials
+	// of the client.
+	Logout(ctx context.Context) error
+}
+
+// SessionExpirer is an optional interface that a Client using cookie
+// authentication may satisfy, to report when its session expires.
+type SessionExpirer interface {
+	// SessionExpiry returns the expiry time of the current session cookie,
+	// or the zero time if it is not known.
+	SessionExpiry() time.Time
+}
@@ -13,11 +13,31 @@
 package kivik
 
 import (
+	"context"
+	ejson "encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
 
 	"golang.org/x/xerrors"
+
+	kerrors "github.com/dannyzhou2015/kivik/v4/errors"
+)
+
+// Pseudo-status codes, returned by StatusCode for errors which did not
+// originate from an HTTP response.
+const (
+	// StatusUnknownError indicates an error of unknown origin.
+	StatusUnknownError = kerrors.StatusUnknownError
+	// StatusNetworkError indicates a network failure, or a cancelled or
+	// expired context.
+	StatusNetworkError = kerrors.StatusNetworkError
+	// StatusBadResponse indicates a response that could not be decoded.
+	StatusBadResponse = kerrors.StatusBadResponse
+	// StatusBadAPICall indicates that the client was used incorrectly.
+	StatusBadAPICall = kerrors.StatusBadAPICall
 )
 
 // Error represents an error returned by Kivik.
@@ -36,8 +56,70 @@ type Error struct {
 	// Message is the error message.
 	Message string
 
+	// Code is the CouchDB error code, as returned in the "error" field of an
+	// error response, such as "conflict", "not_found" or "file_exists".
+	Code string
+
+	// Reason is the human-readable reason, as returned in the "reason" field
+	// of an error response.
+	Reason string
+
 	// Err is the originating error, if any.
 	Err error
+
+	// Op describes the Kivik method call which produced the error, if known.
+	Op *Operation
+}
+
+// Operation describes a Kivik method call, for the purpose of providing
+// context to errors.
+type Operation struct {
+	// Method is the name of the Client or DB method, such as "Get" or
+	// "CreateDB".
+	Method string
+	// Driver is the name of the driver used by the client.
+	Driver string
+	// DB is the name of the database, if any.
+	DB string
+	// DocID is the document ID, if any.
+	DocID string
+	// Rev is the document revision, if any.
+	Rev string
+}
+
+// String returns a concise description of the operation, such as
+// "Get(db=foo, docID=bar, rev=1-xxx) [couch]".
+func (o *Operation) String() string {
+	args := make([]string, 0, 3)
+	if o.DB != "" {
+		args = append(args, "db="+o.DB)
+	}
+	if o.DocID != "" {
+		args = append(args, "docID="+o.DocID)
+	}
+	if o.Rev != "" {
+		args = append(args, "rev="+o.Rev)
+	}
+	str := o.Method + "(" + strings.Join(args, ", ") + ")"
+	if o.Driver != "" {
+		str += " [" + o.Driver + "]"
+	}
+	return str
+}
+
+// wrap annotates err with the operation. Errors which already carry an
+// operation are returned unaltered, so that the innermost Kivik call is
+// reported.
+func (o *Operation) wrap(err error) error {
+	if err == nil || ErrorOperation(err) != nil {
+		return err
+	}
+	if e, ok := err.(*Error); ok {
+		annotated := *e
+		annotated.Op = o
+		return &annotated
+	}
+	return &Error{Err: err, Op: o}
 }
 
 var (
@@ -56,15 +138,28 @@ func (e *Error) Error() string {
 	return e.Message + ": " + e.Err.Error()
 }
 
-// StatusCode returns the HTTP status code associated with the error, or 500
-// (internal server error), if none.
+// StatusCode returns the HTTP status code associated with the error. If none
+// is set, the status of the originating error is returned, or 500 (internal
+// server error) if there is no originating error.
 func (e *Error) StatusCode() int {
 	if e.HTTPStatus == 0 {
+		if e.Err != nil {
+			return StatusCode(e.Err)
+		}
 		return http.StatusInternalServerError
 	}
 	return e.HTTPStatus
 }
 
+// ErrorCode returns the CouchDB error code. If the Code field is not set, it
+// is derived from the status code.
+func (e *Error) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return kerrors.StatusText(e.StatusCode())
+}
+
 // Cause satisfies the github.com/pkg/errors.causer interface by returning e.Err.
 func (e *Error) Cause() error {
 	return e.Err
@@ -76,17 +171,31 @@ func (e *Error) Unwrap() error {
 	return e.Err
 }
 
-// Format implements fmt.Formatter
+// Format implements fmt.Formatter. The %+v verb includes the operation, if
+// known, and the HTTP status.
 func (e *Error) Format(f fmt.State, c rune) {
-	parts := make([]string, 0, 3)
+	full := c == 'v' && f.Flag('+')
+	parts := make([]string, 0, 4)
+	if full && e.Op != nil {
+		parts = append(parts, e.Op.String())
+	}
 	if e.Message != "" {
 		parts = append(parts, e.Message)
+	} else if e.Err == nil && e.Reason != "" {
+		parts = append(parts, e.Reason)
 	}
-	switch c {
-	case 'v':
-		if f.Flag('+') {
-			parts = append(parts, fmt.Sprintf("%d / %s", e.HTTPStatus, http.StatusText(e.HTTPStatus)))
+	if inner, ok := e.Err.(*Error); ok && full && e.HTTPStatus == 0 {
+		// Let the originating error report its own status.
+		parts = append(parts, fmt.Sprintf("%+v", inner))
+		_, _ = fmt.Fprint(f, strings.Join(parts, ": "))
+		return
+	}
+	if full {
+		status := e.HTTPStatus
+		if status == 0 {
+			status = e.StatusCode()
 		}
+		parts = append(parts, fmt.Sprintf("%d / %s", status, statusText(status)))
 	}
 	if e.Err != nil {
 		parts = append(parts, e.Err.Error())
@@ -94,12 +203,23 @@ func (e *Error) Format(f fmt.State, c rune) {
 	_, _ = fmt.Fprint(f, strings.Join(parts, ": "))
 }
 
+// statusText returns the text for an HTTP status code, or for one of Kivik's
+// pseudo-status codes.
+func statusText(code int) string {
+	if text := http.StatusText(code); text != "" {
+		return text
+	}
+	return kerrors.StatusText(code)
+}
+
 func (e *Error) msg() string {
-	switch e.Message {
-	case "":
-		return http.StatusText(e.StatusCode())
-	default:
+	switch {
+	case e.Message != "":
 		return e.Message
+	case e.Reason != "":
+		return e.Reason
+	default:
+		return http.StatusText(e.StatusCode())
 	}
 }
 
@@ -111,6 +231,35 @@ type causer interface {
 	Cause() error
 }
 
+type errorCoder interface {
+	ErrorCode() string
+}
+
+type reasoner interface {
+	Reason() string
+}
+
+// walkErrors calls fn for err, and each error it wraps, until fn returns true.
+// Both the Go 1.13 Unwrap and the github.com/pkg/errors Cause conventions are
+// followed.
+func walkErrors(err error, fn func(error) bool) bool {
+	for err != nil {
+		if fn(err) {
+			return true
+		}
+		if uw := xerrors.Unwrap(err); uw != nil {
+			err = uw
+			continue
+		}
+		if c, ok := err.(causer); ok {
+			err = c.Cause()
+			continue
+		}
+		return false
+	}
+	return false
+}
+
 // StatusCode returns the HTTP status code embedded in the error, or 500
 // (internal server error), if there was no specified status code.  If err is
 // nil, StatusCode returns 0. This provides a convenient way to determine the
@@ -134,23 +283,234 @@ type causer interface {
 //  type statusCoder interface {
 //      StatusCode() (httpStatusCode int)
 //  }
+//
+// Errors which do not conform to this interface are classified by their
+// origin: network failures and cancelled or expired contexts return
+// StatusNetworkError, and JSON decoding failures return StatusBadResponse.
 func StatusCode(err error) int {
 	if err == nil {
 		return 0
 	}
-	var coder statusCoder
-	for {
+	if code, ok := explicitStatusCode(err); ok {
+		return code
+	}
+	return classifyError(err)
+}
+
+// explicitStatusCode returns the status code of the first error in the chain
+// that conforms to the statusCoder interface.
+func explicitStatusCode(err error) (int, bool) {
+	var code int
+	found := walkErrors(err, func(err error) bool {
+		var coder statusCoder
 		if xerrors.As(err, &coder) {
-			return coder.StatusCode()
+			code = coder.StatusCode()
+			return true
 		}
-		if uw := xerrors.Unwrap(err); uw != nil {
-			err = uw
-			continue
+		return false
+	})
+	return code, found
+}
+
+// classifyError maps errors without an explicit status code to one of the
+// pseudo-status codes.
+func classifyError(err error) int {
+	status := http.StatusInternalServerError
+	walkErrors(err, func(err error) bool {
+		switch err.(type) {
+		case net.Error:
+			status = StatusNetworkError
+		case *ejson.SyntaxError, *ejson.UnmarshalTypeError:
+			status = StatusBadResponse
+		default:
+			switch err {
+			case context.Canceled, context.DeadlineExceeded:
+				status = StatusNetworkError
+			case io.ErrUnexpectedEOF:
+				status = StatusBadResponse
+			default:
+				return false
+			}
 		}
-		if c, ok := err.(causer); ok {
-			err = c.Cause()
-			continue
+		return true
+	})
+	return status
+}
+
+// decodeError marks err, returned while decoding a response, with
+// StatusBadResponse, unless it already carries a status, or was caused by the
+// network. This is necessary because the errors returned by jsoniter cannot be
+// told apart from other errors.
+func decodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := explicitStatusCode(err); ok || classifyError(err) == StatusNetworkError {
+		return err
+	}
+	return &Error{HTTPStatus: StatusBadResponse, Err: err}
+}
+
+// unmarshal unmarshals data into dest, marking errors with StatusBadResponse.
+func unmarshal(data []byte, dest interface{}) error {
+	return decodeError(json.Unmarshal(data, dest))
+}
+
+// decode decodes the JSON value read from r into dest, marking errors with
+// StatusBadResponse.
+func decode(r io.Reader, dest interface{}) error {
+	return decodeError(json.NewDecoder(r).Decode(dest))
+}
+
+// ErrorCode returns the CouchDB error code embedded in err, such as
+// "conflict", "not_found" or "file_exists". If err carries no explicit code,
+// one is derived from StatusCode(err). If err is nil, ErrorCode returns an
+// empty string.
+//
+// Driver implementations may provide an explicit code by returning errors
+// which conform to this interface:
+//
+//  type errorCoder interface {
+//      ErrorCode() string
+//  }
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var code string
+	walkErrors(err, func(err error) bool {
+		if e, ok := err.(*Error); ok {
+			code = e.Code
+			return code != ""
 		}
-		return http.StatusInternalServerError
+		if coder, ok := err.(errorCoder); ok {
+			code = coder.ErrorCode()
+			return true
+		}
+		return false
+	})
+	if code != "" {
+		return code
+	}
+	return kerrors.StatusText(StatusCode(err))
+}
+
+// ErrorReason returns the human-readable reason embedded in err, as returned
+// by the server, or an empty string if there is none.
+//
+// Driver implementations may provide a reason by returning errors which
+// conform to this interface:
+//
+//  type reasoner interface {
+//      Reason() string
+//  }
+func ErrorReason(err error) string {
+	var reason string
+	walkErrors(err, func(err error) bool {
+		if e, ok := err.(*Error); ok {
+			reason = e.Reason
+			return reason != ""
+		}
+		if r, ok := err.(reasoner); ok {
+			reason = r.Reason()
+			return true
+		}
+		return false
+	})
+	return reason
+}
+
+// ErrorOperation returns the Operation which produced err, or nil if it is
+// not known.
+func ErrorOperation(err error) *Operation {
+	var op *Operation
+	walkErrors(err, func(err error) bool {
+		if e, ok := err.(*Error); ok && e.Op != nil {
+			op = e.Op
+			return true
+		}
+		return false
+	})
+	return op
+}
+
+// IsNotFound returns true if err indicates that the requested resource does
+// not exist.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsNotModified returns true if err indicates that a conditional request,
+// made with OptionIfNoneMatch, found the resource unchanged.
+func IsNotModified(err error) bool {
+	return StatusCode(err) == http.StatusNotModified
+}
+
+// IsConflict returns true if err indicates a document update conflict.
+func IsConflict(err error) bool {
+	return StatusCode(err) == http.StatusConflict
+}
+
+// IsUnauthorized returns true if err indicates missing or invalid
+// credentials.
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden returns true if err indicates that the authenticated user lacks
+// permission for the requested operation.
+func IsForbidden(err error) bool {
+	return StatusCode(err) == http.StatusForbidden
+}
+
+// IsTimeout returns true if err indicates a timeout, either reported by the
+// server, or due to an expired context or a network timeout.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if walkErrors(err, func(err error) bool {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+		netErr, ok := err.(net.Error)
+		return ok && netErr.Timeout()
+	}) {
+		return true
+	}
+	switch StatusCode(err) {
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryable returns true if err indicates a transient failure, such that
+// the same request may succeed if repeated. This includes network errors,
+// rate limiting (429), and 5xx errors other than 501 (not implemented).
+// Cancelled or expired contexts are never retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if walkErrors(err, func(err error) bool {
+		return err == context.Canceled || err == context.DeadlineExceeded
+	}) {
+		return false
+	}
+	code, explicit := explicitStatusCode(err)
+	if !explicit {
+		return classifyError(err) == StatusNetworkError
+	}
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code == http.StatusNotImplemented:
+		return false
+	case code >= 500 && code < 600:
+		return true
+	case code == StatusNetworkError:
+		return true
 	}
+	return false
 }
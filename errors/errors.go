@@ -17,6 +17,10 @@ package errors // import "github.com/dannyzhou2015/kivik/v4/errors"
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 
@@ -28,6 +32,7 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 // statusError is an error message bundled with an HTTP status code.
 type statusError struct {
 	statusCode int
+	code       string
 	message    string
 }
 
@@ -35,7 +40,7 @@ type statusError struct {
 // type.
 func (se *statusError) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]string{
-		"error":  statusText(se.statusCode),
+		"error":  se.ErrorCode(),
 		"reason": se.message,
 	})
 }
@@ -54,6 +59,15 @@ func (se *statusError) Reason() string {
 	return se.message
 }
 
+// ErrorCode returns the CouchDB error code, such as "conflict". If none was
+// provided, it is derived from the HTTP status code.
+func (se *statusError) ErrorCode() string {
+	if se.code != "" {
+		return se.code
+	}
+	return StatusText(se.statusCode)
+}
+
 // New is a wrapper around the standard errors.New, to avoid the need for
 // multiple imports.
 func New(msg string) error {
@@ -76,6 +90,31 @@ func Statusf(status int, format string, args ...interface{}) error {
 	}
 }
 
+// StatusReason returns a new error with the designated HTTP status, CouchDB
+// error code (such as "conflict" or "file_exists") and reason.
+func StatusReason(status int, code, reason string) error {
+	return &statusError{
+		statusCode: status,
+		code:       code,
+		message:    reason,
+	}
+}
+
+// FromResponse parses a CouchDB error response body, of the form
+// {"error":"not_found","reason":"missing"}, into an error with the designated
+// HTTP status. If body is not a valid error object, its raw content is used
+// as the reason.
+func FromResponse(status int, body []byte) error {
+	var resp struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+		return StatusReason(status, "", string(body))
+	}
+	return StatusReason(status, resp.Error, resp.Reason)
+}
+
 type wrappedError struct {
 	err        error
 	statusCode int
@@ -104,6 +143,62 @@ func WrapStatus(status int, err error) error {
 	}
 }
 
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+// RetryAfter returns the delay requested by the server.
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.delay
+}
+
+func (e *retryAfterError) Cause() error {
+	return e.err
+}
+
+// WithRetryAfter bundles an existing error with the delay the server asked
+// the client to wait before retrying, such as from a Retry-After header.
+// Kivik waits for this delay, rather than its usual backoff, when it retries
+// the request under a RetryPolicy. The status code of err is preserved.
+func WithRetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{
+		err:   err,
+		delay: delay,
+	}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP date. ok is false if value is empty or
+// invalid. A date in the past yields a zero delay.
+func ParseRetryAfter(value string) (delay time.Duration, ok bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay = time.Until(date); delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
 // Wrap is a wrapper around pkg/errors.Wrap()
 func Wrap(err error, msg string) error {
 	return errors.Wrap(err, msg)
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	pkgErrors "github.com/pkg/errors"
 	"gitlab.com/flimzy/testy"
@@ -208,3 +209,133 @@ func TestErrorf(t *testing.T) {
 		t.Errorf("Unexpected Error: %s", e)
 	}
 }
+
+func TestStatusReason(t *testing.T) {
+	err := StatusReason(http.StatusConflict, "conflict", "Document update conflict.")
+	expected := &statusError{
+		statusCode: http.StatusConflict,
+		code:       "conflict",
+		message:    "Document update conflict.",
+	}
+	if d := testy.DiffInterface(expected, err); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestStatusErrorCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *statusError
+		expected string
+	}{
+		{
+			name:     "explicit code",
+			err:      &statusError{statusCode: http.StatusPreconditionFailed, code: "file_exists"},
+			expected: "file_exists",
+		},
+		{
+			name:     "derived from status",
+			err:      &statusError{statusCode: http.StatusNotFound},
+			expected: "not_found",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.err.ErrorCode(); result != test.expected {
+				t.Errorf("Unexpected ErrorCode: %s", result)
+			}
+		})
+	}
+}
+
+func TestFromResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected *statusError
+	}{
+		{
+			name:   "standard error",
+			status: http.StatusNotFound,
+			body:   `{"error":"not_found","reason":"Database does not exist."}`,
+			expected: &statusError{
+				statusCode: http.StatusNotFound,
+				code:       "not_found",
+				message:    "Database does not exist.",
+			},
+		},
+		{
+			name:   "invalid JSON",
+			status: http.StatusBadGateway,
+			body:   "<html>Bad Gateway</html>",
+			expected: &statusError{
+				statusCode: http.StatusBadGateway,
+				message:    "<html>Bad Gateway</html>",
+			},
+		},
+		{
+			name:   "missing error field",
+			status: http.StatusBadRequest,
+			body:   `{"foo":"bar"}`,
+			expected: &statusError{
+				statusCode: http.StatusBadRequest,
+				message:    `{"foo":"bar"}`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := FromResponse(test.status, []byte(test.body))
+			if d := testy.DiffInterface(test.expected, err); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	if err := WithRetryAfter(nil, time.Second); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	e := Status(http.StatusTooManyRequests, "slow down")
+	err := WithRetryAfter(e, 3*time.Second)
+	if msg := err.Error(); msg != "slow down" {
+		t.Errorf("Unexpected Error: %s", msg)
+	}
+	ra, ok := err.(interface{ RetryAfter() time.Duration })
+	if !ok {
+		t.Fatal("Expected a RetryAfter method")
+	}
+	if delay := ra.RetryAfter(); delay != 3*time.Second {
+		t.Errorf("Unexpected RetryAfter: %v", delay)
+	}
+	if cause := pkgErrors.Cause(err); cause != e {
+		t.Errorf("Unexpected Cause: %v", cause)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "120", delay: 2 * time.Minute, ok: true},
+		{value: " 0 ", delay: 0, ok: true},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+		{value: "Wed, 21 Oct 2015 07:28:00 GMT", delay: 0, ok: true},
+	}
+	for _, test := range tests {
+		delay, ok := ParseRetryAfter(test.value)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("%q: expected %v, %t, got %v, %t", test.value, test.delay, test.ok, delay, ok)
+		}
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay, ok := ParseRetryAfter(future); !ok || delay <= 59*time.Minute || delay > time.Hour {
+		t.Errorf("Unexpected delay for %s: %v, %t", future, delay, ok)
+	}
+}
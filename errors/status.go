@@ -12,6 +12,21 @@
 
 package errors
 
+// Pseudo-status codes, used to classify errors which did not originate from
+// an HTTP response, such as network failures or malformed response bodies.
+const (
+	// StatusUnknownError indicates an error of unknown origin.
+	StatusUnknownError = 600
+	// StatusNetworkError indicates a network error, such as a connection
+	// reset, a DNS failure, or a cancelled or expired context.
+	StatusNetworkError = 601
+	// StatusBadResponse indicates that the server's response could not be
+	// understood, such as a malformed JSON body.
+	StatusBadResponse = 602
+	// StatusBadAPICall indicates that the client was used incorrectly.
+	StatusBadAPICall = 604
+)
+
 var statusTextStrings = map[int]string{
 	400: "bad_request",
 	401: "unauthorized",
@@ -29,15 +44,16 @@ var statusTextStrings = map[int]string{
 	500: "internal_server_error",
 	501: "not_implemented",
 
-	600: "unknown",
-	601: "network_error",
-	602: "bad_response",
-	604: "bad_api_call",
+	StatusUnknownError: "unknown",
+	StatusNetworkError: "network_error",
+	StatusBadResponse:  "bad_response",
+	StatusBadAPICall:   "bad_api_call",
 }
 
-// statusText returns a text for the HTTP status code. It returns the string
-// "unknown" if the code is unknown to Kivik.
-func statusText(code int) string {
+// StatusText returns the CouchDB-style error code for the HTTP status code,
+// such as "not_found" for 404. It returns the string "unknown" if the code is
+// unknown to Kivik.
+func StatusText(code int) string {
 	if text, ok := statusTextStrings[code]; ok {
 		return text
 	}
@@ -13,15 +13,25 @@
 package kivik
 
 import (
+	"context"
+	ejson "encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 
 	pkgerrs "github.com/pkg/errors"
 	"gitlab.com/flimzy/testy"
 	"golang.org/x/xerrors"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	kerrors "github.com/dannyzhou2015/kivik/v4/errors"
 )
 
 func TestStatusCoder(t *testing.T) {
@@ -69,6 +79,36 @@ func TestStatusCoder(t *testing.T) {
 			}(),
 			Expected: 400,
 		},
+		{
+			Name:     "status inherited from wrapped error",
+			Err:      &Error{Message: "foo", Err: &Error{HTTPStatus: 409}},
+			Expected: 409,
+		},
+		{
+			Name:     "context cancelled",
+			Err:      context.Canceled,
+			Expected: StatusNetworkError,
+		},
+		{
+			Name:     "wrapped deadline exceeded",
+			Err:      pkgerrs.Wrap(context.DeadlineExceeded, "foo"),
+			Expected: StatusNetworkError,
+		},
+		{
+			Name:     "network error",
+			Err:      &url.Error{Op: "Get", URL: "http://example.com/", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			Expected: StatusNetworkError,
+		},
+		{
+			Name:     "JSON syntax error",
+			Err:      xerrors.Errorf("foo: %w", &ejson.SyntaxError{}),
+			Expected: StatusBadResponse,
+		},
+		{
+			Name:     "unexpected EOF",
+			Err:      io.ErrUnexpectedEOF,
+			Expected: StatusBadResponse,
+		},
 	}
 	for _, test := range tests {
 		func(test scTest) {
@@ -108,6 +148,30 @@ func TestFormatError(t *testing.T) {
 		std:  "It's missing: not found",
 		full: `It's missing: 404 / Not Found: not found`,
 	})
+	tests.Add("reason without message", tst{
+		err:  &Error{HTTPStatus: http.StatusConflict, Code: "conflict", Reason: "Document update conflict."},
+		str:  "Document update conflict.",
+		std:  "Document update conflict.",
+		full: `Document update conflict.: 409 / Conflict`,
+	})
+	tests.Add("with operation", tst{
+		err: &Error{
+			Err: &Error{HTTPStatus: http.StatusNotFound, Message: "missing"},
+			Op:  &Operation{Method: "Get", Driver: "couch", DB: "foo", DocID: "bar", Rev: "1-xxx"},
+		},
+		str:  "missing",
+		std:  "missing",
+		full: `Get(db=foo, docID=bar, rev=1-xxx) [couch]: missing: 404 / Not Found`,
+	})
+	tests.Add("operation wrapping driver error", tst{
+		err: &Error{
+			Err: kerrors.Status(http.StatusConflict, "Document update conflict."),
+			Op:  &Operation{Method: "Put", DB: "foo", DocID: "bar"},
+		},
+		str:  "Document update conflict.",
+		std:  "Document update conflict.",
+		full: `Put(db=foo, docID=bar): 409 / Conflict: Document update conflict.`,
+	})
 	tests.Add("embedded error", func() interface{} {
 		_, err := json.Marshal(func() {}) //nolint:staticcheck
 		return tst{
@@ -149,3 +213,268 @@ func TestFormatError(t *testing.T) {
 		}
 	})
 }
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   string
+		reason string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "standard error",
+			err:  errors.New("foo"),
+			code: "internal_server_error",
+		},
+		{
+			name:   "explicit code",
+			err:    &Error{HTTPStatus: http.StatusPreconditionFailed, Code: "file_exists", Reason: "The database could not be created, the file already exists."},
+			code:   "file_exists",
+			reason: "The database could not be created, the file already exists.",
+		},
+		{
+			name: "derived from status",
+			err:  &Error{HTTPStatus: http.StatusConflict},
+			code: "conflict",
+		},
+		{
+			name:   "driver error",
+			err:    pkgerrs.Wrap(kerrors.StatusReason(http.StatusNotFound, "not_found", "deleted"), "foo"),
+			code:   "not_found",
+			reason: "deleted",
+		},
+		{
+			name:   "wrapped driver error",
+			err:    &Error{Message: "foo", Err: kerrors.FromResponse(http.StatusForbidden, []byte(`{"error":"forbidden","reason":"nope"}`))},
+			code:   "forbidden",
+			reason: "nope",
+		},
+		{
+			name: "network error",
+			err:  context.Canceled,
+			code: "network_error",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := ErrorCode(test.err); code != test.code {
+				t.Errorf("Unexpected code: %s", code)
+			}
+			if reason := ErrorReason(test.err); reason != test.reason {
+				t.Errorf("Unexpected reason: %s", reason)
+			}
+		})
+	}
+}
+
+type timeoutErr struct{}
+
+var _ net.Error = timeoutErr{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestErrorPredicates(t *testing.T) {
+	type tst struct {
+		err          error
+		notModified  bool
+		notFound     bool
+		conflict     bool
+		unauthorized bool
+		forbidden    bool
+		timeout      bool
+		retryable    bool
+	}
+	tests := testy.NewTable()
+	tests.Add("nil", tst{})
+	tests.Add("standard error", tst{
+		err: errors.New("foo"),
+	})
+	tests.Add("not modified", tst{
+		err:         &Error{HTTPStatus: http.StatusNotModified},
+		notModified: true,
+	})
+	tests.Add("not found", tst{
+		err:      pkgerrs.Wrap(&Error{HTTPStatus: http.StatusNotFound}, "foo"),
+		notFound: true,
+	})
+	tests.Add("conflict", tst{
+		err:      kerrors.Status(http.StatusConflict, "conflict"),
+		conflict: true,
+	})
+	tests.Add("unauthorized", tst{
+		err:          &Error{HTTPStatus: http.StatusUnauthorized},
+		unauthorized: true,
+	})
+	tests.Add("forbidden", tst{
+		err:       &Error{HTTPStatus: http.StatusForbidden},
+		forbidden: true,
+	})
+	tests.Add("gateway timeout", tst{
+		err:       &Error{HTTPStatus: http.StatusGatewayTimeout},
+		timeout:   true,
+		retryable: true,
+	})
+	tests.Add("service unavailable", tst{
+		err:       &Error{HTTPStatus: http.StatusServiceUnavailable},
+		retryable: true,
+	})
+	tests.Add("too many requests", tst{
+		err:       &Error{HTTPStatus: http.StatusTooManyRequests},
+		retryable: true,
+	})
+	tests.Add("explicit internal server error", tst{
+		err:       &Error{HTTPStatus: http.StatusInternalServerError},
+		retryable: true,
+	})
+	tests.Add("not implemented", tst{
+		err: &Error{HTTPStatus: http.StatusNotImplemented},
+	})
+	tests.Add("deadline exceeded", tst{
+		err:     xerrors.Errorf("foo: %w", context.DeadlineExceeded),
+		timeout: true,
+	})
+	tests.Add("cancelled", tst{
+		err: &url.Error{Op: "Get", URL: "/", Err: context.Canceled},
+	})
+	tests.Add("network timeout", tst{
+		err:       &url.Error{Op: "Get", URL: "/", Err: timeoutErr{}},
+		timeout:   true,
+		retryable: true,
+	})
+	tests.Add("connection reset", tst{
+		err:       &Error{Message: "foo", Err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}},
+		retryable: true,
+	})
+	tests.Add("bad response", tst{
+		err: &ejson.SyntaxError{},
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		if r := IsNotModified(test.err); r != test.notModified {
+			t.Errorf("IsNotModified: %t", r)
+		}
+		if r := IsNotFound(test.err); r != test.notFound {
+			t.Errorf("IsNotFound: %t", r)
+		}
+		if r := IsConflict(test.err); r != test.conflict {
+			t.Errorf("IsConflict: %t", r)
+		}
+		if r := IsUnauthorized(test.err); r != test.unauthorized {
+			t.Errorf("IsUnauthorized: %t", r)
+		}
+		if r := IsForbidden(test.err); r != test.forbidden {
+			t.Errorf("IsForbidden: %t", r)
+		}
+		if r := IsTimeout(test.err); r != test.timeout {
+			t.Errorf("IsTimeout: %t", r)
+		}
+		if r := IsRetryable(test.err); r != test.retryable {
+			t.Errorf("IsRetryable: %t", r)
+		}
+	})
+}
+
+func TestOperationWrap(t *testing.T) {
+	op := &Operation{Method: "Put", DB: "foo", DocID: "bar"}
+	type tst struct {
+		err      error
+		expected error
+	}
+	tests := testy.NewTable()
+	tests.Add("nil", tst{})
+	tests.Add("standard error", tst{
+		err:      errors.New("foo"),
+		expected: &Error{Err: errors.New("foo"), Op: op},
+	})
+	tests.Add("kivik error", tst{
+		err:      &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: docID required"},
+		expected: &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: docID required", Op: op},
+	})
+	tests.Add("already annotated", tst{
+		err:      &Error{Err: errors.New("foo"), Op: &Operation{Method: "Get"}},
+		expected: &Error{Err: errors.New("foo"), Op: &Operation{Method: "Get"}},
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		err := op.wrap(test.err)
+		if d := testy.DiffInterface(test.expected, err); d != nil {
+			t.Error(d)
+		}
+		if test.err != nil && err.Error() != test.err.Error() {
+			t.Errorf("Error() changed: %s", err)
+		}
+		if StatusCode(err) != StatusCode(test.err) {
+			t.Errorf("StatusCode changed: %d", StatusCode(err))
+		}
+	})
+}
+
+func TestErrorOperation(t *testing.T) {
+	op := &Operation{Method: "Get", DB: "foo"}
+	if result := ErrorOperation(nil); result != nil {
+		t.Errorf("Unexpected operation for nil: %v", result)
+	}
+	if result := ErrorOperation(errors.New("foo")); result != nil {
+		t.Errorf("Unexpected operation for standard error: %v", result)
+	}
+	err := pkgerrs.Wrap(&Error{Err: errors.New("foo"), Op: op}, "bar")
+	if result := ErrorOperation(err); result != op {
+		t.Errorf("Unexpected operation: %v", result)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	bad := func() *rows {
+		return &rows{
+			iter: &iter{
+				ready: true,
+				curVal: &driver.Row{
+					Key:   []byte(`{"foo"`),
+					Value: []byte(`[1,`),
+					Doc:   []byte(`{"foo":}`),
+				},
+			},
+		}
+	}
+	scans := map[string]func(interface{}) error{
+		"ScanKey":   bad().ScanKey,
+		"ScanValue": bad().ScanValue,
+		"ScanDoc":   bad().ScanDoc,
+		"ScanDoc reader": (&rows{
+			iter: &iter{
+				ready:  true,
+				curVal: &driver.Row{DocReader: strings.NewReader(`{"foo":`)},
+			},
+		}).ScanDoc,
+		"Row.ScanDoc": (&row{body: ioutil.NopCloser(strings.NewReader(`nul`))}).ScanDoc,
+	}
+	for name, scan := range scans {
+		t.Run(name, func(t *testing.T) {
+			var dest interface{}
+			err := scan(&dest)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if status := StatusCode(err); status != StatusBadResponse {
+				t.Errorf("Unexpected status %d for %s", status, err)
+			}
+		})
+	}
+	t.Run("explicit status", func(t *testing.T) {
+		err := decodeError(&Error{HTTPStatus: http.StatusNotFound})
+		if status := StatusCode(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+	t.Run("network error", func(t *testing.T) {
+		err := decodeError(context.Canceled)
+		if status := StatusCode(err); status != StatusNetworkError {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package failover
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// router routes reads to a healthy node, and writes to the primary node.
+type router struct {
+	*Pool
+}
+
+var (
+	_ forward.ClientRouter   = &router{}
+	_ driver.Authenticator   = &router{}
+	_ driver.Pinger          = &router{}
+	_ driver.Impersonator    = &router{}
+	_ driver.Deauthenticator = &router{}
+	_ driver.SessionExpirer  = &router{}
+)
+
+func (r *router) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.Client) error) error {
+	route := r.read
+	if call.Write {
+		route = r.write
+	}
+	return route(ctx, func(ctx context.Context, n *node) error {
+		return fn(ctx, n.client)
+	})
+}
+
+// DB returns a router which creates per-node handles as they are needed.
+func (r *router) DB(dbName string, options map[string]interface{}) (driver.DB, forward.DBRouter, error) {
+	db := &database{
+		pool:    r.Pool,
+		name:    dbName,
+		options: options,
+		dbs:     make(map[*node]driver.DB),
+	}
+	base, err := db.handle(r.snapshot()[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return base, db, nil
+}
+
+// Close stops background health checks, and closes every node's client.
+func (r *router) Close(ctx context.Context) error {
+	return r.close(ctx)
+}
+
+// Authenticate authenticates every node, so that reads on any node share the
+// session.
+func (r *router) Authenticate(ctx context.Context, authenticator interface{}) error {
+	return r.authenticate(ctx, authenticator)
+}
+
+// Logout ends the session of every node.
+func (r *router) Logout(ctx context.Context) error {
+	return r.logout(ctx)
+}
+
+// SessionExpiry returns the session expiry of the primary node, which holds
+// the session for writes.
+func (r *router) SessionExpiry() time.Time {
+	if expirer, ok := r.primaryNode().client.(driver.SessionExpirer); ok {
+		return expirer.SessionExpiry()
+	}
+	return time.Time{}
+}
+
+// Impersonates reports whether every node supports impersonation, as a call
+// may be made on any node.
+func (r *router) Impersonates() bool {
+	nodes := r.snapshot()
+	for _, n := range nodes {
+		if i, ok := n.client.(driver.Impersonator); !ok || !i.Impersonates() {
+			return false
+		}
+	}
+	return len(nodes) > 0
+}
+
+// Ping reports whether any node is up.
+func (r *router) Ping(ctx context.Context) (up bool, err error) {
+	err = r.read(ctx, func(ctx context.Context, n *node) error {
+		return ping(ctx, n.client)
+	})
+	return err == nil, err
+}
@@ -0,0 +1,79 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package failover
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// database routes calls to a per-node driver.DB, created on first use.
+type database struct {
+	pool    *Pool
+	name    string
+	options map[string]interface{}
+
+	mu  sync.Mutex
+	dbs map[*node]driver.DB
+}
+
+var _ forward.DBRouter = &database{}
+
+func (db *database) handle(n *node) (driver.DB, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if h, ok := db.dbs[n]; ok {
+		return h, nil
+	}
+	h, err := n.client.DB(db.name, db.options)
+	if err != nil {
+		return nil, err
+	}
+	db.dbs[n] = h
+	return h, nil
+}
+
+// Route calls fn with a handle on a healthy node for reads, failing over as
+// necessary, or with a handle on the primary node for writes.
+func (db *database) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.DB) error) error {
+	route := db.pool.read
+	if call.Write {
+		route = db.pool.write
+	}
+	return route(ctx, func(ctx context.Context, n *node) error {
+		h, err := db.handle(n)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, h)
+	})
+}
+
+// Close closes every per-node handle created so far.
+func (db *database) Close(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var firstErr error
+	for n, h := range db.dbs {
+		if closer, ok := h.(driver.DBCloser); ok {
+			if err := closer.Close(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(db.dbs, n)
+	}
+	return firstErr
+}
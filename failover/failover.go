@@ -0,0 +1,150 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package failover provides a Kivik driver which spreads calls across several
+// nodes of a CouchDB cluster, and fails over automatically when a node is
+// unavailable.
+//
+// The failover driver wraps another driver, and accepts a comma-separated list
+// of DSNs, one per node:
+//
+//	d := failover.Register("couch-cluster", &couchdb.Couch{}, failover.Config{
+//		Balance: failover.LeastLatency,
+//	})
+//	client, err := kivik.New("couch-cluster", "http://a:5984/,http://b:5984/")
+//
+// Reads are balanced across healthy nodes, and are retried on the next node
+// when a node fails with a retryable error, as reported by kivik.IsRetryable.
+// Writes and session-bound calls are pinned to a single primary node, so
+// that a client reads its own session and sees a consistent write order.
+// When the primary fails, it is marked unhealthy, and the next healthy node
+// becomes the primary. The failed write itself is not repeated, as it may
+// have been applied; use a kivik.RetryPolicy to retry it on the new primary.
+//
+// Nodes are health-checked in the background with Ping, or Version for drivers
+// which do not support Ping. The health of each node is available from
+// Driver.Pool for monitoring.
+package failover
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// DefaultHealthInterval is the health check interval used when
+// Config.HealthInterval is zero.
+const DefaultHealthInterval = 10 * time.Second
+
+// Balance selects how reads are distributed across healthy nodes.
+type Balance int
+
+// Read balancing strategies.
+const (
+	// RoundRobin sends each read to the next healthy node in turn.
+	RoundRobin Balance = iota
+	// LeastLatency sends each read to the healthy node with the lowest
+	// observed latency.
+	LeastLatency
+)
+
+// Config configures a failover driver.
+type Config struct {
+	// Balance selects how reads are distributed.
+	Balance Balance
+
+	// HealthInterval is the interval between background health checks. If
+	// zero, DefaultHealthInterval is used. If negative, background health
+	// checks are disabled, and Pool.Check must be called explicitly.
+	HealthInterval time.Duration
+
+	// Discover, if true, adds the nodes listed in the cluster membership of
+	// the first node that reports it, on each health check.
+	Discover bool
+
+	// NodeURL maps a cluster node name, such as "couchdb@10.0.0.2", to a
+	// DSN, for use with Discover. seed is the first DSN provided to the
+	// client. If nil, the host part of the node name replaces the host of
+	// seed, retaining its scheme, credentials, port and path.
+	NodeURL func(seed *url.URL, node string) string
+}
+
+// Driver is a driver.Driver which returns failover clients.
+type Driver struct {
+	base driver.Driver
+	cfg  Config
+
+	mu    sync.Mutex
+	pools map[string]*Pool
+}
+
+var _ driver.Driver = &Driver{}
+
+// New returns a failover driver which connects to each node with base.
+func New(base driver.Driver, cfg Config) *Driver {
+	return &Driver{
+		base:  base,
+		cfg:   cfg,
+		pools: make(map[string]*Pool),
+	}
+}
+
+// Register makes a failover driver, which wraps base, available by the
+// provided name. It panics under the same conditions as kivik.Register.
+func Register(name string, base driver.Driver, cfg Config) *Driver {
+	d := New(base, cfg)
+	kivik.Register(name, d)
+	return d
+}
+
+// NewClient returns a client for the comma-separated list of node DSNs in
+// dsn.
+func (d *Driver) NewClient(dsn string, options map[string]interface{}) (driver.Client, error) {
+	var dsns []string
+	for _, s := range strings.Split(dsn, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			dsns = append(dsns, s)
+		}
+	}
+	if len(dsns) == 0 {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "failover: no DSN provided"}
+	}
+	pool, err := newPool(d.base, d.cfg, dsns, options)
+	if err != nil {
+		return nil, err
+	}
+	pool.onClose = func() {
+		d.mu.Lock()
+		if d.pools[dsn] == pool {
+			delete(d.pools, dsn)
+		}
+		d.mu.Unlock()
+	}
+	d.mu.Lock()
+	d.pools[dsn] = pool
+	d.mu.Unlock()
+	return forward.NewClient(pool.snapshot()[0].client, &router{pool}), nil
+}
+
+// Pool returns the pool of the most recently created, open client for dsn,
+// or nil if there is none.
+func (d *Driver) Pool(dsn string) *Pool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pools[dsn]
+}
@@ -0,0 +1,323 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package failover
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// fakeNode simulates a single cluster node.
+type fakeNode struct {
+	mu      sync.Mutex
+	dsn     string
+	down    bool
+	err     error
+	latency time.Duration
+	calls   []string
+	members []string
+}
+
+func (n *fakeNode) call(method string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, method)
+	if n.down {
+		return &kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Message: n.dsn + " is down"}
+	}
+	return n.err
+}
+
+func (n *fakeNode) setDown(down bool) {
+	n.mu.Lock()
+	n.down = down
+	n.mu.Unlock()
+}
+
+func (n *fakeNode) callCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.calls)
+}
+
+type fakeCluster struct {
+	mu    sync.Mutex
+	nodes map[string]*fakeNode
+	now   time.Time
+}
+
+func newFakeCluster(dsns ...string) *fakeCluster {
+	c := &fakeCluster{nodes: make(map[string]*fakeNode), now: time.Now()}
+	for _, dsn := range dsns {
+		c.nodes[dsn] = &fakeNode{dsn: dsn}
+	}
+	return c
+}
+
+func (c *fakeCluster) node(dsn string) *fakeNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[dsn]
+}
+
+func (c *fakeCluster) driver() driver.Driver {
+	return &mock.Driver{
+		NewClientFunc: func(dsn string, _ map[string]interface{}) (driver.Client, error) {
+			n := c.node(dsn)
+			if n == nil {
+				n = &fakeNode{dsn: dsn}
+				c.mu.Lock()
+				c.nodes[dsn] = n
+				c.mu.Unlock()
+			}
+			client := &mock.Client{
+				ID: dsn,
+				VersionFunc: func(context.Context) (*driver.Version, error) {
+					if err := n.call("Version"); err != nil {
+						return nil, err
+					}
+					return &driver.Version{Version: dsn}, nil
+				},
+				DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+					return &mock.DB{
+						PutFunc: func(context.Context, string, interface{}, map[string]interface{}) (string, error) {
+							if err := n.call("Put"); err != nil {
+								return "", err
+							}
+							return "1-" + dsn, nil
+						},
+					}, nil
+				},
+			}
+			return &mock.Cluster{
+				Client: client,
+				MembershipFunc: func(context.Context) (*driver.ClusterMembership, error) {
+					return &driver.ClusterMembership{ClusterNodes: n.members}, nil
+				},
+			}, nil
+		},
+	}
+}
+
+func newTestPool(t *testing.T, cluster *fakeCluster, cfg Config, dsns string) (*Pool, driver.Client) {
+	cfg.HealthInterval = -1
+	d := New(cluster.driver(), cfg)
+	c, err := d.NewClient(dsns, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := d.Pool(dsns)
+	pool.now = func() time.Time { return cluster.now }
+	return pool, c
+}
+
+func TestReadFailover(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/")
+	cluster.node("http://a/").setDown(true)
+	pool, c := newTestPool(t, cluster, Config{}, "http://a/,http://b/")
+	for i := 0; i < 4; i++ {
+		ver, err := c.Version(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver.Version != "http://b/" {
+			t.Errorf("Read served by %s", ver.Version)
+		}
+	}
+	if n := cluster.node("http://a/").callCount(); n != 1 {
+		t.Errorf("Unhealthy node should be skipped, got %d calls", n)
+	}
+	status := pool.Nodes()
+	if status[0].Healthy || status[0].Failures != 1 || status[0].LastError == nil {
+		t.Errorf("Unexpected status for failed node: %+v", status[0])
+	}
+	if !status[1].Healthy {
+		t.Errorf("Expected healthy node: %+v", status[1])
+	}
+	for _, s := range status {
+		if s.Primary {
+			t.Errorf("No node should be primary before the first write: %+v", s)
+		}
+	}
+
+	cluster.node("http://b/").setDown(true)
+	_, err := c.Version(context.Background())
+	testy.StatusError(t, "http://a/ is down", http.StatusServiceUnavailable, err)
+}
+
+func TestRoundRobin(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/", "http://c/")
+	_, c := newTestPool(t, cluster, Config{}, "http://a/,http://b/,http://c/")
+	for i := 0; i < 6; i++ {
+		if _, err := c.Version(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dsn := range []string{"http://a/", "http://b/", "http://c/"} {
+		if n := cluster.node(dsn).callCount(); n != 2 {
+			t.Errorf("Expected 2 reads from %s, got %d", dsn, n)
+		}
+	}
+}
+
+func TestLeastLatency(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/")
+	pool, c := newTestPool(t, cluster, Config{Balance: LeastLatency}, "http://a/,http://b/")
+	pool.nodes[0].checked(cluster.now, 50*time.Millisecond, nil)
+	pool.nodes[1].checked(cluster.now, 5*time.Millisecond, nil)
+	ver, err := c.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Version != "http://b/" {
+		t.Errorf("Expected fastest node, got %s", ver.Version)
+	}
+}
+
+func TestWritePinning(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/")
+	pool, c := newTestPool(t, cluster, Config{}, "http://a/,http://b/")
+	db, err := c.DB("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func() (string, error) {
+		return db.Put(context.Background(), "doc", map[string]string{}, nil)
+	}
+	for i := 0; i < 3; i++ {
+		if rev, err := put(); err != nil || rev != "1-http://a/" {
+			t.Fatalf("Write not pinned to primary: %s, %v", rev, err)
+		}
+	}
+
+	cluster.node("http://a/").setDown(true)
+	if _, err := put(); kivik.StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Nodes reports the pinned primary, and does not replace it.
+	if status := pool.Nodes(); status[0].Healthy || !status[0].Primary || status[1].Primary {
+		t.Errorf("Expected unhealthy primary a: %+v", status)
+	}
+	if rev, err := put(); err != nil || rev != "1-http://b/" {
+		t.Fatalf("Expected failover to new primary: %s, %v", rev, err)
+	}
+	if status := pool.Nodes(); status[0].Primary || !status[1].Primary {
+		t.Errorf("Expected primary b: %+v", status)
+	}
+
+	cluster.node("http://a/").setDown(false)
+	pool.Check(context.Background())
+	if !pool.Nodes()[0].Healthy {
+		t.Fatal("Health check should mark recovered node healthy")
+	}
+	if rev, _ := put(); rev != "1-http://b/" {
+		t.Errorf("Primary should remain pinned after recovery, got %s", rev)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	cluster := newFakeCluster("http://admin:abc123@a:5984/")
+	cluster.node("http://admin:abc123@a:5984/").members = []string{"couchdb@a", "couchdb@b"}
+	pool, _ := newTestPool(t, cluster, Config{Discover: true}, "http://admin:abc123@a:5984/")
+	pool.Check(context.Background())
+	status := pool.Nodes()
+	if len(status) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(status))
+	}
+	if status[1].DSN != "http://admin:xxxxx@b:5984/" {
+		t.Errorf("Unexpected discovered DSN: %s", status[1].DSN)
+	}
+	if !status[1].Healthy {
+		t.Error("Discovered node should be health-checked")
+	}
+}
+
+func TestNodeURL(t *testing.T) {
+	called := false
+	cfg := Config{
+		NodeURL: func(seed *url.URL, node string) string {
+			called = true
+			return "https://" + node
+		},
+	}
+	p := &Pool{cfg: cfg}
+	if got := p.nodeURL("couchdb@x"); got != "https://couchdb@x" || !called {
+		t.Errorf("Custom NodeURL not used: %s", got)
+	}
+}
+
+func TestNonRetryableError(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/")
+	pool, c := newTestPool(t, cluster, Config{}, "http://a/,http://b/")
+	cluster.node("http://a/").err = &kivik.Error{HTTPStatus: http.StatusForbidden, Message: "forbidden"}
+	cluster.node("http://b/").err = cluster.node("http://a/").err
+	_, err := c.Version(context.Background())
+	testy.StatusError(t, "forbidden", http.StatusForbidden, err)
+	if n := cluster.node("http://a/").callCount() + cluster.node("http://b/").callCount(); n != 1 {
+		t.Errorf("Non-retryable error should not fail over, got %d calls", n)
+	}
+	for _, s := range pool.Nodes() {
+		if !s.Healthy {
+			t.Errorf("Non-retryable error marked %s unhealthy", s.DSN)
+		}
+	}
+}
+
+func TestDriverPool(t *testing.T) {
+	d := New(newFakeCluster().driver(), Config{HealthInterval: -1})
+	c, err := d.NewClient("http://a/, http://b/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := d.Pool("http://a/, http://b/")
+	if pool == nil || len(pool.Nodes()) != 2 {
+		t.Fatal("Expected pool with 2 nodes")
+	}
+	if err := c.(driver.ClientCloser).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.Pool("http://a/, http://b/") != nil {
+		t.Error("Closed pool should be removed")
+	}
+	if _, err := d.NewClient(" , ", nil); kivik.StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestEmulatedInterfaces(t *testing.T) {
+	cluster := newFakeCluster("http://a/", "http://b/")
+	_, c := newTestPool(t, cluster, Config{}, "http://a/,http://b/")
+	if _, ok := c.(driver.Pinger); ok {
+		t.Error("Client should not implement Pinger when the nodes do not")
+	}
+	db, err := c.DB("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.(driver.MetaGetter); ok {
+		t.Error("DB should not implement MetaGetter when the nodes do not")
+	}
+	if _, ok := c.(driver.Cluster); !ok {
+		t.Error("Client should implement Cluster")
+	}
+}
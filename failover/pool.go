@@ -0,0 +1,485 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package failover
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// NodeStatus describes the health of a single node.
+type NodeStatus struct {
+	// DSN is the node's DSN, with any password redacted.
+	DSN string
+	// Healthy is false if the most recent health check or call to the node
+	// failed.
+	Healthy bool
+	// Primary is true for the node to which writes are currently pinned. No
+	// node is primary before the first write. An unhealthy primary is
+	// replaced by the next write, not by Nodes.
+	Primary bool
+	// Latency is a moving average of the node's health check latency.
+	Latency time.Duration
+	// LastCheck is the time of the most recent health check.
+	LastCheck time.Time
+	// LastError is the error which marked the node unhealthy, if any.
+	LastError error
+	// Failures is the number of consecutive failures.
+	Failures int
+}
+
+type node struct {
+	dsn    string
+	host   string
+	client driver.Client
+
+	mu        sync.Mutex
+	healthy   bool
+	latency   time.Duration
+	lastCheck time.Time
+	lastErr   error
+	failures  int
+}
+
+func (n *node) isHealthy() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.healthy
+}
+
+func (n *node) getLatency() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.latency
+}
+
+// report records the outcome of a call or health check.
+func (n *node) report(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err == nil {
+		n.healthy = true
+		n.failures = 0
+		n.lastErr = nil
+		return
+	}
+	n.healthy = false
+	n.failures++
+	n.lastErr = err
+}
+
+// checked records the outcome of a health check.
+func (n *node) checked(now time.Time, latency time.Duration, err error) {
+	n.report(err)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lastCheck = now
+	if err != nil {
+		return
+	}
+	if n.latency == 0 {
+		n.latency = latency
+	} else {
+		n.latency = (n.latency*7 + latency*3) / 10
+	}
+}
+
+// Pool is the set of nodes used by a failover client.
+type Pool struct {
+	base    driver.Driver
+	cfg     Config
+	options map[string]interface{}
+	seed    *url.URL
+	now     func() time.Time
+	next    uint64
+	onClose func()
+
+	mu      sync.RWMutex
+	nodes   []*node
+	primary *node
+	auths   []interface{}
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPool(base driver.Driver, cfg Config, dsns []string, options map[string]interface{}) (*Pool, error) {
+	p := &Pool{
+		base:    base,
+		cfg:     cfg,
+		options: options,
+		now:     time.Now,
+	}
+	p.seed, _ = url.Parse(dsns[0])
+	for _, dsn := range dsns {
+		n, err := p.newNode(dsn)
+		if err != nil {
+			_ = p.closeNodes(context.Background())
+			return nil, err
+		}
+		n.healthy = true
+		p.nodes = append(p.nodes, n)
+	}
+	interval := cfg.HealthInterval
+	if interval == 0 {
+		interval = DefaultHealthInterval
+	}
+	if interval > 0 {
+		p.stop = make(chan struct{})
+		p.done = make(chan struct{})
+		go p.run(interval)
+	}
+	return p, nil
+}
+
+func (p *Pool) newNode(dsn string) (*node, error) {
+	client, err := p.base.NewClient(dsn, p.options)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{dsn: dsn, client: client}
+	if u, err := url.Parse(dsn); err == nil {
+		n.host = u.Host
+	}
+	return n, nil
+}
+
+func (p *Pool) run(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		p.Check(ctx)
+		cancel()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Nodes returns the current status of every node, in the order they were
+// added.
+func (p *Pool) Nodes() []NodeStatus {
+	p.mu.RLock()
+	nodes, primary := p.nodes, p.primary
+	p.mu.RUnlock()
+	status := make([]NodeStatus, len(nodes))
+	for i, n := range nodes {
+		n.mu.Lock()
+		status[i] = NodeStatus{
+			DSN:       redact(n.dsn),
+			Healthy:   n.healthy,
+			Primary:   n == primary,
+			Latency:   n.latency,
+			LastCheck: n.lastCheck,
+			LastError: n.lastErr,
+			Failures:  n.failures,
+		}
+		n.mu.Unlock()
+	}
+	return status
+}
+
+// Check discovers new nodes, if configured to do so, then health-checks every
+// node. It is called periodically in the background, unless background
+// health checks are disabled.
+func (p *Pool) Check(ctx context.Context) {
+	if p.cfg.Discover {
+		p.discover(ctx)
+	}
+	nodes := p.snapshot()
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for _, n := range nodes {
+		go func(n *node) {
+			defer wg.Done()
+			start := p.now()
+			err := ping(ctx, n.client)
+			end := p.now()
+			n.checked(end, end.Sub(start), err)
+		}(n)
+	}
+	wg.Wait()
+}
+
+func ping(ctx context.Context, client driver.Client) error {
+	if pinger, ok := client.(driver.Pinger); ok {
+		up, err := pinger.Ping(ctx)
+		if err == nil && !up {
+			err = &kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Message: "failover: node is down"}
+		}
+		return err
+	}
+	_, err := client.Version(ctx)
+	return err
+}
+
+func (p *Pool) discover(ctx context.Context) {
+	for _, n := range p.snapshot() {
+		cluster, ok := n.client.(driver.Cluster)
+		if !ok {
+			continue
+		}
+		members, err := cluster.Membership(ctx)
+		if err != nil {
+			continue
+		}
+		for _, name := range members.ClusterNodes {
+			if dsn := p.nodeURL(name); dsn != "" {
+				p.addNode(ctx, dsn)
+			}
+		}
+		return
+	}
+}
+
+func (p *Pool) nodeURL(name string) string {
+	if p.cfg.NodeURL != nil {
+		return p.cfg.NodeURL(p.seed, name)
+	}
+	if p.seed == nil {
+		return ""
+	}
+	host := name
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		host = name[i+1:]
+	}
+	u := *p.seed
+	if port := p.seed.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String()
+}
+
+// addNode adds a client for dsn, unless a node with the same host is already
+// in the pool.
+func (p *Pool) addNode(ctx context.Context, dsn string) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return
+	}
+	p.mu.RLock()
+	for _, n := range p.nodes {
+		if n.host == u.Host {
+			p.mu.RUnlock()
+			return
+		}
+	}
+	auths := p.auths
+	p.mu.RUnlock()
+	n, err := p.newNode(dsn)
+	if err != nil {
+		return
+	}
+	if auth, ok := n.client.(driver.Authenticator); ok {
+		for _, a := range auths {
+			_ = auth.Authenticate(ctx, a)
+		}
+	}
+	p.mu.Lock()
+	p.nodes = append(p.nodes, n)
+	p.mu.Unlock()
+}
+
+func (p *Pool) snapshot() []*node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.nodes
+}
+
+// readOrder returns the nodes in the order they should be tried for a read:
+// healthy nodes, ordered by the balancing strategy, followed by unhealthy
+// nodes as a last resort.
+func (p *Pool) readOrder() []*node {
+	nodes := p.snapshot()
+	healthy := make([]*node, 0, len(nodes))
+	var unhealthy []*node
+	for _, n := range nodes {
+		if n.isHealthy() {
+			healthy = append(healthy, n)
+		} else {
+			unhealthy = append(unhealthy, n)
+		}
+	}
+	if len(healthy) > 1 {
+		switch p.cfg.Balance {
+		case LeastLatency:
+			sort.SliceStable(healthy, func(i, j int) bool {
+				return healthy[i].getLatency() < healthy[j].getLatency()
+			})
+		default:
+			start := int(atomic.AddUint64(&p.next, 1) % uint64(len(healthy)))
+			healthy = append(healthy[start:], healthy[:start]...)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// primaryNode returns the node which receives writes. The primary remains
+// pinned until it becomes unhealthy.
+func (p *Pool) primaryNode() *node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.primary != nil && p.primary.isHealthy() {
+		return p.primary
+	}
+	for _, n := range p.nodes {
+		if n.isHealthy() {
+			p.primary = n
+			return n
+		}
+	}
+	if p.primary == nil {
+		p.primary = p.nodes[0]
+	}
+	return p.primary
+}
+
+// failed records the outcome of a call to n, and returns true if the call
+// failed in a way that another node may not.
+func (p *Pool) failed(n *node, err error) bool {
+	switch {
+	case err == nil:
+		n.report(nil)
+	case kivik.IsRetryable(err):
+		n.report(err)
+		return true
+	}
+	return false
+}
+
+// read calls fn with each node in turn, until it succeeds, or fails with an
+// error which is not retryable.
+func (p *Pool) read(ctx context.Context, fn func(context.Context, *node) error) error {
+	var err error
+	for _, n := range p.readOrder() {
+		err = fn(ctx, n)
+		if !p.failed(n, err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// write calls fn with the primary node.
+func (p *Pool) write(ctx context.Context, fn func(context.Context, *node) error) error {
+	n := p.primaryNode()
+	err := fn(ctx, n)
+	p.failed(n, err)
+	return err
+}
+
+// authenticate authenticates every node with a. Only the primary node's
+// error is returned; nodes which fail are marked unhealthy. a is retained, to
+// authenticate nodes added later by discovery.
+func (p *Pool) authenticate(ctx context.Context, a interface{}) error {
+	nodes := p.snapshot()
+	for _, n := range nodes {
+		if _, ok := n.client.(driver.Authenticator); !ok {
+			return forward.NotImplemented("Authenticate")
+		}
+	}
+	primary := p.primaryNode()
+	var primaryErr error
+	for _, n := range nodes {
+		err := n.client.(driver.Authenticator).Authenticate(ctx, a)
+		p.failed(n, err)
+		if n == primary {
+			primaryErr = err
+		}
+	}
+	if primaryErr == nil {
+		p.mu.Lock()
+		p.auths = append(p.auths, a)
+		p.mu.Unlock()
+	}
+	return primaryErr
+}
+
+// logout ends the session of every node, and discards the authenticators
+// retained for nodes added later. Only the primary node's error is returned.
+func (p *Pool) logout(ctx context.Context) error {
+	nodes := p.snapshot()
+	for _, n := range nodes {
+		if _, ok := n.client.(driver.Deauthenticator); !ok {
+			return forward.NotImplemented("Logout")
+		}
+	}
+	primary := p.primaryNode()
+	var primaryErr error
+	for _, n := range nodes {
+		err := n.client.(driver.Deauthenticator).Logout(ctx)
+		p.failed(n, err)
+		if n == primary {
+			primaryErr = err
+		}
+	}
+	p.mu.Lock()
+	p.auths = nil
+	p.mu.Unlock()
+	return primaryErr
+}
+
+func (p *Pool) close(ctx context.Context) error {
+	var err error
+	p.closeOnce.Do(func() {
+		if p.stop != nil {
+			close(p.stop)
+			<-p.done
+		}
+		if p.onClose != nil {
+			p.onClose()
+		}
+		err = p.closeNodes(ctx)
+	})
+	return err
+}
+
+func (p *Pool) closeNodes(ctx context.Context) error {
+	var firstErr error
+	for _, n := range p.snapshot() {
+		if closer, ok := n.client.(driver.ClientCloser); ok {
+			if err := closer.Close(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// redact replaces the password in dsn, if any.
+func redact(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return dsn
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
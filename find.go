@@ -28,49 +28,79 @@ func (db *DB) Find(ctx context.Context, query interface{}, options ...Options) R
 	if db.err != nil {
 		return &errRS{err: db.err}
 	}
+	op := db.op("Find", "", "")
+	ctx, span := db.trace(ctx, op)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return &errRS{err: span.fail(op.wrap(err))}
+	}
+	retry, opts := db.retryPolicy(options), mergeOptions(options...)
 	if finder, ok := db.driverDB.(driver.OptsFinder); ok {
-		rowsi, err := finder.Find(ctx, query, mergeOptions(options...))
+		var rowsi driver.Rows
+		err := retry.do(ctx, func() (err error) {
+			rowsi, err = finder.Find(ctx, query, opts)
+			return err
+		})
 		if err != nil {
-			return &errRS{err: err}
+			return &errRS{err: span.fail(op.wrap(err))}
 		}
-		return newRows(ctx, rowsi)
+		rs := newRows(ctx, rowsi)
+		rs.trace(span)
+		return rs
 	}
 	// nolint:staticcheck
 	if finder, ok := db.driverDB.(driver.Finder); ok {
-		rowsi, err := finder.Find(ctx, query)
+		var rowsi driver.Rows
+		err := retry.do(ctx, func() (err error) {
+			rowsi, err = finder.Find(ctx, query)
+			return err
+		})
 		if err != nil {
-			return &errRS{err: err}
+			return &errRS{err: span.fail(op.wrap(err))}
 		}
-		return newRows(ctx, rowsi)
+		rs := newRows(ctx, rowsi)
+		rs.trace(span)
+		return rs
 	}
-	return &rows{err: findNotImplemented}
+	return &rows{err: span.fail(op.wrap(findNotImplemented))}
 }
 
 // CreateIndex creates an index if it doesn't already exist. ddoc and name may
 // be empty, in which case they will be auto-generated.  index must be a valid
 // index object, as described here:
 // http://docs.couchdb.org/en/stable/api/database/find.html#db-index
-func (db *DB) CreateIndex(ctx context.Context, ddoc, name string, index interface{}, options ...Options) error {
+func (db *DB) CreateIndex(ctx context.Context, ddoc, name string, index interface{}, options ...Options) (err error) {
+	op := db.op("CreateIndex", ddoc, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
 	if finder, ok := db.driverDB.(driver.OptsFinder); ok {
-		return finder.CreateIndex(ctx, ddoc, name, index, mergeOptions(options...))
+		return op.wrap(finder.CreateIndex(ctx, ddoc, name, index, mergeOptions(options...)))
 	}
 	// nolint:staticcheck
 	if finder, ok := db.driverDB.(driver.Finder); ok {
-		return finder.CreateIndex(ctx, ddoc, name, index)
+		return op.wrap(finder.CreateIndex(ctx, ddoc, name, index))
 	}
-	return findNotImplemented
+	return op.wrap(findNotImplemented)
 }
 
 // DeleteIndex deletes the requested index.
-func (db *DB) DeleteIndex(ctx context.Context, ddoc, name string, options ...Options) error {
+func (db *DB) DeleteIndex(ctx context.Context, ddoc, name string, options ...Options) (err error) {
+	op := db.op("DeleteIndex", ddoc, "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
 	if finder, ok := db.driverDB.(driver.OptsFinder); ok {
-		return finder.DeleteIndex(ctx, ddoc, name, mergeOptions(options...))
+		return op.wrap(finder.DeleteIndex(ctx, ddoc, name, mergeOptions(options...)))
 	}
 	// nolint:staticcheck
 	if finder, ok := db.driverDB.(driver.Finder); ok {
-		return finder.DeleteIndex(ctx, ddoc, name)
+		return op.wrap(finder.DeleteIndex(ctx, ddoc, name))
 	}
-	return findNotImplemented
+	return op.wrap(findNotImplemented)
 }
 
 // Index is a MonboDB-style index definition.
@@ -82,14 +112,20 @@ type Index struct {
 }
 
 // GetIndexes returns the indexes defined on the current database.
-func (db *DB) GetIndexes(ctx context.Context, options ...Options) ([]Index, error) {
+func (db *DB) GetIndexes(ctx context.Context, options ...Options) (indexes []Index, err error) {
+	op := db.op("GetIndexes", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if finder, ok := db.driverDB.(driver.OptsFinder); ok {
 		dIndexes, err := finder.GetIndexes(ctx, mergeOptions(options...))
 		indexes := make([]Index, len(dIndexes))
 		for i, index := range dIndexes {
 			indexes[i] = Index(index)
 		}
-		return indexes, err
+		return indexes, op.wrap(err)
 	}
 	// nolint:staticcheck
 	if finder, ok := db.driverDB.(driver.Finder); ok {
@@ -98,9 +134,9 @@ func (db *DB) GetIndexes(ctx context.Context, options ...Options) ([]Index, erro
 		for i, index := range dIndexes {
 			indexes[i] = Index(index)
 		}
-		return indexes, err
+		return indexes, op.wrap(err)
 	}
-	return nil, findNotImplemented
+	return nil, op.wrap(findNotImplemented)
 }
 
 // QueryPlan is the query execution plan for a query, as returned by the Explain
@@ -121,11 +157,17 @@ type QueryPlan struct {
 
 // Explain returns the query plan for a given query. Explain takes the same
 // arguments as Find.
-func (db *DB) Explain(ctx context.Context, query interface{}, options ...Options) (*QueryPlan, error) {
+func (db *DB) Explain(ctx context.Context, query interface{}, options ...Options) (plan *QueryPlan, err error) {
+	op := db.op("Explain", "", "")
+	ctx, span := db.trace(ctx, op)
+	defer span.finish(&err)
+	if err := db.client.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if explainer, ok := db.driverDB.(driver.OptsFinder); ok {
 		plan, err := explainer.Explain(ctx, query, mergeOptions(options...))
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		qp := QueryPlan(*plan)
 		return &qp, nil
@@ -134,10 +176,10 @@ func (db *DB) Explain(ctx context.Context, query interface{}, options ...Options
 	if explainer, ok := db.driverDB.(driver.Finder); ok {
 		plan, err := explainer.Explain(ctx, query)
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		qp := QueryPlan(*plan)
 		return &qp, nil
 	}
-	return nil, findNotImplemented
+	return nil, op.wrap(findNotImplemented)
 }
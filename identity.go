@@ -0,0 +1,93 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// WithUser returns a copy of ctx which carries the identity of an end user.
+// Calls made with the returned context, by a client whose driver supports
+// impersonation, are made as that user, so that validate_doc_update functions
+// and database security checks see the end user, rather than the client's
+// own credentials.
+//
+// If the client's driver does not support impersonation, calls made with the
+// returned context fail with http.StatusNotImplemented, rather than being made
+// with the client's own credentials. Authenticate, Logout and Close, which act
+// on the client's own session, are not affected. Check SupportsImpersonation
+// before relying on it:
+//
+//	if !client.SupportsImpersonation() {
+//		log.Fatal("driver cannot act on behalf of end users")
+//	}
+//	ctx = kivik.WithUser(ctx, "bob", "editors")
+//	rev, err := client.DB("articles").Put(ctx, docID, doc)
+func WithUser(ctx context.Context, name string, roles ...string) context.Context {
+	r := make([]string, len(roles))
+	copy(r, roles)
+	return driver.ContextWithIdentity(ctx, &driver.Identity{Name: name, Roles: r})
+}
+
+// UserFromContext returns the name and roles of the user attached to ctx by
+// WithUser. ok is false if ctx carries no user.
+func UserFromContext(ctx context.Context) (name string, roles []string, ok bool) {
+	id := driver.IdentityFromContext(ctx)
+	if id == nil {
+		return "", nil, false
+	}
+	return id.Name, id.Roles, true
+}
+
+// SupportsImpersonation returns true if the client's driver makes calls as
+// the user attached to their context by WithUser.
+func (c *Client) SupportsImpersonation() bool {
+	i, ok := c.driverClient.(driver.Impersonator)
+	return ok && i.Impersonates()
+}
+
+// ForContext returns the proxy authenticator to use for a request made with
+// ctx. If ctx carries a user attached by WithUser, a copy of a is returned
+// for that user and roles, signed with the same secret. Otherwise a is
+// returned. Drivers which authenticate with a ProxyAuth may use it to
+// support impersonation:
+//
+//	for k, v := range proxyAuth.ForContext(ctx).Header() {
+//		req.Header[k] = v
+//	}
+func (a *ProxyAuth) ForContext(ctx context.Context) *ProxyAuth {
+	id := driver.IdentityFromContext(ctx)
+	if id == nil {
+		return a
+	}
+	return &ProxyAuth{
+		Username: id.Name,
+		Roles:    id.Roles,
+		Secret:   a.Secret,
+		Hash:     a.Hash,
+	}
+}
+
+// checkIdentity returns a http.StatusNotImplemented error if ctx carries a
+// user attached by WithUser, and the client's driver does not support
+// impersonation, so that the call is not made with the client's own
+// credentials instead.
+func (c *Client) checkIdentity(ctx context.Context) error {
+	if c == nil || driver.IdentityFromContext(ctx) == nil || c.SupportsImpersonation() {
+		return nil
+	}
+	return &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support impersonation"}
+}
@@ -0,0 +1,126 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestWithUser(t *testing.T) {
+	if _, _, ok := UserFromContext(context.Background()); ok {
+		t.Error("Unexpected user in empty context")
+	}
+	roles := []string{"editors", "readers"}
+	ctx := WithUser(context.Background(), "bob", roles...)
+	roles[0] = "admins"
+	name, got, ok := UserFromContext(ctx)
+	if !ok || name != "bob" {
+		t.Errorf("Unexpected user: %q, %t", name, ok)
+	}
+	if d := testy.DiffInterface([]string{"editors", "readers"}, got); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface(&driver.Identity{Name: "bob", Roles: []string{"editors", "readers"}}, driver.IdentityFromContext(ctx)); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSupportsImpersonation(t *testing.T) {
+	tests := map[string]struct {
+		client driver.Client
+		want   bool
+	}{
+		"not implemented": {&mock.Client{}, false},
+		"disabled":        {&mock.Impersonator{ImpersonatesFunc: func() bool { return false }}, false},
+		"enabled":         {&mock.Impersonator{ImpersonatesFunc: func() bool { return true }}, true},
+	}
+	for name, test := range tests {
+		c := &Client{driverClient: test.client}
+		if got := c.SupportsImpersonation(); got != test.want {
+			t.Errorf("%s: expected %t, got %t", name, test.want, got)
+		}
+	}
+}
+
+func TestProxyAuthForContext(t *testing.T) {
+	a := &ProxyAuth{Username: "gateway", Roles: []string{"_admin"}, Secret: "secret"}
+	if got := a.ForContext(context.Background()); got != a {
+		t.Errorf("Expected the original authenticator, got %+v", got)
+	}
+	h := a.ForContext(WithUser(context.Background(), "bob", "a", "b")).Header()
+	if d := testy.DiffInterface([]string{"bob", "a,b", "9c90819f883772660da011f41042fabea4a174e2873386b30949f106dbac797e"}, []string{
+		h.Get(ProxyAuthUserHeader),
+		h.Get(ProxyAuthRolesHeader),
+		h.Get(ProxyAuthTokenHeader),
+	}); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestImpersonationRequired(t *testing.T) {
+	ctx := WithUser(context.Background(), "bob")
+	t.Run("not supported", func(t *testing.T) {
+		c := &Client{driverClient: &mock.Impersonator{
+			Client: &mock.Client{
+				AllDBsFunc: func(context.Context, map[string]interface{}) ([]string, error) {
+					t.Fatal("AllDBs should not be called")
+					return nil, nil
+				},
+			},
+			ImpersonatesFunc: func() bool { return false },
+		}}
+		_, err := c.AllDBs(ctx)
+		testy.StatusError(t, "kivik: driver does not support impersonation", http.StatusNotImplemented, err)
+		db := &DB{client: c, name: "foo", driverDB: &mock.DB{
+			GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+				t.Fatal("Get should not be called")
+				return nil, nil
+			},
+		}}
+		err = db.Get(ctx, "bar").ScanDoc(&struct{}{})
+		testy.StatusError(t, "kivik: driver does not support impersonation", http.StatusNotImplemented, err)
+	})
+	t.Run("supported", func(t *testing.T) {
+		c := &Client{driverClient: &mock.Impersonator{
+			Client: &mock.Client{
+				AllDBsFunc: func(ctx context.Context, _ map[string]interface{}) ([]string, error) {
+					if driver.IdentityFromContext(ctx) == nil {
+						t.Error("Expected the identity to be passed to the driver")
+					}
+					return []string{"foo"}, nil
+				},
+			},
+			ImpersonatesFunc: func() bool { return true },
+		}}
+		if _, err := c.AllDBs(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("no user", func(t *testing.T) {
+		c := &Client{driverClient: &mock.Client{
+			AllDBsFunc: func(context.Context, map[string]interface{}) ([]string, error) {
+				return []string{"foo"}, nil
+			},
+		}}
+		if _, err := c.AllDBs(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
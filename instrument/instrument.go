@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package instrument provides kivik.Tracer implementations, which expose the
+// calls made with a Kivik client as Prometheus-style metrics, and as
+// OpenTelemetry-style spans.
+//
+// Neither adapter depends on the Prometheus or OpenTelemetry libraries. Metrics
+// may be served directly in the Prometheus text format, or read with Collect
+// and forwarded to any metrics system. Spans are passed to a SpanExporter,
+// which may forward them to any tracing system.
+//
+// To use both adapters at once, combine them with Multi:
+//
+//	metrics := instrument.NewMetrics()
+//	tracer := instrument.NewTracer(exporter)
+//	client, err := kivik.New("couch", dsn, kivik.Options{
+//		kivik.OptionTracer: instrument.Multi(metrics, tracer),
+//	})
+//
+// NodeCollector exports the server-side statistics of each node of a CouchDB
+// cluster, as reported by Client.NodeStats and Client.NodeSystem, in the
+// Prometheus text format.
+package instrument
+
+import (
+	"context"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// Multi returns a kivik.Tracer which starts a span with each of tracers.
+func Multi(tracers ...kivik.Tracer) kivik.Tracer {
+	return multiTracer(tracers)
+}
+
+type multiTracer []kivik.Tracer
+
+var _ kivik.Tracer = multiTracer{}
+
+func (t multiTracer) StartSpan(ctx context.Context, op *kivik.Operation) (context.Context, kivik.Span) {
+	spans := make(multiSpan, 0, len(t))
+	for _, tracer := range t {
+		var span kivik.Span
+		ctx, span = tracer.StartSpan(ctx, op)
+		if span != nil {
+			spans = append(spans, span)
+		}
+	}
+	return ctx, spans
+}
+
+type multiSpan []kivik.Span
+
+var _ kivik.Span = multiSpan{}
+
+func (s multiSpan) AddRows(n int) {
+	for _, span := range s {
+		span.AddRows(n)
+	}
+}
+
+func (s multiSpan) AddBytes(n int64) {
+	for _, span := range s {
+		span.AddBytes(n)
+	}
+}
+
+func (s multiSpan) End(err error) {
+	for _, span := range s {
+		span.End(err)
+	}
+}
@@ -0,0 +1,240 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// DefaultBuckets are the default latency histogram buckets, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is a kivik.Tracer which aggregates calls into latency histograms,
+// and row and byte counters, labeled by method, database and status.
+type Metrics struct {
+	buckets []float64
+	now     func() time.Time
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+var (
+	_ kivik.Tracer = &Metrics{}
+	_ http.Handler = &Metrics{}
+)
+
+type seriesKey struct {
+	method string
+	db     string
+	status int
+}
+
+type series struct {
+	count   uint64
+	sum     time.Duration
+	buckets []uint64
+	rows    int64
+	bytes   int64
+}
+
+// NewMetrics returns a new Metrics, with the provided histogram bucket upper
+// bounds, in seconds. If no buckets are provided, DefaultBuckets are used.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Metrics{
+		buckets: b,
+		now:     time.Now,
+		series:  make(map[seriesKey]*series),
+	}
+}
+
+// StartSpan satisfies the kivik.Tracer interface.
+func (m *Metrics) StartSpan(ctx context.Context, op *kivik.Operation) (context.Context, kivik.Span) {
+	return ctx, &metricsSpan{
+		metrics: m,
+		method:  op.Method,
+		db:      op.DB,
+		start:   m.now(),
+	}
+}
+
+type metricsSpan struct {
+	metrics *Metrics
+	method  string
+	db      string
+	start   time.Time
+	rows    int64
+	bytes   int64
+}
+
+func (s *metricsSpan) AddRows(n int)    { atomic.AddInt64(&s.rows, int64(n)) }
+func (s *metricsSpan) AddBytes(n int64) { atomic.AddInt64(&s.bytes, n) }
+
+func (s *metricsSpan) End(err error) {
+	m := s.metrics
+	elapsed := m.now().Sub(s.start)
+	key := seriesKey{method: s.method, db: s.db, status: kivik.StatusCode(err)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ser, ok := m.series[key]
+	if !ok {
+		ser = &series{buckets: make([]uint64, len(m.buckets))}
+		m.series[key] = ser
+	}
+	ser.count++
+	ser.sum += elapsed
+	for i, bound := range m.buckets {
+		if elapsed.Seconds() <= bound {
+			ser.buckets[i]++
+		}
+	}
+	ser.rows += atomic.LoadInt64(&s.rows)
+	ser.bytes += atomic.LoadInt64(&s.bytes)
+}
+
+// Bucket is a cumulative histogram bucket.
+type Bucket struct {
+	// UpperBound is the inclusive upper bound of the bucket, in seconds.
+	UpperBound float64
+	// Count is the number of calls which completed within UpperBound.
+	Count uint64
+}
+
+// Series is a snapshot of the metrics for one combination of labels.
+type Series struct {
+	Method string
+	DB     string
+	// Status is the value of kivik.StatusCode for the call's error, or 0
+	// for calls which succeeded.
+	Status int
+	// Count is the number of completed calls.
+	Count uint64
+	// Sum is the total duration of all calls.
+	Sum time.Duration
+	// Buckets is the latency histogram, in increasing order of UpperBound.
+	Buckets []Bucket
+	// Rows is the total number of rows or results read.
+	Rows int64
+	// Bytes is the total number of document or row bytes read.
+	Bytes int64
+}
+
+// Collect returns a snapshot of all series, sorted by method, database and
+// status.
+func (m *Metrics) Collect() []Series {
+	m.mu.Lock()
+	result := make([]Series, 0, len(m.series))
+	for key, ser := range m.series {
+		buckets := make([]Bucket, len(m.buckets))
+		for i, bound := range m.buckets {
+			buckets[i] = Bucket{UpperBound: bound, Count: ser.buckets[i]}
+		}
+		result = append(result, Series{
+			Method:  key.method,
+			DB:      key.db,
+			Status:  key.status,
+			Count:   ser.count,
+			Sum:     ser.sum,
+			Buckets: buckets,
+			Rows:    ser.rows,
+			Bytes:   ser.bytes,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		if a.DB != b.DB {
+			return a.DB < b.DB
+		}
+		return a.Status < b.Status
+	})
+	return result
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format. Calls which succeeded have the status label "ok".
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	all := m.Collect()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# HELP kivik_request_duration_seconds Duration of Kivik client calls, including the lifetime of any returned iterator.")
+	fmt.Fprintln(bw, "# TYPE kivik_request_duration_seconds histogram")
+	for _, s := range all {
+		labels := s.labels()
+		for _, b := range s.Buckets {
+			fmt.Fprintf(bw, "kivik_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, formatFloat(b.UpperBound), b.Count)
+		}
+		fmt.Fprintf(bw, "kivik_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, s.Count)
+		fmt.Fprintf(bw, "kivik_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(s.Sum.Seconds()))
+		fmt.Fprintf(bw, "kivik_request_duration_seconds_count{%s} %d\n", labels, s.Count)
+	}
+	fmt.Fprintln(bw, "# HELP kivik_rows_total Rows or results read from Kivik client calls.")
+	fmt.Fprintln(bw, "# TYPE kivik_rows_total counter")
+	for _, s := range all {
+		fmt.Fprintf(bw, "kivik_rows_total{%s} %d\n", s.labels(), s.Rows)
+	}
+	fmt.Fprintln(bw, "# HELP kivik_read_bytes_total Document and row bytes read from Kivik client calls.")
+	fmt.Fprintln(bw, "# TYPE kivik_read_bytes_total counter")
+	for _, s := range all {
+		fmt.Fprintf(bw, "kivik_read_bytes_total{%s} %d\n", s.labels(), s.Bytes)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+func (s Series) labels() string {
+	status := "ok"
+	if s.Status != 0 {
+		status = strconv.Itoa(s.Status)
+	}
+	return fmt.Sprintf("method=%s,db=%s,status=%q", quoteLabel(s.Method), quoteLabel(s.DB), status)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,109 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+func testMetrics() *Metrics {
+	m := NewMetrics(0.1, 1)
+	now := time.Unix(0, 0)
+	m.now = func() time.Time {
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}
+	_, span := m.StartSpan(context.Background(), &kivik.Operation{Method: "AllDocs", DB: "foo"})
+	span.AddRows(2)
+	span.AddBytes(100)
+	span.End(nil)
+	_, span = m.StartSpan(context.Background(), &kivik.Operation{Method: "Get", DB: `a"b`})
+	span.End(&kivik.Error{HTTPStatus: http.StatusNotFound})
+	return m
+}
+
+func TestMetricsCollect(t *testing.T) {
+	m := testMetrics()
+	series := m.Collect()
+	if d := testy.DiffInterface([]Series{
+		{
+			Method: "AllDocs",
+			DB:     "foo",
+			Count:  1,
+			Sum:    500 * time.Millisecond,
+			Buckets: []Bucket{
+				{UpperBound: 0.1},
+				{UpperBound: 1, Count: 1},
+			},
+			Rows:  2,
+			Bytes: 100,
+		},
+		{
+			Method: "Get",
+			DB:     `a"b`,
+			Status: http.StatusNotFound,
+			Count:  1,
+			Sum:    500 * time.Millisecond,
+			Buckets: []Bucket{
+				{UpperBound: 0.1},
+				{UpperBound: 1, Count: 1},
+			},
+		},
+	}, series); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := testMetrics()
+	buf := &bytes.Buffer{}
+	if err := m.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE kivik_request_duration_seconds histogram\n",
+		`kivik_request_duration_seconds_bucket{method="AllDocs",db="foo",status="ok",le="0.1"} 0` + "\n",
+		`kivik_request_duration_seconds_bucket{method="AllDocs",db="foo",status="ok",le="+Inf"} 1` + "\n",
+		`kivik_request_duration_seconds_sum{method="AllDocs",db="foo",status="ok"} 0.5` + "\n",
+		`kivik_rows_total{method="AllDocs",db="foo",status="ok"} 2` + "\n",
+		`kivik_read_bytes_total{method="AllDocs",db="foo",status="ok"} 100` + "\n",
+		`kivik_request_duration_seconds_count{method="Get",db="a\"b",status="404"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := testMetrics()
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Unexpected content type: %s", ct)
+	}
+	if !strings.Contains(w.Body.String(), "kivik_rows_total") {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,247 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// NodeCollector exports the statistics and system metrics of the nodes of a
+// CouchDB cluster in the Prometheus text exposition format. It fetches them
+// from the server on every scrape, so it may be served in place of a sidecar
+// exporter:
+//
+//	http.Handle("/metrics", instrument.NewNodeCollector(client))
+//
+// Every metric has a node label. Node statistics are named after their path,
+// such that couchdb.httpd.requests is exported as couchdb_httpd_requests_total.
+// Counters are exported as counters, gauges as gauges, and histograms as
+// summaries. System metrics are prefixed with couchdb_node_.
+type NodeCollector struct {
+	client *kivik.Client
+}
+
+var _ http.Handler = &NodeCollector{}
+
+// NewNodeCollector returns a new NodeCollector, which collects the metrics of
+// client's cluster nodes.
+func NewNodeCollector(client *kivik.Client) *NodeCollector {
+	return &NodeCollector{client: client}
+}
+
+type sample struct {
+	// suffix is appended to the family name, such as _sum for a summary.
+	suffix string
+	labels string
+	value  string
+}
+
+type family struct {
+	help    string
+	typ     string
+	samples []sample
+}
+
+type families map[string]*family
+
+func (f families) add(name, typ, help, labels, value string) {
+	f.addSample(name, typ, help, sample{labels: labels, value: value})
+}
+
+func (f families) addSample(name, typ, help string, s sample) {
+	fam, ok := f[name]
+	if !ok {
+		fam = &family{help: help, typ: typ}
+		f[name] = fam
+	}
+	fam.samples = append(fam.samples, s)
+}
+
+// WritePrometheus fetches the metrics of each node listed in the
+// ClusterNodes of the cluster membership, and writes them to w. A node whose
+// metrics cannot be fetched is reported with a couchdb_node_scrape_success
+// value of 0. An error is returned only if the membership cannot be read, in
+// which case nothing is written.
+func (c *NodeCollector) WritePrometheus(ctx context.Context, w io.Writer) error {
+	members, err := c.client.Membership(ctx)
+	if err != nil {
+		return err
+	}
+	f := families{}
+	for _, node := range members.ClusterNodes {
+		success := "1"
+		if err := c.collectStats(ctx, f, node); err != nil {
+			success = "0"
+		}
+		if err := c.collectSystem(ctx, f, node); err != nil {
+			success = "0"
+		}
+		f.add("couchdb_node_scrape_success", "gauge", "Whether the metrics of the node were fetched successfully.", nodeLabel(node), success)
+	}
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		fam := f[name]
+		if fam.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, helpEscaper.Replace(fam.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, fam.typ)
+		sort.SliceStable(fam.samples, func(i, j int) bool {
+			a, b := fam.samples[i], fam.samples[j]
+			if a.labels != b.labels {
+				return a.labels < b.labels
+			}
+			return a.suffix < b.suffix
+		})
+		for _, s := range fam.samples {
+			fmt.Fprintf(bw, "%s%s{%s} %s\n", name, s.suffix, s.labels, s.value)
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (c *NodeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	if err := c.WritePrometheus(r.Context(), buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func nodeLabel(node string) string {
+	return "node=" + quoteLabel(node)
+}
+
+// metricName converts a statistic path, such as couchdb.httpd.requests, to a
+// metric name, such as couchdb_httpd_requests.
+func metricName(path string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, path)
+	if !strings.HasPrefix(name, "couchdb_") {
+		name = "couchdb_" + name
+	}
+	return name
+}
+
+func (c *NodeCollector) collectStats(ctx context.Context, f families, node string) error {
+	stats, err := c.client.NodeStats(ctx, node)
+	if err != nil {
+		return err
+	}
+	labels := nodeLabel(node)
+	for path, stat := range stats.Counters {
+		f.add(metricName(path)+"_total", "counter", stat.Desc, labels, strconv.FormatInt(stat.Value, 10))
+	}
+	for path, stat := range stats.Gauges {
+		f.add(metricName(path), "gauge", stat.Desc, labels, formatFloat(stat.Value))
+	}
+	for path, stat := range stats.Histograms {
+		name := metricName(path)
+		for _, p := range stat.Percentiles {
+			f.add(name, "summary", stat.Desc, labels+",quantile="+quoteLabel(formatFloat(quantile(p.Percentile))), formatFloat(p.Value))
+		}
+		f.addSample(name, "summary", stat.Desc, sample{suffix: "_count", labels: labels, value: strconv.FormatInt(stat.N, 10)})
+		f.addSample(name, "summary", stat.Desc, sample{suffix: "_sum", labels: labels, value: formatFloat(stat.ArithmeticMean * float64(stat.N))})
+	}
+	return nil
+}
+
+// quantile converts a percentile to a quantile, rounding away the error of
+// the division, such that 99.9 becomes 0.999.
+func quantile(percentile float64) float64 {
+	return math.Round(percentile*1e4) / 1e6
+}
+
+func (c *NodeCollector) collectSystem(ctx context.Context, f families, node string) error {
+	sys, err := c.client.NodeSystem(ctx, node)
+	if err != nil {
+		return err
+	}
+	labels := nodeLabel(node)
+	gauge := func(name, help string, value int64) {
+		f.add("couchdb_node_"+name, "gauge", help, labels, strconv.FormatInt(value, 10))
+	}
+	counter := func(name, help string, value int64) {
+		f.add("couchdb_node_"+name+"_total", "counter", help, labels, strconv.FormatInt(value, 10))
+	}
+	f.add("couchdb_node_uptime_seconds", "gauge", "Time since the node was started.", labels, formatFloat(sys.Uptime.Seconds()))
+	gauge("run_queue", "Number of processes ready to run.", sys.RunQueue)
+	gauge("ets_table_count", "Number of ETS tables.", sys.ETSTableCount)
+	gauge("os_proc_count", "Number of OS processes, such as query servers.", sys.OSProcCount)
+	gauge("stale_proc_count", "Number of stale OS processes.", sys.StaleProcCount)
+	gauge("process_count", "Number of Erlang processes.", sys.ProcessCount)
+	gauge("process_limit", "Maximum number of Erlang processes.", sys.ProcessLimit)
+	gauge("internal_replication_jobs", "Number of internal replication jobs.", sys.InternalReplicationJobs)
+	counter("context_switches", "Number of context switches.", sys.ContextSwitches)
+	counter("reductions", "Number of reductions.", sys.Reductions)
+	counter("garbage_collections", "Number of garbage collections.", sys.GarbageCollectionCount)
+	counter("words_reclaimed", "Number of words reclaimed by garbage collection.", sys.WordsReclaimed)
+	counter("io_input_bytes", "Bytes received through ports.", sys.IOInput)
+	counter("io_output_bytes", "Bytes sent through ports.", sys.IOOutput)
+	for _, m := range []struct {
+		typ   string
+		value int64
+	}{
+		{"atom", sys.Memory.Atom},
+		{"atom_used", sys.Memory.AtomUsed},
+		{"binary", sys.Memory.Binary},
+		{"code", sys.Memory.Code},
+		{"ets", sys.Memory.ETS},
+		{"other", sys.Memory.Other},
+		{"processes", sys.Memory.Processes},
+		{"processes_used", sys.Memory.ProcessesUsed},
+	} {
+		f.add("couchdb_node_memory_bytes", "gauge", "Memory used by the Erlang VM, by type.", labels+",type="+quoteLabel(m.typ), strconv.FormatInt(m.value, 10))
+	}
+	for queue, q := range sys.MessageQueues {
+		for _, s := range []struct {
+			stat  string
+			value int64
+		}{
+			{"count", q.Count},
+			{"max", q.Max},
+			{"median", q.Median},
+			{"min", q.Min},
+			{"p90", q.P90},
+			{"p99", q.P99},
+		} {
+			f.add("couchdb_node_message_queue_length", "gauge", "Message queue lengths of named processes and process groups.", labels+",queue="+quoteLabel(queue)+",stat="+quoteLabel(s.stat), strconv.FormatInt(s.value, 10))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// cluster is a driver client which reports the membership and the node
+// statistics of a cluster.
+type cluster struct {
+	*mock.Cluster
+	stats  map[string]string
+	system map[string]string
+}
+
+var _ driver.NodeStatser = &cluster{}
+
+func (c *cluster) NodeStats(_ context.Context, node string) (jsoniter.RawMessage, error) {
+	if stats, ok := c.stats[node]; ok {
+		return jsoniter.RawMessage(stats), nil
+	}
+	return nil, errors.New("node unavailable")
+}
+
+func (c *cluster) NodeSystem(_ context.Context, node string) (jsoniter.RawMessage, error) {
+	if system, ok := c.system[node]; ok {
+		return jsoniter.RawMessage(system), nil
+	}
+	return nil, errors.New("node unavailable")
+}
+
+var (
+	registerOnce sync.Once
+	clusters     sync.Map
+)
+
+func newCluster(t *testing.T, c *cluster) *kivik.Client {
+	registerOnce.Do(func() {
+		kivik.Register("instrumenttest", &mock.Driver{
+			NewClientFunc: func(dsn string, _ map[string]interface{}) (driver.Client, error) {
+				c, _ := clusters.Load(dsn)
+				return c.(*cluster), nil
+			},
+		})
+	})
+	clusters.Store(t.Name(), c)
+	client, err := kivik.New("instrumenttest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func membership(nodes ...string) *mock.Cluster {
+	return &mock.Cluster{
+		Client: &mock.Client{},
+		MembershipFunc: func(context.Context) (*driver.ClusterMembership, error) {
+			return &driver.ClusterMembership{AllNodes: nodes, ClusterNodes: nodes}, nil
+		},
+	}
+}
+
+func TestNodeCollector(t *testing.T) {
+	client := newCluster(t, &cluster{
+		Cluster: membership("node1@a", "node2@b"),
+		stats: map[string]string{
+			"node1@a": `{
+				"couchdb": {
+					"httpd": {"requests": {"value": 120, "type": "counter", "desc": "number of HTTP requests"}},
+					"request_time": {
+						"value": {"n": 4, "arithmetic_mean": 2.5, "percentile": [[50, 2], [999, 9]]},
+						"type": "histogram",
+						"desc": "length of a request"
+					}
+				},
+				"fabric": {"open_shard": {"value": 0.5, "type": "gauge", "desc": "shard open rate"}}
+			}`,
+		},
+		system: map[string]string{
+			"node1@a": `{
+				"uptime": 60,
+				"memory": {"binary": 100, "ets": 200},
+				"process_count": 900,
+				"message_queues": {"couch_server": 3}
+			}`,
+		},
+	})
+	c := NewNodeCollector(client)
+	buf := &bytes.Buffer{}
+	if err := c.WritePrometheus(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# HELP couchdb_httpd_requests_total number of HTTP requests\n",
+		"# TYPE couchdb_httpd_requests_total counter\n",
+		`couchdb_httpd_requests_total{node="node1@a"} 120` + "\n",
+		"# TYPE couchdb_fabric_open_shard gauge\n",
+		`couchdb_fabric_open_shard{node="node1@a"} 0.5` + "\n",
+		"# TYPE couchdb_request_time summary\n",
+		`couchdb_request_time{node="node1@a",quantile="0.5"} 2` + "\n",
+		`couchdb_request_time{node="node1@a",quantile="0.999"} 9` + "\n",
+		`couchdb_request_time_sum{node="node1@a"} 10` + "\n",
+		`couchdb_request_time_count{node="node1@a"} 4` + "\n",
+		`couchdb_node_uptime_seconds{node="node1@a"} 60` + "\n",
+		`couchdb_node_memory_bytes{node="node1@a",type="binary"} 100` + "\n",
+		`couchdb_node_process_count{node="node1@a"} 900` + "\n",
+		`couchdb_node_message_queue_length{node="node1@a",queue="couch_server",stat="max"} 3` + "\n",
+		`couchdb_node_scrape_success{node="node1@a"} 1` + "\n",
+		`couchdb_node_scrape_success{node="node2@b"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "# TYPE couchdb_node_scrape_success "); n != 1 {
+		t.Errorf("Expected one TYPE line per metric, got %d", n)
+	}
+}
+
+func TestNodeCollectorMembershipError(t *testing.T) {
+	client := newCluster(t, &cluster{
+		Cluster: &mock.Cluster{
+			Client: &mock.Client{},
+			MembershipFunc: func(context.Context) (*driver.ClusterMembership, error) {
+				return nil, &kivik.Error{HTTPStatus: http.StatusUnauthorized, Message: "unauthorized"}
+			},
+		},
+	})
+	c := NewNodeCollector(client)
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Unexpected status: %d", rec.Code)
+	}
+	buf := &bytes.Buffer{}
+	err := c.WritePrometheus(context.Background(), buf)
+	testy.StatusError(t, "unauthorized", http.StatusUnauthorized, err)
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected output: %s", buf.String())
+	}
+}
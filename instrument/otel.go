@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// Span attribute keys, following the OpenTelemetry semantic conventions for
+// database clients where one exists.
+const (
+	AttrDBSystem       = "db.system"
+	AttrDBName         = "db.name"
+	AttrDBOperation    = "db.operation"
+	AttrDocID          = "kivik.doc_id"
+	AttrRev            = "kivik.rev"
+	AttrRows           = "kivik.rows"
+	AttrBytes          = "kivik.bytes"
+	AttrHTTPStatusCode = "http.status_code"
+)
+
+// TraceID is an OpenTelemetry-compatible trace ID.
+type TraceID [16]byte
+
+// String returns the hex encoding of the trace ID.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID is an OpenTelemetry-compatible span ID.
+type SpanID [8]byte
+
+// String returns the hex encoding of the span ID.
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns true if both the trace ID and span ID are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc. Spans started by a
+// Tracer with this context become children of sc. This may be used to join an
+// existing trace, such as one propagated from an incoming HTTP request.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the SpanContext carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// StatusCode is the status of a completed span.
+type StatusCode int
+
+// Span status codes.
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "Ok"
+	case StatusError:
+		return "Error"
+	}
+	return "Unset"
+}
+
+// SpanData describes a completed span.
+type SpanData struct {
+	// Name is the span name, of the form "kivik.<Method>".
+	Name string
+	SpanContext
+	// Parent is the context of the parent span, if any.
+	Parent     SpanContext
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	Status     StatusCode
+	// StatusMessage is the error message of a failed call.
+	StatusMessage string
+}
+
+// SpanExporter receives completed spans.
+type SpanExporter interface {
+	ExportSpan(SpanData)
+}
+
+// SpanExporterFunc is an adapter to allow the use of ordinary functions as a
+// SpanExporter.
+type SpanExporterFunc func(SpanData)
+
+var _ SpanExporter = SpanExporterFunc(nil)
+
+// ExportSpan calls f(span).
+func (f SpanExporterFunc) ExportSpan(span SpanData) { f(span) }
+
+// Tracer is a kivik.Tracer which records each call as an OpenTelemetry-style
+// span, and passes it to an exporter on completion.
+type Tracer struct {
+	exporter SpanExporter
+	now      func() time.Time
+}
+
+var _ kivik.Tracer = &Tracer{}
+
+// NewTracer returns a new Tracer which passes completed spans to exporter.
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		now:      time.Now,
+	}
+}
+
+// StartSpan satisfies the kivik.Tracer interface. The returned context carries
+// the new span's SpanContext.
+func (t *Tracer) StartSpan(ctx context.Context, op *kivik.Operation) (context.Context, kivik.Span) {
+	parent, _ := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID}
+	if !parent.IsValid() {
+		parent = SpanContext{}
+		_, _ = rand.Read(sc.TraceID[:])
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+	attrs := map[string]interface{}{
+		AttrDBSystem:    op.Driver,
+		AttrDBOperation: op.Method,
+	}
+	if op.DB != "" {
+		attrs[AttrDBName] = op.DB
+	}
+	if op.DocID != "" {
+		attrs[AttrDocID] = op.DocID
+	}
+	if op.Rev != "" {
+		attrs[AttrRev] = op.Rev
+	}
+	s := &otelSpan{
+		tracer: t,
+		data: SpanData{
+			Name:        "kivik." + op.Method,
+			SpanContext: sc,
+			Parent:      parent,
+			StartTime:   t.now(),
+			Attributes:  attrs,
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), s
+}
+
+type otelSpan struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	rows  int64
+	bytes int64
+}
+
+func (s *otelSpan) AddRows(n int) {
+	s.mu.Lock()
+	s.rows += int64(n)
+	s.mu.Unlock()
+}
+
+func (s *otelSpan) AddBytes(n int64) {
+	s.mu.Lock()
+	s.bytes += n
+	s.mu.Unlock()
+}
+
+func (s *otelSpan) End(err error) {
+	s.mu.Lock()
+	data := s.data
+	data.EndTime = s.tracer.now()
+	data.Attributes[AttrRows] = s.rows
+	data.Attributes[AttrBytes] = s.bytes
+	if err != nil {
+		data.Status = StatusError
+		data.StatusMessage = err.Error()
+		data.Attributes[AttrHTTPStatusCode] = kivik.StatusCode(err)
+	} else {
+		data.Status = StatusOK
+	}
+	s.mu.Unlock()
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
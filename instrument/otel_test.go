@@ -0,0 +1,109 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package instrument
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+func TestTracer(t *testing.T) {
+	var spans []SpanData
+	tracer := NewTracer(SpanExporterFunc(func(s SpanData) {
+		spans = append(spans, s)
+	}))
+	parent := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	ctx, span := tracer.StartSpan(ctx, &kivik.Operation{
+		Method: "Get",
+		Driver: "couch",
+		DB:     "foo",
+		DocID:  "bar",
+	})
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok || !sc.IsValid() {
+		t.Fatal("Expected span context in returned context")
+	}
+	span.AddRows(1)
+	span.AddBytes(42)
+	span.End(&kivik.Error{HTTPStatus: http.StatusNotFound, Err: errors.New("missing")})
+
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "kivik.Get" {
+		t.Errorf("Unexpected name: %s", s.Name)
+	}
+	if s.TraceID != parent.TraceID || s.Parent != parent || s.SpanContext != sc {
+		t.Errorf("Unexpected span context: %v, parent %v", s.SpanContext, s.Parent)
+	}
+	if s.Status != StatusError || s.StatusMessage != "missing" {
+		t.Errorf("Unexpected status: %s %s", s.Status, s.StatusMessage)
+	}
+	if d := testy.DiffInterface(map[string]interface{}{
+		AttrDBSystem:       "couch",
+		AttrDBName:         "foo",
+		AttrDBOperation:    "Get",
+		AttrDocID:          "bar",
+		AttrRows:           int64(1),
+		AttrBytes:          int64(42),
+		AttrHTTPStatusCode: http.StatusNotFound,
+	}, s.Attributes); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestTracerRoot(t *testing.T) {
+	var got SpanData
+	tracer := NewTracer(SpanExporterFunc(func(s SpanData) { got = s }))
+	_, span := tracer.StartSpan(context.Background(), &kivik.Operation{Method: "AllDBs"})
+	span.End(nil)
+	if !got.IsValid() {
+		t.Error("Expected a new trace to be started")
+	}
+	if got.Parent.IsValid() {
+		t.Error("Root span should have no parent")
+	}
+	if got.Status != StatusOK {
+		t.Errorf("Unexpected status: %s", got.Status)
+	}
+	if _, ok := got.Attributes[AttrDBName]; ok {
+		t.Error("Unexpected db.name attribute")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	m := NewMetrics()
+	var exported int
+	tracer := Multi(m, NewTracer(SpanExporterFunc(func(SpanData) { exported++ })))
+	ctx, span := tracer.StartSpan(context.Background(), &kivik.Operation{Method: "Get"})
+	if _, ok := SpanContextFromContext(ctx); !ok {
+		t.Error("Context not propagated")
+	}
+	span.AddRows(3)
+	span.End(nil)
+	if exported != 1 {
+		t.Errorf("Expected 1 exported span, got %d", exported)
+	}
+	if series := m.Collect(); len(series) != 1 || series[0].Rows != 3 {
+		t.Errorf("Unexpected metrics: %v", series)
+	}
+}
@@ -0,0 +1,495 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package forward
+
+import (
+	"context"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+type client struct {
+	base   driver.Client
+	router ClientRouter
+}
+
+var (
+	_ driver.Client               = &client{}
+	_ driver.ClientReplicator     = &client{}
+	_ driver.Authenticator        = &client{}
+	_ driver.Cluster              = &client{}
+	_ driver.ClientCloser         = &client{}
+	_ driver.Configer             = &client{}
+	_ driver.Sessioner            = &client{}
+	_ driver.DBUpdaterWithOptions = &client{}
+	_ driver.Impersonator         = &client{}
+	_ driver.Deauthenticator      = &client{}
+	_ driver.SessionExpirer       = &client{}
+	_ driver.ClientScheduler      = &client{}
+	_ driver.ActiveTasker         = &client{}
+	_ driver.NodeStatser          = &client{}
+)
+
+// NewClient returns a driver.Client which forwards calls to router. base is
+// the underlying client, whose optional interfaces are exposed as described
+// in the package documentation.
+func NewClient(base driver.Client, router ClientRouter) driver.Client {
+	c := &client{base: base, router: router}
+	_, isPinger := base.(driver.Pinger)
+	_, isStatser := base.(driver.DBsStatser)
+	switch {
+	case isPinger && isStatser:
+		return struct {
+			*client
+			pinger
+			dbsStatser
+		}{c, pinger{c}, dbsStatser{c}}
+	case isPinger:
+		return struct {
+			*client
+			pinger
+		}{c, pinger{c}}
+	case isStatser:
+		return struct {
+			*client
+			dbsStatser
+		}{c, dbsStatser{c}}
+	}
+	return c
+}
+
+func (c *client) route(ctx context.Context, method, dbName string, write bool, fn func(context.Context, driver.Client) error) error {
+	return c.router.Route(ctx, &Call{Method: method, DB: dbName, Write: write}, fn)
+}
+
+func (c *client) Version(ctx context.Context) (ver *driver.Version, err error) {
+	err = c.route(ctx, "Version", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		ver, err = t.Version(ctx)
+		return err
+	})
+	return ver, err
+}
+
+func (c *client) AllDBs(ctx context.Context, options map[string]interface{}) (dbs []string, err error) {
+	err = c.route(ctx, "AllDBs", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		dbs, err = t.AllDBs(ctx, options)
+		return err
+	})
+	return dbs, err
+}
+
+func (c *client) DBExists(ctx context.Context, dbName string, options map[string]interface{}) (exists bool, err error) {
+	err = c.route(ctx, "DBExists", dbName, false, func(ctx context.Context, t driver.Client) (err error) {
+		exists, err = t.DBExists(ctx, dbName, options)
+		return err
+	})
+	return exists, err
+}
+
+func (c *client) CreateDB(ctx context.Context, dbName string, options map[string]interface{}) error {
+	return c.route(ctx, "CreateDB", dbName, true, func(ctx context.Context, t driver.Client) error {
+		return t.CreateDB(ctx, dbName, options)
+	})
+}
+
+func (c *client) DestroyDB(ctx context.Context, dbName string, options map[string]interface{}) error {
+	return c.route(ctx, "DestroyDB", dbName, true, func(ctx context.Context, t driver.Client) error {
+		return t.DestroyDB(ctx, dbName, options)
+	})
+}
+
+// DB is not routed, as it makes no request.
+func (c *client) DB(dbName string, options map[string]interface{}) (driver.DB, error) {
+	base, router, err := c.router.DB(dbName, options)
+	if err != nil {
+		return nil, err
+	}
+	return NewDB(base, dbName, router), nil
+}
+
+// Close is not routed, so that resources are always released.
+func (c *client) Close(ctx context.Context) error {
+	return c.router.Close(ctx)
+}
+
+func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (rep driver.Replication, err error) {
+	if _, ok := c.base.(driver.ClientReplicator); !ok {
+		return nil, NotImplemented("Replicate")
+	}
+	err = c.route(ctx, "Replicate", "", true, func(ctx context.Context, t driver.Client) (err error) {
+		replicator, ok := t.(driver.ClientReplicator)
+		if !ok {
+			return NotImplemented("Replicate")
+		}
+		rep, err = replicator.Replicate(ctx, targetDSN, sourceDSN, options)
+		return err
+	})
+	return rep, err
+}
+
+func (c *client) GetReplications(ctx context.Context, options map[string]interface{}) (reps []driver.Replication, err error) {
+	if _, ok := c.base.(driver.ClientReplicator); !ok {
+		return nil, NotImplemented("GetReplications")
+	}
+	err = c.route(ctx, "GetReplications", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		replicator, ok := t.(driver.ClientReplicator)
+		if !ok {
+			return NotImplemented("GetReplications")
+		}
+		reps, err = replicator.GetReplications(ctx, options)
+		return err
+	})
+	return reps, err
+}
+
+func (c *client) Authenticate(ctx context.Context, authenticator interface{}) error {
+	if _, ok := c.base.(driver.Authenticator); !ok {
+		return NotImplemented("Authenticate")
+	}
+	if auth, ok := c.router.(driver.Authenticator); ok {
+		return auth.Authenticate(ctx, authenticator)
+	}
+	return c.route(ctx, "Authenticate", "", true, func(ctx context.Context, t driver.Client) error {
+		auth, ok := t.(driver.Authenticator)
+		if !ok {
+			return NotImplemented("Authenticate")
+		}
+		return auth.Authenticate(ctx, authenticator)
+	})
+}
+
+func (c *client) Logout(ctx context.Context) error {
+	if _, ok := c.base.(driver.Deauthenticator); !ok {
+		return NotImplemented("Logout")
+	}
+	if deauth, ok := c.router.(driver.Deauthenticator); ok {
+		return deauth.Logout(ctx)
+	}
+	return c.route(ctx, "Logout", "", true, func(ctx context.Context, t driver.Client) error {
+		deauth, ok := t.(driver.Deauthenticator)
+		if !ok {
+			return NotImplemented("Logout")
+		}
+		return deauth.Logout(ctx)
+	})
+}
+
+// SessionExpiry returns the session expiry reported by the router, or else by
+// the base client. It is not routed, as it makes no request.
+func (c *client) SessionExpiry() time.Time {
+	e, ok := c.router.(driver.SessionExpirer)
+	if !ok {
+		e, ok = c.base.(driver.SessionExpirer)
+	}
+	if !ok {
+		return time.Time{}
+	}
+	return e.SessionExpiry()
+}
+
+// Impersonates reports whether the router, or else the base client, supports
+// impersonation.
+func (c *client) Impersonates() bool {
+	i, ok := c.router.(driver.Impersonator)
+	if !ok {
+		i, ok = c.base.(driver.Impersonator)
+	}
+	return ok && i.Impersonates()
+}
+
+func (c *client) ClusterStatus(ctx context.Context, options map[string]interface{}) (status string, err error) {
+	if _, ok := c.base.(driver.Cluster); !ok {
+		return "", NotImplemented("ClusterStatus")
+	}
+	err = c.route(ctx, "ClusterStatus", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		cluster, ok := t.(driver.Cluster)
+		if !ok {
+			return NotImplemented("ClusterStatus")
+		}
+		status, err = cluster.ClusterStatus(ctx, options)
+		return err
+	})
+	return status, err
+}
+
+func (c *client) ClusterSetup(ctx context.Context, action interface{}) error {
+	if _, ok := c.base.(driver.Cluster); !ok {
+		return NotImplemented("ClusterSetup")
+	}
+	return c.route(ctx, "ClusterSetup", "", true, func(ctx context.Context, t driver.Client) error {
+		cluster, ok := t.(driver.Cluster)
+		if !ok {
+			return NotImplemented("ClusterSetup")
+		}
+		return cluster.ClusterSetup(ctx, action)
+	})
+}
+
+func (c *client) Membership(ctx context.Context) (members *driver.ClusterMembership, err error) {
+	if _, ok := c.base.(driver.Cluster); !ok {
+		return nil, NotImplemented("Membership")
+	}
+	err = c.route(ctx, "Membership", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		cluster, ok := t.(driver.Cluster)
+		if !ok {
+			return NotImplemented("Membership")
+		}
+		members, err = cluster.Membership(ctx)
+		return err
+	})
+	return members, err
+}
+
+func (c *client) Config(ctx context.Context, node string) (config driver.Config, err error) {
+	if _, ok := c.base.(driver.Configer); !ok {
+		return nil, NotImplemented("Config")
+	}
+	err = c.route(ctx, "Config", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		configer, ok := t.(driver.Configer)
+		if !ok {
+			return NotImplemented("Config")
+		}
+		config, err = configer.Config(ctx, node)
+		return err
+	})
+	return config, err
+}
+
+func (c *client) ConfigSection(ctx context.Context, node, section string) (sec driver.ConfigSection, err error) {
+	if _, ok := c.base.(driver.Configer); !ok {
+		return nil, NotImplemented("ConfigSection")
+	}
+	err = c.route(ctx, "ConfigSection", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		configer, ok := t.(driver.Configer)
+		if !ok {
+			return NotImplemented("ConfigSection")
+		}
+		sec, err = configer.ConfigSection(ctx, node, section)
+		return err
+	})
+	return sec, err
+}
+
+func (c *client) ConfigValue(ctx context.Context, node, section, key string) (value string, err error) {
+	if _, ok := c.base.(driver.Configer); !ok {
+		return "", NotImplemented("ConfigValue")
+	}
+	err = c.route(ctx, "ConfigValue", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		configer, ok := t.(driver.Configer)
+		if !ok {
+			return NotImplemented("ConfigValue")
+		}
+		value, err = configer.ConfigValue(ctx, node, section, key)
+		return err
+	})
+	return value, err
+}
+
+func (c *client) SetConfigValue(ctx context.Context, node, section, key, value string) (old string, err error) {
+	if _, ok := c.base.(driver.Configer); !ok {
+		return "", NotImplemented("SetConfigValue")
+	}
+	err = c.route(ctx, "SetConfigValue", "", true, func(ctx context.Context, t driver.Client) (err error) {
+		configer, ok := t.(driver.Configer)
+		if !ok {
+			return NotImplemented("SetConfigValue")
+		}
+		old, err = configer.SetConfigValue(ctx, node, section, key, value)
+		return err
+	})
+	return old, err
+}
+
+func (c *client) DeleteConfigKey(ctx context.Context, node, section, key string) (old string, err error) {
+	if _, ok := c.base.(driver.Configer); !ok {
+		return "", NotImplemented("DeleteConfigKey")
+	}
+	err = c.route(ctx, "DeleteConfigKey", "", true, func(ctx context.Context, t driver.Client) (err error) {
+		configer, ok := t.(driver.Configer)
+		if !ok {
+			return NotImplemented("DeleteConfigKey")
+		}
+		old, err = configer.DeleteConfigKey(ctx, node, section, key)
+		return err
+	})
+	return old, err
+}
+
+// Session is routed as a write, so that it is served by the client which
+// holds the session.
+func (c *client) Session(ctx context.Context) (session *driver.Session, err error) {
+	if _, ok := c.base.(driver.Sessioner); !ok {
+		return nil, NotImplemented("Session")
+	}
+	err = c.route(ctx, "Session", "", true, func(ctx context.Context, t driver.Client) (err error) {
+		sessioner, ok := t.(driver.Sessioner)
+		if !ok {
+			return NotImplemented("Session")
+		}
+		session, err = sessioner.Session(ctx)
+		return err
+	})
+	return session, err
+}
+
+// DBUpdates adapts clients which implement the legacy driver.DBUpdater
+// interface to driver.DBUpdaterWithOptions.
+func (c *client) DBUpdates(ctx context.Context, options map[string]interface{}) (updates driver.DBUpdates, err error) {
+	switch c.base.(type) {
+	case driver.DBUpdaterWithOptions, driver.DBUpdater:
+	default:
+		return nil, NotImplemented("DBUpdates")
+	}
+	err = c.route(ctx, "DBUpdates", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		switch u := t.(type) {
+		case driver.DBUpdaterWithOptions:
+			updates, err = u.DBUpdates(ctx, options)
+		case driver.DBUpdater:
+			updates, err = u.DBUpdates(ctx)
+		default:
+			return NotImplemented("DBUpdates")
+		}
+		return err
+	})
+	return updates, err
+}
+
+func (c *client) SchedulerJobs(ctx context.Context, options map[string]interface{}) (jobs []*driver.SchedulerJob, err error) {
+	if _, ok := c.base.(driver.ClientScheduler); !ok {
+		return nil, NotImplemented("SchedulerJobs")
+	}
+	err = c.route(ctx, "SchedulerJobs", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		scheduler, ok := t.(driver.ClientScheduler)
+		if !ok {
+			return NotImplemented("SchedulerJobs")
+		}
+		jobs, err = scheduler.SchedulerJobs(ctx, options)
+		return err
+	})
+	return jobs, err
+}
+
+func (c *client) SchedulerDocs(ctx context.Context, replicatorDB string, options map[string]interface{}) (docs []*driver.SchedulerDoc, err error) {
+	if _, ok := c.base.(driver.ClientScheduler); !ok {
+		return nil, NotImplemented("SchedulerDocs")
+	}
+	err = c.route(ctx, "SchedulerDocs", replicatorDB, false, func(ctx context.Context, t driver.Client) (err error) {
+		scheduler, ok := t.(driver.ClientScheduler)
+		if !ok {
+			return NotImplemented("SchedulerDocs")
+		}
+		docs, err = scheduler.SchedulerDocs(ctx, replicatorDB, options)
+		return err
+	})
+	return docs, err
+}
+
+func (c *client) SchedulerDoc(ctx context.Context, replicatorDB, docID string) (doc *driver.SchedulerDoc, err error) {
+	if _, ok := c.base.(driver.ClientScheduler); !ok {
+		return nil, NotImplemented("SchedulerDoc")
+	}
+	err = c.router.Route(ctx, &Call{Method: "SchedulerDoc", DB: replicatorDB, DocID: docID}, func(ctx context.Context, t driver.Client) (err error) {
+		scheduler, ok := t.(driver.ClientScheduler)
+		if !ok {
+			return NotImplemented("SchedulerDoc")
+		}
+		doc, err = scheduler.SchedulerDoc(ctx, replicatorDB, docID)
+		return err
+	})
+	return doc, err
+}
+
+func (c *client) ActiveTasks(ctx context.Context) (tasks []*driver.ActiveTask, err error) {
+	if _, ok := c.base.(driver.ActiveTasker); !ok {
+		return nil, NotImplemented("ActiveTasks")
+	}
+	err = c.route(ctx, "ActiveTasks", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		tasker, ok := t.(driver.ActiveTasker)
+		if !ok {
+			return NotImplemented("ActiveTasks")
+		}
+		tasks, err = tasker.ActiveTasks(ctx)
+		return err
+	})
+	return tasks, err
+}
+
+func (c *client) NodeStats(ctx context.Context, node string) (stats jsoniter.RawMessage, err error) {
+	if _, ok := c.base.(driver.NodeStatser); !ok {
+		return nil, NotImplemented("NodeStats")
+	}
+	err = c.route(ctx, "NodeStats", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		statser, ok := t.(driver.NodeStatser)
+		if !ok {
+			return NotImplemented("NodeStats")
+		}
+		stats, err = statser.NodeStats(ctx, node)
+		return err
+	})
+	return stats, err
+}
+
+func (c *client) NodeSystem(ctx context.Context, node string) (system jsoniter.RawMessage, err error) {
+	if _, ok := c.base.(driver.NodeStatser); !ok {
+		return nil, NotImplemented("NodeSystem")
+	}
+	err = c.route(ctx, "NodeSystem", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		statser, ok := t.(driver.NodeStatser)
+		if !ok {
+			return NotImplemented("NodeSystem")
+		}
+		system, err = statser.NodeSystem(ctx, node)
+		return err
+	})
+	return system, err
+}
+
+// pinger is embedded by clients whose base implements driver.Pinger.
+type pinger struct {
+	c *client
+}
+
+func (p pinger) Ping(ctx context.Context) (up bool, err error) {
+	if pinger, ok := p.c.router.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	err = p.c.route(ctx, "Ping", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		pinger, ok := t.(driver.Pinger)
+		if !ok {
+			return NotImplemented("Ping")
+		}
+		up, err = pinger.Ping(ctx)
+		return err
+	})
+	return up, err
+}
+
+// dbsStatser is embedded by clients whose base implements driver.DBsStatser.
+type dbsStatser struct {
+	c *client
+}
+
+func (s dbsStatser) DBsStats(ctx context.Context, dbNames []string) (stats []*driver.DBStats, err error) {
+	err = s.c.route(ctx, "DBsStats", "", false, func(ctx context.Context, t driver.Client) (err error) {
+		statser, ok := t.(driver.DBsStatser)
+		if !ok {
+			return NotImplemented("DBsStats")
+		}
+		stats, err = statser.DBsStats(ctx, dbNames)
+		return err
+	})
+	return stats, err
+}
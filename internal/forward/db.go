@@ -0,0 +1,590 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package forward
+
+import (
+	"context"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+type db struct {
+	base   driver.DB
+	name   string
+	router DBRouter
+}
+
+var (
+	_ driver.DB              = &db{}
+	_ driver.Purger          = &db{}
+	_ driver.OptsFinder      = &db{}
+	_ driver.Flusher         = &db{}
+	_ driver.DesignDocer     = &db{}
+	_ driver.LocalDocer      = &db{}
+	_ driver.DBCloser        = &db{}
+	_ driver.RevsDiffer      = &db{}
+	_ driver.BulkGetter      = &db{}
+	_ driver.PartitionedDB   = &db{}
+	_ driver.DesignDocInfoer = &db{}
+	_ driver.Searcher        = &db{}
+)
+
+// Bits of the mask of optional interfaces which Kivik emulates, and which are
+// only exposed when the base DB implements them.
+const (
+	capBulkDocer = 1 << iota
+	capCopier
+	capMetaGetter
+	capAttachmentMetaGetter
+)
+
+// NewDB returns a driver.DB which forwards calls to router. base is the
+// underlying DB, whose optional interfaces are exposed as described in the
+// package documentation, and name is the name of the database.
+func NewDB(base driver.DB, name string, router DBRouter) driver.DB {
+	d := &db{base: base, name: name, router: router}
+	var mask int
+	if _, ok := base.(driver.BulkDocer); ok {
+		mask |= capBulkDocer
+	}
+	if _, ok := base.(driver.Copier); ok {
+		mask |= capCopier
+	}
+	if _, ok := base.(driver.MetaGetter); ok {
+		mask |= capMetaGetter
+	}
+	if _, ok := base.(driver.AttachmentMetaGetter); ok {
+		mask |= capAttachmentMetaGetter
+	}
+	// Each combination needs its own type, so that the interfaces of base are
+	// exposed exactly.
+	switch mask {
+	case capBulkDocer | capCopier | capMetaGetter | capAttachmentMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			copier
+			metaGetter
+			attachmentMetaGetter
+		}{d, bulkDocer{d}, copier{d}, metaGetter{d}, attachmentMetaGetter{d}}
+	case capCopier | capMetaGetter | capAttachmentMetaGetter:
+		return struct {
+			*db
+			copier
+			metaGetter
+			attachmentMetaGetter
+		}{d, copier{d}, metaGetter{d}, attachmentMetaGetter{d}}
+	case capBulkDocer | capMetaGetter | capAttachmentMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			metaGetter
+			attachmentMetaGetter
+		}{d, bulkDocer{d}, metaGetter{d}, attachmentMetaGetter{d}}
+	case capMetaGetter | capAttachmentMetaGetter:
+		return struct {
+			*db
+			metaGetter
+			attachmentMetaGetter
+		}{d, metaGetter{d}, attachmentMetaGetter{d}}
+	case capBulkDocer | capCopier | capAttachmentMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			copier
+			attachmentMetaGetter
+		}{d, bulkDocer{d}, copier{d}, attachmentMetaGetter{d}}
+	case capCopier | capAttachmentMetaGetter:
+		return struct {
+			*db
+			copier
+			attachmentMetaGetter
+		}{d, copier{d}, attachmentMetaGetter{d}}
+	case capBulkDocer | capAttachmentMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			attachmentMetaGetter
+		}{d, bulkDocer{d}, attachmentMetaGetter{d}}
+	case capAttachmentMetaGetter:
+		return struct {
+			*db
+			attachmentMetaGetter
+		}{d, attachmentMetaGetter{d}}
+	case capBulkDocer | capCopier | capMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			copier
+			metaGetter
+		}{d, bulkDocer{d}, copier{d}, metaGetter{d}}
+	case capCopier | capMetaGetter:
+		return struct {
+			*db
+			copier
+			metaGetter
+		}{d, copier{d}, metaGetter{d}}
+	case capBulkDocer | capMetaGetter:
+		return struct {
+			*db
+			bulkDocer
+			metaGetter
+		}{d, bulkDocer{d}, metaGetter{d}}
+	case capMetaGetter:
+		return struct {
+			*db
+			metaGetter
+		}{d, metaGetter{d}}
+	case capBulkDocer | capCopier:
+		return struct {
+			*db
+			bulkDocer
+			copier
+		}{d, bulkDocer{d}, copier{d}}
+	case capCopier:
+		return struct {
+			*db
+			copier
+		}{d, copier{d}}
+	case capBulkDocer:
+		return struct {
+			*db
+			bulkDocer
+		}{d, bulkDocer{d}}
+	}
+	return d
+}
+
+func (db *db) route(ctx context.Context, method, docID string, write bool, fn func(context.Context, driver.DB) error) error {
+	return db.router.Route(ctx, &Call{Method: method, DB: db.name, DocID: docID, Write: write}, fn)
+}
+
+// rowsCall routes a call which returns driver.Rows.
+func (db *db) rowsCall(ctx context.Context, method, docID string, fn func(context.Context, driver.DB) (driver.Rows, error)) (rows driver.Rows, err error) {
+	err = db.route(ctx, method, docID, false, func(ctx context.Context, t driver.DB) (err error) {
+		rows, err = fn(ctx, t)
+		return err
+	})
+	return rows, err
+}
+
+// revCall routes a write which returns a revision.
+func (db *db) revCall(ctx context.Context, method, docID string, fn func(context.Context, driver.DB) (string, error)) (rev string, err error) {
+	err = db.route(ctx, method, docID, true, func(ctx context.Context, t driver.DB) (err error) {
+		rev, err = fn(ctx, t)
+		return err
+	})
+	return rev, err
+}
+
+func (db *db) AllDocs(ctx context.Context, options map[string]interface{}) (driver.Rows, error) {
+	return db.rowsCall(ctx, "AllDocs", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		return t.AllDocs(ctx, options)
+	})
+}
+
+func (db *db) Get(ctx context.Context, docID string, options map[string]interface{}) (doc *driver.Document, err error) {
+	err = db.route(ctx, "Get", docID, false, func(ctx context.Context, t driver.DB) (err error) {
+		doc, err = t.Get(ctx, docID, options)
+		return err
+	})
+	return doc, err
+}
+
+func (db *db) CreateDoc(ctx context.Context, doc interface{}, options map[string]interface{}) (docID, rev string, err error) {
+	err = db.route(ctx, "CreateDoc", "", true, func(ctx context.Context, t driver.DB) (err error) {
+		docID, rev, err = t.CreateDoc(ctx, doc, options)
+		return err
+	})
+	return docID, rev, err
+}
+
+func (db *db) Put(ctx context.Context, docID string, doc interface{}, options map[string]interface{}) (string, error) {
+	return db.revCall(ctx, "Put", docID, func(ctx context.Context, t driver.DB) (string, error) {
+		return t.Put(ctx, docID, doc, options)
+	})
+}
+
+func (db *db) Delete(ctx context.Context, docID, rev string, options map[string]interface{}) (string, error) {
+	return db.revCall(ctx, "Delete", docID, func(ctx context.Context, t driver.DB) (string, error) {
+		return t.Delete(ctx, docID, rev, options)
+	})
+}
+
+func (db *db) Stats(ctx context.Context) (stats *driver.DBStats, err error) {
+	err = db.route(ctx, "Stats", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		stats, err = t.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (db *db) Compact(ctx context.Context) error {
+	return db.route(ctx, "Compact", "", true, func(ctx context.Context, t driver.DB) error {
+		return t.Compact(ctx)
+	})
+}
+
+func (db *db) CompactView(ctx context.Context, ddocID string) error {
+	return db.route(ctx, "CompactView", ddocID, true, func(ctx context.Context, t driver.DB) error {
+		return t.CompactView(ctx, ddocID)
+	})
+}
+
+func (db *db) ViewCleanup(ctx context.Context) error {
+	return db.route(ctx, "ViewCleanup", "", true, func(ctx context.Context, t driver.DB) error {
+		return t.ViewCleanup(ctx)
+	})
+}
+
+func (db *db) Security(ctx context.Context) (sec *driver.Security, err error) {
+	err = db.route(ctx, "Security", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		sec, err = t.Security(ctx)
+		return err
+	})
+	return sec, err
+}
+
+func (db *db) SetSecurity(ctx context.Context, security *driver.Security) error {
+	return db.route(ctx, "SetSecurity", "", true, func(ctx context.Context, t driver.DB) error {
+		return t.SetSecurity(ctx, security)
+	})
+}
+
+func (db *db) Changes(ctx context.Context, options map[string]interface{}) (changes driver.Changes, err error) {
+	err = db.route(ctx, "Changes", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		changes, err = t.Changes(ctx, options)
+		return err
+	})
+	return changes, err
+}
+
+func (db *db) PutAttachment(ctx context.Context, docID, rev string, att *driver.Attachment, options map[string]interface{}) (string, error) {
+	return db.revCall(ctx, "PutAttachment", docID, func(ctx context.Context, t driver.DB) (string, error) {
+		return t.PutAttachment(ctx, docID, rev, att, options)
+	})
+}
+
+func (db *db) GetAttachment(ctx context.Context, docID, filename string, options map[string]interface{}) (att *driver.Attachment, err error) {
+	err = db.route(ctx, "GetAttachment", docID, false, func(ctx context.Context, t driver.DB) (err error) {
+		att, err = t.GetAttachment(ctx, docID, filename, options)
+		return err
+	})
+	return att, err
+}
+
+func (db *db) DeleteAttachment(ctx context.Context, docID, rev, filename string, options map[string]interface{}) (string, error) {
+	return db.revCall(ctx, "DeleteAttachment", docID, func(ctx context.Context, t driver.DB) (string, error) {
+		return t.DeleteAttachment(ctx, docID, rev, filename, options)
+	})
+}
+
+func (db *db) Query(ctx context.Context, ddoc, view string, options map[string]interface{}) (driver.Rows, error) {
+	return db.rowsCall(ctx, "Query", ddoc, func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		return t.Query(ctx, ddoc, view, options)
+	})
+}
+
+// Close is not routed, so that resources are always released.
+func (db *db) Close(ctx context.Context) error {
+	return db.router.Close(ctx)
+}
+
+func (db *db) Purge(ctx context.Context, docRevMap map[string][]string) (result *driver.PurgeResult, err error) {
+	if _, ok := db.base.(driver.Purger); !ok {
+		return nil, NotImplemented("Purge")
+	}
+	err = db.route(ctx, "Purge", "", true, func(ctx context.Context, t driver.DB) (err error) {
+		purger, ok := t.(driver.Purger)
+		if !ok {
+			return NotImplemented("Purge")
+		}
+		result, err = purger.Purge(ctx, docRevMap)
+		return err
+	})
+	return result, err
+}
+
+func (db *db) Find(ctx context.Context, query interface{}, options map[string]interface{}) (driver.Rows, error) {
+	if _, ok := finder(db.base); !ok {
+		return nil, NotImplemented("Find")
+	}
+	return db.rowsCall(ctx, "Find", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		f, ok := finder(t)
+		if !ok {
+			return nil, NotImplemented("Find")
+		}
+		return f.Find(ctx, query, options)
+	})
+}
+
+func (db *db) CreateIndex(ctx context.Context, ddoc, name string, index interface{}, options map[string]interface{}) error {
+	if _, ok := finder(db.base); !ok {
+		return NotImplemented("CreateIndex")
+	}
+	return db.route(ctx, "CreateIndex", ddoc, true, func(ctx context.Context, t driver.DB) error {
+		f, ok := finder(t)
+		if !ok {
+			return NotImplemented("CreateIndex")
+		}
+		return f.CreateIndex(ctx, ddoc, name, index, options)
+	})
+}
+
+func (db *db) GetIndexes(ctx context.Context, options map[string]interface{}) (indexes []driver.Index, err error) {
+	if _, ok := finder(db.base); !ok {
+		return nil, NotImplemented("GetIndexes")
+	}
+	err = db.route(ctx, "GetIndexes", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		f, ok := finder(t)
+		if !ok {
+			return NotImplemented("GetIndexes")
+		}
+		indexes, err = f.GetIndexes(ctx, options)
+		return err
+	})
+	return indexes, err
+}
+
+func (db *db) DeleteIndex(ctx context.Context, ddoc, name string, options map[string]interface{}) error {
+	if _, ok := finder(db.base); !ok {
+		return NotImplemented("DeleteIndex")
+	}
+	return db.route(ctx, "DeleteIndex", ddoc, true, func(ctx context.Context, t driver.DB) error {
+		f, ok := finder(t)
+		if !ok {
+			return NotImplemented("DeleteIndex")
+		}
+		return f.DeleteIndex(ctx, ddoc, name, options)
+	})
+}
+
+func (db *db) Explain(ctx context.Context, query interface{}, options map[string]interface{}) (plan *driver.QueryPlan, err error) {
+	if _, ok := finder(db.base); !ok {
+		return nil, NotImplemented("Explain")
+	}
+	err = db.route(ctx, "Explain", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		f, ok := finder(t)
+		if !ok {
+			return NotImplemented("Explain")
+		}
+		plan, err = f.Explain(ctx, query, options)
+		return err
+	})
+	return plan, err
+}
+
+func (db *db) Flush(ctx context.Context) error {
+	if _, ok := db.base.(driver.Flusher); !ok {
+		return NotImplemented("Flush")
+	}
+	return db.route(ctx, "Flush", "", true, func(ctx context.Context, t driver.DB) error {
+		flusher, ok := t.(driver.Flusher)
+		if !ok {
+			return NotImplemented("Flush")
+		}
+		return flusher.Flush(ctx)
+	})
+}
+
+func (db *db) DesignDocs(ctx context.Context, options map[string]interface{}) (driver.Rows, error) {
+	if _, ok := db.base.(driver.DesignDocer); !ok {
+		return nil, NotImplemented("DesignDocs")
+	}
+	return db.rowsCall(ctx, "DesignDocs", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		ddocer, ok := t.(driver.DesignDocer)
+		if !ok {
+			return nil, NotImplemented("DesignDocs")
+		}
+		return ddocer.DesignDocs(ctx, options)
+	})
+}
+
+func (db *db) LocalDocs(ctx context.Context, options map[string]interface{}) (driver.Rows, error) {
+	if _, ok := db.base.(driver.LocalDocer); !ok {
+		return nil, NotImplemented("LocalDocs")
+	}
+	return db.rowsCall(ctx, "LocalDocs", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		ldocer, ok := t.(driver.LocalDocer)
+		if !ok {
+			return nil, NotImplemented("LocalDocs")
+		}
+		return ldocer.LocalDocs(ctx, options)
+	})
+}
+
+func (db *db) RevsDiff(ctx context.Context, revMap interface{}) (driver.Rows, error) {
+	if _, ok := db.base.(driver.RevsDiffer); !ok {
+		return nil, NotImplemented("RevsDiff")
+	}
+	return db.rowsCall(ctx, "RevsDiff", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		rd, ok := t.(driver.RevsDiffer)
+		if !ok {
+			return nil, NotImplemented("RevsDiff")
+		}
+		return rd.RevsDiff(ctx, revMap)
+	})
+}
+
+func (db *db) BulkGet(ctx context.Context, docs []driver.BulkGetReference, options map[string]interface{}) (driver.Rows, error) {
+	if _, ok := db.base.(driver.BulkGetter); !ok {
+		return nil, NotImplemented("BulkGet")
+	}
+	return db.rowsCall(ctx, "BulkGet", "", func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		bulkGetter, ok := t.(driver.BulkGetter)
+		if !ok {
+			return nil, NotImplemented("BulkGet")
+		}
+		return bulkGetter.BulkGet(ctx, docs, options)
+	})
+}
+
+func (db *db) PartitionStats(ctx context.Context, name string) (stats *driver.PartitionStats, err error) {
+	if _, ok := db.base.(driver.PartitionedDB); !ok {
+		return nil, NotImplemented("PartitionStats")
+	}
+	err = db.route(ctx, "PartitionStats", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		pdb, ok := t.(driver.PartitionedDB)
+		if !ok {
+			return NotImplemented("PartitionStats")
+		}
+		stats, err = pdb.PartitionStats(ctx, name)
+		return err
+	})
+	return stats, err
+}
+
+func (db *db) Search(ctx context.Context, ddoc, index, query string, options map[string]interface{}) (driver.Rows, error) {
+	if _, ok := db.base.(driver.Searcher); !ok {
+		return nil, NotImplemented("Search")
+	}
+	return db.rowsCall(ctx, "Search", ddoc, func(ctx context.Context, t driver.DB) (driver.Rows, error) {
+		searcher, ok := t.(driver.Searcher)
+		if !ok {
+			return nil, NotImplemented("Search")
+		}
+		return searcher.Search(ctx, ddoc, index, query, options)
+	})
+}
+
+func (db *db) SearchInfo(ctx context.Context, ddoc, index string) (info *driver.SearchInfo, err error) {
+	if _, ok := db.base.(driver.Searcher); !ok {
+		return nil, NotImplemented("SearchInfo")
+	}
+	err = db.route(ctx, "SearchInfo", ddoc, false, func(ctx context.Context, t driver.DB) (err error) {
+		searcher, ok := t.(driver.Searcher)
+		if !ok {
+			return NotImplemented("SearchInfo")
+		}
+		info, err = searcher.SearchInfo(ctx, ddoc, index)
+		return err
+	})
+	return info, err
+}
+
+func (db *db) SearchAnalyze(ctx context.Context, text string) (tokens []string, err error) {
+	if _, ok := db.base.(driver.Searcher); !ok {
+		return nil, NotImplemented("SearchAnalyze")
+	}
+	err = db.route(ctx, "SearchAnalyze", "", false, func(ctx context.Context, t driver.DB) (err error) {
+		searcher, ok := t.(driver.Searcher)
+		if !ok {
+			return NotImplemented("SearchAnalyze")
+		}
+		tokens, err = searcher.SearchAnalyze(ctx, text)
+		return err
+	})
+	return tokens, err
+}
+
+func (db *db) DesignDocInfo(ctx context.Context, ddocID string) (info *driver.DesignDocInfo, err error) {
+	if _, ok := db.base.(driver.DesignDocInfoer); !ok {
+		return nil, NotImplemented("DesignDocInfo")
+	}
+	err = db.route(ctx, "DesignDocInfo", ddocID, false, func(ctx context.Context, t driver.DB) (err error) {
+		infoer, ok := t.(driver.DesignDocInfoer)
+		if !ok {
+			return NotImplemented("DesignDocInfo")
+		}
+		info, err = infoer.DesignDocInfo(ctx, ddocID)
+		return err
+	})
+	return info, err
+}
+
+// bulkDocer is embedded by DBs whose base implements driver.BulkDocer.
+type bulkDocer struct {
+	db *db
+}
+
+func (b bulkDocer) BulkDocs(ctx context.Context, docs []interface{}, options map[string]interface{}) (results driver.BulkResults, err error) {
+	err = b.db.route(ctx, "BulkDocs", "", true, func(ctx context.Context, t driver.DB) (err error) {
+		bulkDocer, ok := t.(driver.BulkDocer)
+		if !ok {
+			return NotImplemented("BulkDocs")
+		}
+		results, err = bulkDocer.BulkDocs(ctx, docs, options)
+		return err
+	})
+	return results, err
+}
+
+// copier is embedded by DBs whose base implements driver.Copier.
+type copier struct {
+	db *db
+}
+
+func (c copier) Copy(ctx context.Context, targetID, sourceID string, options map[string]interface{}) (string, error) {
+	return c.db.revCall(ctx, "Copy", targetID, func(ctx context.Context, t driver.DB) (string, error) {
+		copier, ok := t.(driver.Copier)
+		if !ok {
+			return "", NotImplemented("Copy")
+		}
+		return copier.Copy(ctx, targetID, sourceID, options)
+	})
+}
+
+// metaGetter is embedded by DBs whose base implements driver.MetaGetter.
+type metaGetter struct {
+	db *db
+}
+
+func (m metaGetter) GetMeta(ctx context.Context, docID string, options map[string]interface{}) (size int64, rev string, err error) {
+	err = m.db.route(ctx, "GetMeta", docID, false, func(ctx context.Context, t driver.DB) (err error) {
+		metaer, ok := t.(driver.MetaGetter)
+		if !ok {
+			return NotImplemented("GetMeta")
+		}
+		size, rev, err = metaer.GetMeta(ctx, docID, options)
+		return err
+	})
+	return size, rev, err
+}
+
+// attachmentMetaGetter is embedded by DBs whose base implements
+// driver.AttachmentMetaGetter.
+type attachmentMetaGetter struct {
+	db *db
+}
+
+func (m attachmentMetaGetter) GetAttachmentMeta(ctx context.Context, docID, filename string, options map[string]interface{}) (att *driver.Attachment, err error) {
+	err = m.db.route(ctx, "GetAttachmentMeta", docID, false, func(ctx context.Context, t driver.DB) (err error) {
+		metaer, ok := t.(driver.AttachmentMetaGetter)
+		if !ok {
+			return NotImplemented("GetAttachmentMeta")
+		}
+		att, err = metaer.GetAttachmentMeta(ctx, docID, filename, options)
+		return err
+	})
+	return att, err
+}
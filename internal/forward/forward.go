@@ -0,0 +1,121 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package forward provides the driver.Client and driver.DB implementations
+// shared by the driver wrappers, such as middleware, failover and cache. Each
+// call is described by a Call, and passed to a router, which chooses the
+// underlying client or DB to serve it, and may act before and after it.
+//
+// Kivik emulates some optional interfaces when a driver does not implement
+// them: Pinger and DBsStatser on a client, and BulkDocer, Copier, MetaGetter
+// and AttachmentMetaGetter on a DB. The forwarding client and DB implement
+// these interfaces only when the base client or DB does, so that the
+// emulation works behind a wrapper just as it does without one. The other
+// optional interfaces, which Kivik does not emulate, are always implemented,
+// and return the same http.StatusNotImplemented error Kivik would return,
+// without calling the router, when the base does not implement them.
+package forward
+
+import (
+	"context"
+	"net/http"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// Call describes a forwarded call.
+type Call struct {
+	// Method is the name of the driver method being called, such as "Get".
+	Method string
+	// DB is the name of the database, or empty for server-level calls.
+	DB string
+	// DocID is the document ID, if the call applies to a single document.
+	DocID string
+	// Write is true for calls which modify the server, or which are bound
+	// to the client's session.
+	Write bool
+}
+
+// ClientRouter routes the calls of a forwarding client.
+//
+// A ClientRouter may also implement driver.Authenticator,
+// driver.Deauthenticator, driver.Pinger, driver.Impersonator or
+// driver.SessionExpirer, to handle those calls itself, rather than have them
+// routed. The client still implements only the interfaces the base client
+// implements.
+type ClientRouter interface {
+	// Route calls fn with the client which is to serve call, and returns the
+	// error returned by fn, or an error of its own.
+	Route(ctx context.Context, call *Call, fn func(context.Context, driver.Client) error) error
+	// DB returns the router for the calls of the named database, and a
+	// handle whose optional interfaces the forwarding DB exposes.
+	DB(name string, options map[string]interface{}) (driver.DB, DBRouter, error)
+	// Close releases the resources of the client. It is not routed, so that
+	// resources are always released.
+	Close(ctx context.Context) error
+}
+
+// DBRouter routes the calls of a forwarding DB.
+type DBRouter interface {
+	// Route calls fn with the DB which is to serve call, and returns the
+	// error returned by fn, or an error of its own.
+	Route(ctx context.Context, call *Call, fn func(context.Context, driver.DB) error) error
+	// Close releases the resources of the DB. It is not routed.
+	Close(ctx context.Context) error
+}
+
+// NotImplemented returns the error returned when the underlying driver does
+// not implement method.
+func NotImplemented(method string) error {
+	return &kivik.Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support " + method}
+}
+
+// legacyFinder adapts the deprecated driver.Finder interface to
+// driver.OptsFinder, discarding options.
+type legacyFinder struct {
+	driver.Finder // nolint:staticcheck
+}
+
+var _ driver.OptsFinder = legacyFinder{}
+
+func (f legacyFinder) Find(ctx context.Context, query interface{}, _ map[string]interface{}) (driver.Rows, error) {
+	return f.Finder.Find(ctx, query)
+}
+
+func (f legacyFinder) CreateIndex(ctx context.Context, ddoc, name string, index interface{}, _ map[string]interface{}) error {
+	return f.Finder.CreateIndex(ctx, ddoc, name, index)
+}
+
+func (f legacyFinder) GetIndexes(ctx context.Context, _ map[string]interface{}) ([]driver.Index, error) {
+	return f.Finder.GetIndexes(ctx)
+}
+
+func (f legacyFinder) DeleteIndex(ctx context.Context, ddoc, name string, _ map[string]interface{}) error {
+	return f.Finder.DeleteIndex(ctx, ddoc, name)
+}
+
+func (f legacyFinder) Explain(ctx context.Context, query interface{}, _ map[string]interface{}) (*driver.QueryPlan, error) {
+	return f.Finder.Explain(ctx, query)
+}
+
+// finder returns db as an OptsFinder, adapting the legacy Finder interface if
+// necessary.
+func finder(db driver.DB) (driver.OptsFinder, bool) {
+	switch t := db.(type) {
+	case driver.OptsFinder:
+		return t, true
+	case driver.Finder: // nolint:staticcheck
+		return legacyFinder{t}, true
+	}
+	return nil, false
+}
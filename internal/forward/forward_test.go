@@ -0,0 +1,211 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package forward
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// passthrough routes every call to the base client.
+type passthrough struct {
+	base driver.Client
+}
+
+var _ ClientRouter = passthrough{}
+
+func (r passthrough) Route(ctx context.Context, _ *Call, fn func(context.Context, driver.Client) error) error {
+	return fn(ctx, r.base)
+}
+
+func (r passthrough) DB(name string, options map[string]interface{}) (driver.DB, DBRouter, error) {
+	db, err := r.base.DB(name, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, dbPassthrough{db}, nil
+}
+
+func (passthrough) Close(context.Context) error {
+	return nil
+}
+
+// dbPassthrough routes every call to the base DB.
+type dbPassthrough struct {
+	base driver.DB
+}
+
+func (r dbPassthrough) Route(ctx context.Context, _ *Call, fn func(context.Context, driver.DB) error) error {
+	return fn(ctx, r.base)
+}
+
+func (dbPassthrough) Close(context.Context) error {
+	return nil
+}
+
+func TestForwardedInterfaces(t *testing.T) {
+	for _, tt := range forwardedInterfaces() {
+		t.Run(tt.name, func(t *testing.T) {
+			var called string
+			base := tt.base(&called)
+			client := NewClient(base, passthrough{base})
+			if err := tt.call(client); err != nil {
+				t.Fatal(err)
+			}
+			if called != tt.name {
+				t.Errorf("Expected %s to be forwarded, got %q", tt.name, called)
+			}
+		})
+	}
+}
+
+func TestNotImplemented(t *testing.T) {
+	base := &mock.Client{}
+	client := NewClient(base, passthrough{base})
+	if _, ok := client.(driver.Pinger); ok {
+		t.Error("Client should not implement Pinger when the base does not")
+	}
+	_, err := client.(driver.ActiveTasker).ActiveTasks(context.Background())
+	testy.StatusError(t, "kivik: driver does not support ActiveTasks", http.StatusNotImplemented, err)
+}
+
+// forwardTest tests that a call to an optional interface is forwarded. base
+// returns a client implementing the interface, which sets called to the name
+// of the method it receives.
+type forwardTest struct {
+	name string
+	base func(called *string) driver.Client
+	call func(driver.Client) error
+}
+
+// forwardedInterfaces returns a test for each optional interface which must be
+// forwarded.
+func forwardedInterfaces() []forwardTest {
+	db := func(called *string) driver.Client {
+		return &mock.Client{
+			DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+				return &mock.DesignDocInfoer{
+					DB: &mock.DB{},
+					DesignDocInfoFunc: func(context.Context, string) (*driver.DesignDocInfo, error) {
+						*called = "DesignDocInfo"
+						return &driver.DesignDocInfo{}, nil
+					},
+				}, nil
+			},
+		}
+	}
+	return []forwardTest{
+		{
+			name: "SchedulerJobs",
+			base: func(called *string) driver.Client {
+				return &mock.ClientScheduler{
+					Client: &mock.Client{},
+					SchedulerJobsFunc: func(context.Context, map[string]interface{}) ([]*driver.SchedulerJob, error) {
+						*called = "SchedulerJobs"
+						return nil, nil
+					},
+				}
+			},
+			call: func(c driver.Client) error {
+				_, err := c.(driver.ClientScheduler).SchedulerJobs(context.Background(), nil)
+				return err
+			},
+		},
+		{
+			name: "ActiveTasks",
+			base: func(called *string) driver.Client {
+				return &mock.ActiveTasker{
+					Client: &mock.Client{},
+					ActiveTasksFunc: func(context.Context) ([]*driver.ActiveTask, error) {
+						*called = "ActiveTasks"
+						return nil, nil
+					},
+				}
+			},
+			call: func(c driver.Client) error {
+				_, err := c.(driver.ActiveTasker).ActiveTasks(context.Background())
+				return err
+			},
+		},
+		{
+			name: "NodeStats",
+			base: func(called *string) driver.Client {
+				return &mock.NodeStatser{
+					Client: &mock.Client{},
+					NodeStatsFunc: func(context.Context, string) (jsoniter.RawMessage, error) {
+						*called = "NodeStats"
+						return jsoniter.RawMessage(`{}`), nil
+					},
+				}
+			},
+			call: func(c driver.Client) error {
+				_, err := c.(driver.NodeStatser).NodeStats(context.Background(), "_local")
+				return err
+			},
+		},
+		{
+			name: "Logout",
+			base: func(called *string) driver.Client {
+				return &mock.Deauthenticator{
+					Client: &mock.Client{},
+					LogoutFunc: func(context.Context) error {
+						*called = "Logout"
+						return nil
+					},
+				}
+			},
+			call: func(c driver.Client) error {
+				return c.(driver.Deauthenticator).Logout(context.Background())
+			},
+		},
+		{
+			name: "SessionExpiry",
+			base: func(called *string) driver.Client {
+				return &mock.SessionExpirer{
+					Client: &mock.Client{},
+					SessionExpiryFunc: func() time.Time {
+						*called = "SessionExpiry"
+						return time.Now()
+					},
+				}
+			},
+			call: func(c driver.Client) error {
+				if c.(driver.SessionExpirer).SessionExpiry().IsZero() {
+					return errors.New("zero session expiry")
+				}
+				return nil
+			},
+		},
+		{
+			name: "DesignDocInfo",
+			base: db,
+			call: func(c driver.Client) error {
+				db, err := c.DB("foo", nil)
+				if err != nil {
+					return err
+				}
+				_, err = db.(driver.DesignDocInfoer).DesignDocInfo(context.Background(), "_design/foo")
+				return err
+			},
+		},
+	}
+}
@@ -14,6 +14,9 @@ package mock
 
 import (
 	"context"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
 
 	"github.com/dannyzhou2015/kivik/v4/driver"
 )
@@ -94,6 +97,45 @@ func (c *Authenticator) Authenticate(ctx context.Context, a interface{}) error {
 	return c.AuthenticateFunc(ctx, a)
 }
 
+// Deauthenticator mocks driver.Client and driver.Deauthenticator
+type Deauthenticator struct {
+	*Client
+	LogoutFunc func(context.Context) error
+}
+
+var _ driver.Deauthenticator = &Deauthenticator{}
+
+// Logout calls c.LogoutFunc
+func (c *Deauthenticator) Logout(ctx context.Context) error {
+	return c.LogoutFunc(ctx)
+}
+
+// SessionExpirer mocks driver.Client and driver.SessionExpirer
+type SessionExpirer struct {
+	*Client
+	SessionExpiryFunc func() time.Time
+}
+
+var _ driver.SessionExpirer = &SessionExpirer{}
+
+// SessionExpiry calls c.SessionExpiryFunc
+func (c *SessionExpirer) SessionExpiry() time.Time {
+	return c.SessionExpiryFunc()
+}
+
+// Impersonator mocks driver.Client and driver.Impersonator
+type Impersonator struct {
+	*Client
+	ImpersonatesFunc func() bool
+}
+
+var _ driver.Impersonator = &Impersonator{}
+
+// Impersonates calls c.ImpersonatesFunc
+func (c *Impersonator) Impersonates() bool {
+	return c.ImpersonatesFunc()
+}
+
 // DBUpdater mocks driver.Client and driver.DBUpdater
 type DBUpdater struct {
 	*Client
@@ -158,6 +200,63 @@ func (c *Cluster) Membership(ctx context.Context) (*driver.ClusterMembership, er
 	return c.MembershipFunc(ctx)
 }
 
+// ClientScheduler mocks driver.Client and driver.ClientScheduler
+type ClientScheduler struct {
+	*Client
+	SchedulerJobsFunc func(context.Context, map[string]interface{}) ([]*driver.SchedulerJob, error)
+	SchedulerDocsFunc func(context.Context, string, map[string]interface{}) ([]*driver.SchedulerDoc, error)
+	SchedulerDocFunc  func(context.Context, string, string) (*driver.SchedulerDoc, error)
+}
+
+var _ driver.ClientScheduler = &ClientScheduler{}
+
+// SchedulerJobs calls c.SchedulerJobsFunc
+func (c *ClientScheduler) SchedulerJobs(ctx context.Context, options map[string]interface{}) ([]*driver.SchedulerJob, error) {
+	return c.SchedulerJobsFunc(ctx, options)
+}
+
+// SchedulerDocs calls c.SchedulerDocsFunc
+func (c *ClientScheduler) SchedulerDocs(ctx context.Context, replicatorDB string, options map[string]interface{}) ([]*driver.SchedulerDoc, error) {
+	return c.SchedulerDocsFunc(ctx, replicatorDB, options)
+}
+
+// SchedulerDoc calls c.SchedulerDocFunc
+func (c *ClientScheduler) SchedulerDoc(ctx context.Context, replicatorDB, docID string) (*driver.SchedulerDoc, error) {
+	return c.SchedulerDocFunc(ctx, replicatorDB, docID)
+}
+
+// ActiveTasker mocks driver.Client and driver.ActiveTasker
+type ActiveTasker struct {
+	*Client
+	ActiveTasksFunc func(context.Context) ([]*driver.ActiveTask, error)
+}
+
+var _ driver.ActiveTasker = &ActiveTasker{}
+
+// ActiveTasks calls c.ActiveTasksFunc
+func (c *ActiveTasker) ActiveTasks(ctx context.Context) ([]*driver.ActiveTask, error) {
+	return c.ActiveTasksFunc(ctx)
+}
+
+// NodeStatser mocks driver.Client and driver.NodeStatser
+type NodeStatser struct {
+	*Client
+	NodeStatsFunc  func(context.Context, string) (jsoniter.RawMessage, error)
+	NodeSystemFunc func(context.Context, string) (jsoniter.RawMessage, error)
+}
+
+var _ driver.NodeStatser = &NodeStatser{}
+
+// NodeStats calls c.NodeStatsFunc
+func (c *NodeStatser) NodeStats(ctx context.Context, node string) (jsoniter.RawMessage, error) {
+	return c.NodeStatsFunc(ctx, node)
+}
+
+// NodeSystem calls c.NodeSystemFunc
+func (c *NodeStatser) NodeSystem(ctx context.Context, node string) (jsoniter.RawMessage, error) {
+	return c.NodeSystemFunc(ctx, node)
+}
+
 // ClientCloser mocks driver.Client and driver.ClientCloser
 type ClientCloser struct {
 	*Client
@@ -262,6 +262,19 @@ func (db *DesignDocer) DesignDocs(ctx context.Context, options map[string]interf
 	return db.DesignDocsFunc(ctx, options)
 }
 
+// DesignDocInfoer mocks a driver.DB and driver.DesignDocInfoer
+type DesignDocInfoer struct {
+	*DB
+	DesignDocInfoFunc func(context.Context, string) (*driver.DesignDocInfo, error)
+}
+
+var _ driver.DesignDocInfoer = &DesignDocInfoer{}
+
+// DesignDocInfo calls db.DesignDocInfoFunc
+func (db *DesignDocInfoer) DesignDocInfo(ctx context.Context, ddocID string) (*driver.DesignDocInfo, error) {
+	return db.DesignDocInfoFunc(ctx, ddocID)
+}
+
 // LocalDocer mocks a driver.DB and driver.DesignDocer
 type LocalDocer struct {
 	*DB
@@ -97,6 +97,19 @@ func (r *Bookmarker) Bookmark() string {
 	return r.BookmarkFunc()
 }
 
+// RowsETagger wraps driver.RowsETagger
+type RowsETagger struct {
+	*Rows
+	ETagFunc func() string
+}
+
+var _ driver.RowsETagger = &RowsETagger{}
+
+// ETag calls r.ETagFunc
+func (r *RowsETagger) ETag() string {
+	return r.ETagFunc()
+}
+
 // QueryIndexer provides driver.QueryIndexer.
 type QueryIndexer struct {
 	*Rows
@@ -38,6 +38,8 @@ type iter struct {
 	cancel func() // cancel function to exit context goroutine when iterator is closed
 
 	curVal interface{}
+
+	span *span // ended when the iterator is closed
 }
 
 func (i *iter) rlock() (unlock func(), err error) {
@@ -119,6 +121,9 @@ func (i *iter) next() (doClose, ok bool) {
 	if i.lasterr != nil {
 		return true, false
 	}
+	if !i.eoq {
+		i.span.addRow(i.curVal)
+	}
 	return false, true
 }
 
@@ -149,9 +154,26 @@ func (i *iter) close(err error) error {
 		i.cancel()
 	}
 
+	if i.lasterr != nil {
+		i.span.end(i.lasterr)
+	} else {
+		i.span.end(err)
+	}
+
 	return err
 }
 
+// trace attaches s to the iterator, to be ended when the iterator is closed.
+func (i *iter) trace(s *span) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		s.end(i.lasterr)
+		return
+	}
+	i.span = s
+}
+
 // Err returns the error, if any, that was encountered during iteration. Err
 // may be called after an explicit or implicit Close.
 func (i *iter) Err() error {
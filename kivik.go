@@ -17,6 +17,7 @@ import (
 
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/dannyzhou2015/kivik/v4/driver"
 	"github.com/dannyzhou2015/kivik/v4/internal/registry"
@@ -28,6 +29,13 @@ type Client struct {
 	dsn          string
 	driverName   string
 	driverClient driver.Client
+	retry        *RetryPolicy
+	tracer       Tracer
+
+	authMu  sync.Mutex
+	session *session
+	// sessionEnds counts the calls to endSession.
+	sessionEnds uint64
 }
 
 // Options is a collection of options. The keys and values are backend specific.
@@ -40,6 +48,10 @@ func mergeOptions(otherOpts ...Options) Options {
 	options := make(Options)
 	for _, opts := range otherOpts {
 		for k, v := range opts {
+			switch k {
+			case OptionRetryPolicy, OptionTracer:
+				continue
+			}
 			options[k] = v
 		}
 	}
@@ -59,13 +71,16 @@ func Register(name string, driver driver.Driver) {
 // and a driver-specific data source name.
 //
 // The use of options is driver-specific, so consult with the documentation for
-// your driver for supported options.
+// your driver for supported options. The OptionRetryPolicy and OptionTracer
+// options are handled by Kivik, and set the default retry policy and the
+// tracer for the client.
 func New(driverName, dataSourceName string, options ...Options) (*Client, error) {
 	driveri := registry.Driver(driverName)
 	if driveri == nil {
 		return nil, &Error{HTTPStatus: http.StatusBadRequest, Message: fmt.Sprintf("kivik: unknown driver %q (forgotten import?)", driverName)}
 	}
-	client, err := driveri.NewClient(dataSourceName, mergeOptions(options...))
+	retry, opts := extractRetryPolicy(nil, options), mergeOptions(options...)
+	client, err := driveri.NewClient(dataSourceName, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +88,8 @@ func New(driverName, dataSourceName string, options ...Options) (*Client, error)
 		dsn:          dataSourceName,
 		driverName:   driverName,
 		driverClient: client,
+		retry:        retry,
+		tracer:       extractTracer(options),
 	}, nil
 }
 
@@ -104,10 +121,20 @@ type Version struct {
 }
 
 // Version returns version and vendor info about the backend.
-func (c *Client) Version(ctx context.Context) (*Version, error) {
-	ver, err := c.driverClient.Version(ctx)
+func (c *Client) Version(ctx context.Context) (version *Version, err error) {
+	op := c.op("Version", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	var ver *driver.Version
+	err = c.retry.do(ctx, func() (err error) {
+		ver, err = c.driverClient.Version(ctx)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
 	v := &Version{}
 	*v = Version(*ver)
@@ -117,44 +144,97 @@ func (c *Client) Version(ctx context.Context) (*Version, error) {
 // DB returns a handle to the requested database. Any options parameters
 // passed are merged, with later values taking precidence. If any errors occur
 // at this stage, they are deferred, or may be checked directly with Err()
+//
+// The OptionRetryPolicy option sets the default retry policy for calls made
+// with the returned handle, overriding that of the client.
 func (c *Client) DB(dbName string, options ...Options) *DB {
-	db, err := c.driverClient.DB(dbName, mergeOptions(options...))
+	retry, opts := c.retryPolicy(options), mergeOptions(options...)
+	db, err := c.driverClient.DB(dbName, opts)
 	return &DB{
 		client:   c,
 		name:     dbName,
 		driverDB: db,
-		err:      err,
+		err:      c.op("DB", dbName).wrap(err),
+		retry:    retry,
 	}
 }
 
 // AllDBs returns a list of all databases.
-func (c *Client) AllDBs(ctx context.Context, options ...Options) ([]string, error) {
-	return c.driverClient.AllDBs(ctx, mergeOptions(options...))
+func (c *Client) AllDBs(ctx context.Context, options ...Options) (dbs []string, err error) {
+	op := c.op("AllDBs", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	retry, opts := c.retryPolicy(options), mergeOptions(options...)
+	err = retry.do(ctx, func() (err error) {
+		dbs, err = c.driverClient.AllDBs(ctx, opts)
+		return err
+	})
+	return dbs, op.wrap(err)
 }
 
 // DBExists returns true if the specified database exists.
-func (c *Client) DBExists(ctx context.Context, dbName string, options ...Options) (bool, error) {
-	return c.driverClient.DBExists(ctx, dbName, mergeOptions(options...))
+func (c *Client) DBExists(ctx context.Context, dbName string, options ...Options) (exists bool, err error) {
+	op := c.op("DBExists", dbName)
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return false, op.wrap(err)
+	}
+	retry, opts := c.retryPolicy(options), mergeOptions(options...)
+	err = retry.do(ctx, func() (err error) {
+		exists, err = c.driverClient.DBExists(ctx, dbName, opts)
+		return err
+	})
+	return exists, op.wrap(err)
 }
 
 // CreateDB creates a DB of the requested name.
-func (c *Client) CreateDB(ctx context.Context, dbName string, options ...Options) error {
-	return c.driverClient.CreateDB(ctx, dbName, mergeOptions(options...))
+func (c *Client) CreateDB(ctx context.Context, dbName string, options ...Options) (err error) {
+	op := c.op("CreateDB", dbName)
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
+	return op.wrap(c.driverClient.CreateDB(ctx, dbName, mergeOptions(options...)))
 }
 
 // DestroyDB deletes the requested DB.
-func (c *Client) DestroyDB(ctx context.Context, dbName string, options ...Options) error {
-	return c.driverClient.DestroyDB(ctx, dbName, mergeOptions(options...))
+func (c *Client) DestroyDB(ctx context.Context, dbName string, options ...Options) (err error) {
+	op := c.op("DestroyDB", dbName)
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return op.wrap(err)
+	}
+	return op.wrap(c.driverClient.DestroyDB(ctx, dbName, mergeOptions(options...)))
+}
+
+// Authenticate authenticates the client with the passed authenticator. This
+// may be one of the Authenticator types of this package, such as BasicAuth or
+// CookieAuth, or a driver-specific authenticator. If the driver does not
+// understand the authenticator, an error will be returned.
+//
+// Following a successful authentication with a CookieAuth, the client
+// re-authenticates automatically before the session expires. See
+// SessionExpiry.
+func (c *Client) Authenticate(ctx context.Context, a interface{}) (err error) {
+	op := c.op("Authenticate", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	return op.wrap(c.authenticate(ctx, a, nil))
 }
 
-// Authenticate authenticates the client with the passed authenticator, which
-// is driver-specific. If the driver does not understand the authenticator, an
-// error will be returned.
-func (c *Client) Authenticate(ctx context.Context, a interface{}) error {
-	if auth, ok := c.driverClient.(driver.Authenticator); ok {
-		return auth.Authenticate(ctx, a)
+// op returns an Operation describing a call to the named Client method.
+func (c *Client) op(method, dbName string) *Operation {
+	return &Operation{
+		Method: method,
+		Driver: c.driverName,
+		DB:     dbName,
 	}
-	return &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support authentication"}
 }
 
 func missingArg(arg string) error {
@@ -162,13 +242,19 @@ func missingArg(arg string) error {
 }
 
 // DBsStats returns database statistics about one or more databases.
-func (c *Client) DBsStats(ctx context.Context, dbnames []string) ([]*DBStats, error) {
-	dbstats, err := c.nativeDBsStats(ctx, dbnames)
+func (c *Client) DBsStats(ctx context.Context, dbnames []string) (dbstats []*DBStats, err error) {
+	op := c.op("DBsStats", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	dbstats, err = c.nativeDBsStats(ctx, dbnames)
 	switch StatusCode(err) {
 	case http.StatusNotFound, http.StatusNotImplemented:
-		return c.fallbackDBsStats(ctx, dbnames)
+		dbstats, err = c.fallbackDBsStats(ctx, dbnames)
 	}
-	return dbstats, err
+	return dbstats, op.wrap(err)
 }
 
 func (c *Client) fallbackDBsStats(ctx context.Context, dbnames []string) ([]*DBStats, error) {
@@ -194,7 +280,9 @@ func (c *Client) nativeDBsStats(ctx context.Context, dbnames []string) ([]*DBSta
 	}
 	dbstats := make([]*DBStats, len(stats))
 	for i, stat := range stats {
-		dbstats[i] = driverStats2kivikStats(stat)
+		if stat != nil {
+			dbstats[i] = driverStats2kivikStats(stat)
+		}
 	}
 	return dbstats, nil
 }
@@ -203,18 +291,29 @@ func (c *Client) nativeDBsStats(ctx context.Context, dbnames []string) ([]*DBSta
 // for instance by querying the /_up endpoint. If the underlying driver
 // supports the Pinger interface, it will be used. Otherwise, a fallback is
 // made to calling Version.
-func (c *Client) Ping(ctx context.Context) (bool, error) {
+func (c *Client) Ping(ctx context.Context) (up bool, err error) {
+	op := c.op("Ping", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return false, op.wrap(err)
+	}
 	if pinger, ok := c.driverClient.(driver.Pinger); ok {
-		return pinger.Ping(ctx)
+		up, err = pinger.Ping(ctx)
+		return up, op.wrap(err)
 	}
-	_, err := c.driverClient.Version(ctx)
-	return err == nil, err
+	_, err = c.driverClient.Version(ctx)
+	return err == nil, op.wrap(err)
 }
 
 // Close cleans up any resources used by Client.
-func (c *Client) Close(ctx context.Context) error {
+func (c *Client) Close(ctx context.Context) (err error) {
+	op := c.op("Close", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	c.endSession()
 	if closer, ok := c.driverClient.(driver.ClientCloser); ok {
-		return closer.Close(ctx)
+		return op.wrap(closer.Close(ctx))
 	}
 	return nil
 }
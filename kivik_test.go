@@ -562,6 +562,24 @@ func TestDBsStats(t *testing.T) {
 				{Name: "bar", DiskSize: 321},
 			},
 		},
+		{
+			name: "native missing database",
+			client: &Client{
+				driverClient: &mock.DBsStatser{
+					DBsStatsFunc: func(_ context.Context, names []string) ([]*driver.DBStats, error) {
+						return []*driver.DBStats{
+							{Name: "foo", DiskSize: 123},
+							nil,
+						}, nil
+					},
+				},
+			},
+			dbnames: []string{"foo", "bar"},
+			expected: []*DBStats{
+				{Name: "foo", DiskSize: 123},
+				nil,
+			},
+		},
 		{
 			name: "native error",
 			client: &Client{
@@ -742,3 +760,39 @@ func TestDBClose(t *testing.T) {
 		testy.Error(t, test.err, err)
 	})
 }
+
+func TestOperationContext(t *testing.T) {
+	client := &Client{
+		driverName: "mock",
+		driverClient: &mock.Client{
+			CreateDBFunc: func(_ context.Context, _ string, _ map[string]interface{}) error {
+				return &Error{HTTPStatus: http.StatusPreconditionFailed, Code: "file_exists"}
+			},
+			DBFunc: func(_ string, _ map[string]interface{}) (driver.DB, error) {
+				return &mock.DB{
+					DeleteFunc: func(_ context.Context, _, _ string, _ map[string]interface{}) (string, error) {
+						return "", errors.New("delete failed")
+					},
+				}, nil
+			},
+		},
+	}
+	t.Run("client", func(t *testing.T) {
+		err := client.CreateDB(context.Background(), "foo")
+		expected := &Operation{Method: "CreateDB", Driver: "mock", DB: "foo"}
+		if d := testy.DiffInterface(expected, ErrorOperation(err)); d != nil {
+			t.Error(d)
+		}
+		if code := ErrorCode(err); code != "file_exists" {
+			t.Errorf("Unexpected code: %s", code)
+		}
+	})
+	t.Run("db", func(t *testing.T) {
+		_, err := client.DB("foo").Delete(context.Background(), "bar", "1-xxx")
+		testy.StatusError(t, "delete failed", http.StatusInternalServerError, err)
+		expected := &Operation{Method: "Delete", Driver: "mock", DB: "foo", DocID: "bar", Rev: "1-xxx"}
+		if d := testy.DiffInterface(expected, ErrorOperation(err)); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -0,0 +1,469 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package livequery provides live Mango queries, which report the initial
+// results of a query, followed by the changes to those results as documents
+// change.
+//
+//	lq, err := livequery.Subscribe(ctx, db, livequery.Query{
+//		Selector: map[string]interface{}{"status": "open"},
+//		Sort:     []interface{}{map[string]interface{}{"priority": "desc"}},
+//		Limit:    10,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer lq.Close()
+//	render(lq.Results())
+//	for lq.Next() {
+//		event := lq.Event()
+//		// apply event to the dashboard
+//	}
+//	return lq.Err()
+//
+// The initial results are read with DB.Find. Changes are then read from a
+// continuous changes feed, with include_docs, starting from the update sequence
+// current before the initial results were read. Each changed document is
+// evaluated against the selector locally, so the feed is not filtered on the
+// server, and the query needs no index.
+//
+// All matching documents are held in memory, sorted locally, so that the window
+// selected by Skip and Limit can be kept current: when a document leaves the
+// window, the next matching document enters it.
+package livequery // import "github.com/dannyzhou2015/kivik/v4/livequery"
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/collate"
+	"github.com/dannyzhou2015/kivik/v4/mango"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// pageSize is the number of documents requested per Find call while reading
+// the initial results.
+const pageSize = 1000
+
+// Query is a live Mango query.
+type Query struct {
+	// Selector is a Mango selector, as for DB.Find.
+	Selector map[string]interface{}
+	// Sort is a Mango sort specification: a list of field names, or of
+	// objects mapping a field name to "asc" or "desc". Unlike CouchDB,
+	// fields may be sorted in different directions. Documents which sort
+	// equally are ordered by ID.
+	Sort []interface{}
+	// Skip is the number of matching documents to skip.
+	Skip int
+	// Limit is the maximum number of results. If zero, all matching documents
+	// are results.
+	Limit int
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+// Event types.
+const (
+	// Added reports a document which entered the results.
+	Added EventType = iota
+	// Updated reports a new revision of a document which remains in the
+	// results, possibly at a new position.
+	Updated
+	// Removed reports a document which left the results, either because it
+	// no longer matches, was deleted, or was displaced from the window.
+	Removed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Updated:
+		return "updated"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Result is a document in the results.
+type Result struct {
+	ID  string
+	Rev string
+	Doc jsoniter.RawMessage
+
+	doc map[string]interface{}
+}
+
+// ScanDoc unmarshals the document into dest.
+func (r *Result) ScanDoc(dest interface{}) error {
+	return json.Unmarshal(r.Doc, dest)
+}
+
+// Event reports a change to the results.
+type Event struct {
+	Type EventType
+	Result
+	// Index is the document's position in the results after the change, or
+	// -1 if it was removed.
+	Index int
+	// OldIndex is the document's position in the results before the change,
+	// or -1 if it was added.
+	OldIndex int
+}
+
+// LiveQuery is an iterator over the changes to the results of a query. It is
+// not safe for concurrent use.
+type LiveQuery struct {
+	changes *kivik.Changes
+	sel     *mango.Selector
+	less    func(a, b *Result) bool
+	q       Query
+
+	// matching holds all matching documents, in sorted order.
+	matching []*Result
+	pending  []*Event
+	cur      *Event
+	seq      string
+	err      error
+}
+
+// Subscribe runs q against db, and returns a LiveQuery which holds its initial
+// results, and reports subsequent changes. options are passed to DB.Changes,
+// and may be used to set a heartbeat. The LiveQuery remains open until it is
+// closed, ctx is cancelled, or the changes feed fails.
+func Subscribe(ctx context.Context, db *kivik.DB, q Query, options ...kivik.Options) (*LiveQuery, error) {
+	if q.Skip < 0 || q.Limit < 0 {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "livequery: skip and limit must not be negative"}
+	}
+	selector := q.Selector
+	if selector == nil {
+		selector = map[string]interface{}{}
+	}
+	sel, err := mango.Compile(selector)
+	if err != nil {
+		return nil, err
+	}
+	less, err := compileSort(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	lq := &LiveQuery{sel: sel, less: less, q: q}
+
+	seq, err := currentSeq(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := lq.snapshot(ctx, db, selector); err != nil {
+		return nil, err
+	}
+	opts := kivik.Options{}
+	for _, o := range options {
+		for k, v := range o {
+			opts[k] = v
+		}
+	}
+	opts["feed"] = "continuous"
+	opts["include_docs"] = true
+	if seq != "" {
+		opts["since"] = seq
+	}
+	lq.changes, err = db.Changes(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	lq.seq = seq
+	return lq, nil
+}
+
+// currentSeq returns the database's current update sequence.
+func currentSeq(ctx context.Context, db *kivik.DB) (string, error) {
+	changes, err := db.Changes(ctx, kivik.Options{"since": "now", "limit": 1})
+	if err != nil {
+		return "", err
+	}
+	for changes.Next() {
+	}
+	if err := changes.Err(); err != nil {
+		return "", err
+	}
+	return changes.LastSeq(), changes.Close()
+}
+
+// snapshot reads all documents matching selector, a page at a time.
+func (lq *LiveQuery) snapshot(ctx context.Context, db *kivik.DB, selector map[string]interface{}) error {
+	var bookmark string
+	var skip int
+	for {
+		query := map[string]interface{}{
+			"selector": selector,
+			"limit":    pageSize,
+		}
+		if bookmark != "" {
+			query["bookmark"] = bookmark
+		} else if skip > 0 {
+			query["skip"] = skip
+		}
+		rs := db.Find(ctx, query)
+		var n int
+		for rs.Next() {
+			var doc jsoniter.RawMessage
+			if err := rs.ScanDoc(&doc); err != nil {
+				_ = rs.Close()
+				return err
+			}
+			r, err := newResult(doc)
+			if err != nil {
+				_ = rs.Close()
+				return err
+			}
+			// The selector is evaluated locally too, so that the results
+			// are consistent with later evaluations.
+			if lq.sel.Match(r.doc) {
+				lq.matching = append(lq.matching, r)
+			}
+			n++
+		}
+		if err := rs.Err(); err != nil {
+			return err
+		}
+		meta, err := rs.Finish()
+		if err != nil {
+			return err
+		}
+		if n < pageSize {
+			break
+		}
+		bookmark = meta.Bookmark
+		skip += n
+	}
+	sort.SliceStable(lq.matching, func(i, j int) bool {
+		return lq.less(lq.matching[i], lq.matching[j])
+	})
+	return nil
+}
+
+func newResult(doc jsoniter.RawMessage) (*Result, error) {
+	r := &Result{Doc: doc}
+	if err := json.Unmarshal(doc, &r.doc); err != nil {
+		return nil, err
+	}
+	r.ID, _ = r.doc["_id"].(string)
+	r.Rev, _ = r.doc["_rev"].(string)
+	return r, nil
+}
+
+// window returns the documents within the Skip and Limit window.
+func (lq *LiveQuery) window() []*Result {
+	if lq.q.Skip >= len(lq.matching) {
+		return nil
+	}
+	w := lq.matching[lq.q.Skip:]
+	if lq.q.Limit > 0 && lq.q.Limit < len(w) {
+		w = w[:lq.q.Limit]
+	}
+	return w
+}
+
+// Results returns the current results, in order. Before the first call to
+// Next, these are the initial results. After each call to Next, they reflect
+// all of the events returned so far, and any other events resulting from the
+// same change, which are still to be returned.
+func (lq *LiveQuery) Results() []*Result {
+	w := lq.window()
+	results := make([]*Result, len(w))
+	copy(results, w)
+	return results
+}
+
+// Seq returns the update sequence of the last change applied to the results.
+func (lq *LiveQuery) Seq() string {
+	return lq.seq
+}
+
+// Next waits for the next event. It returns false when the LiveQuery is
+// closed, or its changes feed fails, after which Err reports the reason.
+func (lq *LiveQuery) Next() bool {
+	for len(lq.pending) == 0 {
+		if lq.changes == nil || !lq.changes.Next() {
+			if lq.changes != nil {
+				lq.err = lq.changes.Err()
+			}
+			return false
+		}
+		lq.apply()
+	}
+	lq.cur, lq.pending = lq.pending[0], lq.pending[1:]
+	return true
+}
+
+// Event returns the current event.
+func (lq *LiveQuery) Event() *Event {
+	return lq.cur
+}
+
+// Err returns the error which ended the LiveQuery, if any.
+func (lq *LiveQuery) Err() error {
+	return lq.err
+}
+
+// Close closes the changes feed.
+func (lq *LiveQuery) Close() error {
+	if lq.changes == nil {
+		return nil
+	}
+	return lq.changes.Close()
+}
+
+// apply applies the current change, and queues the resulting events.
+func (lq *LiveQuery) apply() {
+	c := lq.changes
+	if seq := c.Seq(); seq != "" {
+		lq.seq = seq
+	}
+	id := c.ID()
+	if strings.HasPrefix(id, "_design/") {
+		return
+	}
+	var r *Result
+	if !c.Deleted() {
+		var doc jsoniter.RawMessage
+		if err := c.ScanDoc(&doc); err == nil {
+			if res, err := newResult(doc); err == nil && lq.sel.Match(res.doc) {
+				r = res
+			}
+		}
+	}
+
+	before := lq.window()
+	oldIndex := make(map[string]int, len(before))
+	for i, d := range before {
+		oldIndex[d.ID] = i
+	}
+	var prevRev string
+	for i, d := range lq.matching {
+		if d.ID == id {
+			prevRev = d.Rev
+			lq.matching = append(lq.matching[:i:i], lq.matching[i+1:]...)
+			break
+		}
+	}
+	if r != nil {
+		i := sort.Search(len(lq.matching), func(i int) bool {
+			return lq.less(r, lq.matching[i])
+		})
+		lq.matching = append(lq.matching[:i:i], append([]*Result{r}, lq.matching[i:]...)...)
+	}
+	after := lq.window()
+	newIndex := make(map[string]int, len(after))
+	for i, d := range after {
+		newIndex[d.ID] = i
+	}
+
+	// Removals are reported first, in their former order, then additions
+	// and updates, in their new order.
+	for i, d := range before {
+		if _, ok := newIndex[d.ID]; !ok {
+			lq.pending = append(lq.pending, &Event{Type: Removed, Result: *d, Index: -1, OldIndex: i})
+		}
+	}
+	for i, d := range after {
+		old, ok := oldIndex[d.ID]
+		switch {
+		case !ok:
+			lq.pending = append(lq.pending, &Event{Type: Added, Result: *d, Index: i, OldIndex: -1})
+		case d.ID == id && d.Rev != prevRev:
+			lq.pending = append(lq.pending, &Event{Type: Updated, Result: *d, Index: i, OldIndex: old})
+		}
+	}
+}
+
+// compileSort returns a function which orders results according to a Mango
+// sort specification, then by ID.
+func compileSort(spec []interface{}) (func(a, b *Result) bool, error) {
+	type sortField struct {
+		field string
+		desc  bool
+	}
+	fields := make([]sortField, 0, len(spec))
+	for _, s := range spec {
+		switch t := s.(type) {
+		case string:
+			fields = append(fields, sortField{field: t})
+		case map[string]interface{}:
+			if len(t) != 1 {
+				return nil, invalidSort(s)
+			}
+			for field, dir := range t {
+				switch dir {
+				case "asc":
+					fields = append(fields, sortField{field: field})
+				case "desc":
+					fields = append(fields, sortField{field: field, desc: true})
+				default:
+					return nil, invalidSort(s)
+				}
+			}
+		case map[string]string:
+			if len(t) != 1 {
+				return nil, invalidSort(s)
+			}
+			for field, dir := range t {
+				if dir != "asc" && dir != "desc" {
+					return nil, invalidSort(s)
+				}
+				fields = append(fields, sortField{field: field, desc: dir == "desc"})
+			}
+		default:
+			return nil, invalidSort(s)
+		}
+	}
+	return func(a, b *Result) bool {
+		for _, f := range fields {
+			va, oka := mango.Field(a.doc, f.field)
+			vb, okb := mango.Field(b.doc, f.field)
+			var c int
+			switch {
+			case oka && okb:
+				c = collate.Compare(va, vb)
+			case oka:
+				c = 1
+			case okb:
+				c = -1
+			}
+			if f.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return a.ID < b.ID
+	}, nil
+}
+
+func invalidSort(s interface{}) error {
+	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "livequery: invalid sort field: " + jsonString(s)}
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,239 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package livequery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// fakeDB serves a fixed set of documents to Find, and a changes feed driven
+// by the test.
+type fakeDB struct {
+	docs    []string
+	changes chan *driver.Change
+	opts    []map[string]interface{}
+	queries []interface{}
+}
+
+func (f *fakeDB) driverDB() driver.DB {
+	return &mock.OptsFinder{
+		DB: &mock.DB{
+			ChangesFunc: func(ctx context.Context, opts map[string]interface{}) (driver.Changes, error) {
+				f.opts = append(f.opts, opts)
+				if opts["feed"] != "continuous" {
+					return &mock.Changes{
+						NextFunc:    func(*driver.Change) error { return io.EOF },
+						CloseFunc:   func() error { return nil },
+						LastSeqFunc: func() string { return "5-x" },
+					}, nil
+				}
+				return &mock.Changes{
+					NextFunc: func(ch *driver.Change) error {
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case c, ok := <-f.changes:
+							if !ok {
+								return errors.New("feed failed")
+							}
+							*ch = *c
+							return nil
+						}
+					},
+					CloseFunc:   func() error { return nil },
+					LastSeqFunc: func() string { return "" },
+				}, nil
+			},
+		},
+		FindFunc: func(_ context.Context, query interface{}, _ map[string]interface{}) (driver.Rows, error) {
+			f.queries = append(f.queries, query)
+			docs := f.docs
+			if skip, ok := query.(map[string]interface{})["skip"].(int); ok {
+				docs = docs[skip:]
+			}
+			limit := query.(map[string]interface{})["limit"].(int)
+			if len(docs) > limit {
+				docs = docs[:limit]
+			}
+			return &mock.Rows{
+				NextFunc: func(row *driver.Row) error {
+					if len(docs) == 0 {
+						return io.EOF
+					}
+					row.Doc = []byte(docs[0])
+					docs = docs[1:]
+					return nil
+				},
+				CloseFunc:     func() error { return nil },
+				OffsetFunc:    func() int64 { return 0 },
+				TotalRowsFunc: func() int64 { return 0 },
+				UpdateSeqFunc: func() string { return "" },
+			}, nil
+		},
+	}
+}
+
+var (
+	registerOnce sync.Once
+	fakeDBs      sync.Map
+)
+
+func newTestDB(t *testing.T, docs ...string) (*kivik.DB, *fakeDB) {
+	registerOnce.Do(func() {
+		kivik.Register("livequerytest", &mock.Driver{
+			NewClientFunc: func(string, map[string]interface{}) (driver.Client, error) {
+				return &mock.Client{
+					DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+						f, _ := fakeDBs.Load(name)
+						return f.(*fakeDB).driverDB(), nil
+					},
+				}, nil
+			},
+		})
+	})
+	f := &fakeDB{docs: docs, changes: make(chan *driver.Change, 10)}
+	fakeDBs.Store(t.Name(), f)
+	client, err := kivik.New("livequerytest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.DB(t.Name()), f
+}
+
+func doc(id, rev string, n int, status string) string {
+	return fmt.Sprintf(`{"_id":%q,"_rev":%q,"n":%d,"status":%q}`, id, rev, n, status)
+}
+
+func ids(results []*Result) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// events reads n events, formatted as type:id@index.
+func events(t *testing.T, lq *LiveQuery, n int) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		if !lq.Next() {
+			t.Fatalf("Unexpected end of events: %v", lq.Err())
+		}
+		e := lq.Event()
+		got = append(got, fmt.Sprintf("%s:%s@%d/%d", e.Type, e.ID, e.OldIndex, e.Index))
+	}
+	return got
+}
+
+func TestLiveQuery(t *testing.T) {
+	db, f := newTestDB(t,
+		doc("a", "1-a", 3, "open"),
+		doc("b", "1-b", 1, "open"),
+		doc("c", "1-c", 2, "open"),
+		doc("d", "1-d", 4, "closed"),
+	)
+	ctx := context.Background()
+	lq, err := Subscribe(ctx, db, Query{
+		Selector: map[string]interface{}{"status": "open"},
+		Sort:     []interface{}{map[string]interface{}{"n": "desc"}},
+		Limit:    2,
+	}, kivik.Options{"heartbeat": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lq.Close() // nolint: errcheck
+
+	if d := testy.DiffInterface([]string{"a", "c"}, ids(lq.Results())); d != nil {
+		t.Errorf("Unexpected initial results:\n%s", d)
+	}
+	feedOpts := f.opts[len(f.opts)-1]
+	want := map[string]interface{}{"feed": "continuous", "include_docs": true, "since": "5-x", "heartbeat": 1000}
+	if d := testy.DiffInterface(want, feedOpts); d != nil {
+		t.Errorf("Unexpected feed options:\n%s", d)
+	}
+
+	// A document which enters the window displaces the last result.
+	f.changes <- &driver.Change{ID: "d", Seq: "6", Doc: []byte(doc("d", "2-d", 4, "open"))}
+	if d := testy.DiffInterface([]string{"removed:c@1/-1", "added:d@-1/0"}, events(t, lq, 2)); d != nil {
+		t.Error(d)
+	}
+	// A document outside the window changes nothing visible.
+	f.changes <- &driver.Change{ID: "b", Seq: "7", Doc: []byte(doc("b", "2-b", 0, "open"))}
+	// A result which moves within the window is updated.
+	f.changes <- &driver.Change{ID: "a", Seq: "8", Doc: []byte(doc("a", "2-a", 9, "open"))}
+	if d := testy.DiffInterface([]string{"updated:a@1/0"}, events(t, lq, 1)); d != nil {
+		t.Error(d)
+	}
+	if lq.Seq() != "8" {
+		t.Errorf("Unexpected seq: %s", lq.Seq())
+	}
+	// A deleted result is replaced by the next match.
+	f.changes <- &driver.Change{ID: "d", Seq: "9", Deleted: true, Doc: []byte(`{"_id":"d","_rev":"3-d","_deleted":true}`)}
+	if d := testy.DiffInterface([]string{"removed:d@1/-1", "added:c@-1/1"}, events(t, lq, 2)); d != nil {
+		t.Error(d)
+	}
+	// A result which no longer matches is removed.
+	f.changes <- &driver.Change{ID: "a", Seq: "10", Doc: []byte(doc("a", "3-a", 9, "closed"))}
+	if d := testy.DiffInterface([]string{"removed:a@0/-1", "added:b@-1/1"}, events(t, lq, 2)); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface([]string{"c", "b"}, ids(lq.Results())); d != nil {
+		t.Errorf("Unexpected results:\n%s", d)
+	}
+
+	close(f.changes)
+	if lq.Next() {
+		t.Fatal("Expected end of events")
+	}
+	testy.Error(t, "feed failed", lq.Err())
+}
+
+func TestSnapshotPaging(t *testing.T) {
+	docs := make([]string, pageSize+1)
+	for i := range docs {
+		docs[i] = doc(fmt.Sprintf("%05d", i), "1-x", i, "open")
+	}
+	db, f := newTestDB(t, docs...)
+	lq, err := Subscribe(context.Background(), db, Query{Skip: pageSize - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lq.Close() // nolint: errcheck
+	if d := testy.DiffInterface([]string{"00999", "01000"}, ids(lq.Results())); d != nil {
+		t.Error(d)
+	}
+	if len(f.queries) != 2 {
+		t.Errorf("Expected 2 pages, got %d", len(f.queries))
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	db, _ := newTestDB(t)
+	_, err := Subscribe(context.Background(), db, Query{Sort: []interface{}{map[string]interface{}{"n": "up"}}})
+	testy.StatusError(t, `livequery: invalid sort field: {"n":"up"}`, http.StatusBadRequest, err)
+	_, err = Subscribe(context.Background(), db, Query{Selector: map[string]interface{}{"$foo": 1}})
+	testy.StatusError(t, "mango: invalid operator: $foo", http.StatusBadRequest, err)
+}
@@ -0,0 +1,513 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package mango evaluates Mango selectors, as used by DB.Find, against
+// documents in memory.
+//
+// Values are compared with CouchDB's view collation rules, as implemented by
+// the collate package. As in CouchDB, a condition on a field which does not
+// exist never matches, except for {"$exists": false}. The $regex operator
+// uses Go's regular expression syntax, rather than PCRE.
+package mango // import "github.com/dannyzhou2015/kivik/v4/mango"
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/dannyzhou2015/kivik/v4/collate"
+	"github.com/dannyzhou2015/kivik/v4/errors"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Selector is a compiled Mango selector.
+type Selector struct {
+	m matcher
+}
+
+// matcher matches a document, or any other value to which fields conditions
+// are applied.
+type matcher func(doc interface{}) bool
+
+// condition matches a field value. exists is false when the field is missing.
+type condition func(value interface{}, exists bool) bool
+
+func invalid(format string, args ...interface{}) error {
+	return errors.Status(http.StatusBadRequest, "mango: "+fmt.Sprintf(format, args...))
+}
+
+// Compile compiles selector, which is normally a map[string]interface{}, as
+// would be passed in the selector field of a DB.Find query. Any other value
+// is converted to JSON first, so a json.RawMessage or struct may also be
+// used. An empty selector matches every document.
+func Compile(selector interface{}) (*Selector, error) {
+	sel, err := normalize(selector)
+	if err != nil {
+		return nil, invalid("invalid selector: %s", err)
+	}
+	obj, ok := sel.(map[string]interface{})
+	if !ok {
+		return nil, invalid("selector must be an object")
+	}
+	m, err := compileObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{m: m}, nil
+}
+
+// Match reports whether doc matches the selector. doc is normally a
+// map[string]interface{}, as decoded from JSON. Other values are converted
+// through JSON first.
+func (s *Selector) Match(doc interface{}) bool {
+	if _, ok := doc.(map[string]interface{}); !ok {
+		var err error
+		if doc, err = normalize(doc); err != nil {
+			return false
+		}
+	}
+	return s.m(doc)
+}
+
+// MatchJSON reports whether the JSON-encoded document matches the selector.
+func (s *Selector) MatchJSON(doc []byte) (bool, error) {
+	var d interface{}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return false, err
+	}
+	return s.m(d), nil
+}
+
+func normalize(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return v, nil
+	}
+	var data []byte
+	switch t := v.(type) {
+	case []byte:
+		data = t
+	case jsoniter.RawMessage:
+		data = t
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var result interface{}
+	err := json.Unmarshal(data, &result)
+	return result, err
+}
+
+// compileObject compiles a selector object, whose keys are field names or
+// combination operators.
+func compileObject(obj map[string]interface{}) (matcher, error) {
+	matchers := make([]matcher, 0, len(obj))
+	for key, arg := range obj {
+		var m matcher
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			m, err = compileCombination(key, arg)
+		case "$not":
+			var inner matcher
+			sub, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, invalid("$not requires an object")
+			}
+			if inner, err = compileObject(sub); err == nil {
+				m = func(doc interface{}) bool { return !inner(doc) }
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, invalid("invalid operator: %s", key)
+			}
+			var cond condition
+			if cond, err = compileCondition(arg); err == nil {
+				m = fieldMatcher(splitPath(key), cond)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return and(matchers), nil
+}
+
+func and(matchers []matcher) matcher {
+	if len(matchers) == 1 {
+		return matchers[0]
+	}
+	return func(doc interface{}) bool {
+		for _, m := range matchers {
+			if !m(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func compileCombination(op string, arg interface{}) (matcher, error) {
+	list, ok := arg.([]interface{})
+	if !ok {
+		return nil, invalid("%s requires an array", op)
+	}
+	matchers := make([]matcher, len(list))
+	for i, v := range list {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, invalid("%s requires an array of objects", op)
+		}
+		var err error
+		if matchers[i], err = compileObject(obj); err != nil {
+			return nil, err
+		}
+	}
+	switch op {
+	case "$and":
+		return and(matchers), nil
+	case "$or":
+		return func(doc interface{}) bool {
+			for _, m := range matchers {
+				if m(doc) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return func(doc interface{}) bool {
+		for _, m := range matchers {
+			if m(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// splitPath splits a field name on unescaped dots.
+func splitPath(field string) []string {
+	var path []string
+	var cur strings.Builder
+	for i := 0; i < len(field); i++ {
+		switch {
+		case field[i] == '\\' && i+1 < len(field) && field[i+1] == '.':
+			cur.WriteByte('.')
+			i++
+		case field[i] == '.':
+			path = append(path, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(field[i])
+		}
+	}
+	return append(path, cur.String())
+}
+
+// Field returns the value of the field at path in doc, which is a field name
+// as used in selectors and sort specifications, such as "address.city".
+// Array elements may be addressed by index.
+func Field(doc interface{}, path string) (interface{}, bool) {
+	return getField(doc, splitPath(path))
+}
+
+func getField(v interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[p]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func fieldMatcher(path []string, cond condition) matcher {
+	return func(doc interface{}) bool {
+		v, ok := getField(doc, path)
+		return cond(v, ok)
+	}
+}
+
+// compileCondition compiles the condition applied to a field. An object whose
+// keys are operators applies each operator; other keys of an object are
+// conditions on subfields. Any other value is an implicit $eq.
+func compileCondition(arg interface{}) (condition, error) {
+	obj, ok := arg.(map[string]interface{})
+	if !ok {
+		return eq(arg), nil
+	}
+	conds := make([]condition, 0, len(obj))
+	for key, v := range obj {
+		var cond condition
+		var err error
+		if strings.HasPrefix(key, "$") {
+			cond, err = compileOperator(key, v)
+		} else {
+			var sub condition
+			if sub, err = compileCondition(v); err == nil {
+				path := splitPath(key)
+				cond = func(value interface{}, exists bool) bool {
+					if !exists {
+						return false
+					}
+					v, ok := getField(value, path)
+					return sub(v, ok)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) == 0 {
+		// An empty object is matched by equality.
+		return eq(arg), nil
+	}
+	return func(value interface{}, exists bool) bool {
+		for _, c := range conds {
+			if !c(value, exists) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func eq(arg interface{}) condition {
+	return func(value interface{}, exists bool) bool {
+		return exists && collate.Compare(value, arg) == 0
+	}
+}
+
+// compare returns a condition which applies test to the collation of the
+// field value against arg.
+func compare(arg interface{}, test func(int) bool) condition {
+	return func(value interface{}, exists bool) bool {
+		return exists && test(collate.Compare(value, arg))
+	}
+}
+
+func compileOperator(op string, arg interface{}) (condition, error) {
+	switch op {
+	case "$eq":
+		return eq(arg), nil
+	case "$ne":
+		return compare(arg, func(c int) bool { return c != 0 }), nil
+	case "$gt":
+		return compare(arg, func(c int) bool { return c > 0 }), nil
+	case "$gte":
+		return compare(arg, func(c int) bool { return c >= 0 }), nil
+	case "$lt":
+		return compare(arg, func(c int) bool { return c < 0 }), nil
+	case "$lte":
+		return compare(arg, func(c int) bool { return c <= 0 }), nil
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return nil, invalid("$exists requires a boolean")
+		}
+		return func(_ interface{}, exists bool) bool { return exists == want }, nil
+	case "$type":
+		want, ok := arg.(string)
+		if !ok {
+			return nil, invalid("$type requires a string")
+		}
+		switch want {
+		case "null", "boolean", "number", "string", "array", "object":
+		default:
+			return nil, invalid("invalid type: %s", want)
+		}
+		return func(value interface{}, exists bool) bool {
+			return exists && typeName(value) == want
+		}, nil
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalid("%s requires an array", op)
+		}
+		in := func(value interface{}) bool {
+			for _, a := range list {
+				if collate.Compare(value, a) == 0 {
+					return true
+				}
+			}
+			if values, ok := value.([]interface{}); ok {
+				for _, v := range values {
+					for _, a := range list {
+						if collate.Compare(v, a) == 0 {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}
+		if op == "$in" {
+			return func(value interface{}, exists bool) bool { return exists && in(value) }, nil
+		}
+		return func(value interface{}, exists bool) bool { return exists && !in(value) }, nil
+	case "$size":
+		n, ok := arg.(float64)
+		if !ok || n != math.Trunc(n) {
+			return nil, invalid("$size requires an integer")
+		}
+		return func(value interface{}, exists bool) bool {
+			list, ok := value.([]interface{})
+			return exists && ok && len(list) == int(n)
+		}, nil
+	case "$mod":
+		list, ok := arg.([]interface{})
+		if !ok || len(list) != 2 {
+			return nil, invalid("$mod requires an array of [divisor, remainder]")
+		}
+		divisor, ok1 := list[0].(float64)
+		remainder, ok2 := list[1].(float64)
+		if !ok1 || !ok2 || divisor != math.Trunc(divisor) || remainder != math.Trunc(remainder) || divisor == 0 {
+			return nil, invalid("$mod requires a non-zero integer divisor and an integer remainder")
+		}
+		return func(value interface{}, exists bool) bool {
+			n, ok := value.(float64)
+			return exists && ok && n == math.Trunc(n) && int64(n)%int64(divisor) == int64(remainder)
+		}, nil
+	case "$regex":
+		pattern, ok := arg.(string)
+		if !ok {
+			return nil, invalid("$regex requires a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, invalid("invalid $regex: %s", err)
+		}
+		return func(value interface{}, exists bool) bool {
+			s, ok := value.(string)
+			return exists && ok && re.MatchString(s)
+		}, nil
+	case "$all":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalid("$all requires an array")
+		}
+		return func(value interface{}, exists bool) bool {
+			values, ok := value.([]interface{})
+			if !exists || !ok {
+				return false
+			}
+			for _, a := range list {
+				if !eqAny(values, a) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case "$elemMatch", "$allMatch", "$keyMapMatch":
+		if _, ok := arg.(map[string]interface{}); !ok {
+			return nil, invalid("%s requires an object", op)
+		}
+		sub, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return elementCondition(op, sub), nil
+	case "$not":
+		sub, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, exists bool) bool {
+			return exists && !sub(value, exists)
+		}, nil
+	}
+	return nil, invalid("invalid operator: %s", op)
+}
+
+func elementCondition(op string, sub condition) condition {
+	return func(value interface{}, exists bool) bool {
+		if !exists {
+			return false
+		}
+		switch op {
+		case "$elemMatch":
+			list, _ := value.([]interface{})
+			for _, v := range list {
+				if sub(v, true) {
+					return true
+				}
+			}
+			return false
+		case "$allMatch":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return false
+			}
+			for _, v := range list {
+				if !sub(v, true) {
+					return false
+				}
+			}
+			return true
+		}
+		obj, _ := value.(map[string]interface{})
+		for k := range obj {
+			if sub(k, true) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func eqAny(values []interface{}, arg interface{}) bool {
+	for _, v := range values {
+		if collate.Compare(v, arg) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,166 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package mango
+
+import (
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestSelector(t *testing.T) {
+	type tt struct {
+		selector string
+		status   int
+		err      string
+		match    []string
+		noMatch  []string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("empty", tt{
+		selector: `{}`,
+		match:    []string{`{}`, `{"a":1}`},
+	})
+	tests.Add("implicit eq", tt{
+		selector: `{"type":"fruit"}`,
+		match:    []string{`{"type":"fruit"}`},
+		noMatch:  []string{`{"type":"Fruit"}`, `{}`},
+	})
+	tests.Add("nested field", tt{
+		selector: `{"a.b":1,"c":{"d":2}}`,
+		match:    []string{`{"a":{"b":1},"c":{"d":2}}`},
+		noMatch:  []string{`{"a":{"b":1},"c":{"d":3}}`, `{"a":1,"c":{"d":2}}`},
+	})
+	tests.Add("escaped dot", tt{
+		selector: `{"a\\.b":1}`,
+		match:    []string{`{"a.b":1}`},
+		noMatch:  []string{`{"a":{"b":1}}`},
+	})
+	tests.Add("array index", tt{
+		selector: `{"tags.1":"b"}`,
+		match:    []string{`{"tags":["a","b"]}`},
+		noMatch:  []string{`{"tags":["b"]}`},
+	})
+	tests.Add("comparison", tt{
+		selector: `{"n":{"$gt":1,"$lte":3}}`,
+		match:    []string{`{"n":2}`, `{"n":3}`},
+		noMatch:  []string{`{"n":1}`, `{"n":4}`, `{"n":"2"}`, `{}`},
+	})
+	tests.Add("ne missing", tt{
+		selector: `{"n":{"$ne":1}}`,
+		match:    []string{`{"n":2}`},
+		noMatch:  []string{`{"n":1}`, `{}`},
+	})
+	tests.Add("exists", tt{
+		selector: `{"n":{"$exists":false}}`,
+		match:    []string{`{}`},
+		noMatch:  []string{`{"n":null}`},
+	})
+	tests.Add("type", tt{
+		selector: `{"n":{"$type":"number"}}`,
+		match:    []string{`{"n":1.5}`},
+		noMatch:  []string{`{"n":"1"}`},
+	})
+	tests.Add("in", tt{
+		selector: `{"tag":{"$in":["a","b"]}}`,
+		match:    []string{`{"tag":"a"}`, `{"tag":["x","b"]}`},
+		noMatch:  []string{`{"tag":"c"}`, `{}`},
+	})
+	tests.Add("nin", tt{
+		selector: `{"tag":{"$nin":["a","b"]}}`,
+		match:    []string{`{"tag":"c"}`},
+		noMatch:  []string{`{"tag":"a"}`, `{}`},
+	})
+	tests.Add("size all", tt{
+		selector: `{"tags":{"$size":2,"$all":["a","b"]}}`,
+		match:    []string{`{"tags":["b","a"]}`},
+		noMatch:  []string{`{"tags":["a","c"]}`, `{"tags":["a","b","c"]}`},
+	})
+	tests.Add("mod", tt{
+		selector: `{"n":{"$mod":[3,1]}}`,
+		match:    []string{`{"n":4}`},
+		noMatch:  []string{`{"n":5}`, `{"n":4.5}`},
+	})
+	tests.Add("regex", tt{
+		selector: `{"name":{"$regex":"^ki"}}`,
+		match:    []string{`{"name":"kivik"}`},
+		noMatch:  []string{`{"name":"couch"}`, `{"name":1}`},
+	})
+	tests.Add("elemMatch", tt{
+		selector: `{"items":{"$elemMatch":{"qty":{"$gt":5}}}}`,
+		match:    []string{`{"items":[{"qty":1},{"qty":6}]}`},
+		noMatch:  []string{`{"items":[{"qty":1}]}`, `{"items":{"qty":6}}`},
+	})
+	tests.Add("allMatch", tt{
+		selector: `{"n":{"$allMatch":{"$gt":0}}}`,
+		match:    []string{`{"n":[1,2]}`},
+		noMatch:  []string{`{"n":[1,0]}`, `{"n":[]}`},
+	})
+	tests.Add("keyMapMatch", tt{
+		selector: `{"m":{"$keyMapMatch":{"$eq":"k"}}}`,
+		match:    []string{`{"m":{"k":1}}`},
+		noMatch:  []string{`{"m":{"j":1}}`},
+	})
+	tests.Add("combinations", tt{
+		selector: `{"$or":[{"a":1},{"b":1}],"$nor":[{"c":1}],"$not":{"d":1}}`,
+		match:    []string{`{"a":1}`, `{"b":1,"d":2}`},
+		noMatch:  []string{`{"a":1,"c":1}`, `{"b":1,"d":1}`, `{}`},
+	})
+	tests.Add("field not", tt{
+		selector: `{"a":{"$not":{"$gt":1}}}`,
+		match:    []string{`{"a":1}`},
+		noMatch:  []string{`{"a":2}`, `{}`},
+	})
+	tests.Add("invalid operator", tt{
+		selector: `{"a":{"$foo":1}}`,
+		status:   http.StatusBadRequest,
+		err:      "mango: invalid operator: $foo",
+	})
+	tests.Add("invalid combination", tt{
+		selector: `{"$or":{"a":1}}`,
+		status:   http.StatusBadRequest,
+		err:      "mango: $or requires an array",
+	})
+	tests.Add("not an object", tt{
+		selector: `[]`,
+		status:   http.StatusBadRequest,
+		err:      "mango: selector must be an object",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		sel, err := Compile([]byte(tt.selector))
+		testy.StatusError(t, tt.err, tt.status, err)
+		for _, doc := range tt.match {
+			if ok, err := sel.MatchJSON([]byte(doc)); err != nil || !ok {
+				t.Errorf("Expected %s to match (err: %v)", doc, err)
+			}
+		}
+		for _, doc := range tt.noMatch {
+			if ok, err := sel.MatchJSON([]byte(doc)); err != nil || ok {
+				t.Errorf("Expected %s not to match (err: %v)", doc, err)
+			}
+		}
+	})
+}
+
+func TestMatchGoValue(t *testing.T) {
+	sel, err := Compile(map[string]interface{}{"Name": "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.Match(struct{ Name string }{Name: "foo"}) {
+		t.Error("Expected struct to match")
+	}
+}
@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// ErrCircuitOpen is wrapped by the error returned for calls rejected by an
+// open CircuitBreaker. The returned error has status
+// http.StatusServiceUnavailable.
+var ErrCircuitOpen = errors.New("kivik: circuit breaker open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+// The states of a CircuitBreaker.
+const (
+	// BreakerClosed is the normal state, in which calls proceed.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen is the state in which calls fail fast.
+	BreakerOpen
+	// BreakerHalfOpen is the state in which a probe is in progress, to
+	// determine whether the breaker may close.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker is a middleware which stops sending calls to a server after
+// a number of consecutive failures. While open, calls fail immediately with
+// ErrCircuitOpen. Once the cooldown period has elapsed, the next call probes
+// the server with Ping (or Version, if the driver does not support Ping),
+// and the breaker closes if the probe succeeds, or remains open for another
+// cooldown period if it fails.
+//
+// The state of a CircuitBreaker is shared by all clients which use it.
+type CircuitBreaker struct {
+	// IsFailure reports whether an error counts towards opening the
+	// breaker. If nil, kivik.IsRetryable is used, so that only transient
+	// server and network errors are counted, except that ErrRateLimited and
+	// ErrCircuitOpen, which are returned without contacting the server, are
+	// never counted. Calls which succeed, or fail with an error which is not
+	// a failure, reset the count.
+	//
+	// Regardless of IsFailure, a call which times out, with
+	// context.DeadlineExceeded or a network timeout, is counted as a failure
+	// when the caller's context is still live, so that a server which hangs
+	// opens the breaker. A call made with a context which has been cancelled
+	// or has expired neither counts as a failure nor resets the count.
+	IsFailure func(error) bool
+
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker which opens after threshold
+// consecutive failures, and stays open for at least cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Intercept is the breaker's Middleware.
+func (b *CircuitBreaker) Intercept(ctx context.Context, call *Call, next Handler) error {
+	if err := b.allow(ctx, call); err != nil {
+		return err
+	}
+	err := next(ctx)
+	b.record(ctx, err)
+	return err
+}
+
+func (b *CircuitBreaker) allow(ctx context.Context, call *Call) error {
+	b.mu.Lock()
+	switch {
+	case b.state == BreakerClosed:
+		b.mu.Unlock()
+		return nil
+	case b.state == BreakerHalfOpen, b.now().Sub(b.openedAt) < b.cooldown:
+		b.mu.Unlock()
+		return circuitOpen()
+	}
+	b.state = BreakerHalfOpen
+	b.mu.Unlock()
+
+	up := probe(ctx, call.Client)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !up {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		return circuitOpen()
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	return nil
+}
+
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	isFailure := b.IsFailure
+	if isFailure == nil {
+		isFailure = isServerFailure
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || (!isTimeout(err) && !isFailure(err)) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerClosed && b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// isServerFailure is the default CircuitBreaker.IsFailure.
+func isServerFailure(err error) bool {
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	return kivik.IsRetryable(err)
+}
+
+// isTimeout reports whether err is, or wraps, context.DeadlineExceeded or
+// another error which reports a timeout, such as a net.Error.
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout())
+}
+
+// probe reports whether the server behind client is available.
+func probe(ctx context.Context, client driver.Client) bool {
+	if pinger, ok := client.(driver.Pinger); ok {
+		up, err := pinger.Ping(ctx)
+		return err == nil && up
+	}
+	_, err := client.Version(ctx)
+	return err == nil
+}
+
+func circuitOpen() error {
+	return &kivik.Error{HTTPStatus: http.StatusServiceUnavailable, Err: ErrCircuitOpen}
+}
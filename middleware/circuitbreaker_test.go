@@ -0,0 +1,198 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	var pingUp bool
+	var pings int
+	client := &mock.Pinger{
+		Client: &mock.Client{},
+		PingFunc: func(context.Context) (bool, error) {
+			pings++
+			return pingUp, nil
+		},
+	}
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	call := &Call{Method: "Get", Client: client}
+	unavailable := func(context.Context) error {
+		return &kivik.Error{HTTPStatus: http.StatusServiceUnavailable}
+	}
+	notFound := func(context.Context) error {
+		return &kivik.Error{HTTPStatus: http.StatusNotFound}
+	}
+	ok := func(context.Context) error { return nil }
+	ctx := context.Background()
+
+	_ = b.Intercept(ctx, call, unavailable)
+	_ = b.Intercept(ctx, call, notFound)
+	_ = b.Intercept(ctx, call, unavailable)
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Non-consecutive failures opened the breaker: %s", state)
+	}
+	_ = b.Intercept(ctx, call, unavailable)
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to open, got %s", state)
+	}
+
+	err := b.Intercept(ctx, call, ok)
+	testy.StatusError(t, "kivik: circuit breaker open", http.StatusServiceUnavailable, err)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if pings != 0 {
+		t.Errorf("Unexpected probe during cooldown")
+	}
+
+	now = now.Add(time.Minute)
+	err = b.Intercept(ctx, call, ok)
+	testy.StatusError(t, "kivik: circuit breaker open", http.StatusServiceUnavailable, err)
+	if pings != 1 {
+		t.Errorf("Expected 1 probe, got %d", pings)
+	}
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Failed probe should keep breaker open, got %s", state)
+	}
+
+	now = now.Add(time.Minute)
+	pingUp = true
+	if err := b.Intercept(ctx, call, ok); err != nil {
+		t.Fatalf("Expected call to succeed after probe: %s", err)
+	}
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Successful probe should close breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerVersionProbe(t *testing.T) {
+	var versions int
+	client := &mock.Client{
+		VersionFunc: func(context.Context) (*driver.Version, error) {
+			versions++
+			return &driver.Version{}, nil
+		},
+	}
+	b := NewCircuitBreaker(1, 0)
+	b.IsFailure = func(error) bool { return true }
+	call := &Call{Method: "Put", Client: client}
+	_ = b.Intercept(context.Background(), call, func(context.Context) error {
+		return &kivik.Error{HTTPStatus: http.StatusConflict}
+	})
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to open, got %s", state)
+	}
+	if err := b.Intercept(context.Background(), call, func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if versions != 1 {
+		t.Errorf("Expected Version probe, got %d", versions)
+	}
+}
+
+func TestCircuitBreakerRateLimited(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute)
+	l := NewRateLimiter(Limit{Rate: 0.001}, nil)
+	l.now = func() time.Time { return now }
+	mw := Chain(b.Intercept, l.Intercept)
+	call := &Call{Method: "Get", Client: &mock.Client{}}
+	ok := func(context.Context) error { return nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := mw(ctx, call, ok); err != nil {
+		t.Fatal(err)
+	}
+	err := mw(ctx, call, ok)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	if state := b.State(); state != BreakerClosed {
+		t.Errorf("Rate limited call opened the breaker: %s", state)
+	}
+}
+
+func TestCircuitBreakerNested(t *testing.T) {
+	inner := NewCircuitBreaker(1, time.Minute)
+	outer := NewCircuitBreaker(1, time.Minute)
+	call := &Call{Method: "Get", Client: &mock.Client{}}
+	mw := Chain(outer.Intercept, inner.Intercept)
+	_ = inner.Intercept(context.Background(), call, func(context.Context) error {
+		return &kivik.Error{HTTPStatus: http.StatusServiceUnavailable}
+	})
+	err := mw(context.Background(), call, func(context.Context) error { return nil })
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if state := outer.State(); state != BreakerClosed {
+		t.Errorf("Open inner breaker opened the outer breaker: %s", state)
+	}
+}
+
+func TestCircuitBreakerTimeout(t *testing.T) {
+	b := NewCircuitBreaker(2, time.Minute)
+	hang := &mock.Client{
+		AllDBsFunc: func(ctx context.Context, _ map[string]interface{}) ([]string, error) {
+			<-ctx.Done()
+			return nil, &kivik.Error{Err: ctx.Err()}
+		},
+	}
+	// The driver's own request timeout, which expires before the caller's
+	// context.
+	requestTimeout := func(ctx context.Context, _ *Call, next Handler) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+		defer cancel()
+		return next(ctx)
+	}
+	client := WrapClient(hang, Chain(b.Intercept, requestTimeout))
+
+	t.Run("caller deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		for i := 0; i < 2; i++ {
+			_ = b.Intercept(ctx, &Call{Method: "AllDBs", Client: hang}, func(ctx context.Context) error {
+				_, err := hang.AllDBs(ctx, nil)
+				return err
+			})
+		}
+		if state := b.State(); state != BreakerClosed {
+			t.Fatalf("Expired caller context opened the breaker: %s", state)
+		}
+	})
+	t.Run("server hangs", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, err := client.AllDBs(context.Background(), nil)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if state := b.State(); state != BreakerOpen {
+			t.Fatalf("Expected timeouts to open the breaker, got %s", state)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// router passes every call through the middleware to the underlying client.
+type router struct {
+	client driver.Client
+	mw     Middleware
+}
+
+var _ forward.ClientRouter = &router{}
+
+func (r *router) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.Client) error) error {
+	return r.mw(ctx, &Call{Method: call.Method, DB: call.DB, Client: r.client}, func(ctx context.Context) error {
+		return fn(ctx, r.client)
+	})
+}
+
+func (r *router) DB(dbName string, options map[string]interface{}) (driver.DB, forward.DBRouter, error) {
+	db, err := r.client.DB(dbName, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, &dbRouter{db: db, client: r.client, mw: r.mw}, nil
+}
+
+func (r *router) Close(ctx context.Context) error {
+	if closer, ok := r.client.(driver.ClientCloser); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+// dbRouter passes every call through the middleware to the underlying DB.
+type dbRouter struct {
+	db     driver.DB
+	client driver.Client
+	mw     Middleware
+}
+
+var _ forward.DBRouter = &dbRouter{}
+
+func (r *dbRouter) Route(ctx context.Context, call *forward.Call, fn func(context.Context, driver.DB) error) error {
+	return r.mw(ctx, &Call{
+		Method: call.Method,
+		DB:     call.DB,
+		DocID:  call.DocID,
+		Client: r.client,
+	}, func(ctx context.Context) error {
+		return fn(ctx, r.db)
+	})
+}
+
+func (r *dbRouter) Close(ctx context.Context) error {
+	if closer, ok := r.db.(driver.DBCloser); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
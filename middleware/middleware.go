@@ -0,0 +1,115 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package middleware provides composable middleware for Kivik drivers.
+//
+// A middleware intercepts every call made through a driver.Client, and the
+// driver.DB handles it creates. Middlewares are installed by registering a
+// wrapped driver under a new name:
+//
+//	breaker := middleware.NewCircuitBreaker(5, 30*time.Second)
+//	limiter := middleware.NewRateLimiter(middleware.Limit{Rate: 100, Burst: 10}, nil)
+//	middleware.Register("couch-protected", &couchdb.Couch{}, breaker.Intercept, limiter.Intercept)
+//
+//	client, err := kivik.New("couch-protected", dsn)
+//
+// The wrapped client and DB implement the optional driver interfaces which
+// Kivik emulates, such as driver.Pinger and driver.MetaGetter, only when the
+// underlying driver does, so that Kivik falls back to the same emulation it
+// would use for the underlying driver, through the middleware. The other
+// optional interfaces are always implemented; when the underlying driver does
+// not support one, the wrapper returns an error with status
+// http.StatusNotImplemented, without invoking any middleware. Iterators such
+// as driver.Rows and driver.Changes are returned untouched, so their own
+// optional interfaces remain available.
+package middleware
+
+import (
+	"context"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/forward"
+)
+
+// Call describes a single intercepted driver call.
+type Call struct {
+	// Method is the name of the driver method being called, such as "Get"
+	// or "AllDocs".
+	Method string
+	// DB is the name of the database, or empty for server-level calls.
+	DB string
+	// DocID is the document ID, if the call applies to a single document.
+	DocID string
+	// Client is the underlying, unwrapped driver client. It may be used by
+	// middleware to make out-of-band calls, such as a health check.
+	Client driver.Client
+}
+
+// Handler performs the intercepted driver call.
+type Handler func(ctx context.Context) error
+
+// Middleware intercepts a driver call. It must call next to proceed with the
+// call, and should return the error returned by next. It may instead return
+// an error without calling next, to reject the call.
+type Middleware func(ctx context.Context, call *Call, next Handler) error
+
+// Chain composes mws into a single Middleware. The first middleware is the
+// outermost, and is called first.
+func Chain(mws ...Middleware) Middleware {
+	return func(ctx context.Context, call *Call, next Handler) error {
+		h := next
+		for i := len(mws) - 1; i >= 0; i-- {
+			mw, inner := mws[i], h
+			h = func(ctx context.Context) error {
+				return mw(ctx, call, inner)
+			}
+		}
+		return h(ctx)
+	}
+}
+
+// Register makes the driver base, wrapped with mws, available by the provided
+// name. It panics under the same conditions as kivik.Register.
+func Register(name string, base driver.Driver, mws ...Middleware) {
+	kivik.Register(name, Wrap(base, mws...))
+}
+
+// Wrap returns a driver whose clients are wrapped with mws.
+func Wrap(base driver.Driver, mws ...Middleware) driver.Driver {
+	return &wrappedDriver{Driver: base, mw: Chain(mws...)}
+}
+
+// WrapClient returns a driver.Client which calls mws for every call to
+// client, and to the driver.DB handles it creates.
+func WrapClient(client driver.Client, mws ...Middleware) driver.Client {
+	return wrap(client, Chain(mws...))
+}
+
+func wrap(client driver.Client, mw Middleware) driver.Client {
+	return forward.NewClient(client, &router{client: client, mw: mw})
+}
+
+type wrappedDriver struct {
+	driver.Driver
+	mw Middleware
+}
+
+var _ driver.Driver = &wrappedDriver{}
+
+func (d *wrappedDriver) NewClient(dsn string, options map[string]interface{}) (driver.Client, error) {
+	client, err := d.Driver.NewClient(dsn, options)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(client, d.mw), nil
+}
@@ -0,0 +1,260 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// recorder is a Middleware which records the calls it sees.
+type recorder struct {
+	calls []Call
+}
+
+func (r *recorder) Intercept(ctx context.Context, call *Call, next Handler) error {
+	r.calls = append(r.calls, *call)
+	return next(ctx)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(ctx context.Context, _ *Call, next Handler) error {
+			order = append(order, name)
+			return next(ctx)
+		}
+	}
+	err := Chain(mw("a"), mw("b"), mw("c"))(context.Background(), &Call{}, func(context.Context) error {
+		order = append(order, "call")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b", "c", "call"}, order); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestChainReject(t *testing.T) {
+	var called bool
+	reject := func(context.Context, *Call, Handler) error {
+		return &kivik.Error{HTTPStatus: http.StatusTeapot, Message: "rejected"}
+	}
+	err := Chain(reject)(context.Background(), &Call{}, func(context.Context) error {
+		called = true
+		return nil
+	})
+	testy.StatusError(t, "rejected", http.StatusTeapot, err)
+	if called {
+		t.Error("Rejected call should not proceed")
+	}
+}
+
+func TestWrapClient(t *testing.T) {
+	rec := &recorder{}
+	base := &mock.Client{
+		VersionFunc: func(context.Context) (*driver.Version, error) {
+			return &driver.Version{Version: "2.3.1"}, nil
+		},
+		DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+			return &mock.DB{
+				GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+					return &driver.Document{Rev: "1-xxx"}, nil
+				},
+			}, nil
+		},
+	}
+	client := WrapClient(base, rec.Intercept)
+	ver, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Version != "2.3.1" {
+		t.Errorf("Unexpected version: %s", ver.Version)
+	}
+	db, err := client.DB("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := db.Get(context.Background(), "bar", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Rev != "1-xxx" {
+		t.Errorf("Unexpected rev: %s", doc.Rev)
+	}
+	expected := []Call{
+		{Method: "Version", Client: base},
+		{Method: "Get", DB: "foo", DocID: "bar", Client: base},
+	}
+	if d := testy.DiffInterface(expected, rec.calls); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestNotImplemented(t *testing.T) {
+	rec := &recorder{}
+	client := WrapClient(&mock.Client{
+		DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+			return &mock.DB{}, nil
+		},
+	}, rec.Intercept)
+	db, _ := client.DB("foo", nil)
+
+	_, err := client.(driver.Sessioner).Session(context.Background())
+	testy.StatusError(t, "kivik: driver does not support Session", http.StatusNotImplemented, err)
+	err = db.(driver.Flusher).Flush(context.Background())
+	testy.StatusError(t, "kivik: driver does not support Flush", http.StatusNotImplemented, err)
+	if err := db.(driver.DBCloser).Close(context.Background()); err != nil {
+		t.Errorf("Close should succeed: %s", err)
+	}
+	if len(rec.calls) != 0 {
+		t.Errorf("Unsupported calls should not be intercepted: %v", rec.calls)
+	}
+}
+
+func TestEmulatedInterfaces(t *testing.T) {
+	client := WrapClient(&mock.Client{
+		DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+			return &mock.DB{}, nil
+		},
+	})
+	if _, ok := client.(driver.Pinger); ok {
+		t.Error("Client should not implement Pinger")
+	}
+	if _, ok := client.(driver.DBsStatser); ok {
+		t.Error("Client should not implement DBsStatser")
+	}
+	db, _ := client.DB("foo", nil)
+	if _, ok := db.(driver.MetaGetter); ok {
+		t.Error("DB should not implement MetaGetter")
+	}
+	if _, ok := db.(driver.Copier); ok {
+		t.Error("DB should not implement Copier")
+	}
+
+	client = WrapClient(&mock.Pinger{
+		PingFunc: func(context.Context) (bool, error) {
+			return true, nil
+		},
+		Client: &mock.Client{
+			DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+				return &mock.MetaGetter{DB: &mock.DB{}}, nil
+			},
+		},
+	})
+	if _, ok := client.(driver.Pinger); !ok {
+		t.Error("Client should implement Pinger")
+	}
+	db, _ = client.DB("foo", nil)
+	if _, ok := db.(driver.MetaGetter); !ok {
+		t.Error("DB should implement MetaGetter")
+	}
+	if _, ok := db.(driver.Copier); ok {
+		t.Error("DB should not implement Copier")
+	}
+}
+
+func TestLegacyFinder(t *testing.T) {
+	rec := &recorder{}
+	client := WrapClient(&mock.Client{
+		DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+			return &mock.Finder{
+				FindFunc: func(context.Context, interface{}) (driver.Rows, error) {
+					return &mock.Rows{ID: "a"}, nil
+				},
+			}, nil
+		},
+	}, rec.Intercept)
+	db, _ := client.DB("foo", nil)
+	rows, err := db.(driver.OptsFinder).Find(context.Background(), nil, map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := rows.(*mock.Rows).ID; id != "a" {
+		t.Errorf("Unexpected rows: %s", id)
+	}
+	if len(rec.calls) != 1 || rec.calls[0].Method != "Find" {
+		t.Errorf("Unexpected calls: %v", rec.calls)
+	}
+}
+
+func TestEmulationFallback(t *testing.T) {
+	rec := &recorder{}
+	Register("middleware-fallback", &mock.Driver{
+		NewClientFunc: func(string, map[string]interface{}) (driver.Client, error) {
+			return &mock.Client{
+				VersionFunc: func(context.Context) (*driver.Version, error) {
+					return &driver.Version{}, nil
+				},
+				DBFunc: func(string, map[string]interface{}) (driver.DB, error) {
+					return &mock.DB{
+						GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+							return &driver.Document{
+								Rev:  "1-xxx",
+								Body: ioutil.NopCloser(strings.NewReader(`{"_rev":"1-xxx"}`)),
+							}, nil
+						},
+						PutFunc: func(context.Context, string, interface{}, map[string]interface{}) (string, error) {
+							return "2-xxx", nil
+						},
+					}, nil
+				},
+			}, nil
+		},
+	}, rec.Intercept)
+	client, err := kivik.New("middleware-fallback", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := client.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !up {
+		t.Error("Expected server to be up")
+	}
+	db := client.DB("foo")
+	rev, err := db.GetRev(context.Background(), "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "1-xxx" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	rev, err = db.Copy(context.Background(), "baz", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "2-xxx" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	var methods []string
+	for _, call := range rec.calls {
+		methods = append(methods, call.Method)
+	}
+	if d := testy.DiffInterface([]string{"Version", "Get", "Get", "Put"}, methods); d != nil {
+		t.Error(d)
+	}
+}
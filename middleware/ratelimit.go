@@ -0,0 +1,150 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// ErrRateLimited is wrapped by the error returned when a call cannot be
+// admitted by a RateLimiter before its context's deadline. The returned error
+// has status http.StatusTooManyRequests.
+var ErrRateLimited = errors.New("kivik: rate limit exceeded")
+
+// Limit describes a token bucket.
+type Limit struct {
+	// Rate is the number of calls per second permitted in the long run. A
+	// Rate of zero or less means no limit.
+	Rate float64
+	// Burst is the maximum number of calls which may be made at once. Values
+	// less than 1 are treated as 1.
+	Burst int
+}
+
+// RateLimiter is a token-bucket middleware, which delays calls to keep within
+// the configured rate. A call which would have to wait beyond its context's
+// deadline fails immediately with ErrRateLimited.
+//
+// The buckets of a RateLimiter are shared by all clients which use it.
+type RateLimiter struct {
+	now     func() time.Time
+	def     *bucket
+	methods map[string]*bucket
+}
+
+// NewRateLimiter returns a RateLimiter which applies limit to all calls,
+// except those for methods listed in perMethod, which have their own
+// independent buckets. Method names are those of Call.Method, such as "Get"
+// or "Query".
+func NewRateLimiter(limit Limit, perMethod map[string]Limit) *RateLimiter {
+	l := &RateLimiter{
+		now:     time.Now,
+		def:     newBucket(limit),
+		methods: make(map[string]*bucket, len(perMethod)),
+	}
+	for method, limit := range perMethod {
+		l.methods[method] = newBucket(limit)
+	}
+	return l
+}
+
+// Intercept is the rate limiter's Middleware.
+func (l *RateLimiter) Intercept(ctx context.Context, call *Call, next Handler) error {
+	b, ok := l.methods[call.Method]
+	if !ok {
+		b = l.def
+	}
+	if err := l.wait(ctx, b); err != nil {
+		return err
+	}
+	return next(ctx)
+}
+
+func (l *RateLimiter) wait(ctx context.Context, b *bucket) error {
+	now := l.now()
+	delay, limited := b.reserve(now)
+	if !limited || delay <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < delay {
+		b.cancel()
+		return &kivik.Error{HTTPStatus: http.StatusTooManyRequests, Err: ErrRateLimited}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit Limit) *bucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// reserve takes a token from the bucket, returning the delay until the
+// token is available. It returns false if the bucket is unlimited.
+func (b *bucket) reserve(now time.Time) (time.Duration, bool) {
+	if b.rate <= 0 {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// cancel returns a reserved token to the bucket.
+func (b *bucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens++; b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,83 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newBucket(Limit{Rate: 10, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if delay, _ := b.reserve(now); delay != 0 {
+			t.Fatalf("Unexpected delay within burst: %v", delay)
+		}
+	}
+	if delay, _ := b.reserve(now); delay != 100*time.Millisecond {
+		t.Errorf("Unexpected delay: %v", delay)
+	}
+	b.cancel()
+	now = now.Add(time.Second)
+	if delay, _ := b.reserve(now); delay != 0 {
+		t.Errorf("Bucket should have refilled, got delay %v", delay)
+	}
+	if _, limited := newBucket(Limit{}).reserve(now); limited {
+		t.Error("Zero rate should be unlimited")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(Limit{Rate: 1}, map[string]Limit{
+		"Get": {Rate: 1000, Burst: 5},
+	})
+	l.now = func() time.Time { return now }
+	ok := func(context.Context) error { return nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.Intercept(ctx, &Call{Method: "Put"}, ok); err != nil {
+		t.Fatal(err)
+	}
+	err := l.Intercept(ctx, &Call{Method: "Put"}, ok)
+	testy.StatusError(t, "kivik: rate limit exceeded", http.StatusTooManyRequests, err)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := l.Intercept(ctx, &Call{Method: "Get"}, ok); err != nil {
+			t.Fatalf("Per-method limit not applied: %s", err)
+		}
+	}
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 0.001}, nil)
+	ok := func(context.Context) error { return nil }
+	if err := l.Intercept(context.Background(), &Call{}, ok); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := l.Intercept(ctx, &Call{}, ok)
+	if err != context.Canceled {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+var nodeStatsNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support node statistics"}
+
+// LocalNode is the node name which refers to the node handling the request.
+const LocalNode = "_local"
+
+// StatCounter is a monotonically increasing statistic.
+type StatCounter struct {
+	Value int64
+	Desc  string
+}
+
+// StatGauge is a statistic which may increase or decrease.
+type StatGauge struct {
+	Value float64
+	Desc  string
+}
+
+// Percentile is a percentile of a StatHistogram. Percentile is in the range
+// 0 to 100, such as 50, 99, or 99.9.
+type Percentile struct {
+	Percentile float64
+	Value      float64
+}
+
+// HistogramBucket is a bucket of a StatHistogram.
+type HistogramBucket struct {
+	Value float64
+	Count int64
+}
+
+// StatHistogram summarizes a distribution of values, such as request times
+// in milliseconds, over the server's sampling interval.
+type StatHistogram struct {
+	Desc              string
+	N                 int64
+	Min               float64
+	Max               float64
+	ArithmeticMean    float64
+	GeometricMean     float64
+	HarmonicMean      float64
+	Median            float64
+	Variance          float64
+	StandardDeviation float64
+	Skewness          float64
+	Kurtosis          float64
+	Percentiles       []Percentile
+	Buckets           []HistogramBucket
+}
+
+// NodeStats contains the statistics of a node. Statistics are keyed by their
+// dot-separated path, such as "couchdb.httpd.requests".
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#node-node-name-stats
+type NodeStats struct {
+	Counters   map[string]StatCounter
+	Gauges     map[string]StatGauge
+	Histograms map[string]StatHistogram
+	// RawResponse is the raw response body returned by the server.
+	RawResponse jsoniter.RawMessage
+}
+
+// MemoryStats reports the memory used by the Erlang VM of a node, in bytes.
+type MemoryStats struct {
+	Other         int64 `json:"other"`
+	Atom          int64 `json:"atom"`
+	AtomUsed      int64 `json:"atom_used"`
+	Processes     int64 `json:"processes"`
+	ProcessesUsed int64 `json:"processes_used"`
+	Binary        int64 `json:"binary"`
+	Code          int64 `json:"code"`
+	ETS           int64 `json:"ets"`
+}
+
+// MessageQueue summarizes the message queue lengths of a named process, or of
+// a group of processes. For a single process, Count is 1, and the other
+// values are all equal to its queue length.
+type MessageQueue struct {
+	Count  int64
+	Min    int64
+	Max    int64
+	Median int64
+	P90    int64
+	P99    int64
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (q *MessageQueue) UnmarshalJSON(data []byte) error {
+	var length int64
+	if err := json.Unmarshal(data, &length); err == nil {
+		*q = MessageQueue{Count: 1, Min: length, Max: length, Median: length, P90: length, P99: length}
+		return nil
+	}
+	var summary struct {
+		Count  int64 `json:"count"`
+		Min    int64 `json:"min"`
+		Max    int64 `json:"max"`
+		Median int64 `json:"50"`
+		P90    int64 `json:"90"`
+		P99    int64 `json:"99"`
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return err
+	}
+	*q = MessageQueue(summary)
+	return nil
+}
+
+// NodeSystem contains the system metrics of a node's Erlang VM.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#node-node-name-system
+type NodeSystem struct {
+	Uptime                  time.Duration
+	Memory                  MemoryStats
+	RunQueue                int64
+	ETSTableCount           int64
+	ContextSwitches         int64
+	Reductions              int64
+	GarbageCollectionCount  int64
+	WordsReclaimed          int64
+	IOInput                 int64
+	IOOutput                int64
+	OSProcCount             int64
+	StaleProcCount          int64
+	ProcessCount            int64
+	ProcessLimit            int64
+	InternalReplicationJobs int64
+	// MessageQueues is keyed by process or process group name.
+	MessageQueues map[string]MessageQueue
+	// RawResponse is the raw response body returned by the server.
+	RawResponse jsoniter.RawMessage
+}
+
+// NodeStats returns the statistics of node, which may be LocalNode, or the
+// name of a cluster node, such as returned by Membership. If node is empty,
+// LocalNode is used.
+func (c *Client) NodeStats(ctx context.Context, node string) (stats *NodeStats, err error) {
+	op := c.op("NodeStats", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	statser, ok := c.driverClient.(driver.NodeStatser)
+	if !ok {
+		return nil, op.wrap(nodeStatsNotImplemented)
+	}
+	if node == "" {
+		node = LocalNode
+	}
+	var raw jsoniter.RawMessage
+	err = c.retryPolicy(nil).do(ctx, func() (err error) {
+		raw, err = statser.NodeStats(ctx, node)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	stats = &NodeStats{
+		Counters:    map[string]StatCounter{},
+		Gauges:      map[string]StatGauge{},
+		Histograms:  map[string]StatHistogram{},
+		RawResponse: raw,
+	}
+	if err := stats.decode("", raw); err != nil {
+		return nil, op.wrap(&Error{HTTPStatus: StatusBadResponse, Err: err})
+	}
+	return stats, nil
+}
+
+// decode walks the nested objects of raw, adding each statistic found.
+func (s *NodeStats) decode(path string, raw jsoniter.RawMessage) error {
+	var obj map[string]jsoniter.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		if path == "" {
+			return fmt.Errorf("invalid statistics: %s", err)
+		}
+		// Values other than objects are not statistics.
+		return nil
+	}
+	if value, ok := obj["value"]; ok {
+		var stat struct {
+			Type string `json:"type"`
+			Desc string `json:"desc"`
+		}
+		if err := json.Unmarshal(raw, &stat); err == nil && stat.Type != "" {
+			return s.add(path, stat.Type, stat.Desc, value)
+		}
+	}
+	for key, value := range obj {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		if err := s.decode(child, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NodeStats) add(path, typ, desc string, value jsoniter.RawMessage) error {
+	switch typ {
+	case "counter":
+		var v float64
+		if err := json.Unmarshal(value, &v); err != nil {
+			return fmt.Errorf("invalid counter %q: %s", path, err)
+		}
+		s.Counters[path] = StatCounter{Value: int64(v), Desc: desc}
+	case "gauge":
+		var v float64
+		if err := json.Unmarshal(value, &v); err != nil {
+			return fmt.Errorf("invalid gauge %q: %s", path, err)
+		}
+		s.Gauges[path] = StatGauge{Value: v, Desc: desc}
+	case "histogram":
+		h, err := decodeHistogram(value)
+		if err != nil {
+			return fmt.Errorf("invalid histogram %q: %s", path, err)
+		}
+		h.Desc = desc
+		s.Histograms[path] = *h
+	}
+	return nil
+}
+
+func decodeHistogram(value jsoniter.RawMessage) (*StatHistogram, error) {
+	var v struct {
+		N                 int64        `json:"n"`
+		Min               float64      `json:"min"`
+		Max               float64      `json:"max"`
+		ArithmeticMean    float64      `json:"arithmetic_mean"`
+		GeometricMean     float64      `json:"geometric_mean"`
+		HarmonicMean      float64      `json:"harmonic_mean"`
+		Median            float64      `json:"median"`
+		Variance          float64      `json:"variance"`
+		StandardDeviation float64      `json:"standard_deviation"`
+		Skewness          float64      `json:"skewness"`
+		Kurtosis          float64      `json:"kurtosis"`
+		Percentile        [][2]float64 `json:"percentile"`
+		Histogram         [][2]float64 `json:"histogram"`
+	}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil, err
+	}
+	h := &StatHistogram{
+		N:                 v.N,
+		Min:               v.Min,
+		Max:               v.Max,
+		ArithmeticMean:    v.ArithmeticMean,
+		GeometricMean:     v.GeometricMean,
+		HarmonicMean:      v.HarmonicMean,
+		Median:            v.Median,
+		Variance:          v.Variance,
+		StandardDeviation: v.StandardDeviation,
+		Skewness:          v.Skewness,
+		Kurtosis:          v.Kurtosis,
+	}
+	for _, p := range v.Percentile {
+		h.Percentiles = append(h.Percentiles, Percentile{Percentile: percentile(p[0]), Value: p[1]})
+	}
+	for _, b := range v.Histogram {
+		h.Buckets = append(h.Buckets, HistogramBucket{Value: b[0], Count: int64(b[1])})
+	}
+	return h, nil
+}
+
+// percentile converts CouchDB's percentile keys, which omit the decimal
+// point, such that 999 means 99.9, to percentages.
+func percentile(key float64) float64 {
+	s := strconv.FormatFloat(key, 'f', -1, 64)
+	if key <= 100 || strings.Contains(s, ".") {
+		return key
+	}
+	p, err := strconv.ParseFloat(s[:2]+"."+s[2:], 64)
+	if err != nil {
+		return key
+	}
+	return p
+}
+
+// NodeSystem returns the system metrics of node, which may be LocalNode, or
+// the name of a cluster node, such as returned by Membership. If node is
+// empty, LocalNode is used.
+func (c *Client) NodeSystem(ctx context.Context, node string) (system *NodeSystem, err error) {
+	op := c.op("NodeSystem", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	statser, ok := c.driverClient.(driver.NodeStatser)
+	if !ok {
+		return nil, op.wrap(nodeStatsNotImplemented)
+	}
+	if node == "" {
+		node = LocalNode
+	}
+	var raw jsoniter.RawMessage
+	err = c.retryPolicy(nil).do(ctx, func() (err error) {
+		raw, err = statser.NodeSystem(ctx, node)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	var v struct {
+		Uptime                  int64                   `json:"uptime"`
+		Memory                  MemoryStats             `json:"memory"`
+		RunQueue                int64                   `json:"run_queue"`
+		ETSTableCount           int64                   `json:"ets_table_count"`
+		ContextSwitches         int64                   `json:"context_switches"`
+		Reductions              int64                   `json:"reductions"`
+		GarbageCollectionCount  int64                   `json:"garbage_collection_count"`
+		WordsReclaimed          int64                   `json:"words_reclaimed"`
+		IOInput                 int64                   `json:"io_input"`
+		IOOutput                int64                   `json:"io_output"`
+		OSProcCount             int64                   `json:"os_proc_count"`
+		StaleProcCount          int64                   `json:"stale_proc_count"`
+		ProcessCount            int64                   `json:"process_count"`
+		ProcessLimit            int64                   `json:"process_limit"`
+		InternalReplicationJobs int64                   `json:"internal_replication_jobs"`
+		MessageQueues           map[string]MessageQueue `json:"message_queues"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, op.wrap(&Error{HTTPStatus: StatusBadResponse, Err: err})
+	}
+	return &NodeSystem{
+		Uptime:                  time.Duration(v.Uptime) * time.Second,
+		Memory:                  v.Memory,
+		RunQueue:                v.RunQueue,
+		ETSTableCount:           v.ETSTableCount,
+		ContextSwitches:         v.ContextSwitches,
+		Reductions:              v.Reductions,
+		GarbageCollectionCount:  v.GarbageCollectionCount,
+		WordsReclaimed:          v.WordsReclaimed,
+		IOInput:                 v.IOInput,
+		IOOutput:                v.IOOutput,
+		OSProcCount:             v.OSProcCount,
+		StaleProcCount:          v.StaleProcCount,
+		ProcessCount:            v.ProcessCount,
+		ProcessLimit:            v.ProcessLimit,
+		InternalReplicationJobs: v.InternalReplicationJobs,
+		MessageQueues:           v.MessageQueues,
+		RawResponse:             raw,
+	}, nil
+}
@@ -0,0 +1,246 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+const testNodeStats = `{
+	"couchdb": {
+		"open_databases": {"value": 3, "type": "counter", "desc": "number of open databases"},
+		"httpd": {
+			"requests": {"value": 120, "type": "counter", "desc": "number of HTTP requests"}
+		},
+		"request_time": {
+			"value": {
+				"min": 1, "max": 9, "arithmetic_mean": 4.5, "geometric_mean": 3.5,
+				"harmonic_mean": 2.5, "median": 4, "variance": 2, "standard_deviation": 1.4,
+				"skewness": 0.1, "kurtosis": 0.2, "n": 10,
+				"percentile": [[50, 4], [99, 8.5], [999, 9]],
+				"histogram": [[1, 2], [5, 8]]
+			},
+			"type": "histogram",
+			"desc": "length of a request inside CouchDB without MochiWeb"
+		}
+	},
+	"mem3": {
+		"shard_cache": {
+			"eviction": {"value": 0, "type": "counter", "desc": "number of shard cache evictions"}
+		}
+	},
+	"fabric": {
+		"open_shard": {"value": 0.5, "type": "gauge", "desc": "shard open rate"}
+	}
+}`
+
+func TestNodeStats(t *testing.T) {
+	type tt struct {
+		client driver.Client
+		node   string
+		want   *NodeStats
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		client: &mock.Client{},
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support node statistics",
+	})
+	tests.Add("client error", tt{
+		client: &mock.NodeStatser{
+			NodeStatsFunc: func(context.Context, string) (jsoniter.RawMessage, error) {
+				return nil, errors.New("client error")
+			},
+		},
+		status: http.StatusInternalServerError,
+		err:    "client error",
+	})
+	tests.Add("invalid response", tt{
+		client: &mock.NodeStatser{
+			NodeStatsFunc: func(context.Context, string) (jsoniter.RawMessage, error) {
+				return jsoniter.RawMessage(`[]`), nil
+			},
+		},
+		status: StatusBadResponse,
+		err:    "invalid statistics: ",
+	})
+	tests.Add("success", tt{
+		client: &mock.NodeStatser{
+			NodeStatsFunc: func(_ context.Context, node string) (jsoniter.RawMessage, error) {
+				if node != LocalNode {
+					return nil, errors.New("unexpected node " + node)
+				}
+				return jsoniter.RawMessage(testNodeStats), nil
+			},
+		},
+		want: &NodeStats{
+			Counters: map[string]StatCounter{
+				"couchdb.open_databases":    {Value: 3, Desc: "number of open databases"},
+				"couchdb.httpd.requests":    {Value: 120, Desc: "number of HTTP requests"},
+				"mem3.shard_cache.eviction": {Desc: "number of shard cache evictions"},
+			},
+			Gauges: map[string]StatGauge{
+				"fabric.open_shard": {Value: 0.5, Desc: "shard open rate"},
+			},
+			Histograms: map[string]StatHistogram{
+				"couchdb.request_time": {
+					Desc:              "length of a request inside CouchDB without MochiWeb",
+					N:                 10,
+					Min:               1,
+					Max:               9,
+					ArithmeticMean:    4.5,
+					GeometricMean:     3.5,
+					HarmonicMean:      2.5,
+					Median:            4,
+					Variance:          2,
+					StandardDeviation: 1.4,
+					Skewness:          0.1,
+					Kurtosis:          0.2,
+					Percentiles: []Percentile{
+						{Percentile: 50, Value: 4},
+						{Percentile: 99, Value: 8.5},
+						{Percentile: 99.9, Value: 9},
+					},
+					Buckets: []HistogramBucket{
+						{Value: 1, Count: 2},
+						{Value: 5, Count: 8},
+					},
+				},
+			},
+			RawResponse: jsoniter.RawMessage(testNodeStats),
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		c := &Client{driverClient: tt.client}
+		got, err := c.NodeStats(context.Background(), tt.node)
+		testy.StatusErrorRE(t, "^"+tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+const testNodeSystem = `{
+	"uptime": 3600,
+	"memory": {
+		"other": 1, "atom": 2, "atom_used": 3, "processes": 4,
+		"processes_used": 5, "binary": 6, "code": 7, "ets": 8
+	},
+	"run_queue": 1,
+	"ets_table_count": 150,
+	"context_switches": 1000,
+	"reductions": 2000,
+	"garbage_collection_count": 300,
+	"words_reclaimed": 4000,
+	"io_input": 500,
+	"io_output": 600,
+	"os_proc_count": 2,
+	"stale_proc_count": 0,
+	"process_count": 900,
+	"process_limit": 262144,
+	"internal_replication_jobs": 0,
+	"message_queues": {
+		"couch_server": 3,
+		"couch_db_updater": {"count": 4, "min": 0, "max": 10, "50": 1, "90": 8, "99": 10}
+	},
+	"distribution": {}
+}`
+
+func TestNodeSystem(t *testing.T) {
+	type tt struct {
+		client driver.Client
+		node   string
+		want   *NodeSystem
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		client: &mock.Client{},
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support node statistics",
+	})
+	tests.Add("client error", tt{
+		client: &mock.NodeStatser{
+			NodeSystemFunc: func(context.Context, string) (jsoniter.RawMessage, error) {
+				return nil, errors.New("client error")
+			},
+		},
+		status: http.StatusInternalServerError,
+		err:    "client error",
+	})
+	tests.Add("success", tt{
+		client: &mock.NodeStatser{
+			NodeSystemFunc: func(_ context.Context, node string) (jsoniter.RawMessage, error) {
+				if node != "node1@127.0.0.1" {
+					return nil, errors.New("unexpected node " + node)
+				}
+				return jsoniter.RawMessage(testNodeSystem), nil
+			},
+		},
+		node: "node1@127.0.0.1",
+		want: &NodeSystem{
+			Uptime: time.Hour,
+			Memory: MemoryStats{
+				Other:         1,
+				Atom:          2,
+				AtomUsed:      3,
+				Processes:     4,
+				ProcessesUsed: 5,
+				Binary:        6,
+				Code:          7,
+				ETS:           8,
+			},
+			RunQueue:               1,
+			ETSTableCount:          150,
+			ContextSwitches:        1000,
+			Reductions:             2000,
+			GarbageCollectionCount: 300,
+			WordsReclaimed:         4000,
+			IOInput:                500,
+			IOOutput:               600,
+			OSProcCount:            2,
+			ProcessCount:           900,
+			ProcessLimit:           262144,
+			MessageQueues: map[string]MessageQueue{
+				"couch_server":     {Count: 1, Min: 3, Max: 3, Median: 3, P90: 3, P99: 3},
+				"couch_db_updater": {Count: 4, Max: 10, Median: 1, P90: 8, P99: 10},
+			},
+			RawResponse: jsoniter.RawMessage(testNodeSystem),
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		c := &Client{driverClient: tt.client}
+		got, err := c.NodeSystem(context.Background(), tt.node)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
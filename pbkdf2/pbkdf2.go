@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package pbkdf2 hashes and verifies passwords the way CouchDB stores them in
+// user documents of the _users database, with the pbkdf2 password scheme.
+//
+// In-process drivers may use it to hash the plain-text password of a user
+// document when it is stored, as CouchDB does:
+//
+//	creds, err := pbkdf2.New(password, 0)
+//
+// and to verify credentials against a stored user document:
+//
+//	var creds pbkdf2.Credentials
+//	if err := json.Unmarshal(userDoc, &creds); err != nil {
+//		return err
+//	}
+//	ok, err := creds.Verify(password)
+package pbkdf2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+
+	"github.com/dannyzhou2015/kivik/v4/errors"
+)
+
+const (
+	// Scheme is the value of the password_scheme field of a user document
+	// whose password is hashed with pbkdf2.
+	Scheme = "pbkdf2"
+
+	// DefaultPRF is the pseudo-random function used by New.
+	DefaultPRF = "sha256"
+
+	// DefaultIterations is the number of iterations used by New, when none
+	// is specified. It matches the default of CouchDB 3.4.
+	DefaultIterations = 600000
+
+	// saltSize is the number of random bytes in a salt.
+	saltSize = 16
+)
+
+// prfs maps the values of the pbkdf2_prf field to hash functions. CouchDB
+// uses sha1 when the field is absent.
+var prfs = map[string]func() hash.Hash{
+	"":       sha1.New,
+	"sha":    sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// Credentials are the password fields of a CouchDB user document.
+type Credentials struct {
+	Scheme     string `json:"password_scheme"`
+	PRF        string `json:"pbkdf2_prf,omitempty"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	DerivedKey string `json:"derived_key"`
+}
+
+// New hashes password with a random salt, and returns the resulting
+// credentials. If iterations is zero or less, DefaultIterations is used.
+func New(password string, iterations int) (*Credentials, error) {
+	if iterations <= 0 {
+		iterations = DefaultIterations
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	creds := &Credentials{
+		Scheme:     Scheme,
+		PRF:        DefaultPRF,
+		Iterations: iterations,
+		Salt:       hex.EncodeToString(salt),
+	}
+	creds.DerivedKey = hex.EncodeToString(creds.derive(password, prfs[DefaultPRF]))
+	return creds, nil
+}
+
+// Verify returns true if password matches the credentials. An error is
+// returned if the credentials use an unsupported scheme or pseudo-random
+// function.
+func (c *Credentials) Verify(password string) (bool, error) {
+	if c.Scheme != Scheme {
+		return false, errors.Statusf(http.StatusBadRequest, "pbkdf2: unsupported password scheme %q", c.Scheme)
+	}
+	h, ok := prfs[c.PRF]
+	if !ok {
+		return false, errors.Statusf(http.StatusBadRequest, "pbkdf2: unsupported pseudo-random function %q", c.PRF)
+	}
+	if c.Iterations <= 0 {
+		return false, errors.Status(http.StatusBadRequest, "pbkdf2: invalid iteration count")
+	}
+	want, err := hex.DecodeString(c.DerivedKey)
+	if err != nil {
+		return false, errors.WrapStatus(http.StatusBadRequest, err)
+	}
+	return hmac.Equal(want, c.derive(password, h)), nil
+}
+
+// derive returns the derived key of password. As CouchDB does, the salt is
+// used as-is, rather than hex-decoded, and the key is the size of the hash.
+func (c *Credentials) derive(password string, h func() hash.Hash) []byte {
+	return Key([]byte(password), []byte(c.Salt), c.Iterations, h().Size(), h)
+}
+
+// Key derives a key of keyLen bytes from password and salt, with iter
+// iterations of the pseudo-random function HMAC-h, as described by RFC 8018.
+func Key(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	key := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
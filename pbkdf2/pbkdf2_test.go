@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package pbkdf2
+
+import (
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		h              func() hash.Hash
+		want           string
+	}{
+		// RFC 6070 test vectors
+		{"password", "salt", 1, 20, sha1.New, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 4096, 20, sha1.New, "4b007901b765489abead49d926f721d065a429c1"},
+		{"password", "salt", 2, 40, sha256.New, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43830651afcb5c862f"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(Key([]byte(test.password), []byte(test.salt), test.iter, test.keyLen, test.h))
+		if got != test.want {
+			t.Errorf("Unexpected key for %d iterations: %s", test.iter, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	type tt struct {
+		creds    *Credentials
+		password string
+		want     bool
+		status   int
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("CouchDB sha1", tt{
+		creds: &Credentials{
+			Scheme:     "pbkdf2",
+			Iterations: 10,
+			Salt:       "4e170ffeb6f34daecfd814dfb4001a73",
+			DerivedKey: "ad2ae45dc51bf8ceebe9cb9da8eb31fa9648a5f6",
+		},
+		password: "abc123",
+		want:     true,
+	})
+	tests.Add("wrong password", tt{
+		creds: &Credentials{
+			Scheme:     "pbkdf2",
+			Iterations: 10,
+			Salt:       "4e170ffeb6f34daecfd814dfb4001a73",
+			DerivedKey: "ad2ae45dc51bf8ceebe9cb9da8eb31fa9648a5f6",
+		},
+		password: "abc124",
+		want:     false,
+	})
+	tests.Add("simple scheme", tt{
+		creds:  &Credentials{Scheme: "simple"},
+		status: http.StatusBadRequest,
+		err:    `pbkdf2: unsupported password scheme "simple"`,
+	})
+	tests.Add("unsupported prf", tt{
+		creds:  &Credentials{Scheme: "pbkdf2", PRF: "md5", Iterations: 10},
+		status: http.StatusBadRequest,
+		err:    `pbkdf2: unsupported pseudo-random function "md5"`,
+	})
+	tests.Add("invalid derived key", tt{
+		creds:  &Credentials{Scheme: "pbkdf2", Iterations: 10, DerivedKey: "xyz"},
+		status: http.StatusBadRequest,
+		err:    "encoding/hex: invalid byte: U+0078 'x'",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		got, err := tt.creds.Verify(tt.password)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if got != tt.want {
+			t.Errorf("Unexpected result: %t", got)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	creds, err := New("abc123", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Scheme != Scheme || creds.PRF != DefaultPRF || creds.Iterations != 100 || len(creds.Salt) != 2*saltSize || len(creds.DerivedKey) != 2*sha256.Size {
+		t.Errorf("Unexpected credentials: %+v", creds)
+	}
+	if ok, err := creds.Verify("abc123"); !ok || err != nil {
+		t.Errorf("Failed to verify password: %t, %v", ok, err)
+	}
+	other, err := New("abc123", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Salt == creds.Salt {
+		t.Error("Expected a random salt")
+	}
+}
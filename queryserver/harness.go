@@ -0,0 +1,93 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package queryserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Harness drives a Server with canned protocol messages, as CouchDB would, so
+// that registered functions can be tested without CouchDB. A Harness keeps
+// the same state as a single query server process: added functions and cached
+// design documents persist until reset.
+//
+//	h := queryserver.NewHarness(s)
+//	_, _ = h.Send("add_fun", "by_type")
+//	resp, err := h.Send("map_doc", map[string]interface{}{"_id": "foo", "type": "bar"})
+//	// resp is [[["bar",null]]]
+type Harness struct {
+	sess *session
+	logs []string
+}
+
+// NewHarness returns a new Harness for s.
+func NewHarness(s *Server) *Harness {
+	return &Harness{sess: s.newSession()}
+}
+
+// Send sends a command, made up of the command name and its arguments, each
+// of which is marshaled to JSON. It returns the server's response. If the
+// server responds with an error, it is returned as an *Error.
+func (h *Harness) Send(command string, args ...interface{}) (jsoniter.RawMessage, error) {
+	line, err := json.Marshal(append([]interface{}{command}, args...))
+	if err != nil {
+		return nil, err
+	}
+	return h.SendLine(string(line))
+}
+
+// SendLine sends a raw command line, as CouchDB would write it, and returns
+// the server's response, as Send does.
+func (h *Harness) SendLine(line string) (jsoniter.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := h.sess.handle([]byte(line), &buf); err != nil {
+		return nil, err
+	}
+	h.logs = nil
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, l := range lines[:len(lines)-1] {
+		var msg [2]string
+		if err := json.Unmarshal([]byte(l), &msg); err != nil || msg[0] != "log" {
+			return nil, fmt.Errorf("unexpected output: %s", l)
+		}
+		h.logs = append(h.logs, msg[1])
+	}
+	resp := jsoniter.RawMessage(lines[len(lines)-1])
+	var errResp []interface{}
+	if json.Unmarshal(resp, &errResp) == nil && len(errResp) == 3 && errResp[0] == "error" {
+		id, _ := errResp[1].(string)
+		reason, _ := errResp[2].(string)
+		return resp, &Error{ID: id, Reason: reason}
+	}
+	return resp, nil
+}
+
+// Logs returns the messages logged while handling the most recent command.
+func (h *Harness) Logs() []string {
+	return h.logs
+}
+
+// Replay sends each non-empty line read from r to the server, and returns
+// the full output of the server, including log messages, as Serve would
+// write it. It is intended for comparing the server's output with a
+// transcript of the expected responses.
+func (h *Harness) Replay(r io.Reader) (string, error) {
+	var out bytes.Buffer
+	err := h.sess.serve(r, &out, func() error { return nil })
+	return out.String(), err
+}
@@ -0,0 +1,428 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package queryserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// session holds the state of one connection with CouchDB: the map functions
+// added since the last reset, and the cached design documents.
+type session struct {
+	s     *Server
+	funs  []MapFunc
+	ddocs map[string]map[string]interface{}
+	// logs collects messages logged while handling a command.
+	logs []string
+}
+
+func (s *Server) newSession() *session {
+	return &session{
+		s:     s,
+		ddocs: make(map[string]map[string]interface{}),
+	}
+}
+
+// Error is an error reported to CouchDB as ["error", id, reason].
+type Error struct {
+	ID     string
+	Reason string
+}
+
+func (e *Error) Error() string { return e.ID + ": " + e.Reason }
+
+func newError(id, format string, args ...interface{}) error {
+	return &Error{ID: id, Reason: fmt.Sprintf(format, args...)}
+}
+
+// serve handles each non-empty line read from r, calling flush after each
+// response is written to w.
+func (ss *session) serve(r io.Reader, w io.Writer, flush func() error) error {
+	in := bufio.NewReader(r)
+	for {
+		line, err := in.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if err := ss.handle(line, w); err != nil {
+				return err
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle processes a single command line, and writes the log messages and
+// response to w. Only errors writing to w are returned.
+func (ss *session) handle(line []byte, w io.Writer) error {
+	ss.logs = nil
+	resp, err := ss.dispatch(line)
+	if err != nil {
+		var perr *Error
+		if !errors.As(err, &perr) {
+			perr = &Error{ID: "unknown_error", Reason: err.Error()}
+		}
+		resp = []interface{}{"error", perr.ID, perr.Reason}
+	}
+	for _, msg := range ss.logs {
+		if err := writeLine(w, []interface{}{"log", msg}); err != nil {
+			return err
+		}
+	}
+	return writeLine(w, resp)
+}
+
+func writeLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (ss *session) log(format string, args ...interface{}) {
+	ss.logs = append(ss.logs, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) dispatch(line []byte) (interface{}, error) {
+	var cmd []jsoniter.RawMessage
+	if err := json.Unmarshal(line, &cmd); err != nil || len(cmd) == 0 {
+		return nil, newError("query_protocol_error", "invalid command: %s", line)
+	}
+	var name string
+	if err := json.Unmarshal(cmd[0], &name); err != nil {
+		return nil, newError("query_protocol_error", "invalid command: %s", line)
+	}
+	args := cmd[1:]
+	switch name {
+	case "reset":
+		ss.funs = nil
+		return true, nil
+	case "add_lib":
+		// Libraries are only meaningful to JavaScript functions.
+		return true, nil
+	case "add_fun":
+		return ss.addFun(args)
+	case "map_doc":
+		return ss.mapDoc(args)
+	case "reduce":
+		return ss.reduce(args, false)
+	case "rereduce":
+		return ss.reduce(args, true)
+	case "ddoc":
+		return ss.ddoc(args)
+	}
+	return nil, newError("unknown_command", "unknown command '%s'", name)
+}
+
+// decodeArgs decodes the leading elements of args into dest. Null arguments
+// leave their destinations unchanged.
+func decodeArgs(args []jsoniter.RawMessage, dest ...interface{}) error {
+	if len(args) < len(dest) {
+		return newError("query_protocol_error", "expected %d arguments, got %d", len(dest), len(args))
+	}
+	for i, d := range dest {
+		if len(bytes.TrimSpace(args[i])) == 0 {
+			// A null element of an array decodes to an empty RawMessage.
+			continue
+		}
+		if err := json.Unmarshal(args[i], d); err != nil {
+			return newError("query_protocol_error", "invalid argument %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (ss *session) addFun(args []jsoniter.RawMessage) (interface{}, error) {
+	var src string
+	if err := decodeArgs(args, &src); err != nil {
+		return nil, err
+	}
+	fn, ok := ss.s.mapFunc(src)
+	if !ok {
+		return nil, newError("compilation_error", "unknown map function: %s", name(src))
+	}
+	ss.funs = append(ss.funs, fn)
+	return true, nil
+}
+
+// callMap calls fn for doc, and returns the emitted key/value pairs. If fn
+// panics, the panic is logged and nothing is emitted.
+func (ss *session) callMap(fn MapFunc, doc map[string]interface{}) (rows [][2]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			ss.log("map function raised exception (%v) with doc._id %v", r, doc["_id"])
+			rows = [][2]interface{}{}
+		}
+	}()
+	rows = [][2]interface{}{}
+	fn(doc, func(key, value interface{}) {
+		rows = append(rows, [2]interface{}{key, value})
+	})
+	return rows
+}
+
+func (ss *session) mapDoc(args []jsoniter.RawMessage) (interface{}, error) {
+	var raw jsoniter.RawMessage
+	if err := decodeArgs(args, &raw); err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(ss.funs))
+	for i, fn := range ss.funs {
+		// Each function receives its own copy, so that a function which
+		// modifies the document cannot affect another.
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, newError("query_protocol_error", "invalid document: %s", err)
+		}
+		results[i] = ss.callMap(fn, doc)
+	}
+	return results, nil
+}
+
+func (ss *session) reduce(args []jsoniter.RawMessage, rereduce bool) (interface{}, error) {
+	var srcs []string
+	var keys []KeyID
+	var values []interface{}
+	if rereduce {
+		if err := decodeArgs(args, &srcs, &values); err != nil {
+			return nil, err
+		}
+	} else {
+		var kvs [][2]jsoniter.RawMessage
+		if err := decodeArgs(args, &srcs, &kvs); err != nil {
+			return nil, err
+		}
+		keys = make([]KeyID, len(kvs))
+		values = make([]interface{}, len(kvs))
+		for i, kv := range kvs {
+			var keyID [2]interface{}
+			if err := json.Unmarshal(kv[0], &keyID); err != nil {
+				return nil, newError("query_protocol_error", "invalid key: %s", err)
+			}
+			keys[i].Key = keyID[0]
+			keys[i].ID, _ = keyID[1].(string)
+			if err := json.Unmarshal(kv[1], &values[i]); err != nil {
+				return nil, newError("query_protocol_error", "invalid value: %s", err)
+			}
+		}
+	}
+	results := make([]interface{}, len(srcs))
+	for i, src := range srcs {
+		fn, ok := ss.s.reduceFunc(src)
+		if !ok {
+			return nil, newError("compilation_error", "unknown reduce function: %s", name(src))
+		}
+		result, err := callReduce(fn, keys, values, rereduce)
+		if err != nil {
+			return nil, newError("reduce_error", "%s", err)
+		}
+		results[i] = result
+	}
+	return []interface{}{true, results}, nil
+}
+
+func callReduce(fn ReduceFunc, keys []KeyID, values []interface{}, rereduce bool) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("reduce function raised exception (%v)", r)
+		}
+	}()
+	return fn(keys, values, rereduce)
+}
+
+// ddoc handles the ddoc command, which either caches a design document, or
+// calls one of its functions.
+func (ss *session) ddoc(args []jsoniter.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeArgs(args, &id); err != nil {
+		return nil, err
+	}
+	if id == "new" {
+		var ddoc map[string]interface{}
+		if err := decodeArgs(args[1:], &id, &ddoc); err != nil {
+			return nil, err
+		}
+		ss.ddocs[id] = ddoc
+		return true, nil
+	}
+	ddoc, ok := ss.ddocs[id]
+	if !ok {
+		return nil, newError("query_protocol_error", "uncached design doc: %s", id)
+	}
+	var path []string
+	var fnArgs []jsoniter.RawMessage
+	if err := decodeArgs(args[1:], &path, &fnArgs); err != nil {
+		return nil, err
+	}
+	src, err := resolve(ddoc, path)
+	if err != nil {
+		return nil, err
+	}
+	switch path[0] {
+	case "filters":
+		return ss.filter(src, fnArgs)
+	case "views":
+		return ss.viewFilter(src, fnArgs)
+	case "validate_doc_update":
+		return ss.validate(src, fnArgs)
+	case "updates":
+		return ss.update(src, fnArgs)
+	}
+	return nil, newError("not_implemented", "%s functions are not supported", path[0])
+}
+
+// resolve returns the function source found at path in ddoc.
+func resolve(ddoc map[string]interface{}, path []string) (string, error) {
+	if len(path) == 0 {
+		return "", newError("query_protocol_error", "empty function path")
+	}
+	var v interface{} = ddoc
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			v = nil
+			break
+		}
+		v = m[p]
+	}
+	src, ok := v.(string)
+	if !ok {
+		return "", newError("not_found", "missing function: %v", path)
+	}
+	return src, nil
+}
+
+func (ss *session) filter(src string, args []jsoniter.RawMessage) (interface{}, error) {
+	fn, ok := ss.s.filterFunc(src)
+	if !ok {
+		return nil, newError("compilation_error", "unknown filter function: %s", name(src))
+	}
+	var docs []map[string]interface{}
+	var req map[string]interface{}
+	if err := decodeArgs(args, &docs, &req); err != nil {
+		return nil, err
+	}
+	results := make([]bool, len(docs))
+	for i, doc := range docs {
+		results[i] = ss.callFilter(fn, doc, req)
+	}
+	return []interface{}{true, results}, nil
+}
+
+func (ss *session) callFilter(fn FilterFunc, doc, req map[string]interface{}) (pass bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ss.log("filter function raised exception (%v) with doc._id %v", r, doc["_id"])
+			pass = false
+		}
+	}()
+	return fn(doc, req)
+}
+
+// viewFilter filters documents with a view's map function. A document passes
+// if the function emits at least one row for it.
+func (ss *session) viewFilter(src string, args []jsoniter.RawMessage) (interface{}, error) {
+	fn, ok := ss.s.mapFunc(src)
+	if !ok {
+		return nil, newError("compilation_error", "unknown map function: %s", name(src))
+	}
+	var docs []map[string]interface{}
+	if err := decodeArgs(args, &docs); err != nil {
+		return nil, err
+	}
+	results := make([]bool, len(docs))
+	for i, doc := range docs {
+		results[i] = len(ss.callMap(fn, doc)) > 0
+	}
+	return []interface{}{true, results}, nil
+}
+
+func (ss *session) validate(src string, args []jsoniter.RawMessage) (interface{}, error) {
+	fn, ok := ss.s.validateFunc(src)
+	if !ok {
+		return nil, newError("compilation_error", "unknown validate function: %s", name(src))
+	}
+	var newDoc, oldDoc, secObj map[string]interface{}
+	var userCtx *UserContext
+	if err := decodeArgs(args, &newDoc, &oldDoc, &userCtx, &secObj); err != nil {
+		return nil, err
+	}
+	err := callValidate(fn, newDoc, oldDoc, userCtx, secObj)
+	if err == nil {
+		return 1, nil
+	}
+	var unauthorized Unauthorized
+	if errors.As(err, &unauthorized) {
+		return map[string]string{"unauthorized": string(unauthorized)}, nil
+	}
+	var forbidden Forbidden
+	if errors.As(err, &forbidden) {
+		return map[string]string{"forbidden": string(forbidden)}, nil
+	}
+	return map[string]string{"forbidden": err.Error()}, nil
+}
+
+func callValidate(fn ValidateFunc, newDoc, oldDoc map[string]interface{}, userCtx *UserContext, secObj map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("validate function raised exception (%v)", r)
+		}
+	}()
+	return fn(newDoc, oldDoc, userCtx, secObj)
+}
+
+func (ss *session) update(src string, args []jsoniter.RawMessage) (interface{}, error) {
+	fn, ok := ss.s.updateFunc(src)
+	if !ok {
+		return nil, newError("compilation_error", "unknown update function: %s", name(src))
+	}
+	var doc, req map[string]interface{}
+	if err := decodeArgs(args, &doc, &req); err != nil {
+		return nil, err
+	}
+	newDoc, resp, err := callUpdate(fn, doc, req)
+	if err != nil {
+		return nil, newError("render_error", "%s", err)
+	}
+	if resp == nil {
+		resp = &Response{}
+	}
+	var saved interface{}
+	if newDoc != nil {
+		saved = newDoc
+	}
+	return []interface{}{"up", saved, resp}, nil
+}
+
+func callUpdate(fn UpdateFunc, doc, req map[string]interface{}) (newDoc map[string]interface{}, resp *Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("update function raised exception (%v)", r)
+		}
+	}()
+	return fn(doc, req)
+}
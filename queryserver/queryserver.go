@@ -0,0 +1,235 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package queryserver implements the CouchDB query server protocol, so that
+// view, filter, validate_doc_update and update functions may be written in Go,
+// and run by CouchDB in place of couchjs.
+//
+// Functions are registered with a Server by name. In the design document, the
+// source of each function is simply the name it was registered with, and the
+// design document's language is whatever name the query server is configured
+// under in CouchDB:
+//
+//	{
+//		"_id": "_design/example",
+//		"language": "go",
+//		"views": {
+//			"by_type": {"map": "by_type", "reduce": "_count"}
+//		},
+//		"validate_doc_update": "require_type"
+//	}
+//
+// The query server is a program which registers its functions, then calls
+// Serve with its standard input and output:
+//
+//	func main() {
+//		s := queryserver.New()
+//		s.RegisterMap("by_type", func(doc map[string]interface{}, emit func(key, value interface{})) {
+//			emit(doc["type"], nil)
+//		})
+//		s.RegisterValidate("require_type", func(newDoc, _ map[string]interface{}, _ *queryserver.UserContext, _ map[string]interface{}) error {
+//			if _, ok := newDoc["type"]; !ok {
+//				return queryserver.Forbidden("type is required")
+//			}
+//			return nil
+//		})
+//		if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// CouchDB is then configured to run the program for the "go" language, for
+// example with the environment variable COUCHDB_QUERY_SERVER_GO set to the
+// program's path.
+//
+// Harness drives a Server with canned protocol messages, so that functions may
+// be tested without CouchDB.
+package queryserver // import "github.com/dannyzhou2015/kivik/v4/queryserver"
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// MapFunc is a view map function. It is called for each document, and may
+// call emit any number of times. A MapFunc which panics emits nothing for the
+// document, and the panic is logged to CouchDB.
+type MapFunc func(doc map[string]interface{}, emit func(key, value interface{}))
+
+// KeyID is the key and document ID of a row passed to a reduce function.
+type KeyID struct {
+	Key interface{}
+	ID  string
+}
+
+// ReduceFunc is a view reduce function. On the first pass, keys holds the key
+// and document ID of each value. When rereduce is true, values are the results
+// of previous calls, and keys is nil.
+type ReduceFunc func(keys []KeyID, values []interface{}, rereduce bool) (interface{}, error)
+
+// FilterFunc is a changes feed filter function. It returns true for documents
+// which should be included in the feed. req is the HTTP request, as described
+// in the CouchDB documentation for the request object.
+type FilterFunc func(doc map[string]interface{}, req map[string]interface{}) bool
+
+// UserContext is the user context object passed to validate functions.
+type UserContext struct {
+	DB    string   `json:"db"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// ValidateFunc is a validate_doc_update function. oldDoc is nil when the
+// document is being created. secObj is the database's security object. To
+// reject the update, the function returns Forbidden or Unauthorized. Any other
+// error also rejects the update, as if it were Forbidden.
+type ValidateFunc func(newDoc, oldDoc map[string]interface{}, userCtx *UserContext, secObj map[string]interface{}) error
+
+// Forbidden is returned by a ValidateFunc to reject an update with a
+// 403 Forbidden response.
+type Forbidden string
+
+func (e Forbidden) Error() string { return string(e) }
+
+// Unauthorized is returned by a ValidateFunc to reject an update with a
+// 401 Unauthorized response.
+type Unauthorized string
+
+func (e Unauthorized) Error() string { return string(e) }
+
+// Response is the HTTP response returned by an update function. Only one of
+// Body, JSON or Base64 should be set.
+type Response struct {
+	Code    int               `json:"code,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	JSON    interface{}       `json:"json,omitempty"`
+	Base64  string            `json:"base64,omitempty"`
+}
+
+// UpdateFunc is an update handler. doc is nil if the request did not name an
+// existing document. The function returns the document to save, or nil to
+// save nothing, and the response to send to the client.
+type UpdateFunc func(doc map[string]interface{}, req map[string]interface{}) (map[string]interface{}, *Response, error)
+
+// Server is a query server. Functions must be registered before calling
+// Serve.
+type Server struct {
+	mu        sync.RWMutex
+	maps      map[string]MapFunc
+	reduces   map[string]ReduceFunc
+	filters   map[string]FilterFunc
+	validates map[string]ValidateFunc
+	updates   map[string]UpdateFunc
+}
+
+// New returns a new Server, with no functions registered.
+func New() *Server {
+	return &Server{
+		maps:      make(map[string]MapFunc),
+		reduces:   make(map[string]ReduceFunc),
+		filters:   make(map[string]FilterFunc),
+		validates: make(map[string]ValidateFunc),
+		updates:   make(map[string]UpdateFunc),
+	}
+}
+
+// RegisterMap registers a map function, which may be used by views, and as
+// a changes feed filter with the _view filter.
+func (s *Server) RegisterMap(name string, fn MapFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maps[name] = fn
+}
+
+// RegisterReduce registers a reduce function.
+func (s *Server) RegisterReduce(name string, fn ReduceFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reduces[name] = fn
+}
+
+// RegisterFilter registers a changes feed filter function.
+func (s *Server) RegisterFilter(name string, fn FilterFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters[name] = fn
+}
+
+// RegisterValidate registers a validate_doc_update function.
+func (s *Server) RegisterValidate(name string, fn ValidateFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validates[name] = fn
+}
+
+// RegisterUpdate registers an update handler.
+func (s *Server) RegisterUpdate(name string, fn UpdateFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates[name] = fn
+}
+
+// Serve reads commands from r, one per line, and writes responses to w, until
+// r is exhausted. It returns nil at the end of input, or the first error
+// reading from r or writing to w. Errors in commands and functions are
+// reported to CouchDB, and do not stop the server.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	out := bufio.NewWriter(w)
+	return s.newSession().serve(r, out, out.Flush)
+}
+
+// name returns the registered name referred to by function source src.
+func name(src string) string {
+	return strings.TrimSpace(src)
+}
+
+func (s *Server) mapFunc(src string) (MapFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.maps[name(src)]
+	return fn, ok
+}
+
+func (s *Server) reduceFunc(src string) (ReduceFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.reduces[name(src)]
+	return fn, ok
+}
+
+func (s *Server) filterFunc(src string) (FilterFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.filters[name(src)]
+	return fn, ok
+}
+
+func (s *Server) validateFunc(src string) (ValidateFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.validates[name(src)]
+	return fn, ok
+}
+
+func (s *Server) updateFunc(src string) (UpdateFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.updates[name(src)]
+	return fn, ok
+}
@@ -0,0 +1,211 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package queryserver
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func testServer() *Server {
+	s := New()
+	s.RegisterMap("by_type", func(doc map[string]interface{}, emit func(key, value interface{})) {
+		if t, ok := doc["type"]; ok {
+			emit(t, doc["n"])
+		}
+	})
+	s.RegisterMap("strict", func(doc map[string]interface{}, emit func(key, value interface{})) {
+		emit(doc["type"].(string), nil)
+	})
+	s.RegisterReduce("sum", func(_ []KeyID, values []interface{}, _ bool) (interface{}, error) {
+		var sum float64
+		for _, v := range values {
+			n, ok := v.(float64)
+			if !ok {
+				return nil, errors.New("not a number")
+			}
+			sum += n
+		}
+		return sum, nil
+	})
+	s.RegisterReduce("ids", func(keys []KeyID, values []interface{}, rereduce bool) (interface{}, error) {
+		if rereduce {
+			return len(values), nil
+		}
+		ids := make([]string, len(keys))
+		for i, k := range keys {
+			ids[i] = k.ID
+		}
+		return ids, nil
+	})
+	s.RegisterFilter("fruit", func(doc, req map[string]interface{}) bool {
+		return doc["type"] == "fruit"
+	})
+	s.RegisterValidate("require_type", func(newDoc, oldDoc map[string]interface{}, userCtx *UserContext, _ map[string]interface{}) error {
+		if userCtx.Name == "" {
+			return Unauthorized("login required")
+		}
+		if _, ok := newDoc["type"]; !ok {
+			return Forbidden("type is required")
+		}
+		if oldDoc != nil && oldDoc["type"] != newDoc["type"] {
+			return errors.New("type is immutable")
+		}
+		return nil
+	})
+	s.RegisterUpdate("touch", func(doc, req map[string]interface{}) (map[string]interface{}, *Response, error) {
+		if doc == nil {
+			return nil, &Response{Code: 404, Body: "missing"}, nil
+		}
+		doc["touched"] = true
+		return doc, &Response{JSON: map[string]interface{}{"ok": true}}, nil
+	})
+	return s
+}
+
+func TestHarness(t *testing.T) {
+	type step struct {
+		cmd  string
+		args []interface{}
+		want string
+		err  string
+		logs []string
+	}
+	type tt struct {
+		steps []step
+	}
+
+	fruit := map[string]interface{}{"_id": "a", "type": "fruit", "n": 1}
+	veg := map[string]interface{}{"_id": "b", "type": "veg"}
+	ddoc := map[string]interface{}{
+		"_id":                 "_design/foo",
+		"filters":             map[string]interface{}{"f": "fruit", "missing": "nope"},
+		"views":               map[string]interface{}{"v": map[string]interface{}{"map": "strict"}},
+		"validate_doc_update": "require_type",
+		"updates":             map[string]interface{}{"u": "touch"},
+		"shows":               map[string]interface{}{"s": "show"},
+	}
+	newDDoc := step{cmd: "ddoc", args: []interface{}{"new", "_design/foo", ddoc}, want: `true`}
+
+	tests := testy.NewTable()
+	tests.Add("map", tt{steps: []step{
+		{cmd: "reset", args: []interface{}{map[string]interface{}{}}, want: `true`},
+		{cmd: "add_fun", args: []interface{}{" by_type\n"}, want: `true`},
+		{cmd: "add_fun", args: []interface{}{"strict"}, want: `true`},
+		{cmd: "map_doc", args: []interface{}{fruit}, want: `[[["fruit",1]],[["fruit",null]]]`},
+		{
+			cmd:  "map_doc",
+			args: []interface{}{map[string]interface{}{"_id": "c"}},
+			want: `[[],[]]`,
+			logs: []string{"map function raised exception (interface conversion: interface {} is nil, not string) with doc._id c"},
+		},
+		{cmd: "reset", want: `true`},
+		{cmd: "map_doc", args: []interface{}{fruit}, want: `[]`},
+	}})
+	tests.Add("unknown map function", tt{steps: []step{
+		{cmd: "add_fun", args: []interface{}{"function(doc) { emit(doc._id) }"}, err: "compilation_error: unknown map function: function(doc) { emit(doc._id) }"},
+	}})
+	tests.Add("reduce", tt{steps: []step{
+		{
+			cmd:  "reduce",
+			args: []interface{}{[]string{"sum", "ids"}, []interface{}{[]interface{}{[]interface{}{"x", "a"}, 1}, []interface{}{[]interface{}{"y", "b"}, 2}}},
+			want: `[true,[3,["a","b"]]]`,
+		},
+		{cmd: "rereduce", args: []interface{}{[]string{"sum", "ids"}, []interface{}{3, 4}}, want: `[true,[7,2]]`},
+		{cmd: "rereduce", args: []interface{}{[]string{"sum"}, []interface{}{"x"}}, err: "reduce_error: not a number"},
+		{cmd: "rereduce", args: []interface{}{[]string{"avg"}, []interface{}{1}}, err: "compilation_error: unknown reduce function: avg"},
+	}})
+	tests.Add("filters", tt{steps: []step{
+		newDDoc,
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"filters", "f"}, []interface{}{[]interface{}{fruit, veg}, map[string]interface{}{}}}, want: `[true,[true,false]]`},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"views", "v", "map"}, []interface{}{[]interface{}{fruit, map[string]interface{}{}}}}, want: `[true,[true,false]]`, logs: []string{"map function raised exception (interface conversion: interface {} is nil, not string) with doc._id <nil>"}},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"filters", "missing"}, []interface{}{[]interface{}{}, map[string]interface{}{}}}, err: "compilation_error: unknown filter function: nope"},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"filters", "other"}, []interface{}{[]interface{}{}, map[string]interface{}{}}}, err: "not_found: missing function: [filters other]"},
+	}})
+	tests.Add("uncached ddoc", tt{steps: []step{
+		{cmd: "ddoc", args: []interface{}{"_design/bar", []string{"filters", "f"}, []interface{}{}}, err: "query_protocol_error: uncached design doc: _design/bar"},
+	}})
+	tests.Add("validate", tt{steps: []step{
+		newDDoc,
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"validate_doc_update"}, []interface{}{fruit, nil, map[string]interface{}{"name": "bob"}, map[string]interface{}{}}}, want: `1`},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"validate_doc_update"}, []interface{}{fruit, nil, map[string]interface{}{"name": nil}, map[string]interface{}{}}}, want: `{"unauthorized":"login required"}`},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"validate_doc_update"}, []interface{}{map[string]interface{}{}, nil, map[string]interface{}{"name": "bob"}, map[string]interface{}{}}}, want: `{"forbidden":"type is required"}`},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"validate_doc_update"}, []interface{}{fruit, veg, map[string]interface{}{"name": "bob"}, map[string]interface{}{}}}, want: `{"forbidden":"type is immutable"}`},
+	}})
+	tests.Add("update", tt{steps: []step{
+		newDDoc,
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"updates", "u"}, []interface{}{veg, map[string]interface{}{}}}, want: `["up",{"_id":"b","touched":true,"type":"veg"},{"json":{"ok":true}}]`},
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"updates", "u"}, []interface{}{nil, map[string]interface{}{}}}, want: `["up",null,{"code":404,"body":"missing"}]`},
+	}})
+	tests.Add("unsupported", tt{steps: []step{
+		newDDoc,
+		{cmd: "ddoc", args: []interface{}{"_design/foo", []string{"shows", "s"}, []interface{}{nil, map[string]interface{}{}}}, err: "not_implemented: shows functions are not supported"},
+		{cmd: "list_row", err: "unknown_command: unknown command 'list_row'"},
+	}})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		h := NewHarness(testServer())
+		for _, s := range tt.steps {
+			resp, err := h.Send(s.cmd, s.args...)
+			testy.Error(t, s.err, err)
+			if s.err != "" {
+				continue
+			}
+			if string(resp) != s.want {
+				t.Errorf("%s: unexpected response:\nwant: %s\n got: %s", s.cmd, s.want, resp)
+			}
+			if d := testy.DiffInterface(s.logs, h.Logs()); d != nil {
+				t.Errorf("%s: unexpected logs:\n%s", s.cmd, d)
+			}
+		}
+	})
+}
+
+func TestServe(t *testing.T) {
+	input := strings.Join([]string{
+		`["reset"]`,
+		`["add_fun","strict"]`,
+		``,
+		`["map_doc",{"_id":"a","type":"fruit"}]`,
+		`["map_doc",{"_id":"b"}]`,
+		`not json`,
+	}, "\n")
+	want := strings.Join([]string{
+		`true`,
+		`true`,
+		`[[["fruit",null]]]`,
+		`["log","map function raised exception (interface conversion: interface {} is nil, not string) with doc._id b"]`,
+		`[[]]`,
+		`["error","query_protocol_error","invalid command: not json"]`,
+		``,
+	}, "\n")
+	var out bytes.Buffer
+	if err := testServer().Serve(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffText(want, out.String()); d != nil {
+		t.Error(d)
+	}
+
+	replayed, err := NewHarness(testServer()).Replay(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != out.String() {
+		t.Errorf("Replay output differs from Serve:\n%s", replayed)
+	}
+}
@@ -170,16 +170,22 @@ var replicationNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Me
 // GetReplications returns a list of defined replications in the _replicator
 // database. Options are in the same format as to AllDocs(), except that
 // "conflicts" and "update_seq" are ignored.
-func (c *Client) GetReplications(ctx context.Context, options ...Options) ([]*Replication, error) {
+func (c *Client) GetReplications(ctx context.Context, options ...Options) (replications []*Replication, err error) {
+	op := c.op("GetReplications", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	replicator, ok := c.driverClient.(driver.ClientReplicator)
 	if !ok {
-		return nil, replicationNotImplemented
+		return nil, op.wrap(replicationNotImplemented)
 	}
 	reps, err := replicator.GetReplications(ctx, mergeOptions(options...))
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
-	replications := make([]*Replication, len(reps))
+	replications = make([]*Replication, len(reps))
 	for i, rep := range reps {
 		replications[i] = newReplication(rep)
 	}
@@ -190,14 +196,20 @@ func (c *Client) GetReplications(ctx context.Context, options ...Options) ([]*Re
 //
 // To use an object for either "source" or "target", pass the desired object
 // in options. This will override targetDSN and sourceDSN function parameters.
-func (c *Client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options ...Options) (*Replication, error) {
+func (c *Client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options ...Options) (replication *Replication, err error) {
+	op := c.op("Replicate", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	replicator, ok := c.driverClient.(driver.ClientReplicator)
 	if !ok {
-		return nil, replicationNotImplemented
+		return nil, op.wrap(replicationNotImplemented)
 	}
 	rep, err := replicator.Replicate(ctx, targetDSN, sourceDSN, mergeOptions(options...))
 	if err != nil {
-		return nil, err
+		return nil, op.wrap(err)
 	}
 	return newReplication(rep), nil
 }
@@ -209,3 +221,190 @@ type ReplicationInfo struct {
 	DocsWritten      int64
 	Progress         float64
 }
+
+// replicationPollInterval is the interval at which Wait polls for the state
+// of a replication, and the default interval of Watch.
+var replicationPollInterval = time.Second
+
+// isTerminal returns true if state is one from which a replication does not
+// recover.
+func (s ReplicationState) isTerminal() bool {
+	switch s {
+	case ReplicationComplete, ReplicationError, ReplicationFailed:
+		return true
+	}
+	return false
+}
+
+// ReplicationEventType identifies the kind of a ReplicationEvent.
+type ReplicationEventType int
+
+// The types of ReplicationEvent.
+const (
+	// ReplicationProgressEvent reports the progress of a replication whose
+	// state has not changed since the previous event.
+	ReplicationProgressEvent ReplicationEventType = iota
+	// ReplicationStateEvent reports a change of replication state.
+	ReplicationStateEvent
+)
+
+// ReplicationEvent is a snapshot of a replication's state and progress, as
+// produced by ReplicationWatcher.
+type ReplicationEvent struct {
+	Type ReplicationEventType
+	// Time is the time at which the snapshot was taken.
+	Time time.Time
+	// State is the state of the replication, and PreviousState its state
+	// as of the previous event. These differ only for ReplicationStateEvent.
+	State         ReplicationState
+	PreviousState ReplicationState
+	ReplicationInfo
+}
+
+// ReplicationWatcher is an iterator over periodic snapshots of a
+// replication. See Replication.Watch.
+type ReplicationWatcher struct {
+	rep      *Replication
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	polled bool
+	done   bool
+	state  ReplicationState
+	event  ReplicationEvent
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch returns an iterator over snapshots of the replication's state and
+// progress, taken by calling Update every interval. The first snapshot is
+// taken immediately. A change of state is reported with a
+// ReplicationStateEvent, and otherwise each snapshot is reported with a
+// ReplicationProgressEvent.
+//
+// Iteration ends after the event which reports a terminal state (completed,
+// failed or error), when ctx is cancelled or the watcher is closed, or when
+// Update fails with an error which is not retryable. Retryable errors from
+// Update are ignored, and the replication polled again after interval.
+//
+// If interval is not positive, the replication is polled every second.
+func (r *Replication) Watch(ctx context.Context, interval time.Duration) *ReplicationWatcher {
+	if interval <= 0 {
+		interval = replicationPollInterval
+	}
+	w := &ReplicationWatcher{
+		rep:      r,
+		interval: interval,
+		state:    ReplicationNotStarted,
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	return w
+}
+
+// Next blocks until the next snapshot has been taken, and prepares it for
+// reading with Event. It returns false when iteration has ended. Err should
+// be consulted to determine whether it ended due to an error.
+func (w *ReplicationWatcher) Next() bool {
+	if w.done {
+		return false
+	}
+	for {
+		if w.polled {
+			timer := time.NewTimer(w.interval)
+			select {
+			case <-w.ctx.Done():
+				timer.Stop()
+				w.finish(nil)
+				return false
+			case <-timer.C:
+			}
+		}
+		w.polled = true
+		if err := w.rep.Update(w.ctx); err != nil {
+			if w.ctx.Err() != nil {
+				w.finish(nil)
+				return false
+			}
+			if IsRetryable(err) {
+				continue
+			}
+			w.finish(err)
+			return false
+		}
+		state := w.rep.State()
+		w.event = ReplicationEvent{
+			Type:          ReplicationProgressEvent,
+			Time:          time.Now(),
+			State:         state,
+			PreviousState: w.state,
+			ReplicationInfo: ReplicationInfo{
+				DocWriteFailures: w.rep.DocWriteFailures(),
+				DocsRead:         w.rep.DocsRead(),
+				DocsWritten:      w.rep.DocsWritten(),
+				Progress:         w.rep.Progress(),
+			},
+		}
+		if state != w.state {
+			w.event.Type = ReplicationStateEvent
+		}
+		w.state = state
+		if state.isTerminal() {
+			w.done = true
+			w.cancel()
+		}
+		return true
+	}
+}
+
+func (w *ReplicationWatcher) finish(err error) {
+	w.done = true
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+	w.cancel()
+}
+
+// Event returns the current snapshot.
+func (w *ReplicationWatcher) Event() ReplicationEvent {
+	return w.event
+}
+
+// Err returns the error from Update which ended iteration, if any.
+func (w *ReplicationWatcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the watcher. It may be called concurrently with Next, and is
+// idempotent.
+func (w *ReplicationWatcher) Close() error {
+	w.cancel()
+	return nil
+}
+
+// Wait blocks until the replication reaches a terminal state (completed,
+// failed or error), polling its state with Update. It returns nil if the
+// replication completed, and otherwise the replication's error, an error from
+// Update which is not retryable, or the error of ctx.
+func (r *Replication) Wait(ctx context.Context) error {
+	w := r.Watch(ctx, replicationPollInterval)
+	defer w.Close() // nolint: errcheck
+	for w.Next() {
+	}
+	if err := w.Err(); err != nil {
+		return err
+	}
+	switch state := w.state; {
+	case state == ReplicationComplete:
+		return nil
+	case state.isTerminal():
+		if err := r.Err(); err != nil {
+			return err
+		}
+		return &Error{HTTPStatus: http.StatusInternalServerError, Message: "kivik: replication " + string(state)}
+	}
+	return ctx.Err()
+}
@@ -452,3 +452,131 @@ func TestReplicate(t *testing.T) {
 		})
 	}
 }
+
+type replicationStep struct {
+	state string
+	info  driver.ReplicationInfo
+	err   error
+}
+
+// steppedReplication returns a mock replication, whose state and progress
+// advance through steps with each call to Update. The last step is repeated.
+func steppedReplication(steps ...replicationStep) *mock.Replication {
+	var state string
+	return &mock.Replication{
+		StateFunc: func() string { return state },
+		ErrFunc:   func() error { return nil },
+		UpdateFunc: func(_ context.Context, info *driver.ReplicationInfo) error {
+			step := steps[0]
+			if len(steps) > 1 {
+				steps = steps[1:]
+			}
+			if step.err != nil {
+				return step.err
+			}
+			state = step.state
+			*info = step.info
+			return nil
+		},
+	}
+}
+
+func TestReplicationWatch(t *testing.T) {
+	r := &Replication{irep: steppedReplication(
+		replicationStep{state: "triggered", info: driver.ReplicationInfo{DocsRead: 1}},
+		replicationStep{err: &Error{HTTPStatus: http.StatusServiceUnavailable}},
+		replicationStep{state: "triggered", info: driver.ReplicationInfo{DocsRead: 5, DocsWritten: 4, Progress: 50}},
+		replicationStep{state: "completed", info: driver.ReplicationInfo{DocsRead: 10, DocsWritten: 9, DocWriteFailures: 1, Progress: 100}},
+	)}
+	w := r.Watch(context.Background(), time.Millisecond)
+	var events []ReplicationEvent
+	for w.Next() {
+		event := w.Event()
+		event.Time = time.Time{}
+		events = append(events, event)
+	}
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []ReplicationEvent{
+		{Type: ReplicationStateEvent, State: ReplicationStarted, PreviousState: ReplicationNotStarted, ReplicationInfo: ReplicationInfo{DocsRead: 1}},
+		{Type: ReplicationProgressEvent, State: ReplicationStarted, PreviousState: ReplicationStarted, ReplicationInfo: ReplicationInfo{DocsRead: 5, DocsWritten: 4, Progress: 50}},
+		{Type: ReplicationStateEvent, State: ReplicationComplete, PreviousState: ReplicationStarted, ReplicationInfo: ReplicationInfo{DocsRead: 10, DocsWritten: 9, DocWriteFailures: 1, Progress: 100}},
+	}
+	if d := testy.DiffInterface(want, events); d != nil {
+		t.Error(d)
+	}
+	if w.Next() {
+		t.Error("Expected iteration to stay ended")
+	}
+}
+
+func TestReplicationWatchDefaultInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		w := (&Replication{}).Watch(context.Background(), interval)
+		if w.interval != replicationPollInterval {
+			t.Errorf("%v: unexpected interval: %v", interval, w.interval)
+		}
+		w.Close() // nolint: errcheck
+	}
+}
+
+func TestReplicationWait(t *testing.T) {
+	interval := replicationPollInterval
+	replicationPollInterval = time.Millisecond
+	defer func() { replicationPollInterval = interval }()
+
+	type tt struct {
+		rep    driver.Replication
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("completed", tt{
+		rep: steppedReplication(
+			replicationStep{state: "triggered"},
+			replicationStep{state: "completed"},
+		),
+	})
+	tests.Add("failed without error", tt{
+		rep:    steppedReplication(replicationStep{state: "failed"}),
+		status: http.StatusInternalServerError,
+		err:    "kivik: replication failed",
+	})
+	tests.Add("error", tt{
+		rep: func() driver.Replication {
+			rep := steppedReplication(replicationStep{state: "error"})
+			rep.ErrFunc = func() error {
+				return &Error{HTTPStatus: http.StatusNotFound, Message: "db_not_found"}
+			}
+			return rep
+		}(),
+		status: http.StatusNotFound,
+		err:    "db_not_found",
+	})
+	tests.Add("update error", tt{
+		rep:    steppedReplication(replicationStep{err: &Error{HTTPStatus: http.StatusUnauthorized, Message: "unauthorized"}}),
+		status: http.StatusUnauthorized,
+		err:    "unauthorized",
+	})
+	tests.Add("crashing is not terminal", tt{
+		rep: steppedReplication(replicationStep{state: "crashing"}),
+		ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		},
+		status: http.StatusInternalServerError,
+		err:    "context deadline exceeded",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		ctx, cancel := context.Background(), func() {}
+		if tt.ctx != nil {
+			ctx, cancel = tt.ctx()
+		}
+		defer cancel()
+		err := (&Replication{irep: tt.rep}).Wait(ctx)
+		testy.StatusError(t, tt.err, tt.status, err)
+	})
+}
@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ReplicationEndpoint is the source or target of a replication.
+type ReplicationEndpoint struct {
+	// URL is the DSN of the database.
+	URL string
+	// Headers are additional HTTP headers sent with each request to the
+	// database.
+	Headers map[string]string
+	// Auth, if set, provides credentials for the database, in place of any
+	// credentials in URL.
+	Auth *ReplicationAuth
+}
+
+// ReplicationAuth holds the basic authentication credentials for a
+// replication endpoint.
+type ReplicationAuth struct {
+	Username string
+	Password string
+}
+
+func (e ReplicationEndpoint) isObject() bool {
+	return len(e.Headers) > 0 || e.Auth != nil
+}
+
+func (e ReplicationEndpoint) value() interface{} {
+	if !e.isObject() {
+		return e.URL
+	}
+	obj := map[string]interface{}{"url": e.URL}
+	if len(e.Headers) > 0 {
+		obj["headers"] = e.Headers
+	}
+	if e.Auth != nil {
+		obj["auth"] = map[string]interface{}{
+			"basic": map[string]string{
+				"username": e.Auth.Username,
+				"password": e.Auth.Password,
+			},
+		}
+	}
+	return obj
+}
+
+// ReplicationSpec is a typed description of a replication, which may be
+// passed to ReplicateSpec, or marshaled to JSON as the body of a document in
+// the _replicator database.
+//
+// See https://docs.couchdb.org/en/stable/json-structure.html#replication-settings
+type ReplicationSpec struct {
+	Source ReplicationEndpoint
+	Target ReplicationEndpoint
+
+	// CreateTarget causes the target database to be created, if it does not
+	// exist. CreateTargetParams are the options used to create it, such as
+	// "q" or "partitioned".
+	CreateTarget       bool
+	CreateTargetParams map[string]interface{}
+
+	// Continuous makes the replication continuous.
+	Continuous bool
+
+	// At most one of DocIDs, Selector and Filter may be set, to restrict the
+	// documents replicated. QueryParams are passed to Filter.
+	DocIDs      []string
+	Selector    interface{}
+	Filter      string
+	QueryParams map[string]interface{}
+
+	// SinceSeq is the source update sequence from which to start the
+	// replication.
+	SinceSeq string
+
+	// UseCheckpoints, if non-nil, enables or disables the use of replication
+	// checkpoints. CheckpointInterval is the interval between checkpoints.
+	UseCheckpoints     *bool
+	CheckpointInterval time.Duration
+
+	// WorkerProcesses, WorkerBatchSize, HTTPConnections, ConnectionTimeout
+	// and RetriesPerRequest override the server's replicator configuration,
+	// when non-zero.
+	WorkerProcesses   int
+	WorkerBatchSize   int
+	HTTPConnections   int
+	ConnectionTimeout time.Duration
+	RetriesPerRequest int
+}
+
+// Validate returns a status 400 error if s is incomplete, or sets
+// incompatible fields.
+func (s *ReplicationSpec) Validate() error {
+	if s.Source.URL == "" {
+		return missingArg("source")
+	}
+	if s.Target.URL == "" {
+		return missingArg("target")
+	}
+	var restrictions int
+	if len(s.DocIDs) > 0 {
+		restrictions++
+	}
+	if s.Selector != nil {
+		restrictions++
+	}
+	if s.Filter != "" {
+		restrictions++
+	}
+	if restrictions > 1 {
+		return &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: only one of doc_ids, selector and filter may be set"}
+	}
+	if len(s.QueryParams) > 0 && s.Filter == "" {
+		return &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: query_params requires filter"}
+	}
+	if len(s.CreateTargetParams) > 0 && !s.CreateTarget {
+		return &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: create_target_params requires create_target"}
+	}
+	return nil
+}
+
+// Options returns the replication options described by s, in the form
+// expected by Replicate. The source and target are included only if they
+// must be expressed as objects, because they set headers or credentials.
+func (s *ReplicationSpec) Options() Options {
+	opts := Options{}
+	if s.Source.isObject() {
+		opts["source"] = s.Source.value()
+	}
+	if s.Target.isObject() {
+		opts["target"] = s.Target.value()
+	}
+	if s.CreateTarget {
+		opts["create_target"] = true
+	}
+	if len(s.CreateTargetParams) > 0 {
+		opts["create_target_params"] = s.CreateTargetParams
+	}
+	if s.Continuous {
+		opts["continuous"] = true
+	}
+	if len(s.DocIDs) > 0 {
+		opts["doc_ids"] = s.DocIDs
+	}
+	if s.Selector != nil {
+		opts["selector"] = s.Selector
+	}
+	if s.Filter != "" {
+		opts["filter"] = s.Filter
+	}
+	if len(s.QueryParams) > 0 {
+		opts["query_params"] = s.QueryParams
+	}
+	if s.SinceSeq != "" {
+		opts["since_seq"] = s.SinceSeq
+	}
+	if s.UseCheckpoints != nil {
+		opts["use_checkpoints"] = *s.UseCheckpoints
+	}
+	if s.CheckpointInterval > 0 {
+		opts["checkpoint_interval"] = int64(s.CheckpointInterval / time.Millisecond)
+	}
+	if s.WorkerProcesses > 0 {
+		opts["worker_processes"] = s.WorkerProcesses
+	}
+	if s.WorkerBatchSize > 0 {
+		opts["worker_batch_size"] = s.WorkerBatchSize
+	}
+	if s.HTTPConnections > 0 {
+		opts["http_connections"] = s.HTTPConnections
+	}
+	if s.ConnectionTimeout > 0 {
+		opts["connection_timeout"] = int64(s.ConnectionTimeout / time.Millisecond)
+	}
+	if s.RetriesPerRequest > 0 {
+		opts["retries_per_request"] = s.RetriesPerRequest
+	}
+	return opts
+}
+
+// MarshalJSON satisfies the json.Marshaler interface, producing the body of a
+// _replicator document.
+func (s ReplicationSpec) MarshalJSON() ([]byte, error) {
+	doc := s.Options()
+	doc["source"] = s.Source.value()
+	doc["target"] = s.Target.value()
+	return json.Marshal(doc)
+}
+
+// ReplicateSpec initiates the replication described by spec. Any options are
+// merged with, and take precedence over, those derived from spec.
+func (c *Client) ReplicateSpec(ctx context.Context, spec *ReplicationSpec, options ...Options) (*Replication, error) {
+	if spec == nil {
+		return nil, c.op("Replicate", "").wrap(missingArg("spec"))
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, c.op("Replicate", "").wrap(err)
+	}
+	return c.Replicate(ctx, spec.Target.URL, spec.Source.URL, append([]Options{spec.Options()}, options...)...)
+}
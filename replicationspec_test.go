@@ -0,0 +1,177 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestReplicationSpecValidate(t *testing.T) {
+	type tt struct {
+		spec   *ReplicationSpec
+		status int
+		err    string
+	}
+
+	endpoints := func(s ReplicationSpec) *ReplicationSpec {
+		s.Source = ReplicationEndpoint{URL: "http://a/src"}
+		s.Target = ReplicationEndpoint{URL: "http://b/tgt"}
+		return &s
+	}
+	tests := testy.NewTable()
+	tests.Add("missing source", tt{
+		spec:   &ReplicationSpec{Target: ReplicationEndpoint{URL: "http://b/tgt"}},
+		status: http.StatusBadRequest,
+		err:    "kivik: source required",
+	})
+	tests.Add("missing target", tt{
+		spec:   &ReplicationSpec{Source: ReplicationEndpoint{URL: "http://a/src"}},
+		status: http.StatusBadRequest,
+		err:    "kivik: target required",
+	})
+	tests.Add("doc ids and selector", tt{
+		spec:   endpoints(ReplicationSpec{DocIDs: []string{"a"}, Selector: map[string]interface{}{"type": "x"}}),
+		status: http.StatusBadRequest,
+		err:    "kivik: only one of doc_ids, selector and filter may be set",
+	})
+	tests.Add("query params without filter", tt{
+		spec:   endpoints(ReplicationSpec{QueryParams: map[string]interface{}{"a": "b"}}),
+		status: http.StatusBadRequest,
+		err:    "kivik: query_params requires filter",
+	})
+	tests.Add("create target params without create target", tt{
+		spec:   endpoints(ReplicationSpec{CreateTargetParams: map[string]interface{}{"q": 1}}),
+		status: http.StatusBadRequest,
+		err:    "kivik: create_target_params requires create_target",
+	})
+	tests.Add("valid", tt{
+		spec: endpoints(ReplicationSpec{Filter: "app/by_type", QueryParams: map[string]interface{}{"type": "x"}}),
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		err := tt.spec.Validate()
+		testy.StatusError(t, tt.err, tt.status, err)
+	})
+}
+
+func TestReplicationSpecJSON(t *testing.T) {
+	useCheckpoints := false
+	spec := ReplicationSpec{
+		Source: ReplicationEndpoint{
+			URL:  "http://a/src",
+			Auth: &ReplicationAuth{Username: "bob", Password: "abc123"},
+		},
+		Target: ReplicationEndpoint{
+			URL:     "http://b/tgt",
+			Headers: map[string]string{"X-Token": "xyz"},
+		},
+		CreateTarget:       true,
+		CreateTargetParams: map[string]interface{}{"q": 1},
+		Continuous:         true,
+		Selector:           map[string]interface{}{"type": "order"},
+		SinceSeq:           "42-abc",
+		UseCheckpoints:     &useCheckpoints,
+		CheckpointInterval: 5 * time.Second,
+		WorkerProcesses:    2,
+		WorkerBatchSize:    100,
+		HTTPConnections:    10,
+		ConnectionTimeout:  30 * time.Second,
+		RetriesPerRequest:  3,
+	}
+	got, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+		"source": {"url": "http://a/src", "auth": {"basic": {"username": "bob", "password": "abc123"}}},
+		"target": {"url": "http://b/tgt", "headers": {"X-Token": "xyz"}},
+		"create_target": true,
+		"create_target_params": {"q": 1},
+		"continuous": true,
+		"selector": {"type": "order"},
+		"since_seq": "42-abc",
+		"use_checkpoints": false,
+		"checkpoint_interval": 5000,
+		"worker_processes": 2,
+		"worker_batch_size": 100,
+		"http_connections": 10,
+		"connection_timeout": 30000,
+		"retries_per_request": 3
+	}`
+	if d := testy.DiffAsJSON([]byte(want), got); d != nil {
+		t.Error(d)
+	}
+
+	plain := ReplicationSpec{
+		Source: ReplicationEndpoint{URL: "http://a/src"},
+		Target: ReplicationEndpoint{URL: "http://b/tgt"},
+	}
+	got, err = json.Marshal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffAsJSON([]byte(`{"source":"http://a/src","target":"http://b/tgt"}`), got); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestReplicateSpec(t *testing.T) {
+	c := &Client{
+		driverClient: &mock.ClientReplicator{
+			ReplicateFunc: func(_ context.Context, target, source string, opts map[string]interface{}) (driver.Replication, error) {
+				if target != "http://b/tgt" || source != "http://a/src" {
+					return nil, fmt.Errorf("Unexpected endpoints: %s -> %s", source, target)
+				}
+				want := map[string]interface{}{
+					"continuous": false,
+					"doc_ids":    []string{"a", "b"},
+					"target": map[string]interface{}{
+						"url":     "http://b/tgt",
+						"headers": map[string]string{"X-Token": "xyz"},
+					},
+				}
+				if d := testy.DiffInterface(want, opts); d != nil {
+					return nil, fmt.Errorf("Unexpected options:\n%s", d)
+				}
+				return &mock.Replication{ID: "rep"}, nil
+			},
+		},
+	}
+	spec := &ReplicationSpec{
+		Source:     ReplicationEndpoint{URL: "http://a/src"},
+		Target:     ReplicationEndpoint{URL: "http://b/tgt", Headers: map[string]string{"X-Token": "xyz"}},
+		Continuous: true,
+		DocIDs:     []string{"a", "b"},
+	}
+	rep, err := c.ReplicateSpec(context.Background(), spec, Options{"continuous": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Source != "rep-source" {
+		t.Errorf("Unexpected replication: %v", rep.Source)
+	}
+
+	_, err = c.ReplicateSpec(context.Background(), &ReplicationSpec{})
+	testy.StatusError(t, "kivik: source required", http.StatusBadRequest, err)
+	_, err = c.ReplicateSpec(context.Background(), nil)
+	testy.StatusError(t, "kivik: spec required", http.StatusBadRequest, err)
+}
@@ -114,6 +114,13 @@ type ResultSet interface {
 	// cases.
 	Rev() string
 
+	// ETag returns the unquoted ETag of the response, if known. It is set for
+	// results returned by Get, and by view queries, such as AllDocs and
+	// Query, for drivers which report it. It may be passed as
+	// OptionIfNoneMatch in a later request, to avoid re-reading unchanged
+	// results.
+	ETag() string
+
 	// Key returns the Key of the most recent result as a raw JSON string. For
 	// compound keys, the ScanKey() method may be more convenient.
 	Key() string
@@ -149,6 +156,7 @@ func (baseRS) Attachments() *AttachmentsIterator { return nil }
 func (baseRS) ScanKey(interface{}) error         { return nil }
 func (baseRS) ScanValue(interface{}) error       { return nil }
 func (baseRS) Key() string                       { return "" }
+func (baseRS) ETag() string                      { return "" }
 
 type rows struct {
 	baseRS
@@ -178,6 +186,13 @@ func (r *rows) Close() error {
 	return r.iter.Close()
 }
 
+func (r *rows) ETag() string {
+	if e, ok := r.rowsi.(driver.RowsETagger); ok {
+		return e.ETag()
+	}
+	return ""
+}
+
 func (r *rows) Finish() (ResultMetadata, error) {
 	for r.Next() {
 	}
@@ -203,6 +218,14 @@ var _ iterator = &rowsIterator{}
 
 func (r *rowsIterator) Next(i interface{}) error { return r.Rows.Next(i.(*driver.Row)) }
 
+// NewResultSet returns a ResultSet which iterates over rowsi. It allows
+// packages which produce results locally, rather than through a driver, to
+// return them through the same interface as DB.Query. The ResultSet is closed
+// when ctx is cancelled.
+func NewResultSet(ctx context.Context, rowsi driver.Rows) ResultSet {
+	return newRows(ctx, rowsi)
+}
+
 func newRows(ctx context.Context, rowsi driver.Rows) *rows {
 	return &rows{
 		iter:  newIterator(ctx, &rowsIterator{rowsi}, &driver.Row{}),
@@ -221,9 +244,9 @@ func (r *rows) ScanValue(dest interface{}) (err error) {
 		return row.Error
 	}
 	if row.ValueReader != nil {
-		return json.NewDecoder(row.ValueReader).Decode(dest)
+		return decode(row.ValueReader, dest)
 	}
-	return json.Unmarshal(row.Value, dest)
+	return unmarshal(row.Value, dest)
 }
 
 func (r *rows) ScanDoc(dest interface{}) (err error) {
@@ -238,10 +261,10 @@ func (r *rows) ScanDoc(dest interface{}) (err error) {
 	}
 	doc := row.Doc
 	if row.DocReader != nil {
-		return json.NewDecoder(row.DocReader).Decode(dest)
+		return decode(row.DocReader, dest)
 	}
 	if doc != nil {
-		return json.Unmarshal(doc, dest)
+		return unmarshal(doc, dest)
 	}
 	return &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: doc is nil; does the query include docs?"}
 }
@@ -325,7 +348,7 @@ func (r *rows) ScanKey(dest interface{}) (err error) {
 	if err := row.Error; err != nil {
 		return err
 	}
-	return json.Unmarshal(row.Key, dest)
+	return unmarshal(row.Key, dest)
 }
 
 func (r *rows) ID() string {
@@ -531,6 +531,19 @@ func TestFinish(t *testing.T) {
 	})
 }
 
+func TestRowsETag(t *testing.T) {
+	r := newRows(context.Background(), &mock.Rows{})
+	if etag := r.ETag(); etag != "" {
+		t.Errorf("Unexpected ETag: %s", etag)
+	}
+	r = newRows(context.Background(), &mock.RowsETagger{
+		ETagFunc: func() string { return "abc" },
+	})
+	if etag := r.ETag(); etag != "abc" {
+		t.Errorf("Unexpected ETag: %s", etag)
+	}
+}
+
 func TestScanAllDocs(t *testing.T) {
 	type tt struct {
 		rows *rows
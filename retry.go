@@ -0,0 +1,208 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// OptionRetryPolicy is the option key used to set a *RetryPolicy. It may be
+// passed to New, to set the default policy for the client, to Client.DB, to
+// set the default policy for the database handle, or to any individual call
+// which supports retries. A nil *RetryPolicy disables retries. This option is
+// consumed by Kivik, and is never passed to the driver.
+//
+// Example:
+//
+//	client, err := kivik.New("couch", dsn, kivik.Options{
+//	    kivik.OptionRetryPolicy: &kivik.RetryPolicy{MaxAttempts: 5},
+//	})
+const OptionRetryPolicy = "kivik:retry_policy"
+
+// RetryPolicy configures the automatic retry of operations which fail with a
+// transient error, using exponential backoff with jitter.
+//
+// Only operations which are safe to repeat are retried. These are the read
+// operations (such as Get, GetRev, AllDocs, Query, Find, BulkGet, Stats,
+// Security, the setup of a Changes feed, Version, AllDBs and DBExists), as
+// well as writes which cannot create duplicates: Put with an explicit
+// revision (either a "_rev" field in the document, or a "rev" option),
+// Delete and SetSecurity.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. If zero, 100ms
+	// is used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the upper limit for the delay between attempts. If zero,
+	// 10s is used. A delay requested by the server with a Retry-After header
+	// is honored even if it exceeds MaxBackoff. Drivers report such a delay
+	// with errors.WithRetryAfter.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the delay increases after each
+	// attempt. If less than 1, 2 is used.
+	Multiplier float64
+
+	// Jitter is the fraction, between 0 and 1, by which each delay is
+	// randomly reduced, to avoid synchronized retries from many clients.
+	Jitter float64
+
+	// Retryable reports whether an error should be retried. If nil,
+	// IsRetryable is used.
+	Retryable func(error) bool
+}
+
+// retryAfterer is implemented by errors which carry a server-requested retry
+// delay, such as from a Retry-After header. Like statusCoder, it is not
+// exported, but is considered part of the stable public API. Drivers may
+// implement it, anywhere in the chain of wrapped errors, or use
+// errors.WithRetryAfter:
+//
+//	type retryAfterer interface {
+//	    RetryAfter() time.Duration
+//	}
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// retryAfter returns the server-requested retry delay in err, if any.
+func retryAfter(err error) (time.Duration, bool) {
+	var delay time.Duration
+	found := walkErrors(err, func(err error) bool {
+		if ra, ok := err.(retryAfterer); ok {
+			delay = ra.RetryAfter()
+			return true
+		}
+		return false
+	})
+	return delay, found
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns the delay to wait after the failed attempt number attempt,
+// which is 1-based.
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	if delay, ok := retryAfter(err); ok {
+		return delay
+	}
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay -= delay * jitter * rand.Float64() // nolint:gosec
+	}
+	return time.Duration(delay)
+}
+
+// do calls fn until it succeeds, returns an error which is not retryable, or
+// the attempts are exhausted. No retry is attempted if its delay would exceed
+// the context's deadline. A nil policy calls fn exactly once.
+func (p *RetryPolicy) do(ctx context.Context, fn func() error) error {
+	if p == nil || p.MaxAttempts < 2 {
+		return fn()
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+		delay := p.backoff(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// extractRetryPolicy returns the value of the last OptionRetryPolicy key set
+// in options, or def if none is set. The key itself is dropped from the
+// options passed to drivers by mergeOptions.
+func extractRetryPolicy(def *RetryPolicy, options []Options) *RetryPolicy {
+	policy := def
+	for _, opts := range options {
+		if v, ok := opts[OptionRetryPolicy]; ok {
+			policy, _ = v.(*RetryPolicy)
+		}
+	}
+	return policy
+}
+
+// retryPolicy returns the retry policy for a call to db with the options
+// provided.
+func (db *DB) retryPolicy(options []Options) *RetryPolicy {
+	return extractRetryPolicy(db.retry, options)
+}
+
+// retryPolicy returns the retry policy for a call to c with the options
+// provided.
+func (c *Client) retryPolicy(options []Options) *RetryPolicy {
+	return extractRetryPolicy(c.retry, options)
+}
+
+// hasRev returns true if doc, which has been normalized by normalizeFromJSON,
+// includes a non-empty _rev field.
+func hasRev(doc interface{}) bool {
+	switch t := doc.(type) {
+	case nil:
+		return false
+	case map[string]interface{}:
+		rev, _ := t["_rev"].(string)
+		return rev != ""
+	case map[string]string:
+		return t["_rev"] != ""
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return false
+	}
+	var result struct {
+		Rev string `json:"_rev"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false
+	}
+	return result.Rev != ""
+}
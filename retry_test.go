@@ -0,0 +1,364 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	kerrors "github.com/dannyzhou2015/kivik/v4/errors"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+type retryAfterErr struct {
+	error
+	delay time.Duration
+}
+
+func (e *retryAfterErr) RetryAfter() time.Duration { return e.delay }
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	type tt struct {
+		policy   *RetryPolicy
+		attempt  int
+		err      error
+		expected time.Duration
+	}
+
+	tests := testy.NewTable()
+	tests.Add("defaults, first attempt", tt{
+		policy:   &RetryPolicy{},
+		attempt:  1,
+		expected: 100 * time.Millisecond,
+	})
+	tests.Add("defaults, third attempt", tt{
+		policy:   &RetryPolicy{},
+		attempt:  3,
+		expected: 400 * time.Millisecond,
+	})
+	tests.Add("max backoff", tt{
+		policy:   &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second},
+		attempt:  5,
+		expected: 3 * time.Second,
+	})
+	tests.Add("custom multiplier", tt{
+		policy:   &RetryPolicy{InitialBackoff: time.Second, Multiplier: 3},
+		attempt:  2,
+		expected: 3 * time.Second,
+	})
+	tests.Add("retry after", tt{
+		policy:   &RetryPolicy{MaxBackoff: time.Second},
+		attempt:  1,
+		err:      &Error{Err: &retryAfterErr{error: errors.New("busy"), delay: 5 * time.Second}},
+		expected: 5 * time.Second,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		result := tt.policy.backoff(tt.attempt, tt.err)
+		if result != tt.expected {
+			t.Errorf("Unexpected result: %v", result)
+		}
+	})
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		result := p.backoff(1, nil)
+		if result < 500*time.Millisecond || result > time.Second {
+			t.Fatalf("Backoff out of range: %v", result)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	type tt struct {
+		policy   *RetryPolicy
+		ctx      context.Context
+		errs     []error
+		attempts int
+		status   int
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("nil policy", tt{
+		errs:     []error{&Error{HTTPStatus: http.StatusServiceUnavailable}},
+		attempts: 1,
+		status:   http.StatusServiceUnavailable,
+		err:      "Service Unavailable",
+	})
+	tests.Add("success after retries", tt{
+		policy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		errs: []error{
+			&Error{HTTPStatus: http.StatusServiceUnavailable},
+			&Error{HTTPStatus: http.StatusTooManyRequests},
+			nil,
+		},
+		attempts: 3,
+	})
+	tests.Add("attempts exhausted", tt{
+		policy: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		errs: []error{
+			&Error{HTTPStatus: http.StatusServiceUnavailable},
+			&Error{HTTPStatus: http.StatusBadGateway},
+			nil,
+		},
+		attempts: 2,
+		status:   http.StatusBadGateway,
+		err:      "Bad Gateway",
+	})
+	tests.Add("not retryable", tt{
+		policy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		errs: []error{
+			&Error{HTTPStatus: http.StatusNotFound},
+			nil,
+		},
+		attempts: 1,
+		status:   http.StatusNotFound,
+		err:      "Not Found",
+	})
+	tests.Add("custom retryable", tt{
+		policy: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Retryable:      IsNotFound,
+		},
+		errs: []error{
+			&Error{HTTPStatus: http.StatusNotFound},
+			nil,
+		},
+		attempts: 2,
+	})
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tests.Add("delay exceeds deadline", tt{
+		policy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+		ctx:    deadlineCtx,
+		errs: []error{
+			&Error{HTTPStatus: http.StatusServiceUnavailable},
+			nil,
+		},
+		attempts: 1,
+		status:   http.StatusServiceUnavailable,
+		err:      "Service Unavailable",
+	})
+	tests.Add("context canceled while waiting", func() interface{} {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		return tt{
+			policy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute},
+			ctx:    ctx,
+			errs: []error{
+				&Error{HTTPStatus: http.StatusServiceUnavailable},
+				nil,
+			},
+			attempts: 1,
+			status:   http.StatusServiceUnavailable,
+			err:      "Service Unavailable",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var attempts int
+		err := tt.policy.do(ctx, func() error {
+			err := tt.errs[attempts]
+			attempts++
+			return err
+		})
+		testy.StatusError(t, tt.err, tt.status, err)
+		if attempts != tt.attempts {
+			t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+		}
+	})
+}
+
+func TestExtractRetryPolicy(t *testing.T) {
+	def := &RetryPolicy{MaxAttempts: 2}
+	custom := &RetryPolicy{MaxAttempts: 5}
+	type tt struct {
+		options  []Options
+		expected *RetryPolicy
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no options", tt{
+		expected: def,
+	})
+	tests.Add("unrelated options", tt{
+		options:  []Options{{"foo": "bar"}},
+		expected: def,
+	})
+	tests.Add("override", tt{
+		options:  []Options{{OptionRetryPolicy: custom}},
+		expected: custom,
+	})
+	tests.Add("disable", tt{
+		options:  []Options{{OptionRetryPolicy: (*RetryPolicy)(nil)}},
+		expected: nil,
+	})
+	tests.Add("last wins", tt{
+		options:  []Options{{OptionRetryPolicy: custom}, {OptionRetryPolicy: def}},
+		expected: def,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		result := extractRetryPolicy(def, tt.options)
+		if result != tt.expected {
+			t.Errorf("Unexpected result: %v", result)
+		}
+	})
+}
+
+func TestMergeOptionsDropsRetryPolicy(t *testing.T) {
+	result := mergeOptions(Options{
+		OptionRetryPolicy: &RetryPolicy{},
+		"foo":             "bar",
+	})
+	if _, ok := result[OptionRetryPolicy]; ok {
+		t.Errorf("Retry policy passed to driver")
+	}
+	if result["foo"] != "bar" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+func TestGetRetry(t *testing.T) {
+	var attempts int
+	db := &DB{
+		client: &Client{},
+		retry:  &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		driverDB: &mock.DB{
+			GetFunc: func(_ context.Context, _ string, opts map[string]interface{}) (*driver.Document, error) {
+				attempts++
+				if opts != nil {
+					t.Errorf("Unexpected options: %v", opts)
+				}
+				if attempts < 3 {
+					return nil, &Error{HTTPStatus: http.StatusServiceUnavailable}
+				}
+				return &driver.Document{Rev: "1-xxx", Body: body(`{}`)}, nil
+			},
+		},
+	}
+	rs := db.Get(context.Background(), "foo")
+	if err := rs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if rev := rs.Rev(); rev != "1-xxx" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestPutRetry(t *testing.T) {
+	type tt struct {
+		doc      interface{}
+		options  Options
+		attempts int
+		status   int
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no rev", tt{
+		doc:      map[string]string{"foo": "bar"},
+		attempts: 1,
+		status:   http.StatusServiceUnavailable,
+		err:      "Service Unavailable",
+	})
+	tests.Add("rev in doc", tt{
+		doc:      map[string]string{"_rev": "1-xxx"},
+		attempts: 2,
+	})
+	tests.Add("rev in options", tt{
+		doc:      map[string]string{"foo": "bar"},
+		options:  Options{"rev": "1-xxx"},
+		attempts: 2,
+	})
+	tests.Add("disabled per call", tt{
+		doc:      map[string]string{"_rev": "1-xxx"},
+		options:  Options{OptionRetryPolicy: (*RetryPolicy)(nil)},
+		attempts: 1,
+		status:   http.StatusServiceUnavailable,
+		err:      "Service Unavailable",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		var attempts int
+		db := &DB{
+			client: &Client{},
+			retry:  &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			driverDB: &mock.DB{
+				PutFunc: func(context.Context, string, interface{}, map[string]interface{}) (string, error) {
+					attempts++
+					if attempts < 2 {
+						return "", &Error{HTTPStatus: http.StatusServiceUnavailable}
+					}
+					return "2-xxx", nil
+				},
+			},
+		}
+		_, err := db.Put(context.Background(), "foo", tt.doc, tt.options)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if attempts != tt.attempts {
+			t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+		}
+	})
+}
+
+func TestRetryAfterHonored(t *testing.T) {
+	var attempts int
+	db := &DB{
+		client: &Client{},
+		// Without the server's delay, the backoff would exceed the deadline,
+		// and the request would not be retried.
+		retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour},
+		driverDB: &mock.DB{
+			GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+				attempts++
+				if attempts == 1 {
+					return nil, kerrors.WithRetryAfter(kerrors.Status(http.StatusServiceUnavailable, "busy"), 10*time.Millisecond)
+				}
+				return &driver.Document{Rev: "1-xxx", Body: body(`{}`)}, nil
+			},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := db.Get(ctx, "foo").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("Retried after %v, before the requested delay", elapsed)
+	}
+}
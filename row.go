@@ -22,12 +22,13 @@ import (
 // multipart/related responses. When done, the underlying reader is closed.
 func (r *row) ScanDoc(dest interface{}) error {
 	defer r.body.Close() // nolint:errcheck
-	return json.NewDecoder(r.body).Decode(dest)
+	return decode(r.body, dest)
 }
 
 type row struct {
 	id   string
 	rev  string
+	etag string
 	body io.ReadCloser
 	atts *AttachmentsIterator
 
@@ -50,9 +51,10 @@ func (r *row) Finish() (ResultMetadata, error) {
 	return ResultMetadata{}, r.Close()
 }
 
-func (r *row) Err() error  { return r.err }
-func (r *row) ID() string  { return r.id }
-func (r *row) Rev() string { return r.rev }
+func (r *row) Err() error   { return r.err }
+func (r *row) ID() string   { return r.id }
+func (r *row) Rev() string  { return r.rev }
+func (r *row) ETag() string { return r.etag }
 
 func (r *row) Next() bool {
 	if r.err != nil {
@@ -0,0 +1,254 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+var schedulerNotImplemented = &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support the replication scheduler"}
+
+// SchedulerEvent is an entry in the history of a replication job, such as
+// "added", "started" or "crashed".
+type SchedulerEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	// Reason describes the cause of a "crashed" event.
+	Reason string `json:"reason,omitempty"`
+}
+
+// SchedulerInfo contains the replication statistics reported by the
+// replication scheduler.
+type SchedulerInfo struct {
+	ChangesPending        int64  `json:"changes_pending"`
+	CheckpointedSourceSeq string `json:"checkpointed_source_seq,omitempty"`
+	DocWriteFailures      int64  `json:"doc_write_failures"`
+	DocsRead              int64  `json:"docs_read"`
+	DocsWritten           int64  `json:"docs_written"`
+	MissingRevisionsFound int64  `json:"missing_revisions_found"`
+	RevisionsChecked      int64  `json:"revisions_checked"`
+	SourceSeq             string `json:"source_seq,omitempty"`
+	ThroughSeq            string `json:"through_seq,omitempty"`
+	// Error is the last error reported for a failing replication.
+	Error string `json:"error,omitempty"`
+}
+
+// SchedulerJob is a replication job, as reported by the /_scheduler/jobs
+// endpoint.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#scheduler-jobs
+type SchedulerJob struct {
+	// ID is the replication ID.
+	ID string `json:"id"`
+	// Database is the replicator database containing the replication
+	// document, if the job was not created with Replicate.
+	Database string `json:"database"`
+	// DocID is the ID of the replication document.
+	DocID  string `json:"doc_id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	User   string `json:"user"`
+	// Node is the cluster node running the job.
+	Node      string    `json:"node"`
+	PID       string    `json:"pid"`
+	StartTime time.Time `json:"start_time"`
+	// History lists the job's events, most recent first.
+	History []SchedulerEvent `json:"history"`
+	Info    SchedulerInfo    `json:"info"`
+}
+
+// ErrorCount returns the number of "crashed" events in the job's history.
+func (j *SchedulerJob) ErrorCount() int {
+	var count int
+	for _, event := range j.History {
+		if event.Type == "crashed" {
+			count++
+		}
+	}
+	return count
+}
+
+// LastUpdated returns the time of the job's most recent event, or the zero
+// time if its history is empty.
+func (j *SchedulerJob) LastUpdated() time.Time {
+	var last time.Time
+	for _, event := range j.History {
+		if event.Timestamp.After(last) {
+			last = event.Timestamp
+		}
+	}
+	return last
+}
+
+// SchedulerDoc is the state of a replication document, as reported by the
+// /_scheduler/docs endpoint.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#scheduler-docs
+type SchedulerDoc struct {
+	// ID is the replication ID.
+	ID string `json:"id"`
+	// Database is the replicator database containing the document.
+	Database string `json:"database"`
+	DocID    string `json:"doc_id"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	// Node is the cluster node running the replication.
+	Node  string           `json:"node"`
+	State ReplicationState `json:"state"`
+	// ErrorCount is the number of consecutive errors of the replication.
+	ErrorCount  int           `json:"error_count"`
+	StartTime   time.Time     `json:"start_time"`
+	LastUpdated time.Time     `json:"last_updated"`
+	Info        SchedulerInfo `json:"info"`
+}
+
+func schedulerJob(job *driver.SchedulerJob) *SchedulerJob {
+	var history []SchedulerEvent
+	if job.History != nil {
+		history = make([]SchedulerEvent, len(job.History))
+		for i, event := range job.History {
+			history[i] = SchedulerEvent(event)
+		}
+	}
+	return &SchedulerJob{
+		ID:        job.ID,
+		Database:  job.Database,
+		DocID:     job.DocID,
+		Source:    job.Source,
+		Target:    job.Target,
+		User:      job.User,
+		Node:      job.Node,
+		PID:       job.PID,
+		StartTime: job.StartTime,
+		History:   history,
+		Info:      SchedulerInfo(job.Info),
+	}
+}
+
+func schedulerDoc(doc *driver.SchedulerDoc) *SchedulerDoc {
+	return &SchedulerDoc{
+		ID:          doc.ID,
+		Database:    doc.Database,
+		DocID:       doc.DocID,
+		Source:      doc.Source,
+		Target:      doc.Target,
+		Node:        doc.Node,
+		State:       ReplicationState(doc.State),
+		ErrorCount:  doc.ErrorCount,
+		StartTime:   doc.StartTime,
+		LastUpdated: doc.LastUpdated,
+		Info:        SchedulerInfo(doc.Info),
+	}
+}
+
+// SchedulerJobs returns the replication jobs currently known to the
+// replication scheduler. The "limit" and "skip" options may be used to page
+// through the jobs.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#scheduler-jobs
+func (c *Client) SchedulerJobs(ctx context.Context, options ...Options) (jobs []*SchedulerJob, err error) {
+	op := c.op("SchedulerJobs", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	scheduler, ok := c.driverClient.(driver.ClientScheduler)
+	if !ok {
+		return nil, op.wrap(schedulerNotImplemented)
+	}
+	retry, opts := c.retryPolicy(options), mergeOptions(options...)
+	var driverJobs []*driver.SchedulerJob
+	err = retry.do(ctx, func() (err error) {
+		driverJobs, err = scheduler.SchedulerJobs(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	jobs = make([]*SchedulerJob, len(driverJobs))
+	for i, job := range driverJobs {
+		jobs[i] = schedulerJob(job)
+	}
+	return jobs, nil
+}
+
+// SchedulerDocs returns the states of the replication documents in the
+// replicator database replicatorDB, or in all replicator databases if
+// replicatorDB is empty. The "limit", "skip" and "states" options may be used
+// to page through and filter the documents.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#scheduler-docs
+func (c *Client) SchedulerDocs(ctx context.Context, replicatorDB string, options ...Options) (docs []*SchedulerDoc, err error) {
+	op := c.op("SchedulerDocs", replicatorDB)
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	scheduler, ok := c.driverClient.(driver.ClientScheduler)
+	if !ok {
+		return nil, op.wrap(schedulerNotImplemented)
+	}
+	retry, opts := c.retryPolicy(options), mergeOptions(options...)
+	var driverDocs []*driver.SchedulerDoc
+	err = retry.do(ctx, func() (err error) {
+		driverDocs, err = scheduler.SchedulerDocs(ctx, replicatorDB, opts)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	docs = make([]*SchedulerDoc, len(driverDocs))
+	for i, doc := range driverDocs {
+		docs[i] = schedulerDoc(doc)
+	}
+	return docs, nil
+}
+
+// SchedulerDoc returns the state of the replication document docID in the
+// replicator database replicatorDB.
+//
+// See https://docs.couchdb.org/en/stable/api/server/common.html#get--_scheduler-docs-replicator_db-docid
+func (c *Client) SchedulerDoc(ctx context.Context, replicatorDB, docID string) (doc *SchedulerDoc, err error) {
+	op := c.op("SchedulerDoc", replicatorDB)
+	op.DocID = docID
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
+	if replicatorDB == "" {
+		return nil, op.wrap(missingArg("replicatorDB"))
+	}
+	if docID == "" {
+		return nil, op.wrap(missingArg("docID"))
+	}
+	scheduler, ok := c.driverClient.(driver.ClientScheduler)
+	if !ok {
+		return nil, op.wrap(schedulerNotImplemented)
+	}
+	var driverDoc *driver.SchedulerDoc
+	err = c.retryPolicy(nil).do(ctx, func() (err error) {
+		driverDoc, err = scheduler.SchedulerDoc(ctx, replicatorDB, docID)
+		return err
+	})
+	if err != nil {
+		return nil, op.wrap(err)
+	}
+	return schedulerDoc(driverDoc), nil
+}
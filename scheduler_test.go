@@ -0,0 +1,222 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestSchedulerJobs(t *testing.T) {
+	type tt struct {
+		client driver.Client
+		want   []*SchedulerJob
+		status int
+		err    string
+	}
+
+	started := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		client: &mock.Client{},
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support the replication scheduler",
+	})
+	tests.Add("client error", tt{
+		client: &mock.ClientScheduler{
+			SchedulerJobsFunc: func(context.Context, map[string]interface{}) ([]*driver.SchedulerJob, error) {
+				return nil, errors.New("client error")
+			},
+		},
+		status: http.StatusInternalServerError,
+		err:    "client error",
+	})
+	tests.Add("success", tt{
+		client: &mock.ClientScheduler{
+			SchedulerJobsFunc: func(_ context.Context, opts map[string]interface{}) ([]*driver.SchedulerJob, error) {
+				if d := testy.DiffInterface(map[string]interface{}{"limit": 10}, opts); d != nil {
+					return nil, fmt.Errorf("Unexpected options:\n%s", d)
+				}
+				return []*driver.SchedulerJob{{
+					ID:        "abc+continuous",
+					Database:  "_replicator",
+					DocID:     "rep1",
+					Node:      "node1@127.0.0.1",
+					StartTime: started,
+					History: []driver.SchedulerEvent{
+						{Timestamp: started.Add(2 * time.Minute), Type: "crashed", Reason: "db_not_found"},
+						{Timestamp: started.Add(time.Minute), Type: "started"},
+						{Timestamp: started, Type: "added"},
+					},
+					Info: driver.SchedulerInfo{ChangesPending: 12, DocsRead: 3},
+				}}, nil
+			},
+		},
+		want: []*SchedulerJob{{
+			ID:        "abc+continuous",
+			Database:  "_replicator",
+			DocID:     "rep1",
+			Node:      "node1@127.0.0.1",
+			StartTime: started,
+			History: []SchedulerEvent{
+				{Timestamp: started.Add(2 * time.Minute), Type: "crashed", Reason: "db_not_found"},
+				{Timestamp: started.Add(time.Minute), Type: "started"},
+				{Timestamp: started, Type: "added"},
+			},
+			Info: SchedulerInfo{ChangesPending: 12, DocsRead: 3},
+		}},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		c := &Client{driverClient: tt.client}
+		got, err := c.SchedulerJobs(context.Background(), Options{"limit": 10})
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestSchedulerJobGetters(t *testing.T) {
+	now := time.Now()
+	job := &SchedulerJob{
+		History: []SchedulerEvent{
+			{Timestamp: now, Type: "crashed"},
+			{Timestamp: now.Add(-time.Minute), Type: "crashed"},
+			{Timestamp: now.Add(-2 * time.Minute), Type: "started"},
+		},
+	}
+	if n := job.ErrorCount(); n != 2 {
+		t.Errorf("Unexpected error count: %d", n)
+	}
+	if last := job.LastUpdated(); !last.Equal(now) {
+		t.Errorf("Unexpected last updated time: %v", last)
+	}
+	if last := (&SchedulerJob{}).LastUpdated(); !last.IsZero() {
+		t.Errorf("Expected zero time for empty history, got %v", last)
+	}
+}
+
+func TestSchedulerDocs(t *testing.T) {
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Client{
+		driverClient: &mock.ClientScheduler{
+			SchedulerDocsFunc: func(_ context.Context, replicatorDB string, opts map[string]interface{}) ([]*driver.SchedulerDoc, error) {
+				if replicatorDB != "other/_replicator" {
+					return nil, fmt.Errorf("Unexpected replicator db: %s", replicatorDB)
+				}
+				if d := testy.DiffInterface(map[string]interface{}{"states": "crashing"}, opts); d != nil {
+					return nil, fmt.Errorf("Unexpected options:\n%s", d)
+				}
+				return []*driver.SchedulerDoc{{
+					ID:          "abc",
+					Database:    "other/_replicator",
+					DocID:       "rep1",
+					Node:        "node2@127.0.0.1",
+					State:       "crashing",
+					ErrorCount:  3,
+					LastUpdated: updated,
+					Info:        driver.SchedulerInfo{Error: "db_not_found"},
+				}}, nil
+			},
+		},
+	}
+	got, err := c.SchedulerDocs(context.Background(), "other/_replicator", Options{"states": "crashing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*SchedulerDoc{{
+		ID:          "abc",
+		Database:    "other/_replicator",
+		DocID:       "rep1",
+		Node:        "node2@127.0.0.1",
+		State:       ReplicationCrashing,
+		ErrorCount:  3,
+		LastUpdated: updated,
+		Info:        SchedulerInfo{Error: "db_not_found"},
+	}}
+	if d := testy.DiffInterface(want, got); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSchedulerDoc(t *testing.T) {
+	type tt struct {
+		client       driver.Client
+		replicatorDB string
+		docID        string
+		want         *SchedulerDoc
+		status       int
+		err          string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("missing replicator db", tt{
+		client: &mock.ClientScheduler{},
+		docID:  "rep1",
+		status: http.StatusBadRequest,
+		err:    "kivik: replicatorDB required",
+	})
+	tests.Add("missing doc id", tt{
+		client:       &mock.ClientScheduler{},
+		replicatorDB: "_replicator",
+		status:       http.StatusBadRequest,
+		err:          "kivik: docID required",
+	})
+	tests.Add("not implemented", tt{
+		client:       &mock.Client{},
+		replicatorDB: "_replicator",
+		docID:        "rep1",
+		status:       http.StatusNotImplemented,
+		err:          "kivik: driver does not support the replication scheduler",
+	})
+	tests.Add("not found", tt{
+		client: &mock.ClientScheduler{
+			SchedulerDocFunc: func(context.Context, string, string) (*driver.SchedulerDoc, error) {
+				return nil, &Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+			},
+		},
+		replicatorDB: "_replicator",
+		docID:        "rep1",
+		status:       http.StatusNotFound,
+		err:          "missing",
+	})
+	tests.Add("success", tt{
+		client: &mock.ClientScheduler{
+			SchedulerDocFunc: func(_ context.Context, replicatorDB, docID string) (*driver.SchedulerDoc, error) {
+				return &driver.SchedulerDoc{Database: replicatorDB, DocID: docID, State: "completed"}, nil
+			},
+		},
+		replicatorDB: "_replicator",
+		docID:        "rep1",
+		want:         &SchedulerDoc{Database: "_replicator", DocID: "rep1", State: ReplicationComplete},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		c := &Client{driverClient: tt.client}
+		got, err := c.SchedulerDoc(context.Background(), tt.replicatorDB, tt.docID)
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -12,6 +12,12 @@
 
 package kivik
 
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
 // Members represents the members of a database security document.
 type Members struct {
 	Names []string `json:"names,omitempty"`
@@ -22,4 +28,306 @@ type Members struct {
 type Security struct {
 	Admins  Members `json:"admins"`
 	Members Members `json:"members"`
+	// Extra holds any other fields of the security document, keyed by name.
+	// They are preserved by UpdateSecurity and its helpers.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// SecurityLevel is a level of access to a database.
+type SecurityLevel int
+
+// The levels of access to a database.
+const (
+	// ReaderAccess allows reading documents, and writing non-design
+	// documents.
+	ReaderAccess SecurityLevel = iota + 1
+	// AdminAccess additionally allows writing design documents, and the
+	// security document.
+	AdminAccess
+)
+
+// serverAdminRole is the role of CouchDB server administrators, which have
+// access to every database.
+const serverAdminRole = "_admin"
+
+// securityUpdateAttempts is the number of times UpdateSecurity attempts an
+// update, when it is overwritten by a concurrent update.
+const securityUpdateAttempts = 3
+
+func (m Members) includes(name string, roles []string) bool {
+	if name != "" && contains(m.Names, name) {
+		return true
+	}
+	for _, role := range roles {
+		if contains(m.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Members) isEmpty() bool {
+	return len(m.Names) == 0 && len(m.Roles) == 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether session would be granted level access to the
+// database, according to the rules CouchDB applies to the security document:
+// server admins, with the _admin role, are allowed everything; database
+// admins are matched by name or role against Admins; readers are matched
+// against Members, or else admins. A database without members is public, and
+// allows any user, including an anonymous one, to read. A nil session is
+// anonymous.
+func (s *Security) Allows(session *Session, level SecurityLevel) bool {
+	var name string
+	var roles []string
+	if session != nil {
+		name, roles = session.Name, session.Roles
+	}
+	if contains(roles, serverAdminRole) {
+		return true
+	}
+	if s == nil {
+		return level == ReaderAccess
+	}
+	if s.Admins.includes(name, roles) {
+		return true
+	}
+	if level != ReaderAccess {
+		return false
+	}
+	return s.Members.isEmpty() || s.Members.includes(name, roles)
+}
+
+func (s *Security) clone() *Security {
+	c := &Security{}
+	c.Admins.Names = append([]string(nil), s.Admins.Names...)
+	c.Admins.Roles = append([]string(nil), s.Admins.Roles...)
+	c.Members.Names = append([]string(nil), s.Members.Names...)
+	c.Members.Roles = append([]string(nil), s.Members.Roles...)
+	if s.Extra != nil {
+		c.Extra = copyJSON(s.Extra).(map[string]interface{})
+	}
+	return c
+}
+
+// copyJSON returns a deep copy of a decoded JSON value.
+func copyJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			c[k] = copyJSON(x)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i, x := range t {
+			c[i] = copyJSON(x)
+		}
+		return c
+	}
+	return v
+}
+
+// Equal returns true if s and other grant the same names and roles, in any
+// order.
+func (s *Security) Equal(other *Security) bool {
+	return DiffSecurity(s, other).Empty()
+}
+
+// same returns true if s and other grant the same names and roles, and have
+// the same extra fields.
+func (s *Security) same(other *Security) bool {
+	if len(s.Extra) == 0 && len(other.Extra) == 0 {
+		return s.Equal(other)
+	}
+	return s.Equal(other) && reflect.DeepEqual(s.Extra, other.Extra)
+}
+
+// MembersDiff describes the names and roles added to, and removed from, a
+// Members list.
+type MembersDiff struct {
+	AddedNames   []string `json:"added_names,omitempty"`
+	RemovedNames []string `json:"removed_names,omitempty"`
+	AddedRoles   []string `json:"added_roles,omitempty"`
+	RemovedRoles []string `json:"removed_roles,omitempty"`
+}
+
+// Empty returns true if there are no differences.
+func (d MembersDiff) Empty() bool {
+	return len(d.AddedNames) == 0 && len(d.RemovedNames) == 0 &&
+		len(d.AddedRoles) == 0 && len(d.RemovedRoles) == 0
+}
+
+// SecurityDiff describes the differences between two security documents. It
+// may be marshaled to JSON, to log or review changes to access control.
+type SecurityDiff struct {
+	Admins  MembersDiff `json:"admins"`
+	Members MembersDiff `json:"members"`
+}
+
+// Empty returns true if there are no differences.
+func (d *SecurityDiff) Empty() bool {
+	return d.Admins.Empty() && d.Members.Empty()
+}
+
+// DiffSecurity returns the names and roles added and removed to get from old
+// to new. A nil document is treated as empty. Order is not significant.
+func DiffSecurity(old, new *Security) *SecurityDiff {
+	if old == nil {
+		old = &Security{}
+	}
+	if new == nil {
+		new = &Security{}
+	}
+	return &SecurityDiff{
+		Admins:  diffMembers(old.Admins, new.Admins),
+		Members: diffMembers(old.Members, new.Members),
+	}
+}
+
+func diffMembers(old, new Members) MembersDiff {
+	return MembersDiff{
+		AddedNames:   missingFrom(new.Names, old.Names),
+		RemovedNames: missingFrom(old.Names, new.Names),
+		AddedRoles:   missingFrom(new.Roles, old.Roles),
+		RemovedRoles: missingFrom(old.Roles, new.Roles),
+	}
+}
+
+// missingFrom returns the values of a which are not in b.
+func missingFrom(a, b []string) []string {
+	var result []string
+	for _, v := range a {
+		if !contains(b, v) && !contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func addTo(list *[]string, s string) {
+	if !contains(*list, s) {
+		*list = append(*list, s)
+	}
+}
+
+func removeFrom(list *[]string, s string) {
+	result := (*list)[:0]
+	for _, v := range *list {
+		if v != s {
+			result = append(result, v)
+		}
+	}
+	*list = result
+}
+
+// UpdateSecurity applies fn to the database's security document, and writes
+// the result. As CouchDB does not track revisions of security documents, the
+// document is read again after writing, to verify that the change was not
+// overwritten by a concurrent update. If it was, fn is applied again to the
+// current document. A 409 Conflict error is returned if the update could not
+// be verified after several attempts.
+//
+// The security document is not written if fn does not change it. The
+// verified document is returned.
+func (db *DB) UpdateSecurity(ctx context.Context, fn func(*Security)) (*Security, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := db.Security(ctx)
+		if err != nil {
+			return nil, err
+		}
+		want := current.clone()
+		fn(want)
+		if want.same(current) {
+			return current, nil
+		}
+		if err := db.SetSecurity(ctx, want); err != nil {
+			return nil, err
+		}
+		got, err := db.Security(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if got.same(want) {
+			return got, nil
+		}
+		if attempt >= securityUpdateAttempts {
+			return nil, &Error{HTTPStatus: http.StatusConflict, Message: "kivik: security document modified concurrently"}
+		}
+	}
+}
+
+func (db *DB) updateSecurityList(ctx context.Context, arg, value string, list func(*Security) *[]string, modify func(*[]string, string)) error {
+	if value == "" {
+		return missingArg(arg)
+	}
+	_, err := db.UpdateSecurity(ctx, func(s *Security) {
+		modify(list(s), value)
+	})
+	return err
+}
+
+func adminNames(s *Security) *[]string  { return &s.Admins.Names }
+func adminRoles(s *Security) *[]string  { return &s.Admins.Roles }
+func memberNames(s *Security) *[]string { return &s.Members.Names }
+func memberRoles(s *Security) *[]string { return &s.Members.Roles }
+
+// AddMember adds the named user to the database's members, with
+// UpdateSecurity.
+func (db *DB) AddMember(ctx context.Context, name string) error {
+	return db.updateSecurityList(ctx, "name", name, memberNames, addTo)
+}
+
+// RemoveMember removes the named user from the database's members, with
+// UpdateSecurity. Note that removing the last member makes the database
+// public.
+func (db *DB) RemoveMember(ctx context.Context, name string) error {
+	return db.updateSecurityList(ctx, "name", name, memberNames, removeFrom)
+}
+
+// AddMemberRole adds role to the database's member roles, with
+// UpdateSecurity.
+func (db *DB) AddMemberRole(ctx context.Context, role string) error {
+	return db.updateSecurityList(ctx, "role", role, memberRoles, addTo)
+}
+
+// RemoveMemberRole removes role from the database's member roles, with
+// UpdateSecurity. Note that removing the last member makes the database
+// public.
+func (db *DB) RemoveMemberRole(ctx context.Context, role string) error {
+	return db.updateSecurityList(ctx, "role", role, memberRoles, removeFrom)
+}
+
+// AddAdmin adds the named user to the database's admins, with
+// UpdateSecurity.
+func (db *DB) AddAdmin(ctx context.Context, name string) error {
+	return db.updateSecurityList(ctx, "name", name, adminNames, addTo)
+}
+
+// RemoveAdmin removes the named user from the database's admins, with
+// UpdateSecurity.
+func (db *DB) RemoveAdmin(ctx context.Context, name string) error {
+	return db.updateSecurityList(ctx, "name", name, adminNames, removeFrom)
+}
+
+// AddAdminRole adds role to the database's admin roles, with
+// UpdateSecurity.
+func (db *DB) AddAdminRole(ctx context.Context, role string) error {
+	return db.updateSecurityList(ctx, "role", role, adminRoles, addTo)
+}
+
+// RemoveAdminRole removes role from the database's admin roles, with
+// UpdateSecurity.
+func (db *DB) RemoveAdminRole(ctx context.Context, role string) error {
+	return db.updateSecurityList(ctx, "role", role, adminRoles, removeFrom)
 }
@@ -0,0 +1,257 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func TestSecurityAllows(t *testing.T) {
+	sec := &Security{
+		Admins:  Members{Names: []string{"alice"}, Roles: []string{"ops"}},
+		Members: Members{Names: []string{"bob"}, Roles: []string{"staff"}},
+	}
+	type tt struct {
+		sec     *Security
+		session *Session
+		reader  bool
+		admin   bool
+	}
+	tests := testy.NewTable()
+	tests.Add("server admin", tt{
+		sec:     sec,
+		session: &Session{Name: "root", Roles: []string{"_admin"}},
+		reader:  true,
+		admin:   true,
+	})
+	tests.Add("admin by name", tt{
+		sec:     sec,
+		session: &Session{Name: "alice"},
+		reader:  true,
+		admin:   true,
+	})
+	tests.Add("admin by role", tt{
+		sec:     sec,
+		session: &Session{Name: "carol", Roles: []string{"ops"}},
+		reader:  true,
+		admin:   true,
+	})
+	tests.Add("member by name", tt{
+		sec:     sec,
+		session: &Session{Name: "bob"},
+		reader:  true,
+	})
+	tests.Add("member by role", tt{
+		sec:     sec,
+		session: &Session{Name: "dave", Roles: []string{"staff"}},
+		reader:  true,
+	})
+	tests.Add("other user", tt{
+		sec:     sec,
+		session: &Session{Name: "eve", Roles: []string{"guests"}},
+	})
+	tests.Add("anonymous", tt{
+		sec: sec,
+	})
+	tests.Add("anonymous does not match empty name", tt{
+		sec: &Security{Members: Members{Names: []string{""}}},
+	})
+	tests.Add("public database", tt{
+		sec:    &Security{Admins: Members{Names: []string{"alice"}}},
+		reader: true,
+	})
+	tests.Add("nil security", tt{
+		session: &Session{Name: "bob"},
+		reader:  true,
+	})
+	tests.Run(t, func(t *testing.T, tt tt) {
+		if got := tt.sec.Allows(tt.session, ReaderAccess); got != tt.reader {
+			t.Errorf("Unexpected reader access: %t", got)
+		}
+		if got := tt.sec.Allows(tt.session, AdminAccess); got != tt.admin {
+			t.Errorf("Unexpected admin access: %t", got)
+		}
+	})
+}
+
+func TestDiffSecurity(t *testing.T) {
+	old := &Security{
+		Admins:  Members{Names: []string{"alice"}, Roles: []string{"ops"}},
+		Members: Members{Names: []string{"bob", "carol"}},
+	}
+	new := &Security{
+		Admins:  Members{Names: []string{"alice"}, Roles: []string{"ops"}},
+		Members: Members{Names: []string{"carol", "dave"}, Roles: []string{"staff"}},
+	}
+	diff := DiffSecurity(old, new)
+	if diff.Empty() {
+		t.Error("Expected differences")
+	}
+	result, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"admins":{},"members":{"added_names":["dave"],"removed_names":["bob"],"added_roles":["staff"]}}`
+	if d := testy.DiffJSON([]byte(expected), result); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface(&SecurityDiff{
+		Admins: MembersDiff{RemovedNames: []string{"alice"}, RemovedRoles: []string{"ops"}},
+	}, DiffSecurity(&Security{Admins: old.Admins}, nil)); d != nil {
+		t.Error(d)
+	}
+	reordered := &Security{Members: Members{Names: []string{"carol", "bob"}}, Admins: old.Admins}
+	if !old.Equal(reordered) {
+		t.Error("Expected documents differing only in order to be equal")
+	}
+}
+
+// securityStore is an in-memory security document.
+type securityStore struct {
+	sec driver.Security
+	// afterSet, if set, is called after each write, to simulate concurrent
+	// updates.
+	afterSet func(*driver.Security)
+	sets     int
+}
+
+func (s *securityStore) db() *DB {
+	return &DB{driverDB: &mock.DB{
+		SecurityFunc: func(context.Context) (*driver.Security, error) {
+			sec := s.sec
+			return &sec, nil
+		},
+		SetSecurityFunc: func(_ context.Context, sec *driver.Security) error {
+			s.sets++
+			s.sec = *sec
+			if s.afterSet != nil {
+				s.afterSet(&s.sec)
+			}
+			return nil
+		},
+	}}
+}
+
+func TestSecurityHelpers(t *testing.T) {
+	s := &securityStore{sec: driver.Security{
+		Admins:  driver.Members{Names: []string{"alice"}},
+		Members: driver.Members{Roles: []string{"staff"}},
+	}}
+	db := s.db()
+	ctx := context.Background()
+	for _, fn := range []func() error{
+		func() error { return db.AddMember(ctx, "bob") },
+		func() error { return db.AddMember(ctx, "bob") },
+		func() error { return db.AddMemberRole(ctx, "guests") },
+		func() error { return db.RemoveMemberRole(ctx, "staff") },
+		func() error { return db.AddAdmin(ctx, "carol") },
+		func() error { return db.RemoveAdmin(ctx, "alice") },
+		func() error { return db.AddAdminRole(ctx, "ops") },
+		func() error { return db.RemoveAdminRole(ctx, "none") },
+	} {
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := testy.DiffInterface(driver.Security{
+		Admins:  driver.Members{Names: []string{"carol"}, Roles: []string{"ops"}},
+		Members: driver.Members{Names: []string{"bob"}, Roles: []string{"guests"}},
+	}, s.sec); d != nil {
+		t.Error(d)
+	}
+	if s.sets != 6 {
+		t.Errorf("Unexpected number of writes: %d", s.sets)
+	}
+	err := db.AddMember(ctx, "")
+	testy.StatusError(t, "kivik: name required", http.StatusBadRequest, err)
+	err = db.RemoveAdminRole(ctx, "")
+	testy.StatusError(t, "kivik: role required", http.StatusBadRequest, err)
+}
+
+func TestUpdateSecurityConcurrent(t *testing.T) {
+	s := &securityStore{}
+	concurrent := 1
+	s.afterSet = func(sec *driver.Security) {
+		if concurrent > 0 {
+			concurrent--
+			*sec = driver.Security{Admins: driver.Members{Names: []string{"alice"}}}
+		}
+	}
+	db := s.db()
+	sec, err := db.UpdateSecurity(context.Background(), func(sec *Security) {
+		addTo(&sec.Members.Names, "bob")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Security{
+		Admins:  Members{Names: []string{"alice"}},
+		Members: Members{Names: []string{"bob"}},
+	}
+	if d := testy.DiffInterface(expected, sec); d != nil {
+		t.Error(d)
+	}
+	if s.sets != 2 {
+		t.Errorf("Unexpected number of writes: %d", s.sets)
+	}
+
+	s.afterSet = func(sec *driver.Security) {
+		*sec = driver.Security{}
+	}
+	s.sets = 0
+	err = db.AddAdmin(context.Background(), "carol")
+	testy.StatusError(t, "kivik: security document modified concurrently", http.StatusConflict, err)
+	if s.sets != securityUpdateAttempts {
+		t.Errorf("Unexpected number of attempts: %d", s.sets)
+	}
+}
+
+func TestUpdateSecurityExtra(t *testing.T) {
+	s := &securityStore{sec: driver.Security{
+		Admins: driver.Members{Names: []string{"alice"}},
+		Extra:  map[string]interface{}{"couchdb_auth_only": true},
+	}}
+	db := s.db()
+	if err := db.AddMember(context.Background(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	expected := driver.Security{
+		Admins:  driver.Members{Names: []string{"alice"}},
+		Members: driver.Members{Names: []string{"bob"}},
+		Extra:   map[string]interface{}{"couchdb_auth_only": true},
+	}
+	if d := testy.DiffInterface(expected, s.sec); d != nil {
+		t.Error(d)
+	}
+
+	s.sets = 0
+	sec, err := db.UpdateSecurity(context.Background(), func(sec *Security) {
+		sec.Extra["couchdb_auth_only"] = false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.sets != 1 {
+		t.Errorf("Unexpected number of writes: %d", s.sets)
+	}
+	if d := testy.DiffInterface(map[string]interface{}{"couchdb_auth_only": false}, sec.Extra); d != nil {
+		t.Error(d)
+	}
+}
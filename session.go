@@ -42,14 +42,24 @@ type Session struct {
 }
 
 // Session returns information about the currently authenticated user.
-func (c *Client) Session(ctx context.Context) (*Session, error) {
+func (c *Client) Session(ctx context.Context) (session *Session, err error) {
+	op := c.op("Session", "")
+	ctx, span := c.trace(ctx, op)
+	defer span.finish(&err)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, op.wrap(err)
+	}
 	if sessioner, ok := c.driverClient.(driver.Sessioner); ok {
-		session, err := sessioner.Session(ctx)
+		var session *driver.Session
+		err := c.retry.do(ctx, func() (err error) {
+			session, err = sessioner.Session(ctx)
+			return err
+		})
 		if err != nil {
-			return nil, err
+			return nil, op.wrap(err)
 		}
 		ses := Session(*session)
 		return &ses, nil
 	}
-	return nil, &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support sessions"}
+	return nil, op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not support sessions"})
 }
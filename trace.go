@@ -0,0 +1,177 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// OptionTracer is the option key used to set a Tracer, which observes every
+// call made with the client and its DB handles. It may be passed to New. This
+// option is consumed by Kivik, and is never passed to the driver.
+//
+// The instrument package provides tracers which expose Prometheus-style
+// metrics and OpenTelemetry-style spans.
+const OptionTracer = "kivik:tracer"
+
+// Tracer observes Client and DB method calls.
+type Tracer interface {
+	// StartSpan is called at the start of each call. The returned context is
+	// used for the remainder of the call, including any calls made to the
+	// driver, so it may be used to propagate trace context.
+	StartSpan(ctx context.Context, op *Operation) (context.Context, Span)
+}
+
+// Span observes a single Client or DB method call.
+//
+// For methods which return an iterator, such as a ResultSet, Changes,
+// BulkResults or DBUpdates, the span remains open until the iterator is
+// closed, either explicitly, or implicitly after the last result has been
+// read. For Get, this is when the document has been read with ScanDoc, or the
+// ResultSet is closed.
+type Span interface {
+	// AddRows is called as rows or results are read.
+	AddRows(n int)
+	// AddBytes is called as document or row data is read.
+	AddBytes(n int64)
+	// End is called exactly once, when the call completes. err is nil on
+	// success. StatusCode(err) returns the status of a failed call.
+	End(err error)
+}
+
+// extractTracer returns the value of the last OptionTracer key set in options.
+func extractTracer(options []Options) Tracer {
+	var tracer Tracer
+	for _, opts := range options {
+		if v, ok := opts[OptionTracer]; ok {
+			tracer, _ = v.(Tracer)
+		}
+	}
+	return tracer
+}
+
+// span wraps a Span to guarantee that it is ended only once. A nil *span is
+// valid, and does nothing, so that tracing may be skipped when no Tracer is
+// configured.
+type span struct {
+	Span
+	once sync.Once
+}
+
+// trace starts a span for op, if c has a Tracer.
+func (c *Client) trace(ctx context.Context, op *Operation) (context.Context, *span) {
+	if c == nil || c.tracer == nil {
+		return ctx, nil
+	}
+	ctx, s := c.tracer.StartSpan(ctx, op)
+	if s == nil {
+		return ctx, nil
+	}
+	return ctx, &span{Span: s}
+}
+
+// trace starts a span for op, if db's client has a Tracer.
+func (db *DB) trace(ctx context.Context, op *Operation) (context.Context, *span) {
+	return db.client.trace(ctx, op)
+}
+
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	s.once.Do(func() {
+		s.Span.End(err)
+	})
+}
+
+// fail ends the span with err, and returns err.
+func (s *span) fail(err error) error {
+	s.end(err)
+	return err
+}
+
+// finish ends the span with the error pointed to by err. It is intended to be
+// deferred by methods with a named error result.
+func (s *span) finish(err *error) {
+	s.end(*err)
+}
+
+// addRow records a result read by an iterator. Streamed row values and
+// documents are wrapped so that their bytes are counted as they are read.
+func (s *span) addRow(v interface{}) {
+	if s == nil {
+		return
+	}
+	s.AddRows(1)
+	var size int
+	switch t := v.(type) {
+	case *driver.Row:
+		size = len(t.Key) + len(t.Value) + len(t.Doc)
+		if t.ValueReader != nil {
+			t.ValueReader = &countingReader{Reader: t.ValueReader, span: s}
+		}
+		if t.DocReader != nil {
+			t.DocReader = &countingReader{Reader: t.DocReader, span: s}
+		}
+	case *driver.Change:
+		size = len(t.Doc)
+	}
+	if size > 0 {
+		s.AddBytes(int64(size))
+	}
+}
+
+// countingReader reports the bytes read from Reader to span.
+type countingReader struct {
+	io.Reader
+	span *span
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.span.AddBytes(int64(n))
+	}
+	return n, err
+}
+
+// tracedBody reports the bytes read from a document body to span, and ends
+// the span when the body is closed.
+type tracedBody struct {
+	countingReader
+	closer io.Closer
+}
+
+func newTracedBody(body io.ReadCloser, s *span) io.ReadCloser {
+	if s == nil {
+		return body
+	}
+	s.AddRows(1)
+	return &tracedBody{
+		countingReader: countingReader{Reader: body, span: s},
+		closer:         body,
+	}
+}
+
+func (b *tracedBody) Close() error {
+	err := b.closer.Close()
+	b.span.end(err)
+	return err
+}
@@ -0,0 +1,196 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+type testSpan struct {
+	mu    sync.Mutex
+	op    *Operation
+	rows  int
+	bytes int64
+	ended int
+	err   error
+}
+
+func (s *testSpan) AddRows(n int) {
+	s.mu.Lock()
+	s.rows += n
+	s.mu.Unlock()
+}
+
+func (s *testSpan) AddBytes(n int64) {
+	s.mu.Lock()
+	s.bytes += n
+	s.mu.Unlock()
+}
+
+func (s *testSpan) End(err error) {
+	s.mu.Lock()
+	s.ended++
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *testSpan) state() (rows int, bytes int64, ended int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows, s.bytes, s.ended, s.err
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, op *Operation) (context.Context, Span) {
+	s := &testSpan{op: op}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestTraceRows(t *testing.T) {
+	tracer := &testTracer{}
+	var n int
+	db := &DB{
+		client: &Client{driverName: "mock", tracer: tracer},
+		name:   "foo",
+		driverDB: &mock.DB{
+			AllDocsFunc: func(_ context.Context, opts map[string]interface{}) (driver.Rows, error) {
+				if _, ok := opts[OptionTracer]; ok {
+					t.Error("OptionTracer passed to driver")
+				}
+				return &mock.Rows{
+					NextFunc: func(row *driver.Row) error {
+						if n == 2 {
+							return io.EOF
+						}
+						n++
+						row.ID = "a"
+						row.Key = []byte(`"a"`)
+						row.Value = []byte(`{}`)
+						return nil
+					},
+				}, nil
+			},
+		},
+	}
+	rs := db.AllDocs(context.Background(), Options{OptionTracer: tracer})
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if op := span.op; op.Method != "AllDocs" || op.DB != "foo" || op.Driver != "mock" {
+		t.Errorf("Unexpected operation: %s", op)
+	}
+	if _, _, ended, _ := span.state(); ended != 0 {
+		t.Fatal("Span ended before rows were read")
+	}
+	for rs.Next() {
+	}
+	if err := rs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	rows, bytes, ended, err := span.state()
+	if ended != 1 {
+		t.Errorf("Expected span to end once, got %d", ended)
+	}
+	if err != nil {
+		t.Errorf("Unexpected span error: %s", err)
+	}
+	if rows != 2 || bytes != 10 {
+		t.Errorf("Unexpected counts: %d rows, %d bytes", rows, bytes)
+	}
+	_ = rs.Close()
+	if _, _, ended, _ := span.state(); ended != 1 {
+		t.Errorf("Span ended again on Close")
+	}
+}
+
+func TestTraceError(t *testing.T) {
+	tracer := &testTracer{}
+	db := &DB{
+		client: &Client{tracer: tracer},
+		driverDB: &mock.DB{
+			AllDocsFunc: func(context.Context, map[string]interface{}) (driver.Rows, error) {
+				return nil, &Error{HTTPStatus: http.StatusNotFound, Err: errors.New("missing")}
+			},
+		},
+	}
+	rs := db.AllDocs(context.Background())
+	_ = rs.Close()
+	_, _, ended, err := tracer.spans[0].state()
+	if ended != 1 {
+		t.Fatalf("Expected span to end once, got %d", ended)
+	}
+	if status := StatusCode(err); status != http.StatusNotFound {
+		t.Errorf("Unexpected status: %d", status)
+	}
+}
+
+func TestTraceGet(t *testing.T) {
+	tracer := &testTracer{}
+	db := &DB{
+		client: &Client{tracer: tracer},
+		driverDB: &mock.DB{
+			GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+				return &driver.Document{
+					Rev:  "1-xxx",
+					Body: ioutil.NopCloser(strings.NewReader(`{"_id":"foo"}`)),
+				}, nil
+			},
+		},
+	}
+	rs := db.Get(context.Background(), "foo")
+	span := tracer.spans[0]
+	if span.op.DocID != "foo" {
+		t.Errorf("Unexpected doc ID: %s", span.op.DocID)
+	}
+	var doc map[string]interface{}
+	if err := rs.ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	rows, bytes, ended, err := span.state()
+	if ended != 1 || err != nil {
+		t.Errorf("Expected span to end successfully, got %d, %v", ended, err)
+	}
+	if rows != 1 || bytes != 13 {
+		t.Errorf("Unexpected counts: %d rows, %d bytes", rows, bytes)
+	}
+}
+
+func TestTraceUntraced(t *testing.T) {
+	db := &DB{
+		client: &Client{},
+		driverDB: &mock.DB{
+			GetFunc: func(context.Context, string, map[string]interface{}) (*driver.Document, error) {
+				return &driver.Document{Body: ioutil.NopCloser(strings.NewReader(`{}`))}, nil
+			},
+		},
+	}
+	var doc map[string]interface{}
+	if err := db.Get(context.Background(), "foo").ScanDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -90,6 +90,11 @@ func (f *DBUpdates) Seq() string {
 
 // DBUpdates begins polling for database updates.
 func (c *Client) DBUpdates(ctx context.Context, options ...Options) (*DBUpdates, error) {
+	op := c.op("DBUpdates", "")
+	ctx, span := c.trace(ctx, op)
+	if err := c.checkIdentity(ctx); err != nil {
+		return nil, span.fail(op.wrap(err))
+	}
 	var updaterFunc func(context.Context, map[string]interface{}) (driver.DBUpdates, error)
 	switch t := c.driverClient.(type) {
 	case driver.DBUpdaterWithOptions:
@@ -99,12 +104,14 @@ func (c *Client) DBUpdates(ctx context.Context, options ...Options) (*DBUpdates,
 			return t.DBUpdates(ctx)
 		}
 	default:
-		return nil, &Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not implement DBUpdater"}
+		return nil, span.fail(op.wrap(&Error{HTTPStatus: http.StatusNotImplemented, Message: "kivik: driver does not implement DBUpdater"}))
 	}
 
 	updatesi, err := updaterFunc(ctx, mergeOptions(options...))
 	if err != nil {
-		return nil, err
+		return nil, span.fail(op.wrap(err))
 	}
-	return newDBUpdates(context.Background(), updatesi), nil
+	updates := newDBUpdates(context.Background(), updatesi)
+	updates.trace(span)
+	return updates, nil
 }
@@ -0,0 +1,242 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/dannyzhou2015/kivik/v4/pbkdf2"
+)
+
+// UsersDB is the name of the CouchDB authentication database.
+const UsersDB = "_users"
+
+// userUpdateAttempts is the number of times an update to a user document is
+// attempted, when it conflicts with a concurrent update.
+const userUpdateAttempts = 5
+
+// passwordFields are the fields of a user document which store the password.
+// They are removed when a new password is set, to be recomputed by the
+// server.
+var passwordFields = []string{"password_scheme", "pbkdf2_prf", "iterations", "salt", "derived_key", "password_sha"}
+
+// Users manages the user documents of the _users database.
+//
+// See https://docs.couchdb.org/en/stable/intro/security.html#authentication-database
+type Users struct {
+	db *DB
+}
+
+// Users returns a handle to manage the users of the server's authentication
+// database. options are passed to the DB method.
+func (c *Client) Users(options ...Options) *Users {
+	return &Users{db: c.DB(UsersDB, options...)}
+}
+
+// User is a user of the _users database.
+type User struct {
+	// Name is the user name, without the UserPrefix.
+	Name  string
+	Roles []string
+	Rev   string
+	// Credentials are the stored password fields, or nil if the user has no
+	// password, or the server has not yet hashed it.
+	Credentials *pbkdf2.Credentials
+	// Doc is the complete user document, including any custom fields.
+	Doc map[string]interface{}
+}
+
+func userDocID(name string) string {
+	return UserPrefix + name
+}
+
+func notAUser(name string) error {
+	return &Error{HTTPStatus: http.StatusNotFound, Message: "kivik: " + userDocID(name) + " is not a user document"}
+}
+
+// parseUser parses a user document. It returns nil if the document is not a
+// user document.
+func parseUser(raw jsoniter.RawMessage) (*User, error) {
+	var doc struct {
+		pbkdf2.Credentials
+		Rev   string   `json:"_rev"`
+		Name  string   `json:"name"`
+		Type  string   `json:"type"`
+		Roles []string `json:"roles"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Type != "user" {
+		return nil, nil
+	}
+	user := &User{
+		Name:  doc.Name,
+		Roles: doc.Roles,
+		Rev:   doc.Rev,
+	}
+	if doc.Scheme != "" {
+		creds := doc.Credentials
+		user.Credentials = &creds
+	}
+	if err := json.Unmarshal(raw, &user.Doc); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser creates a new user with the given password and roles. The
+// password is stored in plain text, to be hashed by the server. It returns
+// the revision of the new user document, or a 409 Conflict error if the user
+// already exists.
+func (u *Users) CreateUser(ctx context.Context, name, password string, roles ...string) (rev string, err error) {
+	if name == "" {
+		return "", missingArg("name")
+	}
+	if password == "" {
+		return "", missingArg("password")
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return u.db.Put(ctx, userDocID(name), map[string]interface{}{
+		"_id":      userDocID(name),
+		"name":     name,
+		"type":     "user",
+		"roles":    roles,
+		"password": password,
+	})
+}
+
+// GetUser returns the named user. It returns a 404 Not Found error if the
+// user does not exist.
+func (u *Users) GetUser(ctx context.Context, name string) (*User, error) {
+	if name == "" {
+		return nil, missingArg("name")
+	}
+	var raw jsoniter.RawMessage
+	if err := u.db.Get(ctx, userDocID(name)).ScanDoc(&raw); err != nil {
+		return nil, err
+	}
+	user, err := parseUser(raw)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, notAUser(name)
+	}
+	return user, nil
+}
+
+// SetPassword replaces the password of the named user, and returns the new
+// revision of the user document.
+func (u *Users) SetPassword(ctx context.Context, name, password string) (rev string, err error) {
+	if password == "" {
+		return "", missingArg("password")
+	}
+	return u.update(ctx, name, func(doc map[string]interface{}) {
+		for _, field := range passwordFields {
+			delete(doc, field)
+		}
+		doc["password"] = password
+	})
+}
+
+// SetRoles replaces the roles of the named user, and returns the new revision
+// of the user document.
+func (u *Users) SetRoles(ctx context.Context, name string, roles []string) (rev string, err error) {
+	if roles == nil {
+		roles = []string{}
+	}
+	return u.update(ctx, name, func(doc map[string]interface{}) {
+		doc["roles"] = roles
+	})
+}
+
+// update applies fn to the current user document, and stores the result,
+// preserving any custom fields. If the update conflicts with a concurrent
+// update, it is re-applied to the new document.
+func (u *Users) update(ctx context.Context, name string, fn func(map[string]interface{})) (string, error) {
+	if name == "" {
+		return "", missingArg("name")
+	}
+	docID := userDocID(name)
+	for attempt := 1; ; attempt++ {
+		var doc map[string]interface{}
+		if err := u.db.Get(ctx, docID).ScanDoc(&doc); err != nil {
+			return "", err
+		}
+		if doc["type"] != "user" {
+			return "", notAUser(name)
+		}
+		fn(doc)
+		rev, err := u.db.Put(ctx, docID, doc)
+		if StatusCode(err) != http.StatusConflict || attempt >= userUpdateAttempts {
+			return rev, err
+		}
+	}
+}
+
+// DeleteUser deletes the named user.
+func (u *Users) DeleteUser(ctx context.Context, name string) error {
+	if name == "" {
+		return missingArg("name")
+	}
+	docID := userDocID(name)
+	for attempt := 1; ; attempt++ {
+		rev, err := u.db.GetRev(ctx, docID)
+		if err != nil {
+			return err
+		}
+		_, err = u.db.Delete(ctx, docID, rev)
+		if StatusCode(err) != http.StatusConflict || attempt >= userUpdateAttempts {
+			return err
+		}
+	}
+}
+
+// ListUsers returns all users, ordered by name. Documents of the _users
+// database which are not user documents are ignored.
+func (u *Users) ListUsers(ctx context.Context) ([]*User, error) {
+	rs := u.db.AllDocs(ctx, Options{
+		"include_docs": true,
+		"startkey":     UserPrefix,
+		"endkey":       UserPrefix + EndKeySuffix,
+	})
+	defer rs.Close() // nolint: errcheck
+	var users []*User
+	for rs.Next() {
+		if !strings.HasPrefix(rs.ID(), UserPrefix) {
+			continue
+		}
+		var raw jsoniter.RawMessage
+		if err := rs.ScanDoc(&raw); err != nil {
+			return nil, err
+		}
+		user, err := parseUser(raw)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
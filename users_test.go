@@ -0,0 +1,304 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+	"github.com/dannyzhou2015/kivik/v4/pbkdf2"
+)
+
+// userStore is a minimal in-memory _users database, which enforces
+// revisions.
+type userStore struct {
+	docs map[string]map[string]interface{}
+	// beforePut, if set, is called before each Put, to simulate concurrent
+	// updates.
+	beforePut func(s *userStore, docID string)
+	puts      int
+}
+
+func newUserStore(docs ...string) *userStore {
+	s := &userStore{docs: map[string]map[string]interface{}{}}
+	for _, doc := range docs {
+		var d map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &d); err != nil {
+			panic(err)
+		}
+		s.docs[d["_id"].(string)] = d
+	}
+	return s
+}
+
+func (s *userStore) write(docID string, doc map[string]interface{}) string {
+	n := 1
+	if old, ok := s.docs[docID]; ok {
+		fmt.Sscanf(old["_rev"].(string), "%d-", &n) // nolint: errcheck
+		n++
+	}
+	rev := fmt.Sprintf("%d-x", n)
+	doc["_id"] = docID
+	doc["_rev"] = rev
+	s.docs[docID] = doc
+	return rev
+}
+
+func (s *userStore) client() *Client {
+	db := &mock.DB{
+		GetFunc: func(_ context.Context, docID string, _ map[string]interface{}) (*driver.Document, error) {
+			doc, ok := s.docs[docID]
+			if !ok {
+				return nil, &Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+			}
+			b, _ := json.Marshal(doc)
+			return &driver.Document{Rev: doc["_rev"].(string), Body: body(string(b))}, nil
+		},
+		PutFunc: func(_ context.Context, docID string, doc interface{}, _ map[string]interface{}) (string, error) {
+			s.puts++
+			if s.beforePut != nil {
+				s.beforePut(s, docID)
+			}
+			var d map[string]interface{}
+			body, _ := json.Marshal(doc)
+			_ = json.Unmarshal(body, &d)
+			rev, _ := d["_rev"].(string)
+			var current string
+			if old, ok := s.docs[docID]; ok {
+				current = old["_rev"].(string)
+			}
+			if rev != current {
+				return "", &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}
+			}
+			return s.write(docID, d), nil
+		},
+		DeleteFunc: func(_ context.Context, docID, rev string, _ map[string]interface{}) (string, error) {
+			doc, ok := s.docs[docID]
+			if !ok {
+				return "", &Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+			}
+			if doc["_rev"] != rev {
+				return "", &Error{HTTPStatus: http.StatusConflict, Message: "conflict"}
+			}
+			delete(s.docs, docID)
+			return rev, nil
+		},
+		AllDocsFunc: func(_ context.Context, opts map[string]interface{}) (driver.Rows, error) {
+			start, _ := opts["startkey"].(string)
+			end, _ := opts["endkey"].(string)
+			var ids []string
+			for id := range s.docs {
+				if id >= start && id <= end {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+			return &mock.Rows{
+				NextFunc: func(row *driver.Row) error {
+					if len(ids) == 0 {
+						return io.EOF
+					}
+					row.ID = ids[0]
+					row.Doc, _ = json.Marshal(s.docs[ids[0]])
+					ids = ids[1:]
+					return nil
+				},
+				CloseFunc: func() error { return nil },
+			}, nil
+		},
+	}
+	return &Client{driverClient: &mock.Client{
+		DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+			if name != UsersDB {
+				return nil, fmt.Errorf("unexpected database %s", name)
+			}
+			return db, nil
+		},
+	}}
+}
+
+const testUserDoc = `{
+	"_id": "org.couchdb.user:bob",
+	"_rev": "1-x",
+	"name": "bob",
+	"type": "user",
+	"roles": ["editor"],
+	"email": "bob@example.com",
+	"password_scheme": "pbkdf2",
+	"iterations": 10,
+	"salt": "4e170ffeb6f34daecfd814dfb4001a73",
+	"derived_key": "ad2ae45dc51bf8ceebe9cb9da8eb31fa9648a5f6"
+}`
+
+func TestUsersCreateUser(t *testing.T) {
+	s := newUserStore(testUserDoc)
+	users := s.client().Users()
+	rev, err := users.CreateUser(context.Background(), "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "1-x" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	want := map[string]interface{}{
+		"_id":      "org.couchdb.user:alice",
+		"_rev":     "1-x",
+		"name":     "alice",
+		"type":     "user",
+		"roles":    []interface{}{},
+		"password": "secret",
+	}
+	if d := testy.DiffInterface(want, s.docs["org.couchdb.user:alice"]); d != nil {
+		t.Error(d)
+	}
+	_, err = users.CreateUser(context.Background(), "bob", "secret")
+	testy.StatusError(t, "conflict", http.StatusConflict, err)
+	_, err = users.CreateUser(context.Background(), "", "secret")
+	testy.StatusError(t, "kivik: name required", http.StatusBadRequest, err)
+	_, err = users.CreateUser(context.Background(), "carol", "")
+	testy.StatusError(t, "kivik: password required", http.StatusBadRequest, err)
+}
+
+func TestUsersGetUser(t *testing.T) {
+	s := newUserStore(testUserDoc, `{"_id":"org.couchdb.user:x","_rev":"1-x","type":"other"}`)
+	users := s.client().Users()
+	user, err := users.GetUser(context.Background(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "bob" || user.Rev != "1-x" || user.Doc["email"] != "bob@example.com" {
+		t.Errorf("Unexpected user: %+v", user)
+	}
+	if d := testy.DiffInterface([]string{"editor"}, user.Roles); d != nil {
+		t.Error(d)
+	}
+	if ok, err := user.Credentials.Verify("abc123"); !ok || err != nil {
+		t.Errorf("Failed to verify password: %t, %v", ok, err)
+	}
+	_, err = users.GetUser(context.Background(), "x")
+	testy.StatusError(t, "kivik: org.couchdb.user:x is not a user document", http.StatusNotFound, err)
+	_, err = users.GetUser(context.Background(), "nobody")
+	testy.StatusError(t, "missing", http.StatusNotFound, err)
+}
+
+func TestUsersSetPassword(t *testing.T) {
+	s := newUserStore(testUserDoc)
+	users := s.client().Users()
+	rev, err := users.SetPassword(context.Background(), "bob", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "2-x" {
+		t.Errorf("Unexpected rev: %s", rev)
+	}
+	doc := s.docs["org.couchdb.user:bob"]
+	if doc["password"] != "new" || doc["email"] != "bob@example.com" {
+		t.Errorf("Unexpected document: %v", doc)
+	}
+	for _, field := range passwordFields {
+		if _, ok := doc[field]; ok {
+			t.Errorf("Stale password field %s", field)
+		}
+	}
+}
+
+func TestUsersSetRolesConflict(t *testing.T) {
+	s := newUserStore(testUserDoc)
+	concurrent := 2
+	s.beforePut = func(s *userStore, docID string) {
+		if concurrent > 0 {
+			concurrent--
+			doc := s.docs[docID]
+			doc["email"] = fmt.Sprintf("bob%d@example.com", concurrent)
+			s.write(docID, doc)
+		}
+	}
+	users := s.client().Users()
+	rev, err := users.SetRoles(context.Background(), "bob", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "4-x" || s.puts != 3 {
+		t.Errorf("Unexpected rev %s after %d puts", rev, s.puts)
+	}
+	doc := s.docs["org.couchdb.user:bob"]
+	if d := testy.DiffInterface([]interface{}{"admin"}, doc["roles"]); d != nil {
+		t.Error(d)
+	}
+	if doc["email"] != "bob0@example.com" {
+		t.Errorf("Concurrent update lost: %v", doc["email"])
+	}
+
+	s.beforePut = func(s *userStore, docID string) {
+		s.write(docID, s.docs[docID])
+	}
+	s.puts = 0
+	_, err = users.SetRoles(context.Background(), "bob", nil)
+	testy.StatusError(t, "conflict", http.StatusConflict, err)
+	if s.puts != userUpdateAttempts {
+		t.Errorf("Unexpected number of attempts: %d", s.puts)
+	}
+}
+
+func TestUsersDeleteUser(t *testing.T) {
+	s := newUserStore(testUserDoc)
+	users := s.client().Users()
+	if err := users.DeleteUser(context.Background(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.docs["org.couchdb.user:bob"]; ok {
+		t.Error("User not deleted")
+	}
+	err := users.DeleteUser(context.Background(), "bob")
+	testy.StatusError(t, "missing", http.StatusNotFound, err)
+}
+
+func TestUsersListUsers(t *testing.T) {
+	s := newUserStore(
+		testUserDoc,
+		`{"_id":"org.couchdb.user:alice","_rev":"1-x","name":"alice","type":"user","roles":[]}`,
+		`{"_id":"org.couchdb.user:x","_rev":"1-x","type":"other"}`,
+		`{"_id":"_design/_auth","_rev":"1-x"}`,
+	)
+	got, err := s.client().Users().ListUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, user := range got {
+		names = append(names, user.Name)
+	}
+	if strings.Join(names, ",") != "alice,bob" {
+		t.Errorf("Unexpected users: %v", names)
+	}
+	if got[0].Credentials != nil {
+		t.Errorf("Unexpected credentials: %v", got[0].Credentials)
+	}
+	if d := testy.DiffInterface(&pbkdf2.Credentials{
+		Scheme:     "pbkdf2",
+		Iterations: 10,
+		Salt:       "4e170ffeb6f34daecfd814dfb4001a73",
+		DerivedKey: "ad2ae45dc51bf8ceebe9cb9da8eb31fa9648a5f6",
+	}, got[1].Credentials); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,431 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package views
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/collate"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+type updateMode int
+
+const (
+	updateTrue updateMode = iota
+	updateFalse
+	updateLazy
+)
+
+// query holds the parsed options of a query.
+type query struct {
+	keyRange             collate.Range
+	startDocID, endDocID string
+	keys                 []interface{}
+	hasKeys              bool
+	hasKey, hasBounds    bool
+	skip                 int
+	limit                int
+	reduce, reduceSet    bool
+	group, groupSet      bool
+	// groupLevel is the number of array key elements to group by, or 0 to
+	// group by the full key.
+	groupLevel  int
+	includeDocs bool
+	updateSeq   bool
+	update      updateMode
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: fmt.Sprintf("views: "+format, args...)}
+}
+
+func parseQuery(opts kivik.Options) (*query, error) {
+	q := &query{
+		reduce: true,
+		limit:  -1,
+	}
+	r := &q.keyRange
+	for k, v := range opts {
+		var err error
+		switch k {
+		case "key":
+			r.StartKey, err = normalize(v)
+			r.EndKey, r.HasStartKey, r.HasEndKey = r.StartKey, true, true
+			q.hasKey = true
+		case "keys":
+			var keys interface{}
+			keys, err = normalize(v)
+			if list, ok := keys.([]interface{}); ok {
+				q.keys, q.hasKeys = list, true
+			} else if err == nil {
+				err = badRequest("keys must be an array")
+			}
+		case "startkey", "start_key":
+			r.StartKey, err = normalize(v)
+			r.HasStartKey, q.hasBounds = true, true
+		case "endkey", "end_key":
+			r.EndKey, err = normalize(v)
+			r.HasEndKey, q.hasBounds = true, true
+		case "startkey_docid", "start_key_doc_id":
+			q.startDocID, err = stringOption(k, v)
+		case "endkey_docid", "end_key_doc_id":
+			q.endDocID, err = stringOption(k, v)
+		case "inclusive_end":
+			var inclusive bool
+			inclusive, err = boolOption(k, v)
+			r.ExclusiveEnd = !inclusive
+		case "descending":
+			r.Descending, err = boolOption(k, v)
+		case "skip":
+			q.skip, err = intOption(k, v)
+		case "limit":
+			q.limit, err = intOption(k, v)
+		case "reduce":
+			q.reduce, err = boolOption(k, v)
+			q.reduceSet = true
+		case "group":
+			q.group, err = boolOption(k, v)
+			q.groupSet = true
+		case "group_level":
+			q.groupLevel, err = intOption(k, v)
+			q.group = q.groupLevel > 0
+			q.groupSet = true
+		case "include_docs":
+			q.includeDocs, err = boolOption(k, v)
+		case "update_seq":
+			q.updateSeq, err = boolOption(k, v)
+		case "update":
+			switch fmt.Sprint(v) {
+			case "true":
+				q.update = updateTrue
+			case "false":
+				q.update = updateFalse
+			case "lazy":
+				q.update = updateLazy
+			default:
+				err = badRequest("invalid value for update: %v", v)
+			}
+		case "stale":
+			switch fmt.Sprint(v) {
+			case "ok":
+				q.update = updateFalse
+			case "update_after":
+				q.update = updateLazy
+			default:
+				err = badRequest("invalid value for stale: %v", v)
+			}
+		default:
+			err = badRequest("unsupported option: %s", k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// validate checks for combinations of options which CouchDB rejects. It must
+// be called after reduce has been reconciled with the view definition.
+func (q *query) validate() error {
+	if !q.reduce && q.group {
+		return badRequest("group is invalid for map-only views or when reduce=false")
+	}
+	if q.reduce && q.includeDocs {
+		return badRequest("include_docs is invalid for reduce")
+	}
+	if q.hasKey && q.hasBounds {
+		return badRequest("key is incompatible with startkey and endkey")
+	}
+	if q.hasKeys && (q.keyRange.HasStartKey || q.keyRange.HasEndKey) {
+		return badRequest("keys is incompatible with key, startkey and endkey")
+	}
+	if q.hasKeys && q.reduce && !q.group {
+		return badRequest("multi-key fetches for reduce views must use group=true")
+	}
+	return nil
+}
+
+// normalize converts v to the form it would have after a round trip through
+// JSON, so that it compares like an emitted key.
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, badRequest("invalid key: %s", err)
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func stringOption(name string, v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", badRequest("%s must be a string", name)
+}
+
+func boolOption(name string, v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		if b, err := strconv.ParseBool(t); err == nil {
+			return b, nil
+		}
+	}
+	return false, badRequest("%s must be a boolean", name)
+}
+
+func intOption(name string, v interface{}) (int, error) {
+	var i int
+	switch t := v.(type) {
+	case int:
+		i = t
+	case int64:
+		i = int(t)
+	case float64:
+		i = int(t)
+		if float64(i) != t {
+			return 0, badRequest("%s must be an integer", name)
+		}
+	case string:
+		var err error
+		if i, err = strconv.Atoi(t); err != nil {
+			return 0, badRequest("%s must be an integer", name)
+		}
+	default:
+		return 0, badRequest("%s must be an integer", name)
+	}
+	if i < 0 {
+		return 0, badRequest("%s must not be negative", name)
+	}
+	return i, nil
+}
+
+// docIDDirection returns 1 for ascending queries, and -1 for descending
+// queries. Document IDs are compared by code point, not collated.
+func (q *query) docIDDirection() int {
+	if q.keyRange.Descending {
+		return -1
+	}
+	return 1
+}
+
+// beforeStart reports whether r precedes the start of the query's key range.
+func (q *query) beforeStart(r *viewRow) bool {
+	rng := &q.keyRange
+	if !rng.HasStartKey {
+		return false
+	}
+	c := rng.Compare(r.key, rng.StartKey)
+	if c != 0 || q.startDocID == "" {
+		return c < 0
+	}
+	return q.docIDDirection()*strings.Compare(r.ID, q.startDocID) < 0
+}
+
+// afterEnd reports whether r follows the end of the query's key range.
+func (q *query) afterEnd(r *viewRow) bool {
+	rng := &q.keyRange
+	if !rng.HasEndKey || q.endDocID == "" {
+		return rng.After(r.key)
+	}
+	if c := rng.Compare(r.key, rng.EndKey); c != 0 {
+		return c > 0
+	}
+	c := q.docIDDirection() * strings.Compare(r.ID, q.endDocID)
+	if rng.ExclusiveEnd {
+		return c >= 0
+	}
+	return c > 0
+}
+
+// query answers q from the index. The caller must hold the engine's lock.
+func (ix *index) query(q *query) (*resultRows, error) {
+	all := ix.sortedRows()
+	ordered := all
+	if q.keyRange.Descending {
+		ordered = make([]*viewRow, len(all))
+		for i, r := range all {
+			ordered[len(all)-1-i] = r
+		}
+	}
+	var selected []*viewRow
+	var offset int
+	if q.hasKeys {
+		for _, key := range q.keys {
+			for _, r := range ordered {
+				if collate.Compare(r.key, key) == 0 {
+					selected = append(selected, r)
+				}
+			}
+		}
+	} else {
+		for _, r := range ordered {
+			switch {
+			case q.beforeStart(r):
+				offset++
+			case q.afterEnd(r):
+			default:
+				selected = append(selected, r)
+			}
+		}
+	}
+
+	result := &resultRows{}
+	if q.reduce {
+		rows, err := ix.reduce(q, selected)
+		if err != nil {
+			return nil, err
+		}
+		result.rows = page(q, rows)
+		return result, nil
+	}
+	rows := make([]*driver.Row, len(selected))
+	for i, r := range selected {
+		rows[i] = &driver.Row{ID: r.ID, Key: []byte(r.Key), Value: []byte(r.Value)}
+	}
+	result.rows = page(q, rows)
+	result.offset = int64(offset + q.skip)
+	if result.offset > int64(len(all)) {
+		result.offset = int64(len(all))
+	}
+	result.total = int64(len(all))
+	return result, nil
+}
+
+// page applies skip and limit to rows.
+func page(q *query, rows []*driver.Row) []*driver.Row {
+	if q.skip >= len(rows) {
+		return nil
+	}
+	rows = rows[q.skip:]
+	if q.limit >= 0 && q.limit < len(rows) {
+		rows = rows[:q.limit]
+	}
+	return rows
+}
+
+// groupKey returns the key by which r is grouped.
+func (q *query) groupKey(r *viewRow) interface{} {
+	if !q.group {
+		return nil
+	}
+	if list, ok := r.key.([]interface{}); ok && q.groupLevel > 0 && len(list) > q.groupLevel {
+		return list[:q.groupLevel]
+	}
+	return r.key
+}
+
+// reduce reduces rows, which must be in collation order, into one row per
+// group.
+func (ix *index) reduce(q *query, rows []*viewRow) ([]*driver.Row, error) {
+	reducer := reducers[ix.view.Reduce]
+	var result []*driver.Row
+	for len(rows) > 0 {
+		group := q.groupKey(rows[0])
+		n := 1
+		for n < len(rows) && collate.Compare(q.groupKey(rows[n]), group) == 0 {
+			n++
+		}
+		keys := make([]interface{}, n)
+		values := make([]interface{}, n)
+		for i, r := range rows[:n] {
+			keys[i], values[i] = r.key, r.value
+		}
+		rows = rows[n:]
+		value, err := reducer(keys, values)
+		if err != nil {
+			return nil, err
+		}
+		k, err := json.Marshal(group)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &driver.Row{Key: k, Value: v})
+	}
+	return result, nil
+}
+
+// resultRows is a driver.Rows over the results of a query.
+type resultRows struct {
+	rows      []*driver.Row
+	offset    int64
+	total     int64
+	updateSeq string
+
+	// When db is set, documents are fetched as rows are read.
+	db  *kivik.DB
+	ctx context.Context
+}
+
+var _ driver.Rows = &resultRows{}
+
+func (r *resultRows) Next(row *driver.Row) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	*row = *r.rows[0]
+	r.rows = r.rows[1:]
+	if r.db != nil {
+		var doc jsoniter.RawMessage
+		err := r.db.Get(r.ctx, row.ID).ScanDoc(&doc)
+		switch {
+		case kivik.StatusCode(err) == http.StatusNotFound:
+			// The document was deleted since the index was updated.
+			row.Doc = []byte("null")
+		case err != nil:
+			row.Error = err
+		default:
+			row.Doc = []byte(doc)
+		}
+	}
+	return nil
+}
+
+func (r *resultRows) Close() error {
+	r.rows = nil
+	return nil
+}
+
+func (r *resultRows) UpdateSeq() string { return r.updateSeq }
+func (r *resultRows) Offset() int64     { return r.offset }
+func (r *resultRows) TotalRows() int64  { return r.total }
+
+// errRows is a driver.Rows which fails with err.
+type errRows struct{ err error }
+
+var _ driver.Rows = &errRows{}
+
+func (r *errRows) Next(*driver.Row) error { return r.err }
+func (r *errRows) Close() error           { return nil }
+func (r *errRows) UpdateSeq() string      { return "" }
+func (r *errRows) Offset() int64          { return 0 }
+func (r *errRows) TotalRows() int64       { return 0 }
+
+func errResultSet(ctx context.Context, err error) kivik.ResultSet {
+	return kivik.NewResultSet(ctx, &errRows{err: err})
+}
@@ -0,0 +1,190 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package views
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+)
+
+// Built-in reducers, which behave like their CouchDB counterparts.
+const (
+	// Count counts the rows.
+	Count = "_count"
+	// Sum sums the values, which must be numbers, or arrays or objects of
+	// numbers, which are summed element-wise.
+	Sum = "_sum"
+	// Stats computes the sum, count, min, max and sum of squares of the
+	// values, which must be numbers.
+	Stats = "_stats"
+	// ApproxCountDistinct counts the distinct keys. Unlike CouchDB, which
+	// uses HyperLogLog, the count is exact.
+	ApproxCountDistinct = "_approx_count_distinct"
+)
+
+type reduceFunc func(keys, values []interface{}) (interface{}, error)
+
+var reducers = map[string]reduceFunc{
+	Count:               reduceCount,
+	Sum:                 reduceSum,
+	Stats:               reduceStats,
+	ApproxCountDistinct: reduceCountDistinct,
+}
+
+func reduceError(reducer string, format string, args ...interface{}) error {
+	return &kivik.Error{
+		HTTPStatus: http.StatusInternalServerError,
+		Message:    fmt.Sprintf("views: "+reducer+": "+format, args...),
+	}
+}
+
+func reduceCount(_, values []interface{}) (interface{}, error) {
+	return float64(len(values)), nil
+}
+
+func reduceSum(_, values []interface{}) (interface{}, error) {
+	var acc interface{} = float64(0)
+	for _, v := range values {
+		var err error
+		if acc, err = sum(acc, v); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+// sum adds v to acc. Numbers are added, arrays are added element-wise, and
+// objects are added key-wise.
+func sum(acc, v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case float64:
+		if a, ok := acc.(float64); ok {
+			return a + t, nil
+		}
+		return nil, reduceError(Sum, "cannot add number to %T", acc)
+	case []interface{}:
+		var a []interface{}
+		switch at := acc.(type) {
+		case float64:
+			if at != 0 {
+				return nil, reduceError(Sum, "cannot add array to number")
+			}
+		case []interface{}:
+			a = at
+		default:
+			return nil, reduceError(Sum, "cannot add array to object")
+		}
+		result := make([]interface{}, len(a))
+		copy(result, a)
+		for i, el := range t {
+			if i >= len(result) {
+				result = append(result, float64(0))
+			}
+			var err error
+			if result[i], err = sum(result[i], el); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case map[string]interface{}:
+		a := map[string]interface{}{}
+		switch at := acc.(type) {
+		case float64:
+			if at != 0 {
+				return nil, reduceError(Sum, "cannot add object to number")
+			}
+		case map[string]interface{}:
+			for k, v := range at {
+				a[k] = v
+			}
+		default:
+			return nil, reduceError(Sum, "cannot add object to array")
+		}
+		for k, el := range t {
+			prev, ok := a[k]
+			if !ok {
+				prev = float64(0)
+			}
+			var err error
+			if a[k], err = sum(prev, el); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, reduceError(Sum, "invalid value %v", v)
+}
+
+func reduceStats(_, values []interface{}) (interface{}, error) {
+	var sum, sumsqr, count float64
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		switch t := v.(type) {
+		case float64:
+			sum += t
+			sumsqr += t * t
+			count++
+			min = math.Min(min, t)
+			max = math.Max(max, t)
+		case map[string]interface{}:
+			// A pre-computed stats object.
+			var s struct {
+				Sum, Count, Min, Max, Sumsqr *float64
+			}
+			if err := remarshal(t, &s); err != nil || s.Sum == nil || s.Count == nil || s.Min == nil || s.Max == nil || s.Sumsqr == nil {
+				return nil, reduceError(Stats, "invalid stats object %v", v)
+			}
+			sum += *s.Sum
+			sumsqr += *s.Sumsqr
+			count += *s.Count
+			min = math.Min(min, *s.Min)
+			max = math.Max(max, *s.Max)
+		default:
+			return nil, reduceError(Stats, "invalid value %v", v)
+		}
+	}
+	if count == 0 {
+		min, max = 0, 0
+	}
+	return map[string]interface{}{
+		"sum":    sum,
+		"count":  count,
+		"min":    min,
+		"max":    max,
+		"sumsqr": sumsqr,
+	}, nil
+}
+
+func reduceCountDistinct(keys, _ []interface{}) (interface{}, error) {
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		enc, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		seen[string(enc)] = struct{}{}
+	}
+	return float64(len(seen)), nil
+}
+
+// remarshal converts v to dest by way of JSON.
+func remarshal(v, dest interface{}) error {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(enc, dest)
+}
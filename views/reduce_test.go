@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package views
+
+import (
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestReducers(t *testing.T) {
+	type tt struct {
+		reducer string
+		keys    []interface{}
+		values  []interface{}
+		want    interface{}
+		err     string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("count", tt{
+		reducer: Count,
+		values:  []interface{}{"a", nil, 3.0},
+		want:    3.0,
+	})
+	tests.Add("sum", tt{
+		reducer: Sum,
+		values:  []interface{}{1.0, 2.5},
+		want:    3.5,
+	})
+	tests.Add("sum arrays", tt{
+		reducer: Sum,
+		values:  []interface{}{[]interface{}{1.0, 2.0}, []interface{}{3.0}},
+		want:    []interface{}{4.0, 2.0},
+	})
+	tests.Add("sum objects", tt{
+		reducer: Sum,
+		values: []interface{}{
+			map[string]interface{}{"a": 1.0},
+			map[string]interface{}{"a": 2.0, "b": 1.0},
+		},
+		want: map[string]interface{}{"a": 3.0, "b": 1.0},
+	})
+	tests.Add("sum invalid", tt{
+		reducer: Sum,
+		values:  []interface{}{1.0, "x"},
+		err:     "views: _sum: invalid value x",
+	})
+	tests.Add("stats", tt{
+		reducer: Stats,
+		values:  []interface{}{1.0, 3.0, map[string]interface{}{"sum": 2.0, "count": 2.0, "min": 0.0, "max": 2.0, "sumsqr": 4.0}},
+		want:    map[string]interface{}{"sum": 6.0, "count": 4.0, "min": 0.0, "max": 3.0, "sumsqr": 14.0},
+	})
+	tests.Add("stats invalid", tt{
+		reducer: Stats,
+		values:  []interface{}{true},
+		err:     "views: _stats: invalid value true",
+	})
+	tests.Add("count distinct", tt{
+		reducer: ApproxCountDistinct,
+		keys:    []interface{}{"a", "b", "a", []interface{}{"a"}},
+		want:    3.0,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		got, err := reducers[tt.reducer](tt.keys, tt.values)
+		status := 0
+		if tt.err != "" {
+			status = http.StatusInternalServerError
+		}
+		testy.StatusError(t, tt.err, status, err)
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
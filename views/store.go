@@ -0,0 +1,86 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package views
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the indexes built by an Engine, so that they need not be
+// rebuilt from the beginning of the changes feed.
+type Store interface {
+	// Load returns the most recently saved state, or nil if there is none.
+	Load() ([]byte, error)
+	// Save replaces the saved state with data.
+	Save(data []byte) error
+}
+
+// FileStore is a Store which saves the state to the named file. The file is
+// replaced atomically.
+type FileStore string
+
+var _ Store = FileStore("")
+
+// Load reads the state from the file. A missing file is not an error.
+func (f FileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes the state to a temporary file, then renames it over the file.
+func (f FileStore) Save(data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), filepath.Base(string(f))+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// MemoryStore is a Store which keeps the state in memory. It may be shared
+// between engines, to preserve indexes across engine instances.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+var _ Store = &MemoryStore{}
+
+// Load returns the saved state.
+func (s *MemoryStore) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data, nil
+}
+
+// Save replaces the saved state.
+func (s *MemoryStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	return nil
+}
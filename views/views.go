@@ -0,0 +1,491 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package views provides a local map/reduce view engine, which builds view
+// indexes from the changes feed of any kivik.DB, using map functions written
+// in Go.
+//
+// Indexes are updated incrementally, and may be persisted with a Store, so
+// that they need not be rebuilt from scratch. Queries accept the same options
+// as DB.Query, and results are returned as a kivik.ResultSet:
+//
+//	engine := views.New(db, views.FileStore("/var/lib/app/views.json"))
+//	err := engine.Register("by_type", views.View{
+//		Map: func(doc map[string]interface{}, emit func(key, value interface{})) {
+//			if t, ok := doc["type"].(string); ok {
+//				emit(t, 1)
+//			}
+//		},
+//		Reduce: views.Count,
+//	})
+//	rs := engine.Query(ctx, "by_type", kivik.Options{"group": true})
+//
+// This allows derived views to be built on drivers without server-side views.
+package views
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/collate"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// MapFunc is a view's map function. It is called once for each non-deleted
+// document, and may call emit any number of times. Emitted keys and values must
+// be marshalable to JSON. A MapFunc which panics emits nothing for the
+// document.
+type MapFunc func(doc map[string]interface{}, emit func(key, value interface{}))
+
+// View defines a view.
+type View struct {
+	// Map is the view's map function. It is required.
+	Map MapFunc
+	// Reduce is the name of a built-in reducer: Count, Sum, Stats or
+	// ApproxCountDistinct. If empty, the view has no reduce function.
+	Reduce string
+	// Version identifies the implementation of Map. When it differs from the
+	// version of a persisted index, that index is discarded and rebuilt.
+	Version string
+}
+
+// Engine maintains a set of view indexes over a database.
+type Engine struct {
+	db    *kivik.DB
+	store Store
+
+	// updateMu serializes updates.
+	updateMu sync.Mutex
+
+	mu      sync.Mutex
+	views   map[string]*index
+	seq     string
+	loaded  bool
+	started bool
+	// gen is incremented when the indexes are reset, so that an update in
+	// progress does not advance seq past changes the new indexes have not
+	// seen.
+	gen uint64
+}
+
+// New returns a new Engine, which indexes db. If store is non-nil, indexes are
+// loaded from it on the first update, and saved after each update.
+func New(db *kivik.DB, store Store) *Engine {
+	return &Engine{
+		db:    db,
+		store: store,
+		views: make(map[string]*index),
+	}
+}
+
+// Register adds a view named name to the engine, replacing any existing view
+// of the same name. If the engine has already built its indexes, they are
+// rebuilt from scratch on the next update.
+func (e *Engine) Register(name string, view View) error {
+	if name == "" {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "views: view name required"}
+	}
+	if view.Map == nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "views: map function required"}
+	}
+	if _, ok := reducers[view.Reduce]; view.Reduce != "" && !ok {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "views: unknown reducer: " + view.Reduce}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.views[name] = newIndex(view)
+	if e.started {
+		e.seq = ""
+		e.gen++
+		for _, ix := range e.views {
+			ix.reset()
+		}
+	}
+	return nil
+}
+
+// Seq returns the update sequence up to which the indexes are current.
+func (e *Engine) Seq() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.seq
+}
+
+// Update brings all indexes up to date with the database's changes feed.
+func (e *Engine) Update(ctx context.Context) error {
+	e.updateMu.Lock()
+	defer e.updateMu.Unlock()
+	if err := e.load(); err != nil {
+		return err
+	}
+	for {
+		reset, err := e.update(ctx)
+		if err != nil || !reset {
+			return err
+		}
+	}
+}
+
+// update reads the changes feed since e.seq. It returns true, without
+// finishing, if the indexes are reset by Register in the meantime, in which
+// case the update must be started again.
+func (e *Engine) update(ctx context.Context) (reset bool, err error) {
+	e.mu.Lock()
+	e.started = true
+	since, gen := e.seq, e.gen
+	e.mu.Unlock()
+
+	opts := kivik.Options{"include_docs": true}
+	if since != "" {
+		opts["since"] = since
+	}
+	changes, err := e.db.Changes(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	defer changes.Close() // nolint: errcheck
+	var updated bool
+	for changes.Next() {
+		id := changes.ID()
+		var doc jsoniter.RawMessage
+		if !changes.Deleted() && !strings.HasPrefix(id, "_design/") {
+			if err := changes.ScanDoc(&doc); err != nil {
+				return false, err
+			}
+		}
+		if !e.apply(id, doc, changes.Seq(), gen) {
+			return true, nil
+		}
+		updated = true
+	}
+	if err := changes.Err(); err != nil {
+		return false, err
+	}
+	if last := changes.LastSeq(); last != "" {
+		e.mu.Lock()
+		if e.gen != gen {
+			e.mu.Unlock()
+			return true, nil
+		}
+		if last != e.seq {
+			e.seq = last
+			updated = true
+		}
+		e.mu.Unlock()
+	}
+	if !updated {
+		return false, nil
+	}
+	return false, e.save()
+}
+
+// apply replaces the rows emitted for the document id by all views. doc is
+// nil for deleted documents, which emit nothing. It returns false, without
+// applying the change, if the indexes have been reset since generation gen.
+func (e *Engine) apply(id string, doc jsoniter.RawMessage, seq string, gen uint64) bool {
+	e.mu.Lock()
+	views := make(map[string]*index, len(e.views))
+	for name, ix := range e.views {
+		views[name] = ix
+	}
+	e.mu.Unlock()
+
+	// Map functions are run without holding the lock, so queries need not
+	// wait for them.
+	emitted := make(map[string][]*viewRow, len(views))
+	if doc != nil {
+		for name, ix := range views {
+			emitted[name] = ix.mapDoc(id, doc)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gen != gen {
+		return false
+	}
+	for name, ix := range views {
+		if e.views[name] == ix {
+			ix.setRows(id, emitted[name])
+		}
+	}
+	if seq != "" {
+		e.seq = seq
+	}
+	return true
+}
+
+// Query queries the view named name. The following options are supported,
+// with the same meaning as for DB.Query: key, keys, startkey (start_key),
+// endkey (end_key), startkey_docid, endkey_docid, inclusive_end, descending,
+// skip, limit, reduce, group, group_level, include_docs, update_seq, update
+// and stale.
+//
+// By default, the index is updated before the query is answered. With
+// update=false, or stale=ok, the index is queried as it is. With update=lazy,
+// or stale=update_after, the index is updated in the background after the
+// query is answered.
+func (e *Engine) Query(ctx context.Context, name string, options ...kivik.Options) kivik.ResultSet {
+	opts := make(kivik.Options)
+	for _, o := range options {
+		for k, v := range o {
+			opts[k] = v
+		}
+	}
+	q, err := parseQuery(opts)
+	if err != nil {
+		return errResultSet(ctx, err)
+	}
+	e.mu.Lock()
+	ix, ok := e.views[name]
+	e.mu.Unlock()
+	if !ok {
+		return errResultSet(ctx, &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "views: missing named view: " + name})
+	}
+	if ix.view.Reduce == "" {
+		if q.reduce && q.reduceSet {
+			return errResultSet(ctx, &kivik.Error{HTTPStatus: http.StatusBadRequest, Message: "views: reduce is invalid for map-only views"})
+		}
+		q.reduce = false
+	}
+	if err := q.validate(); err != nil {
+		return errResultSet(ctx, err)
+	}
+	switch q.update {
+	case updateTrue:
+		if err := e.Update(ctx); err != nil {
+			return errResultSet(ctx, err)
+		}
+	case updateLazy:
+		defer func() {
+			go func() { _ = e.Update(context.Background()) }()
+		}()
+	}
+
+	e.mu.Lock()
+	res, err := ix.query(q)
+	seq := e.seq
+	e.mu.Unlock()
+	if err != nil {
+		return errResultSet(ctx, err)
+	}
+	if q.updateSeq {
+		res.updateSeq = seq
+	}
+	if q.includeDocs {
+		res.db = e.db
+		res.ctx = ctx
+	}
+	return kivik.NewResultSet(ctx, res)
+}
+
+// state is the persisted state of an engine.
+type state struct {
+	Seq   string                `json:"seq"`
+	Views map[string]*viewState `json:"views"`
+}
+
+type viewState struct {
+	Version string     `json:"version"`
+	Reduce  string     `json:"reduce"`
+	Rows    []*viewRow `json:"rows"`
+}
+
+// load restores the persisted state, the first time it is called. Persisted
+// indexes are only used if every registered view can be restored, as the
+// indexes must all be current to the same sequence.
+func (e *Engine) load() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.loaded || e.store == nil {
+		e.loaded = true
+		return nil
+	}
+	data, err := e.store.Load()
+	if err != nil {
+		return err
+	}
+	e.loaded = true
+	if len(data) == 0 {
+		return nil
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		// A corrupt state is discarded, and the indexes rebuilt.
+		return nil
+	}
+	for name, ix := range e.views {
+		vs, ok := st.Views[name]
+		if !ok || vs.Version != ix.view.Version || vs.Reduce != ix.view.Reduce {
+			return nil
+		}
+	}
+	for name, ix := range e.views {
+		ix.reset()
+		for _, row := range st.Views[name].Rows {
+			if err := row.decode(); err != nil {
+				return nil
+			}
+			ix.byDoc[row.ID] = append(ix.byDoc[row.ID], row)
+		}
+	}
+	e.seq = st.Seq
+	return nil
+}
+
+// save persists the current state, if the engine has a store.
+func (e *Engine) save() error {
+	if e.store == nil {
+		return nil
+	}
+	e.mu.Lock()
+	st := state{
+		Seq:   e.seq,
+		Views: make(map[string]*viewState, len(e.views)),
+	}
+	for name, ix := range e.views {
+		st.Views[name] = &viewState{
+			Version: ix.view.Version,
+			Reduce:  ix.view.Reduce,
+			Rows:    ix.sortedRows(),
+		}
+	}
+	data, err := json.Marshal(st)
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return e.store.Save(data)
+}
+
+// viewRow is a single emitted row.
+type viewRow struct {
+	ID    string              `json:"id"`
+	Key   jsoniter.RawMessage `json:"key"`
+	Value jsoniter.RawMessage `json:"value"`
+
+	key   interface{}
+	value interface{}
+}
+
+func newViewRow(id string, key, value interface{}) (*viewRow, error) {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	row := &viewRow{ID: id, Key: k, Value: v}
+	return row, row.decode()
+}
+
+// decode populates the decoded key and value, so that they compare and reduce
+// the same way, whether emitted or loaded from a store.
+func (r *viewRow) decode() error {
+	if err := json.Unmarshal(r.Key, &r.key); err != nil {
+		return err
+	}
+	return json.Unmarshal(r.Value, &r.value)
+}
+
+// compareRows orders rows by key, then document ID, then value.
+func compareRows(a, b *viewRow) int {
+	if c := collate.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.ID, b.ID); c != 0 {
+		return c
+	}
+	return collate.Compare(a.value, b.value)
+}
+
+// index holds the rows of a single view.
+type index struct {
+	view   View
+	byDoc  map[string][]*viewRow
+	sorted []*viewRow
+	dirty  bool
+}
+
+func newIndex(view View) *index {
+	return &index{
+		view:  view,
+		byDoc: make(map[string][]*viewRow),
+	}
+}
+
+func (ix *index) reset() {
+	ix.byDoc = make(map[string][]*viewRow)
+	ix.sorted = nil
+	ix.dirty = true
+}
+
+// mapDoc calls the map function for doc, and returns the emitted rows.
+func (ix *index) mapDoc(id string, doc jsoniter.RawMessage) (rows []*viewRow) {
+	// Each view decodes its own copy, so that a map function which modifies
+	// the document cannot affect another.
+	var d map[string]interface{}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rows = nil
+		}
+	}()
+	ix.view.Map(d, func(key, value interface{}) {
+		row, err := newViewRow(id, key, value)
+		if err != nil {
+			panic(err)
+		}
+		rows = append(rows, row)
+	})
+	return rows
+}
+
+func (ix *index) setRows(id string, rows []*viewRow) {
+	if len(rows) == 0 {
+		if _, ok := ix.byDoc[id]; !ok {
+			return
+		}
+		delete(ix.byDoc, id)
+	} else {
+		ix.byDoc[id] = rows
+	}
+	ix.dirty = true
+}
+
+// sortedRows returns all rows in collation order.
+func (ix *index) sortedRows() []*viewRow {
+	if !ix.dirty && ix.sorted != nil {
+		return ix.sorted
+	}
+	rows := make([]*viewRow, 0, len(ix.byDoc))
+	for _, r := range ix.byDoc {
+		rows = append(rows, r...)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j]) < 0
+	})
+	ix.sorted = rows
+	ix.dirty = false
+	return rows
+}
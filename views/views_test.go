@@ -0,0 +1,521 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package views
+
+import (
+	"context"
+	ejson "encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/dannyzhou2015/kivik/v4"
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+// fakeDB is a database whose changes feed reports one change per update.
+type fakeDB struct {
+	mu      sync.Mutex
+	log     []driver.Change
+	docs    map[string]string
+	since   []interface{}
+	changes int
+	// next, if set, is called before each change is read from the feed.
+	next func()
+}
+
+func (f *fakeDB) put(id, doc string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seq := strconv.Itoa(len(f.log) + 1)
+	f.log = append(f.log, driver.Change{ID: id, Seq: seq, Doc: []byte(doc)})
+	f.docs[id] = doc
+}
+
+func (f *fakeDB) del(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seq := strconv.Itoa(len(f.log) + 1)
+	f.log = append(f.log, driver.Change{ID: id, Seq: seq, Deleted: true, Doc: []byte(`{"_deleted":true}`)})
+	delete(f.docs, id)
+}
+
+func (f *fakeDB) driverDB() driver.DB {
+	return &mock.DB{
+		ChangesFunc: func(_ context.Context, opts map[string]interface{}) (driver.Changes, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.changes++
+			f.since = append(f.since, opts["since"])
+			var start int
+			if since, ok := opts["since"].(string); ok {
+				start, _ = strconv.Atoi(since)
+			}
+			pending := append([]driver.Change{}, f.log[start:]...)
+			last := strconv.Itoa(len(f.log))
+			next := f.next
+			return &mock.Changes{
+				NextFunc: func(ch *driver.Change) error {
+					if next != nil {
+						next()
+					}
+					if len(pending) == 0 {
+						return io.EOF
+					}
+					*ch = pending[0]
+					pending = pending[1:]
+					return nil
+				},
+				CloseFunc:   func() error { return nil },
+				LastSeqFunc: func() string { return last },
+			}, nil
+		},
+		GetFunc: func(_ context.Context, id string, _ map[string]interface{}) (*driver.Document, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			doc, ok := f.docs[id]
+			if !ok {
+				return nil, &kivik.Error{HTTPStatus: http.StatusNotFound, Message: "missing"}
+			}
+			return &driver.Document{Body: ioutil.NopCloser(strings.NewReader(doc))}, nil
+		},
+	}
+}
+
+var (
+	registerOnce sync.Once
+	fakeDBs      sync.Map
+)
+
+// newTestDB returns a kivik.DB backed by a new fakeDB.
+func newTestDB(t *testing.T) (*kivik.DB, *fakeDB) {
+	registerOnce.Do(func() {
+		kivik.Register("viewstest", &mock.Driver{
+			NewClientFunc: func(string, map[string]interface{}) (driver.Client, error) {
+				return &mock.Client{
+					DBFunc: func(name string, _ map[string]interface{}) (driver.DB, error) {
+						f, _ := fakeDBs.Load(name)
+						return f.(*fakeDB).driverDB(), nil
+					},
+				}, nil
+			},
+		})
+	})
+	f := &fakeDB{docs: map[string]string{}}
+	fakeDBs.Store(t.Name(), f)
+	client, err := kivik.New("viewstest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.DB(t.Name()), f
+}
+
+func byType(doc map[string]interface{}, emit func(key, value interface{})) {
+	if t, ok := doc["type"].(string); ok {
+		emit(t, doc["n"])
+	}
+}
+
+func byTypeN(doc map[string]interface{}, emit func(key, value interface{})) {
+	if t, ok := doc["type"].(string); ok {
+		emit([]interface{}{t, doc["n"]}, nil)
+	}
+}
+
+type queryResult struct {
+	Rows      []string
+	Offset    int64
+	TotalRows int64
+	UpdateSeq string
+}
+
+// readResults formats each row as id:key=value.
+func readResults(rs kivik.ResultSet) (*queryResult, error) {
+	result := &queryResult{}
+	for rs.Next() {
+		var key, value ejson.RawMessage
+		if err := rs.ScanKey(&key); err != nil {
+			return nil, err
+		}
+		if err := rs.ScanValue(&value); err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, fmt.Sprintf("%s:%s=%s", rs.ID(), key, value))
+	}
+	if err := rs.Err(); err != nil {
+		return nil, err
+	}
+	meta, err := rs.Finish()
+	if err != nil {
+		return nil, err
+	}
+	result.Offset, result.TotalRows, result.UpdateSeq = meta.Offset, meta.TotalRows, meta.UpdateSeq
+	return result, nil
+}
+
+func TestQuery(t *testing.T) {
+	type tt struct {
+		view    string
+		options kivik.Options
+		status  int
+		err     string
+		want    *queryResult
+	}
+
+	tests := testy.NewTable()
+	tests.Add("all rows", tt{
+		view:    "by_type",
+		options: kivik.Options{"reduce": false},
+		want: &queryResult{
+			Rows:      []string{`a:"fruit"=1`, `c:"fruit"=3`, `d:"Fruit"=4`, `b:"veg"=2`},
+			TotalRows: 4,
+		},
+	})
+	tests.Add("key", tt{
+		view:    "by_type",
+		options: kivik.Options{"key": "fruit", "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`a:"fruit"=1`, `c:"fruit"=3`},
+			TotalRows: 4,
+		},
+	})
+	tests.Add("descending", tt{
+		view:    "by_type",
+		options: kivik.Options{"descending": true, "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`b:"veg"=2`, `d:"Fruit"=4`, `c:"fruit"=3`, `a:"fruit"=1`},
+			TotalRows: 4,
+		},
+	})
+	tests.Add("exclusive end", tt{
+		view:    "by_type",
+		options: kivik.Options{"startkey": "Fruit", "endkey": "veg", "inclusive_end": false, "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`d:"Fruit"=4`},
+			Offset:    2,
+			TotalRows: 4,
+		},
+	})
+	tests.Add("docid range", tt{
+		view: "by_type",
+		options: kivik.Options{
+			"startkey": "fruit", "startkey_docid": "c",
+			"endkey": "fruit", "endkey_docid": "z",
+			"reduce": false,
+		},
+		want: &queryResult{
+			Rows:      []string{`c:"fruit"=3`},
+			Offset:    1,
+			TotalRows: 4,
+		},
+	})
+	tests.Add("descending range", tt{
+		view:    "by_type",
+		options: kivik.Options{"descending": true, "startkey": "Fruit", "endkey": "fruit", "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`d:"Fruit"=4`, `c:"fruit"=3`, `a:"fruit"=1`},
+			Offset:    1,
+			TotalRows: 4,
+		},
+	})
+	tests.Add("skip and limit", tt{
+		view:    "by_type",
+		options: kivik.Options{"skip": 1, "limit": 2, "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`c:"fruit"=3`, `d:"Fruit"=4`},
+			Offset:    1,
+			TotalRows: 4,
+		},
+	})
+	tests.Add("keys", tt{
+		view:    "by_type",
+		options: kivik.Options{"keys": []string{"veg", "Fruit", "none"}, "reduce": false},
+		want: &queryResult{
+			Rows:      []string{`b:"veg"=2`, `d:"Fruit"=4`},
+			TotalRows: 4,
+		},
+	})
+	tests.Add("reduce", tt{
+		view: "by_type",
+		want: &queryResult{
+			Rows: []string{`:null=10`},
+		},
+	})
+	tests.Add("group", tt{
+		view:    "by_type",
+		options: kivik.Options{"group": true},
+		want: &queryResult{
+			Rows: []string{`:"fruit"=4`, `:"Fruit"=4`, `:"veg"=2`},
+		},
+	})
+	tests.Add("group level", tt{
+		view:    "by_type_n",
+		options: kivik.Options{"group_level": 1},
+		want: &queryResult{
+			Rows: []string{`:["fruit"]=2`, `:["Fruit"]=1`, `:["veg"]=1`},
+		},
+	})
+	tests.Add("group keys", tt{
+		view:    "by_type",
+		options: kivik.Options{"group": true, "keys": []string{"veg", "fruit"}},
+		want: &queryResult{
+			Rows: []string{`:"veg"=2`, `:"fruit"=4`},
+		},
+	})
+	tests.Add("update seq", tt{
+		view:    "by_type",
+		options: kivik.Options{"update_seq": true, "limit": 0, "reduce": false},
+		want: &queryResult{
+			TotalRows: 4,
+			UpdateSeq: "5",
+		},
+	})
+	tests.Add("unknown view", tt{
+		view:   "foo",
+		status: http.StatusNotFound,
+		err:    "views: missing named view: foo",
+	})
+	tests.Add("unsupported option", tt{
+		view:    "by_type",
+		options: kivik.Options{"foo": true},
+		status:  http.StatusBadRequest,
+		err:     "views: unsupported option: foo",
+	})
+	tests.Add("reduce keys without group", tt{
+		view:    "by_type",
+		options: kivik.Options{"keys": []string{"veg"}, "reduce": true},
+		status:  http.StatusBadRequest,
+		err:     "views: multi-key fetches for reduce views must use group=true",
+	})
+	tests.Add("key and startkey", tt{
+		view:    "by_type",
+		options: kivik.Options{"key": "veg", "startkey": "fruit"},
+		status:  http.StatusBadRequest,
+		err:     "views: key is incompatible with startkey and endkey",
+	})
+	tests.Add("group map-only view", tt{
+		view:    "by_type",
+		options: kivik.Options{"group": true, "reduce": false},
+		status:  http.StatusBadRequest,
+		err:     "views: group is invalid for map-only views or when reduce=false",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db, f := newTestDB(t)
+		f.put("a", `{"_id":"a","type":"fruit","n":1}`)
+		f.put("b", `{"_id":"b","type":"veg","n":2}`)
+		f.put("c", `{"_id":"c","type":"fruit","n":3}`)
+		f.put("d", `{"_id":"d","type":"Fruit","n":4}`)
+		f.put("_design/foo", `{"_id":"_design/foo","type":"fruit","n":5}`)
+		e := New(db, nil)
+		if err := e.Register("by_type", View{Map: byType, Reduce: Sum}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Register("by_type_n", View{Map: byTypeN, Reduce: Count}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := readResults(e.Query(context.Background(), tt.view, tt.options))
+		testy.StatusError(t, tt.err, tt.status, err)
+		if d := testy.DiffInterface(tt.want, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestIncrementalUpdate(t *testing.T) {
+	db, f := newTestDB(t)
+	e := New(db, nil)
+	if err := e.Register("by_type", View{Map: byType}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	f.put("a", `{"_id":"a","type":"fruit","n":1}`)
+	f.put("b", `{"_id":"b","type":"veg","n":2}`)
+	if err := e.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.put("a", `{"_id":"a","type":"nut","n":1}`)
+	f.del("b")
+	f.put("c", `{"_id":"c","type":"veg","n":3}`)
+	result, err := readResults(e.Query(ctx, "by_type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`a:"nut"=1`, `c:"veg"=3`}
+	if d := testy.DiffInterface(want, result.Rows); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface([]interface{}{nil, "2"}, f.since); d != nil {
+		t.Errorf("Unexpected since values:\n%s", d)
+	}
+	if seq := e.Seq(); seq != "5" {
+		t.Errorf("Unexpected seq: %s", seq)
+	}
+
+	f.put("d", `{"_id":"d","type":"veg","n":4}`)
+	result, err = readResults(e.Query(ctx, "by_type", kivik.Options{"update": false}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 2 {
+		t.Errorf("Stale query should not update the index, got %v", result.Rows)
+	}
+}
+
+func TestRegisterDuringUpdate(t *testing.T) {
+	db, f := newTestDB(t)
+	e := New(db, nil)
+	if err := e.Register("by_type", View{Map: byType}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	f.put("a", `{"_id":"a","type":"fruit","n":1}`)
+	f.put("b", `{"_id":"b","type":"veg","n":2}`)
+	if err := e.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.put("c", `{"_id":"c","type":"veg","n":3}`)
+	var once sync.Once
+	f.mu.Lock()
+	f.next = func() {
+		once.Do(func() {
+			// Registered while the update is reading the changes feed.
+			if err := e.Register("by_n", View{Map: byTypeN}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	f.mu.Unlock()
+	if err := e.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"by_type", "by_n"} {
+		result, err := readResults(e.Query(ctx, name, kivik.Options{"update": false}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Rows) != 3 {
+			t.Errorf("%s: expected all documents to be indexed, got %v", name, result.Rows)
+		}
+	}
+	if seq := e.Seq(); seq != "3" {
+		t.Errorf("Unexpected seq: %s", seq)
+	}
+}
+
+func TestMapPanic(t *testing.T) {
+	db, f := newTestDB(t)
+	e := New(db, nil)
+	err := e.Register("strict", View{Map: func(doc map[string]interface{}, emit func(key, value interface{})) {
+		emit(doc["_id"], nil)
+		emit(doc["type"].(string), nil)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put("a", `{"_id":"a","type":"fruit"}`)
+	f.put("b", `{"_id":"b"}`)
+	result, err := readResults(e.Query(context.Background(), "strict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`a:"a"=null`, `a:"fruit"=null`}
+	if d := testy.DiffInterface(want, result.Rows); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestIncludeDocs(t *testing.T) {
+	db, f := newTestDB(t)
+	e := New(db, nil)
+	if err := e.Register("by_type", View{Map: byType}); err != nil {
+		t.Fatal(err)
+	}
+	f.put("a", `{"_id":"a","type":"fruit","n":1}`)
+	ctx := context.Background()
+	rs := e.Query(ctx, "by_type", kivik.Options{"include_docs": true})
+	var docs []map[string]interface{}
+	if err := kivik.ScanAllDocs(rs, &docs); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{"_id": "a", "type": "fruit", "n": float64(1)}}
+	if d := testy.DiffInterface(want, docs); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	e := New(nil, nil)
+	err := e.Register("foo", View{})
+	testy.StatusError(t, "views: map function required", http.StatusBadRequest, err)
+	err = e.Register("foo", View{Map: byType, Reduce: "_median"})
+	testy.StatusError(t, "views: unknown reducer: _median", http.StatusBadRequest, err)
+}
+
+func TestPersist(t *testing.T) {
+	db, f := newTestDB(t)
+	store := &MemoryStore{}
+	ctx := context.Background()
+	f.put("a", `{"_id":"a","type":"fruit","n":1}`)
+	f.put("b", `{"_id":"b","type":"veg","n":2}`)
+
+	e := New(db, store)
+	if err := e.Register("by_type", View{Map: byType, Reduce: Sum, Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		e := New(db, store)
+		if err := e.Register("by_type", View{Map: byType, Reduce: Sum, Version: "1"}); err != nil {
+			t.Fatal(err)
+		}
+		before := len(f.since)
+		if err := e.Update(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if since := f.since[before]; since != "2" {
+			t.Errorf("Expected update from persisted seq, got since=%v", since)
+		}
+		result, err := readResults(e.Query(ctx, "by_type", kivik.Options{"update": false}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]string{`:null=3`}, result.Rows); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("new version", func(t *testing.T) {
+		e := New(db, store)
+		if err := e.Register("by_type", View{Map: byType, Reduce: Sum, Version: "2"}); err != nil {
+			t.Fatal(err)
+		}
+		before := len(f.since)
+		if err := e.Update(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if since := f.since[before]; since != nil {
+			t.Errorf("Expected rebuild, got since=%v", since)
+		}
+	})
+}
@@ -0,0 +1,363 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+)
+
+// Watcher is an iterator over the new revisions of a single document. See
+// DB.Watch.
+type Watcher struct {
+	db      *DB
+	docID   string
+	options Options
+	retry   *RetryPolicy
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu     sync.Mutex
+	feed   *Changes
+	closed bool
+	err    error
+
+	// filter is true once the driver has rejected the doc_ids option, and
+	// the _doc_ids filter is used instead.
+	filter   bool
+	since    string
+	failures int
+
+	seq       string
+	rev       string
+	deleted   bool
+	conflicts []string
+	doc       []byte
+}
+
+// Watch returns an iterator which yields each new revision of the document
+// docID, including deletions, as it is written. Each result reports the
+// winning revision, and any conflicting revisions, of the document.
+//
+// Watch reads a continuous changes feed, restricted to docID with the doc_ids
+// option, or with the _doc_ids filter if the driver rejects doc_ids, and with
+// documents included. By default, only revisions written after the call are
+// reported; pass a "since" option to start from an earlier update sequence.
+// Other options are passed to the driver for every feed opened.
+//
+// When the feed fails with a retryable error, or is closed by the server, it
+// is reopened from the last sequence read, after a delay determined by the
+// DB's RetryPolicy (or the RetryPolicy defaults, if none is set). MaxAttempts
+// is not applied; the Watcher runs until it is closed, ctx is cancelled, or a
+// non-retryable error is encountered.
+//
+// Watch replaces polling loops around GetRev:
+//
+//	w, err := db.Watch(ctx, "config")
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//	for w.Next() {
+//		var cfg Config
+//		if err := w.ScanDoc(&cfg); err != nil {
+//			return err
+//		}
+//		apply(cfg)
+//	}
+//	return w.Err()
+func (db *DB) Watch(ctx context.Context, docID string, options ...Options) (*Watcher, error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+	if docID == "" {
+		return nil, missingArg("docID")
+	}
+	retry := db.retryPolicy(options)
+	if retry == nil {
+		retry = &RetryPolicy{}
+	}
+	opts := mergeOptions(options...)
+	since, _ := opts["since"].(string)
+	if since == "" {
+		since = "now"
+	}
+	delete(opts, "since")
+	w := &Watcher{
+		db:      db,
+		docID:   docID,
+		options: opts,
+		retry:   retry,
+		since:   since,
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	feed, err := w.open(db.retryPolicy(options))
+	if err != nil {
+		w.cancel()
+		return nil, err
+	}
+	w.feed = feed
+	return w, nil
+}
+
+// open opens a changes feed from w.since, falling back to the _doc_ids filter
+// if the driver rejects the doc_ids option. retry is applied to the setup of
+// the feed.
+func (w *Watcher) open(retry *RetryPolicy) (*Changes, error) {
+	for {
+		opts := Options{}
+		for k, v := range w.options {
+			opts[k] = v
+		}
+		opts["feed"] = "continuous"
+		opts["include_docs"] = true
+		opts["conflicts"] = true
+		opts["style"] = "all_docs"
+		opts["since"] = w.since
+		opts["doc_ids"] = []string{w.docID}
+		if w.filter {
+			opts["filter"] = "_doc_ids"
+		}
+		feed, err := w.db.Changes(w.ctx, opts, Options{OptionRetryPolicy: retry})
+		if err == nil {
+			return feed, nil
+		}
+		if !w.filter {
+			switch StatusCode(err) {
+			case http.StatusBadRequest, http.StatusNotImplemented:
+				w.filter = true
+				continue
+			}
+		}
+		return nil, err
+	}
+}
+
+// Next blocks until the next revision of the document is available, and
+// prepares it for reading. It returns false when the Watcher is closed, its
+// context is cancelled, or a non-retryable error is encountered. Err should
+// be consulted to distinguish between these.
+func (w *Watcher) Next() bool {
+	for {
+		w.mu.Lock()
+		feed, closed := w.feed, w.closed
+		w.mu.Unlock()
+		if closed {
+			return false
+		}
+		if feed == nil {
+			var err error
+			if feed, err = w.reconnect(); err != nil {
+				w.fail(err)
+				return false
+			}
+			continue
+		}
+		if !feed.Next() {
+			err := feed.Err()
+			if last := feed.LastSeq(); last != "" {
+				w.since = last
+			}
+			_ = feed.Close()
+			w.mu.Lock()
+			w.feed = nil
+			w.mu.Unlock()
+			if w.ctx.Err() != nil {
+				w.fail(nil)
+				return false
+			}
+			if err != nil && !w.retry.retryable(err) {
+				w.fail(err)
+				return false
+			}
+			w.failures++
+			if !w.sleep(w.retry.backoff(w.failures, err)) {
+				w.fail(nil)
+				return false
+			}
+			continue
+		}
+		if feed.eoq {
+			continue
+		}
+		w.failures = 0
+		change := feed.curVal.(*driver.Change)
+		if change.Seq != "" {
+			w.since = change.Seq
+		}
+		if change.ID != w.docID {
+			// The driver does not honor doc_ids.
+			continue
+		}
+		if w.update(change) {
+			return true
+		}
+	}
+}
+
+// reconnect reopens the feed, waiting between failed attempts.
+func (w *Watcher) reconnect() (*Changes, error) {
+	for {
+		feed, err := w.open(nil)
+		if err == nil {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if w.closed {
+				_ = feed.Close()
+				return nil, nil
+			}
+			w.feed = feed
+			return feed, nil
+		}
+		if w.ctx.Err() != nil {
+			return nil, nil
+		}
+		if !w.retry.retryable(err) {
+			return nil, err
+		}
+		w.failures++
+		if !w.sleep(w.retry.backoff(w.failures, err)) {
+			return nil, nil
+		}
+	}
+}
+
+func (w *Watcher) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// fail closes the Watcher with err, which may be nil.
+func (w *Watcher) fail(err error) {
+	w.mu.Lock()
+	if !w.closed && w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	_ = w.Close()
+}
+
+// update records change as the current result. It returns false if change
+// does not describe a new revision, as happens when a feed is reopened.
+func (w *Watcher) update(change *driver.Change) bool {
+	var doc struct {
+		Rev       string   `json:"_rev"`
+		Deleted   bool     `json:"_deleted"`
+		Conflicts []string `json:"_conflicts"`
+	}
+	if len(change.Doc) > 0 {
+		_ = json.Unmarshal(change.Doc, &doc)
+	}
+	rev, conflicts := doc.Rev, doc.Conflicts
+	if rev == "" && len(change.Changes) > 0 {
+		rev = change.Changes[0]
+		conflicts = change.Changes[1:]
+	}
+	deleted := change.Deleted || doc.Deleted
+	if rev == w.rev && deleted == w.deleted && equalStrings(conflicts, w.conflicts) {
+		return false
+	}
+	w.seq = change.Seq
+	w.rev = rev
+	w.deleted = deleted
+	w.conflicts = append([]string(nil), conflicts...)
+	w.doc = append(w.doc[:0], change.Doc...)
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns the error, if any, that ended iteration. It returns nil if the
+// Watcher was closed, or its context cancelled.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the Watcher, and closes the underlying changes feed. It may be
+// called concurrently with Next. Close is idempotent.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	feed := w.feed
+	w.feed = nil
+	w.mu.Unlock()
+	w.cancel()
+	if feed != nil {
+		return feed.Close()
+	}
+	return nil
+}
+
+// ID returns the ID of the watched document.
+func (w *Watcher) ID() string {
+	return w.docID
+}
+
+// Rev returns the winning revision of the document, as of the current result.
+func (w *Watcher) Rev() string {
+	return w.rev
+}
+
+// Deleted returns true if the current result is a deletion of the document.
+func (w *Watcher) Deleted() bool {
+	return w.deleted
+}
+
+// Conflicts returns the conflicting revisions of the document, as of the
+// current result, or nil if there are none.
+func (w *Watcher) Conflicts() []string {
+	if len(w.conflicts) == 0 {
+		return nil
+	}
+	return w.conflicts
+}
+
+// Seq returns the update sequence of the current result.
+func (w *Watcher) Seq() string {
+	return w.seq
+}
+
+// ScanDoc unmarshals the document, as of the current result, into dest. For
+// a deletion, this is the deletion stub.
+func (w *Watcher) ScanDoc(dest interface{}) error {
+	if w.rev == "" {
+		return &Error{HTTPStatus: http.StatusBadRequest, Message: "kivik: Iterator access before calling Next"}
+	}
+	return unmarshal(w.doc, dest)
+}
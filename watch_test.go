@@ -0,0 +1,180 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/dannyzhou2015/kivik/v4/driver"
+	"github.com/dannyzhou2015/kivik/v4/internal/mock"
+)
+
+func feedOf(err error, changes ...*driver.Change) *mock.Changes {
+	return &mock.Changes{
+		NextFunc: func(ch *driver.Change) error {
+			if len(changes) == 0 {
+				return err
+			}
+			*ch = *changes[0]
+			changes = changes[1:]
+			return nil
+		},
+		CloseFunc:   func() error { return nil },
+		LastSeqFunc: func() string { return "" },
+	}
+}
+
+func TestWatchMissingID(t *testing.T) {
+	db := &DB{driverDB: &mock.DB{}}
+	_, err := db.Watch(context.Background(), "")
+	testy.StatusError(t, "kivik: docID required", http.StatusBadRequest, err)
+}
+
+func TestWatchDBError(t *testing.T) {
+	db := &DB{err: &Error{HTTPStatus: http.StatusNotFound, Message: "db not found"}}
+	_, err := db.Watch(context.Background(), "foo")
+	testy.StatusError(t, "db not found", http.StatusNotFound, err)
+}
+
+func TestWatch(t *testing.T) {
+	var calls []map[string]interface{}
+	feeds := []func() (driver.Changes, error){
+		func() (driver.Changes, error) {
+			return nil, &Error{HTTPStatus: http.StatusBadRequest, Message: "doc_ids not supported"}
+		},
+		func() (driver.Changes, error) {
+			return feedOf(&Error{HTTPStatus: http.StatusServiceUnavailable, Message: "gone"},
+				&driver.Change{ID: "cfg", Seq: "1", Changes: []string{"1-a"}, Doc: []byte(`{"_id":"cfg","_rev":"1-a","x":1}`)},
+				&driver.Change{ID: "other", Seq: "2", Changes: []string{"1-z"}},
+				&driver.Change{ID: "cfg", Seq: "3", Changes: []string{"1-a"}, Doc: []byte(`{"_id":"cfg","_rev":"1-a","x":1}`)},
+			), nil
+		},
+		func() (driver.Changes, error) {
+			return nil, &Error{HTTPStatus: http.StatusBadGateway, Message: "still gone"}
+		},
+		func() (driver.Changes, error) {
+			return feedOf(&Error{HTTPStatus: http.StatusUnauthorized, Message: "unauthorized"},
+				&driver.Change{ID: "cfg", Seq: "4", Changes: []string{"2-b", "2-c"}, Doc: []byte(`{"_id":"cfg","_rev":"2-b","x":2,"_conflicts":["2-c"]}`)},
+				&driver.Change{ID: "cfg", Seq: "5", Deleted: true, Changes: []string{"3-d"}, Doc: []byte(`{"_id":"cfg","_rev":"3-d","_deleted":true}`)},
+			), nil
+		},
+	}
+	db := &DB{
+		driverDB: &mock.DB{
+			ChangesFunc: func(_ context.Context, opts map[string]interface{}) (driver.Changes, error) {
+				calls = append(calls, opts)
+				feed := feeds[0]
+				feeds = feeds[1:]
+				return feed()
+			},
+		},
+		retry: &RetryPolicy{InitialBackoff: time.Millisecond},
+	}
+	w, err := db.Watch(context.Background(), "cfg", Options{"heartbeat": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		Rev       string
+		Deleted   bool
+		Conflicts []string
+		Seq       string
+		Doc       map[string]interface{}
+	}
+	var results []result
+	for w.Next() {
+		r := result{Rev: w.Rev(), Deleted: w.Deleted(), Conflicts: w.Conflicts(), Seq: w.Seq()}
+		if err := w.ScanDoc(&r.Doc); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	testy.StatusError(t, "unauthorized", http.StatusUnauthorized, w.Err())
+	expected := []result{
+		{Rev: "1-a", Seq: "1", Doc: map[string]interface{}{"_id": "cfg", "_rev": "1-a", "x": 1.0}},
+		{Rev: "2-b", Conflicts: []string{"2-c"}, Seq: "4", Doc: map[string]interface{}{"_id": "cfg", "_rev": "2-b", "x": 2.0, "_conflicts": []interface{}{"2-c"}}},
+		{Rev: "3-d", Deleted: true, Seq: "5", Doc: map[string]interface{}{"_id": "cfg", "_rev": "3-d", "_deleted": true}},
+	}
+	if d := testy.DiffInterface(expected, results); d != nil {
+		t.Error(d)
+	}
+	opts := func(since string, filter bool) map[string]interface{} {
+		o := map[string]interface{}{
+			"heartbeat":    1000,
+			"feed":         "continuous",
+			"include_docs": true,
+			"conflicts":    true,
+			"style":        "all_docs",
+			"since":        since,
+			"doc_ids":      []string{"cfg"},
+		}
+		if filter {
+			o["filter"] = "_doc_ids"
+		}
+		return o
+	}
+	expectedCalls := []map[string]interface{}{
+		opts("now", false),
+		opts("now", true),
+		opts("3", true),
+		opts("3", true),
+	}
+	if d := testy.DiffInterface(expectedCalls, calls); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestWatchClose(t *testing.T) {
+	closed := make(chan struct{})
+	db := &DB{
+		driverDB: &mock.DB{
+			ChangesFunc: func(ctx context.Context, _ map[string]interface{}) (driver.Changes, error) {
+				return &mock.Changes{
+					NextFunc: func(*driver.Change) error {
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-closed:
+							return io.EOF
+						}
+					},
+					CloseFunc:   func() error { return nil },
+					LastSeqFunc: func() string { return "" },
+				}, nil
+			},
+		},
+	}
+	w, err := db.Watch(context.Background(), "cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = w.Close()
+	}()
+	if w.Next() {
+		t.Fatal("Expected Next to return false after Close")
+	}
+	close(closed)
+	if err := w.Err(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	err = w.ScanDoc(&struct{}{})
+	testy.StatusError(t, "kivik: Iterator access before calling Next", http.StatusBadRequest, err)
+}